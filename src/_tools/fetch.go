@@ -14,6 +14,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -61,7 +62,7 @@ func main() {
 			}
 		}
 	} else {
-		err := repository.FetchObjects(wants, func(hash string, ot git.ObjectType, content []byte) error {
+		err := repository.FetchObjects(context.Background(), wants, func(hash string, ot git.ObjectType, content []byte) error {
 			switch ot {
 			case git.CommitObject:
 				if c, err := git.DecodeCommit(content); nil == err {
@@ -0,0 +1,412 @@
+/*
+ * cache.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/billziss-gh/golib/appdata"
+)
+
+// The cache directory of a provider contains a directory for every repository
+// (owner/repository or @gists/owner/repository), with subdirectories for the kinds of
+// content that have been fetched, and the @objects directory with the file content that
+// is shared by all repositories (see package prov).
+
+const (
+	cacheRepository = "repository"
+	cacheObjects    = "objects"
+	cacheStale      = "stale"
+)
+
+var cacheContentDirs = []string{
+	"objects", "files", "lfs", "archive", "releases", "actions", "wiki", "mirror.git"}
+
+// cacheInuseDir contains a file for every client that uses a cache directory; see
+// StartExpiration in package prov.
+const cacheInuseDir = "@inuse"
+
+// cacheStaleRe matches the directories that are left behind when their removal is
+// interrupted; see RemoveDirectory in package prov.
+var cacheStaleRe = regexp.MustCompile(`\.[0-9]{8}T[0-9]{6}\.[0-9]{3}Z$`)
+
+type cacheEntry struct {
+	Path    string    `json:"path"`
+	Kind    string    `json:"kind"`
+	Size    int64     `json:"size"`
+	Used    time.Time `json:"used"`
+	Changes bool      `json:"changes,omitempty"`
+	Pinned  bool      `json:"pinned,omitempty"`
+}
+
+// cacheReport lists the entries of a cache directory; for prune and clear it lists the
+// entries that have been removed.
+type cacheReport struct {
+	Provider string       `json:"provider,omitempty"`
+	Dir      string       `json:"dir"`
+	Entries  []cacheEntry `json:"entries"`
+}
+
+func defaultCacheDir() string {
+	d, err := appdata.CacheDir()
+	if nil != err {
+		return ""
+	}
+	return filepath.Join(d, progname)
+}
+
+// scanCache finds the repositories, shared objects and stale directories below a cache
+// directory, which may be the cache directory of a provider or the directory that
+// contains the cache directories of all providers.
+func scanCache(root string) ([]cacheEntry, error) {
+	res := []cacheEntry{}
+	var scan func(dir string, depth int) error
+	scan = func(dir string, depth int) error {
+		infos, err := ioutil.ReadDir(dir)
+		if nil != err {
+			return err
+		}
+		for _, info := range infos {
+			if !info.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, info.Name())
+			kind := ""
+			switch {
+			case cacheStaleRe.MatchString(info.Name()):
+				kind = cacheStale
+			case "@objects" == info.Name():
+				kind = cacheObjects
+			case isCacheRepository(path):
+				kind = cacheRepository
+			case 4 > depth:
+				if err := scan(path, depth+1); nil != err {
+					return err
+				}
+				continue
+			default:
+				continue
+			}
+			rel, _ := filepath.Rel(root, path)
+			e := cacheEntry{Path: filepath.ToSlash(rel), Kind: kind}
+			e.Size, e.Used = cacheDirUsage(path)
+			if cacheRepository == kind {
+				list, _ := filepath.Glob(filepath.Join(path, "files/*/.keep"))
+				e.Changes = 0 != len(list)
+				_, err := os.Stat(filepath.Join(path, "pinned"))
+				e.Pinned = nil == err
+			}
+			res = append(res, e)
+		}
+		return nil
+	}
+	err := scan(root, 1)
+	return res, err
+}
+
+func isCacheRepository(path string) bool {
+	for _, n := range cacheContentDirs {
+		if info, err := os.Stat(filepath.Join(path, n)); nil == err && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheDirUsage returns the size of the files below a directory and the time that the most
+// recent of them was written, which is when content was last fetched into it.
+func cacheDirUsage(dir string) (size int64, used time.Time) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if nil == err && info.Mode().IsRegular() {
+			size += info.Size()
+			if used.Before(info.ModTime()) {
+				used = info.ModTime()
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func removeCacheDir(path string) error {
+	tmpdir := path + time.Now().Format(".20060102T150405.000Z")
+	if cacheStaleRe.MatchString(path) {
+		tmpdir = path
+	} else if err := os.Rename(path, tmpdir); nil != err {
+		return err
+	}
+	return os.RemoveAll(tmpdir)
+}
+
+// pruneCache removes the repositories that have not fetched content since the cutoff
+// time, the shared objects that were fetched before it and the stale directories. With
+// a zero cutoff time it removes everything. Repositories with local changes, pinned
+// repositories and the open repositories (whose directories are in open) are kept.
+func pruneCache(root string, cutoff time.Time, open []string) ([]cacheEntry, error) {
+	entries, err := scanCache(root)
+	if nil != err {
+		return nil, err
+	}
+	isopen := map[string]bool{}
+	for _, dir := range open {
+		isopen[filepath.Clean(dir)] = true
+	}
+	res := []cacheEntry{}
+	for _, e := range entries {
+		path := filepath.Join(root, filepath.FromSlash(e.Path))
+		switch e.Kind {
+		case cacheRepository:
+			if e.Changes || e.Pinned || isopen[path] ||
+				(!cutoff.IsZero() && e.Used.After(cutoff)) {
+				continue
+			}
+			err = removeCacheDir(path)
+		case cacheObjects:
+			if cutoff.IsZero() && 0 == len(open) {
+				err = removeCacheDir(path)
+				break
+			}
+			/* open repositories may be writing to the shared objects; remove files only */
+			before := cutoff
+			if before.IsZero() {
+				before = time.Now()
+			}
+			e.Size = 0
+			filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+				if nil == err && info.Mode().IsRegular() && info.ModTime().Before(before) {
+					if nil == os.Remove(p) {
+						e.Size += info.Size()
+					}
+				}
+				return nil
+			})
+			if 0 == e.Size {
+				continue
+			}
+		case cacheStale:
+			err = removeCacheDir(path)
+		}
+		if nil != err {
+			return res, err
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func formatSize(n int64) string {
+	const suffixes = "KMGTPE"
+	if 1024 > n {
+		return fmt.Sprintf("%d", n)
+	}
+	f, i := float64(n)/1024, 0
+	for ; 1024 <= f && len(suffixes) > i+1; i++ {
+		f /= 1024
+	}
+	return fmt.Sprintf("%.1f%c", f, suffixes[i])
+}
+
+func printCacheReport(cmd string, r *cacheReport) {
+	if "" != r.Provider {
+		fmt.Printf("%s %s\n", r.Provider, r.Dir)
+	} else {
+		fmt.Printf("%s\n", r.Dir)
+	}
+	switch cmd {
+	case "stats":
+		var repos, changes, pinned int
+		var size, objects, stale, total int64
+		for _, e := range r.Entries {
+			switch e.Kind {
+			case cacheRepository:
+				repos++
+				size += e.Size
+				if e.Changes {
+					changes++
+				}
+				if e.Pinned {
+					pinned++
+				}
+			case cacheObjects:
+				objects += e.Size
+			case cacheStale:
+				stale += e.Size
+			}
+			total += e.Size
+		}
+		fmt.Printf("  repositories %d (%s)\n", repos, formatSize(size))
+		fmt.Printf("  local changes %d\n", changes)
+		fmt.Printf("  pinned %d\n", pinned)
+		fmt.Printf("  shared objects %s\n", formatSize(objects))
+		if 0 != stale {
+			fmt.Printf("  stale %s\n", formatSize(stale))
+		}
+		fmt.Printf("  total %s\n", formatSize(total))
+	case "ls":
+		for _, e := range r.Entries {
+			note := ""
+			if e.Changes {
+				note = " (local changes)"
+			} else if e.Pinned {
+				note = " (pinned)"
+			} else if cacheRepository != e.Kind {
+				note = " (" + e.Kind + ")"
+			}
+			fmt.Printf("  %8s  %s  %s%s\n",
+				formatSize(e.Size), e.Used.Local().Format("2006-01-02 15:04"), e.Path, note)
+		}
+	case "prune", "clear":
+		var total int64
+		for _, e := range r.Entries {
+			fmt.Printf("  removed %s (%s)\n", e.Path, formatSize(e.Size))
+			total += e.Size
+		}
+		fmt.Printf("  freed %s\n", formatSize(total))
+	}
+}
+
+// isCache determines if the program was invoked as "hubfs cache command".
+func isCache() bool {
+	return 1 < len(os.Args) && "cache" == os.Args[1]
+}
+
+func cacheUsage(flags *flag.FlagSet) int {
+	fmt.Fprintf(os.Stderr, "usage: %s cache [-dir dir [-force] | -ctl socket] command [args]\n\n", progname)
+	fmt.Fprintf(os.Stderr, "commands:\n"+
+		"  stats                    show the size of the cache\n"+
+		"  ls                       list the cached repositories\n"+
+		"  prune [age]              remove the content not fetched within age (default: 168h)\n"+
+		"  clear                    remove all content except local changes and pins\n\n")
+	flags.SetOutput(os.Stderr)
+	flags.PrintDefaults()
+	return 2
+}
+
+// cache inspects and cleans up the cache directory, either directly or with the control
+// API of a running instance, which operates on the cache directories of its providers.
+func cache(args []string) int {
+	dir := defaultCacheDir()
+	sock := ""
+	force := false
+	flags := flag.NewFlagSet("cache", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.StringVar(&dir, "dir", dir, "cache `directory`")
+	flags.StringVar(&sock, "ctl", sock, "control `socket` of a running instance")
+	flags.BoolVar(&force, "force", force, "prune or clear the cache directory even if it is in use")
+	if nil != flags.Parse(args) || 0 == flags.NArg() {
+		return cacheUsage(flags)
+	}
+	cmd, args := flags.Arg(0), flags.Args()[1:]
+
+	req := &ctlRequest{Command: cmd}
+	switch cmd {
+	case "stats", "ls", "clear":
+		if 0 != len(args) {
+			return cacheUsage(flags)
+		}
+	case "prune":
+		req.Age = "168h"
+		if 1 < len(args) {
+			return cacheUsage(flags)
+		}
+		if 1 == len(args) {
+			req.Age = args[0]
+		}
+	default:
+		return cacheUsage(flags)
+	}
+
+	var reports []*cacheReport
+	if "" != sock {
+		rsp, err := ctlDo(sock, "POST", "cache", req)
+		if nil != err {
+			warn("cache error: %v", err)
+			return 1
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&reports)
+		rsp.Body.Close()
+		if nil != err {
+			warn("cache error: %v", err)
+			return 1
+		}
+	} else {
+		if "" == dir {
+			warn("cache error: no cache directory")
+			return 1
+		}
+		if ("prune" == cmd || "clear" == cmd) && !force {
+			if list := cacheInuse(dir); 0 != len(list) {
+				warn("cache error: %s is in use by a running instance (%s); "+
+					"use -ctl to run the command in the instance or -force", dir, list[0])
+				return 1
+			}
+		}
+		r, err := runCacheCommand(dir, req, nil)
+		if nil != err {
+			warn("cache error: %v", err)
+			return 1
+		}
+		reports = append(reports, r)
+	}
+
+	for _, r := range reports {
+		printCacheReport(cmd, r)
+	}
+	return 0
+}
+
+// cacheInuse returns the files that mark a cache directory (or the cache directories of
+// the providers below it) as used by a running instance.
+func cacheInuse(dir string) []string {
+	list, _ := filepath.Glob(filepath.Join(dir, cacheInuseDir, "*"))
+	more, _ := filepath.Glob(filepath.Join(dir, "*", cacheInuseDir, "*"))
+	return append(list, more...)
+}
+
+// runCacheCommand runs a cache command on a cache directory. The directories of the open
+// repositories are kept by prune and clear.
+func runCacheCommand(dir string, req *ctlRequest, open []string) (r *cacheReport, err error) {
+	r = &cacheReport{Dir: dir}
+	switch req.Command {
+	case "stats", "ls":
+		r.Entries, err = scanCache(dir)
+		sort.Slice(r.Entries, func(i, j int) bool {
+			return r.Entries[i].Path < r.Entries[j].Path
+		})
+	case "prune":
+		var age time.Duration
+		age, err = time.ParseDuration(req.Age)
+		if nil != err || 0 >= age {
+			return nil, errors.New("invalid age: " + req.Age)
+		}
+		r.Entries, err = pruneCache(dir, time.Now().Add(-age), open)
+	case "clear":
+		r.Entries, err = pruneCache(dir, time.Time{}, open)
+	default:
+		err = errors.New("unknown cache command: " + req.Command)
+	}
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
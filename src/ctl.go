@@ -0,0 +1,442 @@
+/*
+ * ctl.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+// The control API is a REST API that a running instance serves over a unix socket
+// (-ctl socket). It is used by "hubfs ctl socket command".
+
+type ctlMount struct {
+	Remote     string `json:"remote"`
+	Mountpoint string `json:"mountpoint,omitempty"`
+	Provider   string `json:"provider"`
+}
+
+type ctlServe struct {
+	Proto string `json:"proto"`
+	Addr  string `json:"addr"`
+}
+
+type ctlRateLimit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+type ctlStatus struct {
+	Version    string                  `json:"version"`
+	Pid        int                     `json:"pid"`
+	Uptime     string                  `json:"uptime"`
+	Mounts     []ctlMount              `json:"mounts"`
+	Servers    []ctlServe              `json:"servers"`
+	RateLimits map[string]ctlRateLimit `json:"ratelimits"`
+	Ops        string                  `json:"ops"`
+}
+
+type ctlRequest struct {
+	Provider string   `json:"provider,omitempty"`
+	Token    string   `json:"token,omitempty"`
+	Paths    []string `json:"paths,omitempty"`
+	Command  string   `json:"command,omitempty"`
+	Age      string   `json:"age,omitempty"`
+}
+
+type ctlError struct {
+	Error string `json:"error"`
+}
+
+// ctlServer serves the control API of the mounts of this process.
+type ctlServer struct {
+	mounts  []*mountSpec
+	clients map[string]prov.Client
+	servers []serveSpec
+	reload  func() error
+	started time.Time
+	srv     http.Server
+}
+
+// startCtlServer listens on the unix socket path and serves the control API. A stale
+// socket file is removed; a socket that is in use by another instance is an error.
+func startCtlServer(path string, mounts []*mountSpec, clients map[string]prov.Client,
+	servers []serveSpec, reload func() error) (*ctlServer, error) {
+	if conn, err := net.Dial("unix", path); nil == err {
+		conn.Close()
+		return nil, errors.New("control socket in use: " + path)
+	}
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if nil != err {
+		return nil, err
+	}
+	os.Chmod(path, 0600)
+
+	ctl := &ctlServer{
+		mounts:  mounts,
+		clients: clients,
+		servers: servers,
+		reload:  reload,
+		started: time.Now(),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", ctl.handler("GET", ctl.status))
+	mux.HandleFunc("/v1/mounts", ctl.handler("GET", ctl.listMounts))
+	mux.HandleFunc("/v1/flush", ctl.handler("POST", ctl.flush))
+	mux.HandleFunc("/v1/prefetch", ctl.handler("POST", ctl.prefetch))
+	mux.HandleFunc("/v1/token", ctl.handler("POST", ctl.token))
+	mux.HandleFunc("/v1/reload", ctl.handler("POST", ctl.reloadConfig))
+	mux.HandleFunc("/v1/cache", ctl.handler("POST", ctl.cache))
+	ctl.srv.Handler = mux
+	go ctl.srv.Serve(ln)
+	return ctl, nil
+}
+
+func (ctl *ctlServer) stop() {
+	ctl.srv.Shutdown(context.Background())
+}
+
+// handler decodes the request, calls fn and encodes its result (or error) as JSON.
+func (ctl *ctlServer) handler(method string,
+	fn func(ctx context.Context, req *ctlRequest) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if method != r.Method {
+			w.Header().Set("Allow", method)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(ctlError{Error: "method not allowed"})
+			return
+		}
+		req := &ctlRequest{}
+		if "POST" == r.Method {
+			if err := json.NewDecoder(r.Body).Decode(req); nil != err && io.EOF != err {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(ctlError{Error: err.Error()})
+				return
+			}
+		}
+		res, err := fn(r.Context(), req)
+		if nil != err {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ctlError{Error: err.Error()})
+			return
+		}
+		if nil == res {
+			res = struct{}{}
+		}
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (ctl *ctlServer) status(ctx context.Context, req *ctlRequest) (interface{}, error) {
+	res := &ctlStatus{
+		Version:    MyProductVersion + " (" + MyVersion + ")",
+		Pid:        os.Getpid(),
+		Uptime:     time.Since(ctl.started).Round(time.Second).String(),
+		Servers:    []ctlServe{},
+		RateLimits: map[string]ctlRateLimit{},
+	}
+	res.Mounts = ctl.getMounts()
+	for _, s := range ctl.servers {
+		res.Servers = append(res.Servers, ctlServe{Proto: s.proto, Addr: s.addr})
+	}
+	for name, client := range ctl.clients {
+		if r := client.GetRateLimit(); 0 != r.Limit {
+			res.RateLimits[name] = ctlRateLimit{Limit: r.Limit, Remaining: r.Remaining, Reset: r.Reset}
+		}
+	}
+	var ops bytes.Buffer
+	util.DumpOps(&ops)
+	res.Ops = ops.String()
+	return res, nil
+}
+
+func (ctl *ctlServer) getMounts() []ctlMount {
+	res := []ctlMount{}
+	for _, m := range ctl.mounts {
+		res = append(res, ctlMount{Remote: m.remote, Mountpoint: m.mntpnt, Provider: m.provider})
+	}
+	return res
+}
+
+func (ctl *ctlServer) listMounts(ctx context.Context, req *ctlRequest) (interface{}, error) {
+	return ctl.getMounts(), nil
+}
+
+// flush flushes the caches of the client of a provider or of all clients.
+func (ctl *ctlServer) flush(ctx context.Context, req *ctlRequest) (interface{}, error) {
+	if "" != req.Provider {
+		client, ok := ctl.clients[req.Provider]
+		if !ok {
+			return nil, errors.New("unknown provider: " + req.Provider)
+		}
+		client.FlushCache()
+		return nil, nil
+	}
+	for _, client := range ctl.clients {
+		client.FlushCache()
+	}
+	return nil, nil
+}
+
+// prefetch fetches the content along local paths below the mountpoints in the background.
+func (ctl *ctlServer) prefetch(ctx context.Context, req *ctlRequest) (interface{}, error) {
+	type target struct {
+		m    *mountSpec
+		path string
+	}
+	targets := []target{}
+	for _, p := range req.Paths {
+		found := false
+		for _, m := range ctl.mounts {
+			if "" == m.mntpnt || nil == m.fs {
+				continue
+			}
+			rel, err := filepath.Rel(m.mntpnt, p)
+			if nil != err || ".." == rel || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			path := "/" + filepath.ToSlash(rel)
+			if "/." == path {
+				path = "/"
+			}
+			targets = append(targets, target{m, path})
+			found = true
+			break
+		}
+		if !found {
+			return nil, errors.New("not below a mountpoint: " + p)
+		}
+	}
+	for _, t := range targets {
+		go t.m.fs.Prefetch(t.path)
+	}
+	return nil, nil
+}
+
+// token sets the auth token of the client of a provider.
+func (ctl *ctlServer) token(ctx context.Context, req *ctlRequest) (interface{}, error) {
+	client, ok := ctl.clients[req.Provider]
+	if !ok {
+		return nil, errors.New("unknown provider: " + req.Provider)
+	}
+	if "" == req.Token {
+		return nil, errors.New("missing token")
+	}
+	return nil, client.SetToken(ctx, req.Token)
+}
+
+func (ctl *ctlServer) reloadConfig(ctx context.Context, req *ctlRequest) (interface{}, error) {
+	if nil == ctl.reload {
+		return nil, errors.New("reload is not supported")
+	}
+	return nil, ctl.reload()
+}
+
+// cache runs a cache command on the cache directories of the clients. The caches of the
+// clients are flushed before they are pruned or cleared, so that unused repositories are
+// closed; the repositories that remain open are kept.
+func (ctl *ctlServer) cache(ctx context.Context, req *ctlRequest) (interface{}, error) {
+	names := []string{}
+	for name := range ctl.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := []*cacheReport{}
+	for _, name := range names {
+		client := ctl.clients[name]
+		dir := client.GetDirectory()
+		if "" == dir {
+			continue
+		}
+		var open []string
+		if "prune" == req.Command || "clear" == req.Command {
+			client.FlushCache()
+			open = client.GetOpenDirectories()
+		}
+		r, err := runCacheCommand(dir, req, open)
+		if nil != err {
+			return nil, err
+		}
+		r.Provider = name
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// isCtl determines if the program was invoked as "hubfs ctl socket command".
+func isCtl() bool {
+	return 1 < len(os.Args) && "ctl" == os.Args[1]
+}
+
+func ctlUsage() int {
+	fmt.Fprintf(os.Stderr, "usage: %s ctl socket command [args]\n\n", progname)
+	fmt.Fprintf(os.Stderr, "commands:\n"+
+		"  status                   show the status of the instance\n"+
+		"  mounts                   list the mounted remotes\n"+
+		"  flush [provider]         flush the caches (of a provider)\n"+
+		"  prefetch path...         fetch the content along paths below a mountpoint\n"+
+		"  token provider token|-   set the auth token of a provider (- reads stdin)\n"+
+		"  reload                   reload the configuration file\n")
+	return 2
+}
+
+// ctl sends a command to the control API of a running instance.
+func ctl(args []string) int {
+	if 2 > len(args) {
+		return ctlUsage()
+	}
+	sock, cmd, args := args[0], args[1], args[2:]
+
+	req := &ctlRequest{}
+	method := "POST"
+	switch cmd {
+	case "status", "mounts":
+		if 0 != len(args) {
+			return ctlUsage()
+		}
+		method = "GET"
+	case "flush":
+		if 1 < len(args) {
+			return ctlUsage()
+		}
+		if 1 == len(args) {
+			req.Provider = args[0]
+		}
+	case "prefetch":
+		if 0 == len(args) {
+			return ctlUsage()
+		}
+		for _, p := range args {
+			p, err := filepath.Abs(p)
+			if nil != err {
+				warn("ctl error: %v", err)
+				return 1
+			}
+			req.Paths = append(req.Paths, p)
+		}
+	case "token":
+		if 2 != len(args) {
+			return ctlUsage()
+		}
+		req.Provider, req.Token = args[0], args[1]
+		if "-" == req.Token {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if nil != err && io.EOF != err {
+				warn("ctl error: %v", err)
+				return 1
+			}
+			req.Token = strings.TrimSpace(line)
+		}
+	case "reload":
+		if 0 != len(args) {
+			return ctlUsage()
+		}
+	default:
+		return ctlUsage()
+	}
+
+	rsp, err := ctlDo(sock, method, cmd, req)
+	if nil != err {
+		warn("ctl error: %v", err)
+		return 1
+	}
+	defer rsp.Body.Close()
+
+	switch cmd {
+	case "status":
+		s := ctlStatus{}
+		if err := json.NewDecoder(rsp.Body).Decode(&s); nil != err {
+			warn("ctl error: %v", err)
+			return 1
+		}
+		fmt.Printf("version %s\npid %d\nuptime %s\n", s.Version, s.Pid, s.Uptime)
+		for _, m := range s.Mounts {
+			fmt.Printf("mount %s %s\n", m.Remote, m.Mountpoint)
+		}
+		for _, v := range s.Servers {
+			fmt.Printf("serve %s %s\n", v.Proto, v.Addr)
+		}
+		names := []string{}
+		for n := range s.RateLimits {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			r := s.RateLimits[n]
+			fmt.Printf("ratelimit %s %d/%d reset %s\n",
+				n, r.Remaining, r.Limit, r.Reset.Format(time.RFC3339))
+		}
+		if "" != s.Ops {
+			fmt.Printf("ops\n%s", s.Ops)
+		}
+	case "mounts":
+		var lst []ctlMount
+		if err := json.NewDecoder(rsp.Body).Decode(&lst); nil != err {
+			warn("ctl error: %v", err)
+			return 1
+		}
+		for _, m := range lst {
+			fmt.Printf("%s %s\n", m.Remote, m.Mountpoint)
+		}
+	}
+	return 0
+}
+
+// ctlDo sends a request to the control API of a running instance. A response with an
+// error status is returned as an error.
+func ctlDo(sock string, method string, cmd string, req *ctlRequest) (*http.Response, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	var rsp *http.Response
+	var err error
+	if "POST" == method {
+		data, _ := json.Marshal(req)
+		rsp, err = client.Post("http://hubfs/v1/"+cmd, "application/json", bytes.NewReader(data))
+	} else {
+		rsp, err = client.Get("http://hubfs/v1/" + cmd)
+	}
+	if nil != err {
+		return nil, err
+	}
+	if http.StatusOK != rsp.StatusCode {
+		e := ctlError{}
+		json.NewDecoder(rsp.Body).Decode(&e)
+		rsp.Body.Close()
+		return nil, errors.New(e.Error)
+	}
+	return rsp, nil
+}
@@ -0,0 +1,155 @@
+/*
+ * daemon.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/winfsp/hubfs/util"
+)
+
+// The daemonEnv environment variable tells the processes started by -daemon their role:
+// the supervisor process runs in the background and starts a worker process, which
+// mounts the file systems.
+const daemonEnv = "HUBFS_DAEMON"
+
+// logKeep is the number of rotated log files that are kept.
+const logKeep = 3
+
+func daemonRole() string {
+	return os.Getenv(daemonEnv)
+}
+
+// daemonize starts the supervisor in the background and waits until the file systems
+// have been mounted.
+func daemonize(mntpnts []string) error {
+	return startDetached(os.Args[1:], []string{daemonEnv + "=supervisor"}, mntpnts)
+}
+
+// openLogFile opens the system log if logfile is "syslog", else a log file that is
+// rotated when it grows beyond logsize (if not 0).
+func openLogFile(logfile string, logsize int64) (io.Writer, error) {
+	if "syslog" == logfile {
+		return openSyslog()
+	}
+	return util.OpenLogFile(logfile, logsize, logKeep)
+}
+
+// supervise runs the worker and starts it again if it fails, after unmounting any file
+// systems that it left behind. The supervisor exits when the worker exits successfully
+// (i.e. the file systems have been unmounted) or when it receives SIGINT or SIGTERM,
+// which it passes on to the worker.
+func supervise(mntpnts []string, pidfile string, logfile string, logsize int64) int {
+	var log io.Writer = os.Stderr
+	if "" != logfile {
+		w, err := openLogFile(logfile, logsize)
+		if nil != err {
+			warn("logfile error: %v", err)
+			return 1
+		}
+		if c, ok := w.(io.Closer); ok {
+			defer c.Close()
+		}
+		log = w
+	}
+	logf := func(format string, a ...interface{}) {
+		fmt.Fprintf(log, "%s %s[%d]: %s\n",
+			time.Now().Format(time.RFC3339), progname, os.Getpid(), fmt.Sprintf(format, a...))
+	}
+
+	if "" != pidfile {
+		err := ioutil.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+		if nil != err {
+			logf("pidfile error: %v", err)
+			return 1
+		}
+		defer os.Remove(pidfile)
+	}
+
+	exe, err := os.Executable()
+	if nil != err {
+		logf("%v", err)
+		return 1
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	backoff := time.Second
+	for {
+		cmd := exec.Command(exe)
+		cmd.Args = os.Args
+		cmd.Env = append(os.Environ(), daemonEnv+"=worker")
+		cmd.Stdout = log
+		cmd.Stderr = log
+		start := time.Now()
+		err = cmd.Start()
+		if nil != err {
+			logf("%v", err)
+			return 1
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+	wait:
+		for {
+			select {
+			case err = <-done:
+				break wait
+			case sig := <-sigc:
+				/* SIGHUP reloads the configuration of the worker */
+				cmd.Process.Signal(sig)
+				if syscall.SIGHUP != sig {
+					<-done
+					return 0
+				}
+			}
+		}
+		if nil == err {
+			return 0
+		}
+
+		logf("worker failed: %v; remounting in %v", err, backoff)
+		for _, m := range mntpnts {
+			unmountStale(m)
+		}
+		timer := time.NewTimer(backoff)
+	sleep:
+		for {
+			select {
+			case <-timer.C:
+				break sleep
+			case sig := <-sigc:
+				if syscall.SIGHUP != sig {
+					return 0
+				}
+			}
+		}
+		if time.Minute < time.Since(start) {
+			backoff = time.Second
+		} else if time.Minute > backoff {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,103 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * detach_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// startDetached starts hubfs with the specified arguments and additional environment in
+// a new session and waits until the file systems have been mounted at mntpnts.
+func startDetached(args []string, env []string, mntpnts []string) error {
+	exe, err := os.Executable()
+	if nil != err {
+		return err
+	}
+
+	cmd := exec.Command(exe)
+	cmd.Args = append([]string{"hubfs"}, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if nil != err {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case err = <-exited:
+			if nil == err {
+				err = errors.New("exited")
+			}
+			return errors.New(fmt.Sprintf("hubfs: %v", err))
+		case <-time.After(100 * time.Millisecond):
+			mounted := true
+			for _, m := range mntpnts {
+				mounted = mounted && isMounted(m)
+			}
+			if mounted {
+				return nil
+			}
+		}
+	}
+
+	cmd.Process.Kill()
+	return errors.New("timeout waiting for mount")
+}
+
+// isMounted determines if a file system is mounted at mntpnt, by comparing its device
+// to the device of its parent directory.
+func isMounted(mntpnt string) bool {
+	mntpnt, err := filepath.Abs(mntpnt)
+	if nil != err {
+		return false
+	}
+	var stat, pstat syscall.Stat_t
+	if nil != syscall.Stat(mntpnt, &stat) || nil != syscall.Stat(filepath.Dir(mntpnt), &pstat) {
+		return false
+	}
+	return stat.Dev != pstat.Dev
+}
+
+// unmountStale unmounts a file system that was left behind by a failed process.
+func unmountStale(mntpnt string) {
+	if "linux" == runtime.GOOS {
+		exec.Command("fusermount", "-u", "-z", mntpnt).Run()
+	} else {
+		exec.Command("umount", "-f", mntpnt).Run()
+	}
+}
+
+func openSyslog() (io.Writer, error) {
+	return syslog.New(syslog.LOG_NOTICE|syslog.LOG_DAEMON, progname)
+}
@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+/*
+ * detach_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func startDetached(args []string, env []string, mntpnts []string) error {
+	return errors.New("running in the background is not supported on Windows")
+}
+
+func unmountStale(mntpnt string) {
+}
+
+func openSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on Windows")
+}
@@ -0,0 +1,174 @@
+/*
+ * httpfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package httpfs serves a FUSE file system over HTTP: directories are served as
+// listings and files as their raw content.
+package httpfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	pathutil "path"
+	"strings"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/iofs"
+)
+
+// xattrOid is the extended attribute that holds the object id of a file; if present it
+// is used as the file ETag.
+const xattrOid = "user.hubfs.oid"
+
+// Handler is an http.Handler that serves a file system. It supports GET and HEAD
+// requests, conditional requests (ETag, If-Modified-Since) and range requests.
+// Directories are listed as HTML, or as JSON if the request accepts application/json.
+type Handler struct {
+	fs   fuse.FileSystemInterface
+	iofs *iofs.FS
+}
+
+type dirEntry struct {
+	Name  string    `json:"name"`
+	Dir   bool      `json:"dir"`
+	Size  int64     `json:"size"`
+	Mode  string    `json:"mode"`
+	Mtime time.Time `json:"mtime"`
+}
+
+// New creates a handler and initializes the file system. Close destroys the file system.
+func New(fs fuse.FileSystemInterface) *Handler {
+	return &Handler{
+		fs:   fs,
+		iofs: iofs.New(fs),
+	}
+}
+
+func (h *Handler) Close() error {
+	return h.iofs.Close()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if "GET" != r.Method && "HEAD" != r.Method {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := pathutil.Clean("/" + r.URL.Path)
+	name := strings.TrimPrefix(path, "/")
+	if "" == name {
+		name = "."
+	}
+
+	info, err := h.iofs.Stat(name)
+	if nil != err {
+		httpError(w, err)
+		return
+	}
+
+	if 0 != info.Mode()&fs.ModeSymlink {
+		errc, target := h.fs.Readlink(path)
+		if 0 != errc {
+			http.Error(w, "500 internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !strings.HasPrefix(target, "/") {
+			target = pathutil.Join(pathutil.Dir(path), target)
+		}
+		http.Redirect(w, r, (&url.URL{Path: target}).EscapedPath(), http.StatusFound)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, (&url.URL{Path: path + "/"}).EscapedPath(), http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(w, r, name)
+		return
+	}
+
+	f, err := h.iofs.Open(name)
+	if nil != err {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+
+	if errc, oid := h.fs.Getxattr(path, xattrOid); 0 == errc && 0 != len(oid) {
+		w.Header().Set("ETag", `"`+string(oid)+`"`)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f.(io.ReadSeeker))
+}
+
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := h.iofs.ReadDir(name)
+	if nil != err {
+		httpError(w, err)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		lst := make([]dirEntry, 0, len(entries))
+		for _, e := range entries {
+			info, err := e.Info()
+			if nil != err {
+				continue
+			}
+			lst = append(lst, dirEntry{
+				Name:  e.Name(),
+				Dir:   e.IsDir(),
+				Size:  info.Size(),
+				Mode:  info.Mode().String(),
+				Mtime: info.ModTime().UTC(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lst)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<title>%s</title>\n<pre>\n",
+		html.EscapeString(pathutil.Clean("/"+r.URL.Path)))
+	if "." != name {
+		fmt.Fprintf(w, "<a href=\"../\">../</a>\n")
+	}
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n",
+			html.EscapeString((&url.URL{Path: "./" + n}).EscapedPath()), html.EscapeString(n))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * httpfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package httpfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/prov/memprov"
+)
+
+func TestHandler(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("dir/file.txt", 0100644, []byte("hello\n"))
+	ref.AddFile("link", 0120000, []byte("dir/file.txt"))
+
+	h := New(hubfs.New(hubfs.Config{Client: client}).FileSystemInterface())
+	defer h.Close()
+
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; len(header) > i+1; i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/owner/repo/main/dir/file.txt")
+	if 200 != w.Code || "hello\n" != w.Body.String() ||
+		`"ce013625030ba8dba906f756967f9e9ca394464a"` != w.Header().Get("ETag") {
+		t.Error(w.Code, w.Body.String(), w.Header())
+	}
+
+	w = get("/owner/repo/main/dir/file.txt", "If-None-Match", w.Header().Get("ETag"))
+	if http.StatusNotModified != w.Code {
+		t.Error(w.Code)
+	}
+
+	w = get("/owner/repo/main/dir/file.txt", "Range", "bytes=1-2")
+	if http.StatusPartialContent != w.Code || "el" != w.Body.String() {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	w = get("/owner/repo/main/dir")
+	if http.StatusMovedPermanently != w.Code || "/owner/repo/main/dir/" != w.Header().Get("Location") {
+		t.Error(w.Code, w.Header())
+	}
+
+	w = get("/owner/repo/main/dir/")
+	if 200 != w.Code || !strings.Contains(w.Body.String(), `<a href="./file.txt">file.txt</a>`) {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	w = get("/owner/repo/main/", "Accept", "application/json")
+	var lst []dirEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &lst); nil != err ||
+		2 != len(lst) || "dir" != lst[0].Name || !lst[0].Dir || "link" != lst[1].Name {
+		t.Error(err, w.Body.String())
+	}
+
+	w = get("/owner/repo/main/link")
+	if http.StatusFound != w.Code || "/owner/repo/main/dir/file.txt" != w.Header().Get("Location") {
+		t.Error(w.Code, w.Header())
+	}
+
+	w = get("/owner/repo/main/nosuchfile")
+	if http.StatusNotFound != w.Code {
+		t.Error(w.Code)
+	}
+
+	req := httptest.NewRequest("PUT", "/owner/repo/main/dir/file.txt", strings.NewReader(""))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if http.StatusMethodNotAllowed != w.Code {
+		t.Error(w.Code)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * attrcache.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// attrTimeout is how long the attributes that are returned with directory entries are
+// kept. It only needs to cover the Getattr calls that follow a Readdir (e.g. ls -l).
+const attrTimeout = 5 * time.Second
+
+// attrcache keeps the attributes that Readdir returns with the directory entries, so that
+// the Getattr calls that follow do not open every entry through the provider again. This
+// matters when the FUSE layer does not use the attributes (or does not keep them long
+// enough) and asks for every entry after listing a large directory.
+//
+// Entries are kept in two generations: the current one receives new entries and the
+// previous one is dropped when the current one is older than the timeout. So an entry is
+// kept at least for the timeout and at most for twice the timeout.
+//
+// On a case-insensitive file system paths are keyed in upper case, so that a Getattr for
+// a path in a different case than the listing still finds the entry.
+type attrcache struct {
+	lock    sync.Mutex
+	timeout time.Duration
+	caseins bool
+	time    time.Time
+	curr    map[string]fuse.Stat_t
+	prev    map[string]fuse.Stat_t
+}
+
+func newAttrcache(timeout time.Duration, caseins bool) *attrcache {
+	return &attrcache{
+		timeout: timeout,
+		caseins: caseins,
+		time:    time.Now(),
+		curr:    make(map[string]fuse.Stat_t),
+	}
+}
+
+func (ac *attrcache) _rotate(now time.Time) {
+	if ac.timeout > now.Sub(ac.time) {
+		return
+	}
+	if 2*ac.timeout > now.Sub(ac.time) {
+		ac.prev = ac.curr
+	} else {
+		ac.prev = nil
+	}
+	ac.curr = make(map[string]fuse.Stat_t)
+	ac.time = now
+}
+
+func (ac *attrcache) key(path string) string {
+	if ac.caseins {
+		return strings.ToUpper(path)
+	}
+	return path
+}
+
+func (ac *attrcache) put(path string, stat *fuse.Stat_t) {
+	path = ac.key(path)
+	ac.lock.Lock()
+	ac._rotate(time.Now())
+	ac.curr[path] = *stat
+	ac.lock.Unlock()
+}
+
+func (ac *attrcache) get(path string, stat *fuse.Stat_t) (ok bool) {
+	path = ac.key(path)
+	ac.lock.Lock()
+	ac._rotate(time.Now())
+	var s fuse.Stat_t
+	if s, ok = ac.curr[path]; !ok {
+		s, ok = ac.prev[path]
+	}
+	ac.lock.Unlock()
+	if ok {
+		*stat = s
+	}
+	return
+}
+
+func (ac *attrcache) clear() {
+	ac.lock.Lock()
+	ac.curr = make(map[string]fuse.Stat_t)
+	ac.prev = nil
+	ac.lock.Unlock()
+}
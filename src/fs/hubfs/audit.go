@@ -0,0 +1,145 @@
+/*
+ * audit.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/util"
+)
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	bytes int64  // first field for 64-bit alignment of atomic operations
+	Time  string `json:"time"`
+	Op    string `json:"op"` // read or list
+	Path  string `json:"path"`
+	Uid   int64  `json:"uid"` // -1 if the caller is not known
+	Bytes int64  `json:"bytes"`
+}
+
+// auditfs appends a record to the audit log for every file that is read and every
+// directory that is listed. The record is written when the file or directory is closed,
+// so that it includes the number of bytes read; the time is the time of the open.
+//
+// The caller uid is only known when the operations come from a FUSE host (usefuse); the
+// file system servers (HTTP, WebDAV, NFS, 9P) call the file system outside of a FUSE
+// request, where there is no FUSE context.
+type auditfs struct {
+	wrapfs
+	fullpath func(path string) string
+	w        io.Writer
+	usefuse  bool
+	lock     sync.Mutex
+	openmap  map[uint64]*auditRecord
+}
+
+func newAuditfs(fs fuse.FileSystemInterface, fullpath func(path string) string,
+	w io.Writer) fuse.FileSystemInterface {
+	return &auditfs{
+		wrapfs:   newWrapfs(fs),
+		fullpath: fullpath,
+		w:        w,
+		openmap:  make(map[uint64]*auditRecord),
+	}
+}
+
+func (fs *auditfs) open(op string, path string, fh uint64) {
+	rec := &auditRecord{
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+		Op:   op,
+		Path: fs.fullpath(path),
+		Uid:  -1,
+	}
+	if fs.usefuse {
+		uid, _, _ := fuse.Getcontext()
+		rec.Uid = int64(uid)
+	}
+	fs.lock.Lock()
+	fs.openmap[fh] = rec
+	fs.lock.Unlock()
+}
+
+func (fs *auditfs) read(fh uint64, n int) {
+	if 0 >= n {
+		return
+	}
+	fs.lock.Lock()
+	rec := fs.openmap[fh]
+	fs.lock.Unlock()
+	if nil != rec {
+		atomic.AddInt64(&rec.bytes, int64(n))
+	}
+}
+
+func (fs *auditfs) release(fh uint64) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	rec := fs.openmap[fh]
+	if nil == rec {
+		return
+	}
+	delete(fs.openmap, fh)
+	rec.Bytes = atomic.LoadInt64(&rec.bytes)
+	line, _ := json.Marshal(rec)
+	_, err := fs.w.Write(append(line, '\n'))
+	if nil != err {
+		util.Log(util.LogWarn, "fs/hubfs", "audit log error", "error", err)
+	}
+}
+
+func (fs *auditfs) Open(path string, flags int) (errc int, fh uint64) {
+	errc, fh = fs.FileSystemInterface.Open(path, flags)
+	if 0 == errc {
+		fs.open("read", path, fh)
+	}
+	return
+}
+
+func (fs *auditfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	n = fs.FileSystemInterface.Read(path, buff, ofst, fh)
+	fs.read(fh, n)
+	return
+}
+
+func (fs *auditfs) Release(path string, fh uint64) (errc int) {
+	errc = fs.FileSystemInterface.Release(path, fh)
+	fs.release(fh)
+	return
+}
+
+func (fs *auditfs) Opendir(path string) (errc int, fh uint64) {
+	errc, fh = fs.FileSystemInterface.Opendir(path)
+	if 0 == errc {
+		fs.open("list", path, fh)
+	}
+	return
+}
+
+func (fs *auditfs) Releasedir(path string, fh uint64) (errc int) {
+	errc = fs.FileSystemInterface.Releasedir(path, fh)
+	fs.release(fh)
+	return
+}
+
+var _ fuse.FileSystemInterface = (*auditfs)(nil)
+var _ fuse.FileSystemGetpath = (*auditfs)(nil)
+var _ fuse.FileSystemChflags = (*auditfs)(nil)
+var _ fuse.FileSystemSetcrtime = (*auditfs)(nil)
+var _ fuse.FileSystemSetchgtime = (*auditfs)(nil)
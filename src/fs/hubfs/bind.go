@@ -0,0 +1,180 @@
+/*
+ * bind.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"context"
+	pathutil "path"
+	"strings"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/overlayfs"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// Bind is a directory at the mount root that presents the file system at a prefix. The
+// prefix (e.g. /owner/repository/ref/path) is relative to the Config prefix.
+type Bind struct {
+	Name   string
+	Prefix string
+}
+
+// newBindfs composes a file system from binds. The root contains a directory for every
+// bind and the control directory; the file system of a bind directory is created when
+// the directory is accessed, with the options of the Config and the prefix of the bind.
+func newBindfs(c Config) fuse.FileSystemInterface {
+	caseins := c.Caseins
+
+	topfs := new(Config{
+		Client:        c.Client,
+		Caseins:       c.Caseins,
+		Noindex:       c.Noindex,
+		Noappledouble: c.Noappledouble,
+		Provider:      c.Provider,
+		Uid:           c.Uid,
+		Gid:           c.Gid,
+		Fmask:         c.Fmask,
+		Dmask:         c.Dmask,
+		CacheQuota:    c.CacheQuota,
+		Timeout:       c.Timeout,
+		Unmount:       c.Unmount,
+		Reload:        c.Reload,
+		notify:        c.notify,
+	}).(*hubfs)
+	topfs.binds = c.Binds
+
+	find := func(name string) *Bind {
+		for i := range c.Binds {
+			if topfs.equal(c.Binds[i].Name, name) {
+				return &c.Binds[i]
+			}
+		}
+		return nil
+	}
+
+	split := func(path string) (string, string) {
+		name, remain := strings.TrimPrefix(path, "/"), "/"
+		if i := strings.IndexByte(name, '/'); -1 != i {
+			name, remain = name[:i], name[i:]
+		}
+		if nil == find(name) {
+			return "", path
+		}
+		return "/" + name, remain
+	}
+
+	newfs := func(prefix string) fuse.FileSystemInterface {
+		b := find(strings.TrimPrefix(prefix, "/"))
+		if nil == b {
+			return nil
+		}
+
+		bc := c
+		bc.Prefix = pathutil.Join("/", c.Prefix, b.Prefix)
+		if "/" == bc.Prefix {
+			bc.Prefix = ""
+		}
+		bc.Binds = nil
+		bc.Audit = nil
+		bc.Deny = nil
+		bc.control = topfs.control
+		bc.mounttime = topfs.mounttime
+		bc.bind = "/" + b.Name
+		bc.bindscope = bc.Prefix
+		return newfs(bc)
+	}
+
+	return overlayfs.New(overlayfs.Config{
+		Topfs:      topfs,
+		Split:      split,
+		Newfs:      newfs,
+		Caseins:    caseins,
+		TimeToLive: 1 * time.Second,
+	})
+}
+
+// bindpath returns a function that maps a path of a file system composed from binds to
+// its full path (/owner/repository/ref/path).
+func bindpath(c Config) func(path string) string {
+	return func(path string) string {
+		name, remain := strings.TrimPrefix(path, "/"), ""
+		if i := strings.IndexByte(name, '/'); -1 != i {
+			name, remain = name[:i], name[i:]
+		}
+		for _, b := range c.Binds {
+			if (c.Caseins && strings.EqualFold(b.Name, name)) || b.Name == name {
+				return pathutil.Join("/", c.Prefix, b.Prefix) + remain
+			}
+		}
+		return path
+	}
+}
+
+// mountpath returns the mount relative path of a full path (/owner/repository/ref/path).
+// A path above the scope of the file system (e.g. a ref whose tree contains the scope)
+// is the root of the file system.
+func (fs *hubfs) mountpath(path string) string {
+	scope, root := fs.control.scope, "/"
+	if "" != fs.bind {
+		scope, root = fs.bindscope, fs.bind
+	}
+	if len(scope) > len(path) && '/' == scope[len(path)] && fs.equal(scope[:len(path)], path) {
+		return root
+	}
+	return pathutil.Join(root, strings.TrimPrefix(path, scope))
+}
+
+// vbind is a bind directory at the root. Its content is presented by the file system of
+// the bind; the root file system only answers for the directory itself.
+type vbind struct {
+	name string
+	time time.Time
+}
+
+func (v *vbind) Name() string {
+	return v.name
+}
+
+func (v *vbind) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vbind) Size() int64 {
+	return 0
+}
+
+func (v *vbind) Target() string {
+	return ""
+}
+
+func (v *vbind) Time() time.Time {
+	return v.time
+}
+
+func (v *vbind) lookup(ctx context.Context, name string) (vnode, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (v *vbind) list(ctx context.Context) ([]vnode, error) {
+	return nil, nil
+}
+
+func (fs *hubfs) vbinds() []vnode {
+	lst := make([]vnode, len(fs.binds))
+	for i, b := range fs.binds {
+		lst[i] = &vbind{name: b.Name, time: fs.mounttime}
+	}
+	return lst
+}
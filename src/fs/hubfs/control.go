@@ -0,0 +1,274 @@
+/*
+ * control.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	pathutil "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/util"
+)
+
+// The control directory is found at the mount root and contains virtual files that
+// perform runtime operations when read or written.
+const controlName = ".hubfs"
+
+// control is shared by all hubfs instances of a mount (the top file system and the
+// file systems of the overlay shards).
+type control struct {
+	scope   string
+	unmount func()
+	reload  func() error
+	notify  func(path string)
+	lock    sync.Mutex
+	fslist  map[*hubfs]bool
+	refs    map[string]string
+}
+
+func newControl(scope string, unmount func(), reload func() error, notify func(path string)) *control {
+	return &control{
+		scope:   scope,
+		unmount: unmount,
+		reload:  reload,
+		notify:  notify,
+		fslist:  make(map[*hubfs]bool),
+		refs:    make(map[string]string),
+	}
+}
+
+func (ctl *control) register(fs *hubfs, add bool) {
+	ctl.lock.Lock()
+	if add {
+		ctl.fslist[fs] = true
+	} else {
+		delete(ctl.fslist, fs)
+	}
+	ctl.lock.Unlock()
+}
+
+// clearattrs drops the attributes that the file systems keep from their directory listings.
+func (ctl *control) clearattrs() {
+	ctl.lock.Lock()
+	for fs := range ctl.fslist {
+		fs.attrs.clear()
+	}
+	ctl.lock.Unlock()
+}
+
+// handles returns the mount relative paths of the open files and directories.
+func (ctl *control) handles() []string {
+	var res []string
+	ctl.lock.Lock()
+	for fs := range ctl.fslist {
+		fs.lock.RLock()
+		for _, obs := range fs.openmap {
+			res = append(res, fs.mountpath(pathutil.Join("/", fs.prefix, obs.path)))
+		}
+		fs.lock.RUnlock()
+	}
+	ctl.lock.Unlock()
+	sort.Strings(res)
+	return res
+}
+
+// seeref records the commit of the ref at a mount relative path. If the ref has moved
+// since it was last seen, the kernel caches of the ref directory are invalidated.
+func (ctl *control) seeref(path string, hash string) {
+	if nil == ctl.notify {
+		return
+	}
+	ctl.lock.Lock()
+	prev, ok := ctl.refs[path]
+	ctl.refs[path] = hash
+	ctl.lock.Unlock()
+	if ok && prev != hash {
+		tracef("path=%q %s->%s", path, prev, hash)
+		go ctl.notify(path)
+	}
+}
+
+// vcontrol is a control file. Its content is computed when the file is looked up;
+// writing to it performs the file's operation.
+type vcontrol struct {
+	name    string
+	content []byte
+	time    time.Time
+	write   func(data []byte) error
+}
+
+func (v *vcontrol) Name() string {
+	return v.name
+}
+
+func (v *vcontrol) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *vcontrol) Size() int64 {
+	return int64(len(v.content))
+}
+
+func (v *vcontrol) Target() string {
+	return ""
+}
+
+func (v *vcontrol) Time() time.Time {
+	return v.time
+}
+
+func (v *vcontrol) reader(ctx context.Context) (io.ReaderAt, error) {
+	return bytes.NewReader(v.content), nil
+}
+
+// vcontroldir is the control directory.
+type vcontroldir struct {
+	fs *hubfs
+}
+
+func (v *vcontroldir) Name() string {
+	return controlName
+}
+
+func (v *vcontroldir) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vcontroldir) Size() int64 {
+	return 0
+}
+
+func (v *vcontroldir) Target() string {
+	return ""
+}
+
+func (v *vcontroldir) Time() time.Time {
+	return time.Now()
+}
+
+func (v *vcontroldir) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, _ := v.list(ctx)
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vcontroldir) list(ctx context.Context) ([]vnode, error) {
+	fs := v.fs
+	now := time.Now()
+
+	var access bytes.Buffer
+	for _, d := range fs.client.GetAccessDiagnoses() {
+		fmt.Fprintf(&access, "%s %s %s: %s\n",
+			d.Time.Format(time.RFC3339), d.Repository, d.Reason, d.Message)
+	}
+
+	var ratelimit bytes.Buffer
+	if r := fs.client.GetRateLimit(); 0 != r.Limit {
+		fmt.Fprintf(&ratelimit, "limit %d\nremaining %d\nreset %s\n",
+			r.Limit, r.Remaining, r.Reset.Format(time.RFC3339))
+	}
+
+	var handles bytes.Buffer
+	for _, p := range fs.control.handles() {
+		fmt.Fprintf(&handles, "%s\n", p)
+	}
+
+	var ops bytes.Buffer
+	util.DumpOps(&ops)
+
+	lst := []vnode{
+		&vcontrol{name: "access", content: access.Bytes(), time: now},
+		&vcontrol{name: "cachepin", time: now, write: func(data []byte) error {
+			config := []string{}
+			for _, p := range strings.Split(string(data), "\n") {
+				if p = strings.TrimSpace(p); "" != p {
+					config = append(config, "config._cachepin="+p)
+				}
+			}
+			_, err := fs.client.SetConfig(config)
+			return err
+		}},
+		&vcontrol{name: "flush", time: now, write: func(data []byte) error {
+			fs.client.FlushCache()
+			fs.control.clearattrs()
+			return nil
+		}},
+		&vcontrol{name: "handles", content: handles.Bytes(), time: now},
+		&vcontrol{name: "loglevel", content: []byte(util.GetLogLevel() + "\n"), time: now,
+			write: func(data []byte) error {
+				return util.SetLogLevel(strings.TrimSpace(string(data)))
+			}},
+		&vcontrol{name: "ops", content: ops.Bytes(), time: now},
+		&vcontrol{name: "pin", time: now, write: func(data []byte) error {
+			config := []string{}
+			for _, p := range strings.Split(string(data), "\n") {
+				if p = strings.TrimSpace(p); "" != p {
+					config = append(config, "config._pin="+p)
+				}
+			}
+			_, err := fs.client.SetConfig(config)
+			return err
+		}},
+		&vcontrol{name: "prefetch", time: now, write: func(data []byte) error {
+			for _, p := range strings.Split(string(data), "\n") {
+				if p = strings.TrimSpace(p); "" != p {
+					go fs.prefetch("/" + strings.TrimPrefix(p, "/"))
+				}
+			}
+			return nil
+		}},
+		&vcontrol{name: "ratelimit", content: ratelimit.Bytes(), time: now},
+		&vcontrol{name: "token", time: now, write: func(data []byte) error {
+			ctx, cancel := fs.context("/" + controlName + "/token")
+			defer cancel()
+			return fs.client.SetToken(ctx, strings.TrimSpace(string(data)))
+		}},
+	}
+	if nil != fs.control.reload {
+		lst = append(lst, &vcontrol{name: "reload", time: now, write: func(data []byte) error {
+			return fs.control.reload()
+		}})
+	}
+	if nil != fs.control.unmount {
+		// Unmount once the write that requested it has completed.
+		lst = append(lst, &vcontrol{name: "unmount", time: now, write: func(data []byte) error {
+			go fs.control.unmount()
+			return nil
+		}})
+	}
+	return lst, nil
+}
+
+// prefetch opens a path in the background so that the repository content along the path
+// is fetched and cached. Directory content is listed as well.
+func (fs *hubfs) prefetch(path string) {
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	errc, obs := fs.open(ctx, path)
+	if 0 != errc {
+		tracef("path=%q errc=%d", path, errc)
+		return
+	}
+	if nil != obs.ref && (nil == obs.entry || fuse.S_IFDIR == obs.entry.Mode()&fuse.S_IFMT) {
+		obs.repository.GetTree(ctx, obs.ref, obs.entry)
+	}
+	fs.release(obs)
+}
@@ -0,0 +1,96 @@
+/*
+ * deny.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	pathutil "path"
+	"strings"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// denyfs fails the opening of files whose paths match any of a list of patterns with
+// EACCES. Patterns are matched against the full path of a file without the leading slash
+// (owner/repository/ref/path). A "**" component matches any number of path components;
+// the other components are matched with path.Match.
+type denyfs struct {
+	wrapfs
+	fullpath func(path string) string
+	caseins  bool
+	patterns [][]string
+}
+
+func newDenyfs(fs fuse.FileSystemInterface, fullpath func(path string) string, caseins bool,
+	patterns []string) fuse.FileSystemInterface {
+	d := &denyfs{
+		wrapfs:   newWrapfs(fs),
+		fullpath: fullpath,
+		caseins:  caseins,
+	}
+	for _, p := range patterns {
+		if caseins {
+			p = strings.ToUpper(p)
+		}
+		d.patterns = append(d.patterns, strings.Split(strings.Trim(p, "/"), "/"))
+	}
+	return d
+}
+
+func (fs *denyfs) denied(path string) bool {
+	path = strings.Trim(fs.fullpath(path), "/")
+	if fs.caseins {
+		path = strings.ToUpper(path)
+	}
+	comps := strings.Split(path, "/")
+	for _, p := range fs.patterns {
+		if matchComponents(p, comps) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchComponents(patt []string, comps []string) bool {
+	if 0 == len(patt) {
+		return 0 == len(comps)
+	}
+	if "**" == patt[0] {
+		for i := 0; len(comps) >= i; i++ {
+			if matchComponents(patt[1:], comps[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if 0 == len(comps) {
+		return false
+	}
+	if m, _ := pathutil.Match(patt[0], comps[0]); !m {
+		return false
+	}
+	return matchComponents(patt[1:], comps[1:])
+}
+
+func (fs *denyfs) Open(path string, flags int) (errc int, fh uint64) {
+	if fs.denied(path) {
+		return -fuse.EACCES, ^uint64(0)
+	}
+	return fs.FileSystemInterface.Open(path, flags)
+}
+
+var _ fuse.FileSystemInterface = (*denyfs)(nil)
+var _ fuse.FileSystemGetpath = (*denyfs)(nil)
+var _ fuse.FileSystemChflags = (*denyfs)(nil)
+var _ fuse.FileSystemSetcrtime = (*denyfs)(nil)
+var _ fuse.FileSystemSetchgtime = (*denyfs)(nil)
@@ -14,53 +14,372 @@
 package hubfs
 
 import (
+	"context"
+	"errors"
 	"io"
+	"os"
 	pathutil "path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	libtrace "github.com/billziss-gh/golib/trace"
 	"github.com/winfsp/cgofuse/fuse"
 	"github.com/winfsp/hubfs/fs/port"
+	"github.com/winfsp/hubfs/httputil"
+	"github.com/winfsp/hubfs/otlp"
 	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+const (
+	statfsInterval    = 10 * time.Second
+	interruptInterval = 100 * time.Millisecond
+	drainTimeout      = 10 * time.Second
+	drainInterval     = 10 * time.Millisecond
 )
 
 type hubfs struct {
 	fuse.FileSystemBase
-	client  prov.Client
-	prefix  string
-	lock    sync.RWMutex
-	fh      uint64
-	openmap map[uint64]*obstack
+	inflight   int64 // first field for 64-bit alignment of atomic operations
+	client     prov.Client
+	prefix     string
+	caseins    bool
+	unorm      func(string) string
+	treetime   string
+	ignoreattr bool
+	noindex    bool
+	noapple    bool
+	mounttime  time.Time
+	nestedrefs bool
+	latest     bool
+	submodules bool
+	info       bool
+	releases   bool
+	issues     bool
+	pulls      bool
+	diff       bool
+	objects    bool
+	actions    bool
+	wiki       bool
+	gists      bool
+	starred    bool
+	collab     bool
+	search     bool
+	notes      bool
+	log        bool
+	archive    bool
+	blame      bool
+	history    int
+	provider   string
+	uid        uint32
+	gid        uint32
+	fmask      uint32
+	dmask      uint32
+	cachequota func() int64
+	cachelock  sync.Mutex // guards cacheuse and cachetime; not fs.lock, which guards openmap
+	cacheuse   int64
+	cachetime  time.Time
+	timeout    time.Duration
+	control    *control
+	controlidx int
+	binds      []Bind
+	bind       string
+	bindscope  string
+	attrs      *attrcache
+	blames     *util.ByteCache // formatted blames by ref hash and path
+	ctx        context.Context
+	cancel     context.CancelFunc
+	lock       sync.RWMutex
+	fh         uint64
+	openmap    map[uint64]*obstack
 }
 
 type obstack struct {
+	parent     *obstack
+	path       string
+	rootidx    int
+	gists      bool
 	owner      prov.Owner
 	repository prov.Repository
+	refpath    string
 	ref        prov.Ref
 	entry      prov.TreeEntry
+	vnode      vnode
 	reader     io.ReaderAt
+	dirlist    *dirlist
+}
+
+type dirent struct {
+	name string
+	stat *fuse.Stat_t
+}
+
+// dirlist is the listing of an open directory. It is filled in the background, so that
+// the first entries of a large directory can be returned while the rest are listed.
+type dirlist struct {
+	lock    sync.Mutex
+	entries []dirent
+	done    bool
+	wait    chan struct{}
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+func (l *dirlist) add(name string, stat *fuse.Stat_t) {
+	e := dirent{name: name}
+	if nil != stat {
+		s := *stat
+		e.stat = &s
+	}
+	l.lock.Lock()
+	l.entries = append(l.entries, e)
+	if nil != l.wait {
+		close(l.wait)
+		l.wait = nil
+	}
+	l.lock.Unlock()
+}
+
+func (l *dirlist) finish() {
+	l.lock.Lock()
+	l.done = true
+	if nil != l.wait {
+		close(l.wait)
+		l.wait = nil
+	}
+	l.lock.Unlock()
+	close(l.stopped)
+}
+
+// get returns the entry at an index. If the entry has not been listed yet, it returns a
+// channel that is closed when more entries have been listed.
+func (l *dirlist) get(i int64) (e *dirent, wait chan struct{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if int64(len(l.entries)) > i {
+		return &l.entries[i], nil
+	}
+	if l.done {
+		return nil, nil
+	}
+	if nil == l.wait {
+		l.wait = make(chan struct{})
+	}
+	return nil, l.wait
+}
+
+func (l *dirlist) stop() {
+	l.cancel()
+	<-l.stopped
 }
 
 type Config struct {
-	Client  prov.Client
-	Prefix  string
-	Caseins bool
-	Overlay bool
+	Client        prov.Client
+	Prefix        string
+	Caseins       bool
+	Unorm         func(string) string // unicode normalization of listed names (e.g. util.NFD)
+	Treetime      string              // file time source: "commit" (default), "author" or "mount"
+	Noindex       bool                // present .metadata_never_index at the root (Spotlight)
+	Noappledouble bool                // do not look up Finder metadata names (.DS_Store, ._*)
+	Overlay       bool
+	Readonly      bool
+	Ignoreattr    bool // Utimens, Chown and Chflags succeed without changing anything
+	Nestedrefs    bool
+	Latest        bool
+	Submodules    bool
+	Info          bool
+	Releases      bool
+	Issues        bool
+	Pulls         bool
+	Diff          bool
+	Objects       bool
+	Actions       bool
+	Wiki          bool
+	Gists         bool
+	Starred       bool
+	Collab        bool
+	Search        bool
+	Notifications bool
+	Log           bool
+	Archive       bool
+	Blame         bool
+	History       int // number of recent commits in @history (0: no @history)
+	Provider      string
+	Uid           uint32
+	Gid           uint32
+	Fmask         uint32 // permission bits cleared from file modes
+	Dmask         uint32 // permission bits cleared from directory modes
+	CacheQuota    func() int64
+	Timeout       time.Duration
+	Audit         io.Writer // audit log of files read and directories listed
+	Deny          []string  // path patterns of files that cannot be opened (e.g. **/*.pem)
+	Binds         []Bind    // directories at the root that present other prefixes
+	Unmount       func()
+	Reload        func() error
+	notify        func(path string)
+	control       *control
+	mounttime     time.Time
+	bind          string // mount relative path of the bind of the file system
+	bindscope     string
 }
 
 func new(c Config) fuse.FileSystemInterface {
-	return &hubfs{
-		client:  c.Client,
-		prefix:  c.Prefix,
-		openmap: make(map[uint64]*obstack),
+	// The file system that is created without a control is the one at the mount root.
+	control, controlidx := c.control, -1
+	if nil == control {
+		control, controlidx = newControl(c.Prefix, c.Unmount, c.Reload, c.notify), len(split(c.Prefix))
+	}
+	mounttime := c.mounttime
+	if mounttime.IsZero() {
+		mounttime = time.Now()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := &hubfs{
+		client:     c.Client,
+		prefix:     c.Prefix,
+		caseins:    c.Caseins,
+		unorm:      c.Unorm,
+		treetime:   c.Treetime,
+		ignoreattr: c.Ignoreattr,
+		noindex:    c.Noindex,
+		noapple:    c.Noappledouble,
+		mounttime:  mounttime,
+		nestedrefs: c.Nestedrefs,
+		latest:     c.Latest,
+		submodules: c.Submodules,
+		info:       c.Info,
+		releases:   c.Releases,
+		issues:     c.Issues,
+		pulls:      c.Pulls,
+		diff:       c.Diff,
+		objects:    c.Objects,
+		actions:    c.Actions,
+		wiki:       c.Wiki,
+		gists:      c.Gists,
+		starred:    c.Starred,
+		collab:     c.Collab,
+		search:     c.Search,
+		notes:      c.Notifications,
+		log:        c.Log,
+		archive:    c.Archive,
+		blame:      c.Blame,
+		history:    c.History,
+		provider:   c.Provider,
+		uid:        c.Uid,
+		gid:        c.Gid,
+		fmask:      c.Fmask,
+		dmask:      c.Dmask,
+		cachequota: c.CacheQuota,
+		timeout:    c.Timeout,
+		control:    control,
+		controlidx: controlidx,
+		bind:       c.bind,
+		bindscope:  c.bindscope,
+		attrs:      newAttrcache(attrTimeout, c.Caseins),
+		blames:     util.NewByteCache(blameCacheSize),
+		ctx:        ctx,
+		cancel:     cancel,
+		openmap:    make(map[uint64]*obstack),
+	}
+	control.register(fs, true)
+	return fs
+}
+
+// context returns the context for a single file system operation. The context is
+// cancelled when the operation times out or when the file system is destroyed. The
+// operation is in flight until the returned cancel function is called; the operation
+// is logged if it was slow.
+func (fs *hubfs) context(path string) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if 0 < fs.timeout {
+		ctx, cancel = context.WithTimeout(fs.ctx, fs.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(fs.ctx)
+	}
+	ctx, done := util.StartOp(ctx, 1, "fs/hubfs", "path", pathutil.Join("/", fs.prefix, path))
+	atomic.AddInt64(&fs.inflight, 1)
+	once := sync.Once{}
+	return ctx, func() {
+		cancel()
+		once.Do(func() {
+			atomic.AddInt64(&fs.inflight, -1)
+			done()
+		})
 	}
 }
 
-func (fs *hubfs) openex(path string, norm bool) (errc int, res *obstack, lst []string) {
+// interruptible runs fn in a separate goroutine, while the goroutine that services the
+// FUSE operation polls for an interrupt; if one is detected the operation is cancelled.
+func interruptible(cancel context.CancelFunc, fn func()) {
+	if !port.Interruptible {
+		fn()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	ticker := time.NewTicker(interruptInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if port.Interrupted() {
+				cancel()
+				<-done
+				return
+			}
+		}
+	}
+}
+
+// Destroy waits (for a while) for the operations in flight to complete, cancels the
+// remaining ones and closes the open files and directories.
+func (fs *hubfs) Destroy() {
+	fs.control.register(fs, false)
+	fs.drain(drainTimeout)
+	fs.cancel()
+
+	fs.lock.Lock()
+	openmap := fs.openmap
+	fs.openmap = make(map[uint64]*obstack)
+	fs.lock.Unlock()
+	for _, obs := range openmap {
+		if closer, ok := obs.reader.(io.Closer); ok {
+			closer.Close()
+		}
+		fs.release(obs)
+	}
+}
+
+// drain waits until there are no operations in flight or until the timeout expires.
+func (fs *hubfs) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for 0 < atomic.LoadInt64(&fs.inflight) {
+		if time.Now().After(deadline) {
+			tracef("inflight=%d", atomic.LoadInt64(&fs.inflight))
+			return false
+		}
+		time.Sleep(drainInterval)
+	}
+	return true
+}
+
+func (fs *hubfs) openex(ctx context.Context, path string, norm bool) (
+	errc int, res *obstack, lst []string) {
+	ctx, span := otlp.Start(ctx, "openex", otlp.KindInternal, "path", path)
+	defer func() { endSpan(span, errc) }()
+
 	if strings.HasSuffix(path, "/.") {
 		errc = -fuse.ENOENT
 		return
@@ -70,37 +389,151 @@ func (fs *hubfs) openex(path string, norm bool) (errc int, res *obstack, lst []s
 	obs := &obstack{}
 	var err error
 	for i, c := range lst {
-		switch i {
-		case 0:
+		switch {
+		case nil != obs.vnode:
+			if _, ok := obs.vnode.(*vhistory); ok {
+				err = fs.enterhistory(ctx, obs, c)
+				if norm && nil == err {
+					lst[i] = obs.ref.Name()
+				}
+				break
+			}
+			obs.vnode, err = vlookup(ctx, obs.vnode, c)
+			if norm && nil == err {
+				lst[i] = obs.vnode.Name()
+			}
+		case fs.controlidx == i && fs.equal(controlName, c):
+			obs.vnode = &vcontroldir{fs: fs}
+			if norm {
+				lst[i] = controlName
+			}
+		case fs.controlidx == i && fs.noindex && fs.equal(noindexName, c):
+			obs.vnode = &vfile{name: noindexName, time: fs.mounttime}
+			if norm {
+				lst[i] = noindexName
+			}
+		case fs.noapple && nil == obs.ref && isAppleName(c):
+			// Finder probes every directory it visits for these names. Outside of
+			// the ref trees each lookup is a provider request, so answer right away.
+			err = prov.ErrNotFound
+		case 0 == i && nil != fs.binds:
+			obs.vnode, err = fs.vfind(fs.vbinds(), c)
+			if norm && nil == err {
+				lst[i] = obs.vnode.Name()
+			}
+		case 0 == i && fs.gists && fs.equal("@gists", c):
+			obs.gists = true
+			if norm {
+				lst[i] = "@gists"
+			}
+		case 0 == i && fs.starred && fs.equal("@starred", c):
+			obs.vnode = &vstarred{fs: fs, time: time.Now()}
+			if norm {
+				lst[i] = "@starred"
+			}
+		case 0 == i && fs.collab && fs.equal("@collab", c):
+			obs.vnode = &vcollab{fs: fs, time: time.Now()}
+			if norm {
+				lst[i] = "@collab"
+			}
+		case 0 == i && fs.search && fs.equal("@search", c):
+			obs.vnode = &vsearch{fs: fs, time: time.Now()}
+			if norm {
+				lst[i] = "@search"
+			}
+		case 0 == i && fs.notes && fs.equal("@notifications", c):
+			obs.vnode = &vnotifications{fs: fs, time: time.Now()}
+			if norm {
+				lst[i] = "@notifications"
+			}
+		case 1 == i && obs.gists:
+			obs.owner, err = fs.client.OpenGistOwner(ctx, c)
+			if norm && nil == err {
+				lst[i] = obs.owner.Name()
+			}
+		case 2 == i && obs.gists:
+			obs.repository, err = fs.client.OpenRepository(ctx, obs.owner, c)
+			if nil == err {
+				obs.ref, err = obs.repository.GetDefaultRef(ctx)
+			}
+			if nil == err {
+				obs.refpath = obs.ref.Name()
+				obs.rootidx = i + 1
+			}
+			if norm && nil == err {
+				lst[i] = obs.repository.Name()
+			}
+		case 0 == i:
 			// We disallow some names to speed up operations:
 			//
 			// - All names containing dots: e.g. ".git", ".DS_Store", "autorun.inf"
 			// - The special git name HEAD
-			if -1 != strings.IndexFunc(c, func(r rune) bool { return '.' == r }) || "HEAD" == c {
+			if -1 != strings.IndexFunc(c, func(r rune) bool { return '.' == r }) || fs.equal("HEAD", c) {
 				obs.owner, err = nil, prov.ErrNotFound
 			} else {
-				obs.owner, err = fs.client.OpenOwner(c)
+				obs.owner, err = fs.client.OpenOwner(ctx, c)
 				if norm && nil == err {
 					lst[i] = obs.owner.Name()
 				}
 			}
-		case 1:
-			obs.repository, err = fs.client.OpenRepository(obs.owner, c)
+		case 1 == i:
+			obs.repository, err = fs.client.OpenRepository(ctx, obs.owner, c)
 			if norm && nil == err {
 				lst[i] = obs.repository.Name()
 			}
-		case 2:
-			obs.ref, err = obs.repository.GetRef(c)
-			if prov.ErrNotFound == err {
-				obs.ref, err = obs.repository.GetTempRef(c)
+		case 2 == i && fs.wiki && fs.equal("@wiki", c):
+			err = fs.enterwiki(ctx, obs)
+			if norm && nil == err {
+				lst[i] = "@wiki"
 			}
+		case 2 == i && fs.isrepovirtual(c):
+			obs.vnode, err = fs.repovirtual(ctx, obs, c)
 			if norm && nil == err {
-				lst[i] = obs.ref.Name()
+				lst[i] = obs.vnode.Name()
+			}
+		case 2 == i:
+			if fs.nestedrefs {
+				lst[i], err = fs.opennested(ctx, obs, c)
+				break
+			}
+			err = fs.unormlookup(c, func(n string) (err error) {
+				obs.ref, err = obs.repository.GetRef(ctx, n)
+				return
+			})
+			if nil == err {
+				fs.seeref(obs)
+			} else if prov.ErrNotFound == err {
+				obs.ref, err = obs.repository.GetTempRef(ctx, c)
+			}
+			if nil == err {
+				obs.refpath = obs.ref.Name()
+				obs.rootidx = i + 1
+			}
+			if norm && nil == err {
+				lst[i] = fs.normname(obs.ref.Name())
 			}
 		default:
-			obs.entry, err = obs.repository.GetTreeEntry(obs.ref, obs.entry, c)
+			if nil == obs.ref {
+				lst[i], err = fs.opennested(ctx, obs, c)
+				break
+			}
+			if nil == obs.entry && obs.rootidx == i && fs.isrefvirtual(c) {
+				obs.vnode, err = fs.refvirtual(ctx, obs, c)
+				if norm && nil == err {
+					lst[i] = obs.vnode.Name()
+				}
+				break
+			}
+			parent := obs.entry
+			err = fs.unormlookup(c, func(n string) (err error) {
+				obs.entry, err = obs.repository.GetTreeEntry(ctx, obs.ref, parent, n)
+				return
+			})
 			if norm && nil == err {
-				lst[i] = obs.entry.Name()
+				lst[i] = fs.normname(obs.entry.Name())
+			}
+			if fs.submodules && nil == err && 0160000 == obs.entry.Mode() {
+				fs.entermodule(ctx, obs, strings.Join(lst[obs.rootidx:i+1], "/"))
 			}
 		}
 		if nil != err {
@@ -113,26 +546,247 @@ func (fs *hubfs) openex(path string, norm bool) (errc int, res *obstack, lst []s
 	return
 }
 
-func (fs *hubfs) open(path string) (errc int, res *obstack) {
-	errc, res, _ = fs.openex(path, false)
+// opennested resolves one component of a nested ref path (e.g. heads/release/1.x).
+// Intermediate components are recorded in obs.refpath until a full ref is found.
+func (fs *hubfs) opennested(ctx context.Context, obs *obstack, c string) (normc string, err error) {
+	if "" == obs.refpath && fs.equal("commits", c) {
+		obs.refpath = "commits"
+		return obs.refpath, nil
+	} else if "commits" == obs.refpath {
+		obs.ref, err = obs.repository.GetTempRef(ctx, c)
+		if nil != err {
+			return c, err
+		}
+		obs.refpath = "commits/" + obs.ref.Name()
+		obs.rootidx = 4
+		return obs.ref.Name(), nil
+	}
+
+	refpath := c
+	if "" != obs.refpath {
+		refpath = obs.refpath + "/" + c
+	}
+
+	obs.ref, err = obs.repository.GetRef(ctx, refpath)
+	if nil == err {
+		fs.seeref(obs)
+		obs.refpath = obs.ref.Name()
+		obs.rootidx = 3 + strings.Count(obs.refpath, "/")
+		return pathutil.Base(obs.refpath), nil
+	} else if prov.ErrNotFound != err {
+		return c, err
+	}
+
+	lst, err := obs.repository.GetRefs(ctx)
+	if nil != err {
+		return c, err
+	}
+	// Refs may differ only by case in the path components; the least name wins,
+	// as in fillnested.
+	match := ""
+	for _, elm := range lst {
+		n := elm.Name()
+		if len(n) > len(refpath) && '/' == n[len(refpath)] && fs.equal(n[:len(refpath)], refpath) {
+			if "" == match || n[:len(refpath)] < match {
+				match = n[:len(refpath)]
+			}
+		}
+	}
+	if "" == match {
+		return c, prov.ErrNotFound
+	}
+	obs.refpath = match
+	return pathutil.Base(obs.refpath), nil
+}
+
+// seeref records the commit of the ref that has been opened in obs.
+func (fs *hubfs) seeref(obs *obstack) {
+	path := pathutil.Join("/", obs.owner.Name(), obs.repository.Name(), obs.ref.Name())
+	fs.control.seeref(fs.mountpath(path), obs.ref.Hash())
+}
+
+func (fs *hubfs) equal(s, t string) bool {
+	if fs.caseins {
+		return strings.EqualFold(s, t)
+	}
+	return s == t
+}
+
+// normname returns a name in the normalization form of the file system.
+func (fs *hubfs) normname(name string) string {
+	if nil != fs.unorm {
+		return fs.unorm(name)
+	}
+	return name
+}
+
+// unormlookup looks up a name as is and, if it is not found, in its other normalization
+// forms; git keeps names in the form in which they were committed.
+func (fs *hubfs) unormlookup(name string, lookup func(n string) error) error {
+	err := lookup(name)
+	if nil == fs.unorm || prov.ErrNotFound != err {
+		return err
+	}
+	for _, n := range []string{util.NFC(name), util.NFD(name)} {
+		if n != name {
+			if err = lookup(n); prov.ErrNotFound != err {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// trimprefix removes the file system prefix from a path that starts with it.
+func (fs *hubfs) trimprefix(path string) string {
+	if len(path) >= len(fs.prefix) && fs.equal(path[:len(fs.prefix)], fs.prefix) {
+		return path[len(fs.prefix):]
+	}
+	return path
+}
+
+// refdepth returns the number of path components occupied by the ref in path;
+// it returns 0 if path does not reach a ref and an error code if path cannot be resolved.
+func (fs *hubfs) refdepth(path string) (depth int, errc int) {
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	lst := split(pathutil.Join(fs.prefix, path))
+	if 3 > len(lst) {
+		return 0, 0
+	}
+	if !fs.nestedrefs {
+		return 1, 0
+	}
+
+	/* open the repository directly: lst includes the prefix, which openex would add again */
+	obs := &obstack{}
+	var err error
+	obs.owner, err = fs.client.OpenOwner(ctx, lst[0])
+	if nil == err {
+		obs.repository, err = fs.client.OpenRepository(ctx, obs.owner, lst[1])
+	}
+	defer fs.release(obs)
+	if nil != err {
+		return 0, fuseErrc(err)
+	}
+
+	for i := 2; len(lst) > i; i++ {
+		if _, err := fs.opennested(ctx, obs, lst[i]); nil != err {
+			return 0, fuseErrc(err)
+		}
+		if nil != obs.ref {
+			return i - 1, 0
+		}
+	}
+	return 0, 0
+}
+
+func (fs *hubfs) open(ctx context.Context, path string) (errc int, res *obstack) {
+	errc, res, _ = fs.openex(ctx, path, false)
+	return
+}
+
+// entermodule replaces the submodule entry at the top of the obstack with the root of
+// the submodule repository at the commit recorded in the submodule entry. If the
+// submodule cannot be entered the entry is left as is and appears as an empty directory.
+func (fs *hubfs) entermodule(ctx context.Context, obs *obstack, path string) {
+	module, err := obs.repository.GetModule(ctx, obs.ref, path, true)
+	if nil != err {
+		tracef("repo=%#v Getmodule(ref=%#v, %#v) = %v",
+			obs.repository.Name(), obs.ref.Name(), path, err)
+		return
+	}
+
+	lst := split(module)
+	if 2 != len(lst) {
+		return
+	}
+
+	sub := &obstack{}
+	sub.owner, err = fs.client.OpenOwner(ctx, lst[0])
+	if nil == err {
+		sub.repository, err = fs.client.OpenRepository(ctx, sub.owner, lst[1])
+	}
+	if nil == err {
+		sub.ref, err = sub.repository.GetTempRef(ctx, obs.entry.Hash())
+	}
+	if nil != err {
+		tracef("repo=%#v module=%#v = %v", obs.repository.Name(), module, err)
+		fs.release(sub)
+		return
+	}
+
+	parent := *obs
+	*obs = obstack{
+		parent:     &parent,
+		rootidx:    len(split(path)) + obs.rootidx,
+		owner:      sub.owner,
+		repository: sub.repository,
+		refpath:    obs.refpath,
+		ref:        sub.ref,
+	}
+}
+
+// enterwiki replaces the repository on the obstack with its wiki repository at the
+// wiki default ref. The original obstack is kept as the parent.
+func (fs *hubfs) enterwiki(ctx context.Context, obs *obstack) (err error) {
+	wiki, err := obs.repository.GetWiki(ctx)
+	if nil != err {
+		return
+	}
+	ref, err := wiki.GetDefaultRef(ctx)
+	if nil != err {
+		return
+	}
+
+	parent := *obs
+	*obs = obstack{
+		parent:     &parent,
+		rootidx:    3,
+		repository: wiki,
+		refpath:    ref.Name(),
+		ref:        ref,
+	}
+	return
+}
+
+// enterhistory opens a commit of the @history directory as a ref, so that its tree can
+// be browsed like the tree of any other ref.
+func (fs *hubfs) enterhistory(ctx context.Context, obs *obstack, c string) (err error) {
+	obs.ref, err = obs.repository.GetTempRef(ctx, c)
+	if nil != err {
+		return
+	}
+	obs.vnode = nil
+	obs.refpath = "@history/" + obs.ref.Name()
+	obs.rootidx = 4
 	return
 }
 
 func (fs *hubfs) release(obs *obstack) {
-	if nil != obs.repository {
+	// A repository without an owner (e.g. a wiki) belongs to the parent repository.
+	if nil != obs.repository && nil != obs.owner {
 		fs.client.CloseRepository(obs.repository)
 	}
 	if nil != obs.owner {
 		fs.client.CloseOwner(obs.owner)
 	}
+	if nil != obs.parent {
+		fs.release(obs.parent)
+	}
 }
 
-func (fs *hubfs) getattr(obs *obstack, entry prov.TreeEntry, path string, stat *fuse.Stat_t) (
-	target string) {
+func (fs *hubfs) getattr(ctx context.Context,
+	obs *obstack, entry prov.TreeEntry, path string, stat *fuse.Stat_t) (target string) {
 
 	if nil != entry {
 		mode := entry.Mode()
-		fuseStat(stat, mode, entry.Size(), obs.ref.TreeTime())
+		if fs.submodules && 0160000 == mode {
+			fs.fuseStat(stat, fuse.S_IFDIR, 0, obs.ref.TreeTime())
+			return
+		}
+		fs.fuseStat(stat, mode, entry.Size(), obs.ref.TreeTime())
 		switch mode & fuse.S_IFMT {
 		case fuse.S_IFLNK:
 			target = entry.Target()
@@ -140,16 +794,21 @@ func (fs *hubfs) getattr(obs *obstack, entry prov.TreeEntry, path string, stat *
 		case 0160000 /* submodule */ :
 			path = pathutil.Join(fs.prefix, path)
 			target = entry.Target()
-			remain := repoPath(path)
-			module, err := obs.repository.GetModule(obs.ref, remain, true)
+			remain := repoPath(path, 1+strings.Count(obs.refpath, "/"))
+			module, err := obs.repository.GetModule(ctx, obs.ref, remain, true)
 			if "" != module {
-				if t, e := filepath.Rel(pathutil.Dir(path), module+"/"+entry.Target()); nil == e {
+				commit := entry.Target()
+				if fs.nestedrefs {
+					// commits are only found in the commits directory of nested refs
+					commit = "commits/" + commit
+				}
+				if t, e := filepath.Rel(pathutil.Dir(path), module+"/"+commit); nil == e {
 					if "windows" == runtime.GOOS {
 						t = strings.ReplaceAll(t, `\`, `/`)
 					}
 					target = t
 				} else {
-					target = strings.TrimPrefix(module, fs.prefix) + "/" + entry.Target()
+					target = fs.trimprefix(module) + "/" + commit
 				}
 			} else {
 				tracef("repo=%#v Getmodule(ref=%#v, %#v) = %v",
@@ -158,25 +817,33 @@ func (fs *hubfs) getattr(obs *obstack, entry prov.TreeEntry, path string, stat *
 			stat.Size = int64(len(target))
 		}
 	} else {
-		fuseStat(stat, fuse.S_IFDIR, 0, time.Now())
+		fs.fuseStat(stat, fuse.S_IFDIR, 0, fs.dirtime(obs))
 	}
 
 	return
 }
 
+func (fs *hubfs) vgetattr(v vnode, stat *fuse.Stat_t) (target string) {
+	fs.fuseStat(stat, v.Mode(), v.Size(), v.Time())
+	return v.Target()
+}
+
 func (fs *hubfs) Getpath(path string, fh uint64) (errc int, normpath string) {
 	defer trace(path, fh)(&errc, &normpath)
 
-	errc0, obs, pathlst := fs.openex(path, true)
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	errc0, obs, pathlst := fs.openex(ctx, path, true)
 	if 0 == errc0 {
 		fs.release(obs)
 	}
 
-	normpath = "/" + pathutil.Join(pathlst...)
-	normpath = strings.TrimPrefix(normpath, fs.prefix)
-	if "" == normpath {
-		normpath = "/"
+	// The prefix components are dropped by count, because they are normalized too.
+	if n := len(split(fs.prefix)); len(pathlst) >= n {
+		pathlst = pathlst[n:]
 	}
+	normpath = "/" + pathutil.Join(pathlst...)
 
 	return
 }
@@ -184,28 +851,76 @@ func (fs *hubfs) Getpath(path string, fh uint64) (errc int, normpath string) {
 func (fs *hubfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc, stat)
 
-	errc, obs := fs.open(path)
+	if fs.attrs.get(path, stat) {
+		return
+	}
+
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	var obs *obstack
+	interruptible(cancel, func() { errc, obs = fs.open(ctx, path) })
+	if 0 != errc {
+		return
+	}
+
+	if nil != obs.vnode {
+		fs.vgetattr(obs.vnode, stat)
+	} else {
+		fs.getattr(ctx, obs, obs.entry, path, stat)
+	}
+
+	fs.release(obs)
+
+	return
+}
+
+// Access checks permissions for the user and primary group of the caller. It is only
+// used when the kernel does not check permissions (no default_permissions).
+func (fs *hubfs) Access(path string, mask uint32) (errc int) {
+	defer trace(path, mask)(&errc)
+
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	var obs *obstack
+	interruptible(cancel, func() { errc, obs = fs.open(ctx, path) })
 	if 0 != errc {
 		return
 	}
 
-	fs.getattr(obs, obs.entry, path, stat)
+	stat := fuse.Stat_t{}
+	if nil != obs.vnode {
+		fs.vgetattr(obs.vnode, &stat)
+	} else {
+		fs.getattr(ctx, obs, obs.entry, path, &stat)
+	}
 
 	fs.release(obs)
 
+	uid, gid, _ := fuse.Getcontext()
+	errc = access(&stat, mask, uid, gid)
+
 	return
 }
 
 func (fs *hubfs) Readlink(path string) (errc int, target string) {
 	defer trace(path)(&errc, &target)
 
-	errc, obs := fs.open(path)
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	errc, obs := fs.open(ctx, path)
 	if 0 != errc {
 		return
 	}
 
 	stat := fuse.Stat_t{}
-	target = fs.getattr(obs, obs.entry, path, &stat)
+	if nil != obs.vnode {
+		target = fs.vgetattr(obs.vnode, &stat)
+	} else {
+		target = fs.getattr(ctx, obs, obs.entry, path, &stat)
+	}
 	if "" == target {
 		errc = -fuse.EINVAL
 	}
@@ -215,14 +930,108 @@ func (fs *hubfs) Readlink(path string) (errc int, target string) {
 	return
 }
 
+// xattrs returns the extended attributes that describe the git object (or virtual node)
+// at the top of the obstack. Attribute names are returned in a stable order. Values are
+// computed when they are read, since some of them need a provider request.
+func (fs *hubfs) xattrs(ctx context.Context, obs *obstack) (names []string, values []func() string) {
+	add := func(name, value string) {
+		if "" != value {
+			names = append(names, name)
+			values = append(values, func() string { return value })
+		}
+	}
+	if nil != obs.vnode {
+		if x, ok := obs.vnode.(vxattrer); ok {
+			x.xattrs(add)
+		}
+		return
+	}
+	add("user.hubfs.provider", fs.provider)
+	if nil != obs.ref {
+		add("user.hubfs.ref", obs.refpath)
+		add("user.hubfs.commit", obs.ref.Hash())
+		if nil == obs.entry {
+			names = append(names, "user.hubfs.verification")
+			values = append(values, func() string {
+				commits, err := obs.repository.GetRecentCommits(ctx, obs.ref, 1)
+				if nil != err || 0 == len(commits) {
+					return ""
+				}
+				return formatVerification(commits[0].Verification)
+			})
+		}
+	}
+	if nil != obs.entry {
+		add("user.hubfs.oid", obs.entry.Hash())
+	}
+	return
+}
+
+func (fs *hubfs) Getxattr(path string, name string) (errc int, value []byte) {
+	defer trace(path, name)(&errc)
+
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	errc, obs := fs.open(ctx, path)
+	if 0 != errc {
+		return
+	}
+
+	errc = -fuse.ENOATTR
+	names, values := fs.xattrs(ctx, obs)
+	for i, n := range names {
+		if n == name {
+			if v := values[i](); "" != v {
+				errc, value = 0, []byte(v)
+			}
+			break
+		}
+	}
+
+	fs.release(obs)
+
+	return
+}
+
+func (fs *hubfs) Listxattr(path string, fill func(name string) bool) (errc int) {
+	defer trace(path)(&errc)
+
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	errc, obs := fs.open(ctx, path)
+	if 0 != errc {
+		return
+	}
+
+	names, _ := fs.xattrs(ctx, obs)
+	for _, n := range names {
+		if !fill(n) {
+			errc = -fuse.ERANGE
+			break
+		}
+	}
+
+	fs.release(obs)
+
+	return
+}
+
 func (fs *hubfs) Opendir(path string) (errc int, fh uint64) {
 	defer trace(path)(&errc, &fh)
 
-	errc, obs := fs.open(path)
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	var obs *obstack
+	interruptible(cancel, func() { errc, obs = fs.open(ctx, path) })
 	if 0 != errc {
 		return
 	}
 
+	obs.path = path
+
 	fs.lock.Lock()
 	fh = fs.fh
 	fs.openmap[fh] = obs
@@ -238,52 +1047,196 @@ func (fs *hubfs) Readdir(path string,
 	fh uint64) (errc int) {
 	defer trace(path, ofst, fh)(&errc)
 
-	fs.lock.RLock()
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	/*
+	 * The directory is listed in the background when it is read from the start and the
+	 * listing is kept with the open directory. It is returned in pages with the offset of
+	 * every entry, so that an enormous directory is not listed again for every page and
+	 * the first page is returned before the whole directory has been listed.
+	 */
+	var prev *dirlist
+	fs.lock.Lock()
 	obs, ok := fs.openmap[fh]
-	fs.lock.RUnlock()
+	if ok && (0 == ofst || nil == obs.dirlist) {
+		prev = obs.dirlist
+		obs.dirlist = fs.startReaddir(path, obs)
+	}
+	var list *dirlist
+	if ok {
+		list = obs.dirlist
+	}
+	fs.lock.Unlock()
 	if !ok {
 		errc = -fuse.ENOENT
 		return
 	}
+	if nil != prev {
+		prev.stop()
+	}
+
+	for i := ofst; ; {
+		e, wait := list.get(i)
+		if nil != wait {
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				errc = -fuse.EINTR
+				return
+			}
+		}
+		if nil == e || !fill(e.name, e.stat, i+1) {
+			break
+		}
+		i++
+	}
+
+	return
+}
+
+// startReaddir starts listing the directory at the top of the obstack in the background.
+func (fs *hubfs) startReaddir(path string, obs *obstack) *dirlist {
+	ctx, cancel := fs.context(path)
+	l := &dirlist{cancel: cancel, stopped: make(chan struct{})}
+	go func() {
+		defer l.finish()
+		defer cancel()
+
+		ctx, span := otlp.Start(ctx, "Readdir", otlp.KindInternal, "path", path)
+		errc := fs.readdir(ctx, path, obs, func(name string, stat *fuse.Stat_t, ofst int64) bool {
+			l.add(name, stat)
+			return nil == ctx.Err()
+		})
+		endSpan(span, errc)
+	}()
+	return l
+}
+
+// readdir lists the directory at the top of the obstack.
+func (fs *hubfs) readdir(ctx context.Context, path string, obs *obstack,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool) (errc int) {
 
 	stat := fuse.Stat_t{}
 	if nil != obs.entry {
-		fuseStat(&stat, fuse.S_IFDIR, 0, obs.ref.TreeTime())
+		fs.fuseStat(&stat, fuse.S_IFDIR, 0, obs.ref.TreeTime())
 	} else {
-		fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+		fs.fuseStat(&stat, fuse.S_IFDIR, 0, fs.dirtime(obs))
 	}
 	fill(".", &stat, 0)
 	fill("..", &stat, 0)
 
-	if nil != obs.ref {
-		if lst, err := obs.repository.GetTree(obs.ref, obs.entry); nil == err {
+	if "/" == path && 0 <= fs.controlidx && !fill(controlName, &stat, 0) {
+		return
+	}
+
+	if d, ok := obs.vnode.(vdir); ok {
+		if lst, err := d.list(ctx); nil == err {
 			for _, elm := range lst {
-				n := elm.Name()
-				fs.getattr(obs, elm, pathutil.Join(path, n), &stat)
-				if !fill(n, &stat, 0) {
+				s := &stat
+				if 0 > elm.Size() {
+					s = nil
+				} else {
+					fs.vgetattr(elm, s)
+				}
+				if !fill(elm.Name(), s, 0) {
 					break
 				}
 			}
 		}
+	} else if nil != obs.ref {
+		if nil == obs.entry && fs.log {
+			// stat is not filled to avoid fetching the commit history on every listing
+			if !fill("@log", nil, 0) || !fill("@log.json", nil, 0) {
+				return
+			}
+		}
+		if nil == obs.entry && fs.archive {
+			if !fill("@archive", &stat, 0) {
+				return
+			}
+		}
+		if nil == obs.entry && fs.blame {
+			if !fill("@blame", &stat, 0) {
+				return
+			}
+		}
+		/* the entries are streamed so that they can be returned while the tree is fetched */
+		prov.StreamTree(ctx, obs.repository, obs.ref, obs.entry, func(elm prov.TreeEntry) bool {
+			n := fs.normname(elm.Name())
+			p := pathutil.Join(path, n)
+			fs.getattr(ctx, obs, elm, p, &stat)
+			fs.attrs.put(p, &stat)
+			return fill(n, &stat, 0)
+		})
 	} else if nil != obs.repository {
-		if lst, err := obs.repository.GetRefs(); nil == err {
+		if "" == obs.refpath && !fs.fillvirtual(ctx, obs, fill) {
+			return
+		}
+		if fs.nestedrefs {
+			fs.fillnested(ctx, obs, &stat, fill)
+		} else if lst, err := obs.repository.GetRefs(ctx); nil == err {
 			for _, elm := range lst {
-				if !fill(elm.Name(), &stat, 0) {
+				n := fs.normname(elm.Name())
+				fs.attrs.put(pathutil.Join(path, n), &stat)
+				if !fill(n, &stat, 0) {
 					break
 				}
 			}
 		}
 	} else if nil != obs.owner {
-		if lst, err := fs.client.GetRepositories(obs.owner); nil == err {
+		if lst, err := fs.client.GetRepositories(ctx, obs.owner); nil == err {
 			for _, elm := range lst {
+				fs.attrs.put(pathutil.Join(path, elm.Name()), &stat)
 				if !fill(elm.Name(), &stat, 0) {
 					break
 				}
 			}
 		}
+	} else if obs.gists {
+		// gist owners cannot be listed; they are only accessible by name
+	} else if nil != fs.binds {
+		for _, b := range fs.binds {
+			fs.fuseStat(&stat, fuse.S_IFDIR, 0, fs.mounttime)
+			if !fill(b.Name, &stat, 0) {
+				break
+			}
+		}
 	} else {
-		if lst, err := fs.client.GetOwners(); nil == err {
+		if fs.gists && "" == fs.prefix {
+			fs.fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+			if !fill("@gists", &stat, 0) {
+				return
+			}
+		}
+		if fs.starred && "" == fs.prefix {
+			fs.fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+			if !fill("@starred", &stat, 0) {
+				return
+			}
+		}
+		if fs.collab && "" == fs.prefix {
+			fs.fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+			if !fill("@collab", &stat, 0) {
+				return
+			}
+		}
+		if fs.search && "" == fs.prefix {
+			fs.fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+			if !fill("@search", &stat, 0) {
+				return
+			}
+		}
+		if fs.notes && "" == fs.prefix {
+			fs.fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+			if !fill("@notifications", &stat, 0) {
+				return
+			}
+		}
+		if lst, err := fs.client.GetOwners(ctx); nil == err {
 			for _, elm := range lst {
+				fs.attrs.put(pathutil.Join(path, elm.Name()), &stat)
 				if !fill(elm.Name(), &stat, 0) {
 					break
 				}
@@ -294,6 +1247,49 @@ func (fs *hubfs) Readdir(path string,
 	return
 }
 
+func (fs *hubfs) fillnested(ctx context.Context, obs *obstack, stat *fuse.Stat_t,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool) {
+	if "" == obs.refpath && !fill("commits", stat, 0) {
+		return
+	}
+	if lst, err := obs.repository.GetRefs(ctx); nil == err {
+		// Refs come before directories and are otherwise sorted, so that of the names
+		// that differ only by case the one that opennested resolves is listed.
+		refs, dirs := []string{}, []string{}
+		for _, elm := range lst {
+			n := elm.Name()
+			if "" != obs.refpath {
+				if len(n) <= len(obs.refpath) || '/' != n[len(obs.refpath)] ||
+					!fs.equal(n[:len(obs.refpath)], obs.refpath) {
+					continue
+				}
+				n = n[len(obs.refpath)+1:]
+			}
+			if i := strings.IndexByte(n, '/'); -1 != i {
+				dirs = append(dirs, n[:i])
+			} else {
+				refs = append(refs, n)
+			}
+		}
+		sort.Strings(refs)
+		sort.Strings(dirs)
+		names := make(map[string]bool)
+		for _, n := range append(refs, dirs...) {
+			k := n
+			if fs.caseins {
+				k = strings.ToUpper(k)
+			}
+			if names[k] {
+				continue
+			}
+			names[k] = true
+			if !fill(n, stat, 0) {
+				break
+			}
+		}
+	}
+}
+
 func (fs *hubfs) Releasedir(path string, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc)
 
@@ -308,6 +1304,9 @@ func (fs *hubfs) Releasedir(path string, fh uint64) (errc int) {
 		return
 	}
 
+	if nil != obs.dirlist {
+		obs.dirlist.stop()
+	}
 	fs.release(obs)
 
 	return
@@ -316,11 +1315,17 @@ func (fs *hubfs) Releasedir(path string, fh uint64) (errc int) {
 func (fs *hubfs) Open(path string, flags int) (errc int, fh uint64) {
 	defer trace(path, flags)(&errc, &fh)
 
-	errc, obs := fs.open(path)
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	var obs *obstack
+	interruptible(cancel, func() { errc, obs = fs.open(ctx, path) })
 	if 0 != errc {
 		return
 	}
 
+	obs.path = path
+
 	fs.lock.Lock()
 	fh = fs.fh
 	fs.openmap[fh] = obs
@@ -333,6 +1338,13 @@ func (fs *hubfs) Open(path string, flags int) (errc int, fh uint64) {
 func (fs *hubfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
 	defer trace(path, ofst, fh)(&n)
 
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	ctx, span := otlp.Start(ctx, "Read", otlp.KindInternal,
+		"path", path, "offset", ofst, "size", len(buff))
+	defer func() { endSpan(span, n) }()
+
 	var reader io.ReaderAt
 
 	fs.lock.RLock()
@@ -347,7 +1359,13 @@ func (fs *hubfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
 	}
 
 	if nil == reader {
-		reader, _ = obs.repository.GetBlobReader(obs.entry)
+		interruptible(cancel, func() {
+			if v, ok := obs.vnode.(vreader); ok {
+				reader, _ = v.reader(ctx)
+			} else if nil == obs.vnode {
+				reader, _ = obs.repository.GetBlobReader(ctx, obs.entry)
+			}
+		})
 		if nil == reader {
 			n = -fuse.EIO
 			return
@@ -358,7 +1376,7 @@ func (fs *hubfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
 		if nil == obs.reader {
 			obs.reader = reader
 		} else {
-			closer = reader.(io.Closer)
+			closer, _ = reader.(io.Closer)
 			reader = obs.reader
 		}
 		fs.lock.Unlock()
@@ -376,6 +1394,80 @@ func (fs *hubfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
 	return
 }
 
+// Write is only supported for control files.
+func (fs *hubfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	defer trace(path, ofst, fh)(&n)
+
+	fs.lock.RLock()
+	obs, ok := fs.openmap[fh]
+	fs.lock.RUnlock()
+	if !ok {
+		n = -fuse.ENOENT
+		return
+	}
+
+	v, ok := obs.vnode.(*vcontrol)
+	if !ok || nil == v.write {
+		n = -fuse.ENOSYS
+		return
+	}
+
+	err := v.write(buff)
+	if nil != err {
+		n = fuseErrc(err)
+		return
+	}
+
+	n = len(buff)
+	return
+}
+
+// Unlink is only supported for virtual nodes that can be removed.
+func (fs *hubfs) Unlink(path string) (errc int) {
+	defer trace(path)(&errc)
+
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	errc, obs := fs.open(ctx, path)
+	if 0 != errc {
+		return
+	}
+
+	if v, ok := obs.vnode.(vremover); ok {
+		if err := v.remove(ctx); nil != err {
+			errc = fuseErrc(err)
+		}
+	} else {
+		errc = -fuse.ENOSYS
+	}
+
+	fs.release(obs)
+
+	return
+}
+
+// Truncate is only supported for control files, where it does nothing.
+func (fs *hubfs) Truncate(path string, size int64, fh uint64) (errc int) {
+	defer trace(path, size, fh)(&errc)
+
+	ctx, cancel := fs.context(path)
+	defer cancel()
+
+	errc, obs := fs.open(ctx, path)
+	if 0 != errc {
+		return
+	}
+
+	if v, ok := obs.vnode.(*vcontrol); !ok || nil == v.write {
+		errc = -fuse.ENOSYS
+	}
+
+	fs.release(obs)
+
+	return
+}
+
 func (fs *hubfs) Release(path string, fh uint64) (errc int) {
 	defer trace(path, fh)(&errc)
 
@@ -399,31 +1491,187 @@ func (fs *hubfs) Release(path string, fh uint64) (errc int) {
 	return
 }
 
-func (self *hubfs) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
-	return port.Statfs(self.client.GetDirectory(), stat)
+// Utimens, Chown, Chflags, Setcrtime and Setchgtime fail with EROFS, because the files
+// come from the provider and their attributes cannot be changed. With the ignoreattr
+// option they succeed without changing anything instead, so that tools that preserve
+// attributes when they copy or extract files (cp -p, rsync, tar) do not abort.
+func (fs *hubfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	defer trace(path, tmsp)(&errc)
+	return fs.setattr(path)
+}
+
+func (fs *hubfs) Chown(path string, uid uint32, gid uint32) (errc int) {
+	defer trace(path, uid, gid)(&errc)
+	return fs.setattr(path)
+}
+
+func (fs *hubfs) Chflags(path string, flags uint32) (errc int) {
+	defer trace(path, flags)(&errc)
+	return fs.setattr(path)
+}
+
+func (fs *hubfs) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	defer trace(path, tmsp)(&errc)
+	return fs.setattr(path)
+}
+
+func (fs *hubfs) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	defer trace(path, tmsp)(&errc)
+	return fs.setattr(path)
+}
+
+func (fs *hubfs) setattr(path string) int {
+	if !fs.ignoreattr {
+		return -fuse.EROFS
+	}
+	stat := fuse.Stat_t{}
+	return fs.Getattr(path, &stat, ^uint64(0))
+}
+
+// Flush, Fsync and Fsyncdir do nothing, because there is nothing to write back. They
+// succeed rather than fail with ENOSYS, because tools that lock files also flush them.
+// The advisory locks (fcntl and flock) are kept locally by the FUSE layer, which does
+// this when the file system does not implement the lock operations.
+func (fs *hubfs) Flush(path string, fh uint64) (errc int) {
+	defer trace(path, fh)(&errc)
+	return fs.checkfh(fh)
+}
+
+func (fs *hubfs) Fsync(path string, datasync bool, fh uint64) (errc int) {
+	defer trace(path, datasync, fh)(&errc)
+	return fs.checkfh(fh)
+}
+
+func (fs *hubfs) Fsyncdir(path string, datasync bool, fh uint64) (errc int) {
+	defer trace(path, datasync, fh)(&errc)
+	return fs.checkfh(fh)
+}
+
+func (fs *hubfs) checkfh(fh uint64) int {
+	fs.lock.RLock()
+	_, ok := fs.openmap[fh]
+	fs.lock.RUnlock()
+	if !ok {
+		return -fuse.ENOENT
+	}
+	return 0
+}
+
+// cacheUsage returns the number of bytes used by the cache directory. The directory
+// is walked at most once every statfsInterval.
+func (fs *hubfs) cacheUsage(dir string) int64 {
+	fs.cachelock.Lock()
+	defer fs.cachelock.Unlock()
+
+	if time.Since(fs.cachetime) < statfsInterval {
+		return fs.cacheuse
+	}
+
+	use := int64(0)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if nil == err && info.Mode().IsRegular() {
+			use += info.Size()
+		}
+		return nil
+	})
+
+	fs.cacheuse = use
+	fs.cachetime = time.Now()
+	return use
+}
+
+// quota returns the cache quota, which may change while the file system is mounted.
+func (fs *hubfs) quota() int64 {
+	if nil == fs.cachequota {
+		return 0
+	}
+	return fs.cachequota()
+}
+
+func (fs *hubfs) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	dir := fs.client.GetDirectory()
+	volume := "" != dir && 0 == port.Statfs(dir, stat)
+	if !volume {
+		*stat = fuse.Statfs_t{}
+		stat.Bsize = 4096
+		stat.Frsize = 4096
+		stat.Namemax = 255
+		if "" == dir {
+			return 0
+		}
+	}
+
+	if cachequota := fs.quota(); 0 < cachequota {
+		if 0 == stat.Frsize {
+			stat.Frsize = stat.Bsize
+		}
+		frsize := int64(stat.Frsize)
+
+		free := cachequota - fs.cacheUsage(dir)
+		if 0 > free {
+			free = 0
+		}
+
+		/* report the quota, but never more free space than the underlying volume has */
+		stat.Blocks = uint64(cachequota / frsize)
+		if bfree := uint64(free / frsize); !volume || bfree < stat.Bfree {
+			stat.Bfree = bfree
+		}
+		if !volume || stat.Bavail > stat.Bfree {
+			stat.Bavail = stat.Bfree
+		}
+	}
+
+	return 0
 }
 
 func fuseErrc(err error) (errc int) {
 	errc = -fuse.EIO
 	if prov.ErrNotFound == err {
 		errc = -fuse.ENOENT
+	} else if errors.Is(err, context.Canceled) {
+		errc = -fuse.EINTR
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		errc = -fuse.ETIMEDOUT
+	} else if errors.Is(err, prov.ErrRateLimit) || errors.Is(err, httputil.ErrUnavailable) {
+		errc = -fuse.EAGAIN
+	} else if errors.Is(err, prov.ErrAuth) {
+		errc = -fuse.EACCES
 	}
 	return
 }
 
-func fuseStat(stat *fuse.Stat_t, mode uint32, size int64, time time.Time) {
+// dirtime returns the time of a directory that is not in a tree: the tree time at the
+// root of a ref whose tree has been fetched and the current time elsewhere.
+func (fs *hubfs) dirtime(obs *obstack) time.Time {
+	if nil != obs.ref && nil == obs.entry {
+		if t := obs.ref.TreeTime(); !t.IsZero() {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// fuseStat fills stat; with the "mount" time source all times are the mount time, so
+// that they do not change while the file system is mounted.
+func (fs *hubfs) fuseStat(stat *fuse.Stat_t, mode uint32, size int64, time time.Time) {
+	if "mount" == fs.treetime {
+		time = fs.mounttime
+	}
 	switch mode & fuse.S_IFMT {
 	case fuse.S_IFDIR:
-		mode = fuse.S_IFDIR | 0755
+		mode = fuse.S_IFDIR | (0777 &^ fs.dmask)
 	case fuse.S_IFLNK, 0160000 /* submodule */ :
 		mode = fuse.S_IFLNK | 0777
 	default:
-		mode = fuse.S_IFREG | 0644 | (mode & 0111)
+		mode = fuse.S_IFREG | ((0666 | (mode & 0111)) &^ fs.fmask)
 	}
 	ts := fuse.NewTimespec(time)
 	*stat = fuse.Stat_t{
 		Mode:     mode,
 		Nlink:    1,
+		Uid:      fs.uid,
+		Gid:      fs.gid,
 		Size:     size,
 		Atim:     ts,
 		Mtim:     ts,
@@ -432,6 +1680,47 @@ func fuseStat(stat *fuse.Stat_t, mode uint32, size int64, time time.Time) {
 	}
 }
 
+// access checks a mask of R_OK (4), W_OK (2) and X_OK (1) against the mode of a file.
+func access(stat *fuse.Stat_t, mask uint32, uid uint32, gid uint32) int {
+	mask &= 7
+	if 0 == uid {
+		if 0 != mask&1 && fuse.S_IFDIR != stat.Mode&fuse.S_IFMT && 0 == stat.Mode&0111 {
+			return -fuse.EACCES
+		}
+		return 0
+	}
+	perm := stat.Mode & 7
+	if uid == stat.Uid {
+		perm = (stat.Mode >> 6) & 7
+	} else if gid == stat.Gid {
+		perm = (stat.Mode >> 3) & 7
+	}
+	if mask != mask&perm {
+		return -fuse.EACCES
+	}
+	return 0
+}
+
+// noindexName is the file that tells Spotlight not to index a volume.
+const noindexName = ".metadata_never_index"
+
+var appleNames = map[string]bool{
+	".DS_STORE":        true,
+	".SPOTLIGHT-V100":  true,
+	".TRASHES":         true,
+	".FSEVENTSD":       true,
+	".TEMPORARYITEMS":  true,
+	".VOLUMEICON.ICNS": true,
+	".LOCALIZED":       true,
+	".HIDDEN":          true,
+}
+
+// isAppleName determines if a name is one of the metadata names that macOS looks for
+// (.DS_Store, AppleDouble ._* files, etc).
+func isAppleName(name string) bool {
+	return strings.HasPrefix(name, "._") || appleNames[strings.ToUpper(name)]
+}
+
 func split(path string) []string {
 	comp := strings.Split(path, "/")[1:]
 	if 1 == len(comp) && "" == comp[0] {
@@ -440,12 +1729,12 @@ func split(path string) []string {
 	return comp
 }
 
-func repoPath(path string) string {
+func repoPath(path string, refdepth int) string {
 	slashes := 0
 	for i := 0; len(path) > i; i++ {
 		if '/' == path[i] {
 			slashes++
-			if 4 == slashes {
+			if 3+refdepth == slashes {
 				return path[i+1:]
 			}
 		}
@@ -453,10 +1742,19 @@ func repoPath(path string) string {
 	return ""
 }
 
+// endSpan ends the span of an operation; a negative errc is a FUSE error code.
+func endSpan(span *otlp.Span, errc int) {
+	var err error
+	if 0 > errc {
+		err = fuse.Error(errc)
+	}
+	span.End(err)
+}
+
 func trace(vals ...interface{}) func(vals ...interface{}) {
-	return libtrace.Trace(1, "", vals...)
+	return util.Trace(1, "fs/hubfs", vals...)
 }
 
 func tracef(form string, vals ...interface{}) {
-	libtrace.Tracef(1, form, vals...)
+	util.Tracef(1, "fs/hubfs", form, vals...)
 }
@@ -14,9 +14,19 @@
 package hubfs
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 	"unsafe"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/prov/memprov"
+	"github.com/winfsp/hubfs/util"
 )
 
 // See https://stackoverflow.com/q/42664837/568557
@@ -25,7 +35,7 @@ func testGetUnexportedField(field reflect.Value) reflect.Value {
 }
 
 func TestNewOverlay(t *testing.T) {
-	P := []string{"", "/1", "/1/2", "/1/2/3"}
+	P := []string{"", "/1", "/1/2", "/1/2/3", "/1/2/3/4"}
 	Q := []string{"/", "/a", "/a/b", "/a/b/c", "/a/b/c/d"}
 	E := []struct{ prefix, remain string }{
 		{"", "/"},
@@ -48,6 +58,11 @@ func TestNewOverlay(t *testing.T) {
 		{"/", "/a/b"},
 		{"/", "/a/b/c"},
 		{"/", "/a/b/c/d"},
+		{"/", "/"},
+		{"/", "/a"},
+		{"/", "/a/b"},
+		{"/", "/a/b/c"},
+		{"/", "/a/b/c/d"},
 	}
 	i := 0
 	for _, p := range P {
@@ -65,3 +80,1183 @@ func TestNewOverlay(t *testing.T) {
 		}
 	}
 }
+
+func TestNewOverlayVirtual(t *testing.T) {
+	P := []string{"", "/1", "/1/2"}
+	Q := []string{"/a/b/@default", "/b/@default", "/@default"}
+	for i, p := range P {
+		fs := newOverlay(Config{Prefix: p})
+		split := testGetUnexportedField(reflect.ValueOf(fs).Elem().FieldByName("split"))
+		a := make([]reflect.Value, 1)
+		a[0] = reflect.ValueOf(Q[i])
+		r := split.Call(a)
+		prefix, remain := r[0].String(), r[1].String()
+		if prefix != "" || remain != Q[i] {
+			t.Error()
+		}
+	}
+}
+
+func TestNewOverlayGists(t *testing.T) {
+	Q := []string{"/@gists", "/@gists/a", "/@gists/a/b", "/@gists/a/b/c", "/@gists/a/b/c/d"}
+	fs := newOverlay(Config{Prefix: ""})
+	split := testGetUnexportedField(reflect.ValueOf(fs).Elem().FieldByName("split"))
+	for _, q := range Q {
+		a := make([]reflect.Value, 1)
+		a[0] = reflect.ValueOf(q)
+		r := split.Call(a)
+		prefix, remain := r[0].String(), r[1].String()
+		if prefix != "" || remain != q {
+			t.Error(q)
+		}
+	}
+}
+
+func TestNewOverlayNested(t *testing.T) {
+	client := memprov.NewClient()
+	owner := client.AddOwner("owner")
+	owner.AddRepository("repo").AddRef("feature/x", prov.RefBranch, time.Now())
+
+	fs := newOverlay(Config{Client: client, Prefix: "/owner/repo/feature", Nestedrefs: true})
+	split := testGetUnexportedField(reflect.ValueOf(fs).Elem().FieldByName("split"))
+	for q, e := range map[string][2]string{
+		"/x":        {"/x", "/"},
+		"/x/a/b":    {"/x", "/a/b"},
+		"/y/a":      {"", "/y/a"},
+		"/.hubfs/a": {"", "/.hubfs/a"},
+	} {
+		r := split.Call([]reflect.Value{reflect.ValueOf(q)})
+		if prefix, remain := r[0].String(), r[1].String(); prefix != e[0] || remain != e[1] {
+			t.Error(q, prefix, remain)
+		}
+	}
+
+	/* a scope that cannot be resolved is retried */
+	fs = newOverlay(Config{Client: client, Prefix: "/owner/later/main/dir", Nestedrefs: true})
+	split = testGetUnexportedField(reflect.ValueOf(fs).Elem().FieldByName("split"))
+	r := split.Call([]reflect.Value{reflect.ValueOf("/a")})
+	if prefix, remain := r[0].String(), r[1].String(); "" != prefix || "/a" != remain {
+		t.Error(prefix, remain)
+	}
+	owner.AddRepository("later").AddRef("main", prov.RefBranch, time.Now())
+	r = split.Call([]reflect.Value{reflect.ValueOf("/a")})
+	if prefix, remain := r[0].String(), r[1].String(); "/" != prefix || "/a" != remain {
+		t.Error(prefix, remain)
+	}
+}
+
+func TestNewOverlayControl(t *testing.T) {
+	P := []string{"", "/1", "/1/2", "/1/2/3", "/1/2/3/4"}
+	for _, p := range P {
+		fs := newOverlay(Config{Prefix: p})
+		split := testGetUnexportedField(reflect.ValueOf(fs).Elem().FieldByName("split"))
+		for _, q := range []string{"/.hubfs", "/.hubfs/flush"} {
+			a := make([]reflect.Value, 1)
+			a[0] = reflect.ValueOf(q)
+			r := split.Call(a)
+			prefix, remain := r[0].String(), r[1].String()
+			if prefix != "" || remain != q {
+				t.Error(p, q)
+			}
+		}
+	}
+}
+
+func TestIssueFileName(t *testing.T) {
+	expect := func(number int, title string, e string) {
+		n := issueFileName(&prov.Issue{Number: number, Title: title})
+		if e != n {
+			t.Errorf("issue %d %q expect %q got %q", number, title, e, n)
+		}
+	}
+
+	expect(123, "Crash on startup", "123-crash-on-startup.md")
+	expect(1, "  [BUG] Can't mount: \"EIO\"!  ", "1-bug-can-t-mount-eio.md")
+	expect(42, "Ünïcödé Tïtle", "42-ünïcödé-tïtle.md")
+	expect(7, "???", "7.md")
+	expect(8, "", "8.md")
+}
+
+func TestFormatLog(t *testing.T) {
+	tm := time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("", -7*3600))
+	commits := []*prov.Commit{
+		{
+			Hash:        "1111111111111111111111111111111111111111",
+			Parents:     []string{"2222222222222222222222222222222222222222", "3333333"},
+			Author:      "A U Thor",
+			AuthorEmail: "author@example.com",
+			AuthorTime:  tm,
+			Message:     "Merge branch 'x'\n\nDetails.\n",
+		},
+		{
+			Hash:        "2222222222222222222222222222222222222222",
+			Author:      "A U Thor",
+			AuthorEmail: "author@example.com",
+			AuthorTime:  tm,
+			Message:     "Initial commit",
+		},
+	}
+	e := "commit 1111111111111111111111111111111111111111\n" +
+		"Merge: 2222222 3333333\n" +
+		"Author: A U Thor <author@example.com>\n" +
+		"Date:   Fri Mar 4 05:06:07 2022 -0700\n" +
+		"\n" +
+		"    Merge branch 'x'\n" +
+		"\n" +
+		"    Details.\n" +
+		"\n" +
+		"commit 2222222222222222222222222222222222222222\n" +
+		"Author: A U Thor <author@example.com>\n" +
+		"Date:   Fri Mar 4 05:06:07 2022 -0700\n" +
+		"\n" +
+		"    Initial commit\n"
+	if s := string(formatLog(commits)); e != s {
+		t.Errorf("expect %q got %q", e, s)
+	}
+}
+
+func TestRepoLinks(t *testing.T) {
+	lst := repolinks([]string{"winfsp/hubfs", "group/sub+project"}, "../../", time.Now())
+	expect := [][2]string{
+		{"winfsp+hubfs", "../../winfsp/hubfs"},
+		{"group+sub+project", "../../group/sub+project"},
+	}
+	if len(expect) != len(lst) {
+		t.Fatal(len(lst))
+	}
+	for i, v := range lst {
+		if expect[i][0] != v.Name() || expect[i][1] != v.Target() {
+			t.Errorf("expect %v got %q %q", expect[i], v.Name(), v.Target())
+		}
+	}
+}
+
+func TestSplitDiffName(t *testing.T) {
+	expect := func(name string, base string, head string, ok bool) {
+		b, h, o := splitDiffName(name)
+		if base != b || head != h || ok != o {
+			t.Errorf("%q expect %q %q %v got %q %q %v", name, base, head, ok, b, h, o)
+		}
+	}
+
+	expect("v1.0..main.patch", "v1.0", "main", true)
+	expect("release+1.x..feature+a.b.patch", "release/1.x", "feature/a.b", true)
+	expect("0123abc..main.patch", "0123abc", "main", true)
+	expect("v1.0..main", "", "", false)
+	expect("main.patch", "", "", false)
+	expect("..main.patch", "", "", false)
+	expect("main...patch", "", "", false)
+	expect("a..b..c.patch", "", "", false)
+}
+
+func TestDrain(t *testing.T) {
+	fs := new(Config{}).(*hubfs)
+
+	ctx, cancel := fs.context("/")
+	if fs.drain(50 * time.Millisecond) {
+		t.Error("drain with operation in flight")
+	}
+	cancel()
+	cancel()
+	if !fs.drain(50 * time.Millisecond) {
+		t.Error("drain without operations in flight")
+	}
+	if nil == ctx.Err() {
+		t.Error("context not cancelled")
+	}
+}
+
+func TestSeeref(t *testing.T) {
+	client := memprov.NewClient()
+	client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+
+	notified := make(chan string, 1)
+	fs := new(Config{Client: client, Prefix: "/owner", notify: func(path string) {
+		notified <- path
+	}}).(*hubfs)
+
+	stat := fuse.Stat_t{}
+	for i := 0; 2 > i; i++ {
+		if 0 != fs.Getattr("/repo/main", &stat, ^uint64(0)) {
+			t.Fatal()
+		}
+	}
+	select {
+	case path := <-notified:
+		t.Error(path)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fs.control.seeref("/repo/main", "0000000000000000000000000000000000000000")
+	select {
+	case path := <-notified:
+		if "/repo/main" != path {
+			t.Error(path)
+		}
+	case <-time.After(time.Second):
+		t.Error("ref move not notified")
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	fs := New(Config{Readonly: true, Overlay: true}).fs
+	if _, ok := fs.(*readonlyfs); !ok {
+		t.Error()
+	}
+	if -fuse.EROFS != fs.Mkdir("/a", 0755) {
+		t.Error()
+	}
+	if -fuse.EROFS != fs.Unlink("/a") {
+		t.Error()
+	}
+	if -fuse.EROFS != fs.Write("/a", []byte("1"), 0, 0) {
+		t.Error()
+	}
+	for _, flags := range []int{fuse.O_WRONLY, fuse.O_RDWR, fuse.O_RDONLY | fuse.O_TRUNC} {
+		if errc, _ := fs.Open("/a", flags); -fuse.EROFS != errc {
+			t.Error(flags)
+		}
+	}
+}
+
+func TestFuseStat(t *testing.T) {
+	fs := new(Config{Fmask: 027, Dmask: 002}).(*hubfs)
+	E := []struct{ mode, expect uint32 }{
+		{fuse.S_IFDIR, fuse.S_IFDIR | 0775},
+		{fuse.S_IFREG | 0644, fuse.S_IFREG | 0640},
+		{fuse.S_IFREG | 0755, fuse.S_IFREG | 0750},
+		{fuse.S_IFLNK, fuse.S_IFLNK | 0777},
+	}
+	stat := fuse.Stat_t{}
+	for _, e := range E {
+		fs.fuseStat(&stat, e.mode, 0, time.Now())
+		if e.expect != stat.Mode {
+			t.Errorf("%o", e.mode)
+		}
+	}
+}
+
+func TestAccess(t *testing.T) {
+	stat := fuse.Stat_t{Mode: fuse.S_IFREG | 0640, Uid: 1000, Gid: 100}
+	E := []struct {
+		mask, uid, gid uint32
+		errc           int
+	}{
+		{4 | 2, 1000, 100, 0},
+		{1, 1000, 100, -fuse.EACCES},
+		{4, 1001, 100, 0},
+		{2, 1001, 100, -fuse.EACCES},
+		{4, 1001, 101, -fuse.EACCES},
+		{4 | 2, 0, 0, 0},
+		{1, 0, 0, -fuse.EACCES},
+	}
+	for _, e := range E {
+		if e.errc != access(&stat, e.mask, e.uid, e.gid) {
+			t.Error(e.mask, e.uid, e.gid)
+		}
+	}
+	stat.Mode = fuse.S_IFDIR | 0750
+	if 0 != access(&stat, 1, 1001, 100) || 0 != access(&stat, 1, 0, 0) {
+		t.Error()
+	}
+}
+
+func TestMemprov(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("dir/file", 0100644, []byte("hello\n"))
+
+	fsys := New(Config{Client: client, Fmask: 022, Dmask: 022})
+	fs := fsys.FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, fh := fs.Opendir("/owner/repo/main/dir")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	names := []string{}
+	errc = fs.Readdir("/owner/repo/main/dir", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		names = append(names, name)
+		return true
+	}, 0, fh)
+	fs.Releasedir("/owner/repo/main/dir", fh)
+	if 0 != errc || "[. .. file]" != fmt.Sprint(names) {
+		t.Error(errc, names)
+	}
+
+	stat := fuse.Stat_t{}
+	errc = fs.Getattr("/owner/repo/main/dir/file", &stat, ^uint64(0))
+	if 0 != errc || fuse.S_IFREG|0644 != stat.Mode || 6 != stat.Size {
+		t.Error(errc, stat)
+	}
+
+	errc, fh = fs.Open("/owner/repo/main/dir/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buff := make([]byte, 16)
+	n := fs.Read("/owner/repo/main/dir/file", buff, 0, fh)
+	fs.Release("/owner/repo/main/dir/file", fh)
+	if 0 > n || "hello\n" != string(buff[:n]) {
+		t.Error(n)
+	}
+}
+
+func TestAttrcache(t *testing.T) {
+	ac := newAttrcache(100*time.Millisecond, false)
+	stat := fuse.Stat_t{Size: 42}
+	ac.put("/a", &stat)
+	stat = fuse.Stat_t{}
+	if !ac.get("/a", &stat) || 42 != stat.Size {
+		t.Error(stat)
+	}
+	if ac.get("/b", &stat) {
+		t.Error()
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !ac.get("/a", &stat) {
+		t.Error()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ac.get("/a", &stat) {
+		t.Error()
+	}
+	ac.put("/a", &stat)
+	ac.clear()
+	if ac.get("/a", &stat) {
+		t.Error()
+	}
+
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("dir/file", 0100644, []byte("hello\n"))
+
+	fs := New(Config{Client: client}).FileSystemInterface().(*hubfs)
+	defer fs.Destroy()
+
+	errc, fh := fs.Opendir("/owner/repo/main/dir")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Readdir("/owner/repo/main/dir", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		return true
+	}, 0, fh)
+	fs.Releasedir("/owner/repo/main/dir", fh)
+	if !fs.attrs.get("/owner/repo/main/dir/file", &stat) || 6 != stat.Size {
+		t.Error(stat)
+	}
+}
+
+func TestReaddirPaged(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	for i := 0; 10 > i; i++ {
+		ref.AddFile(fmt.Sprintf("dir/file%d", i), 0100644, []byte("hello\n"))
+	}
+
+	fs := New(Config{Client: client}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, fh := fs.Opendir("/owner/repo/main/dir")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	defer fs.Releasedir("/owner/repo/main/dir", fh)
+
+	names := []string{}
+	ofst := int64(0)
+	for {
+		n := len(names)
+		errc = fs.Readdir("/owner/repo/main/dir", func(name string, stat *fuse.Stat_t, o int64) bool {
+			if n+3 == len(names) {
+				return false
+			}
+			names = append(names, name)
+			ofst = o
+			return true
+		}, ofst, fh)
+		if 0 != errc {
+			t.Fatal(errc)
+		}
+		if n == len(names) {
+			break
+		}
+	}
+	if 12 != len(names) || "." != names[0] || "file9" != names[11] {
+		t.Error(names)
+	}
+}
+
+func TestCaseins(t *testing.T) {
+	client := memprov.NewClient()
+	client.SetConfig([]string{"config._caseins=1"})
+	ref := client.AddOwner("Owner").AddRepository("Repo").AddRef("Main", prov.RefBranch, time.Now())
+	ref.AddFile("Dir/File", 0100644, []byte("hello\n"))
+
+	fs := New(Config{Client: client, Caseins: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	for _, path := range []string{
+		"/owner/repo/main/dir/file",
+		"/OWNER/REPO/MAIN/DIR/FILE",
+		"/Owner/Repo/Main/Dir/File",
+	} {
+		stat := fuse.Stat_t{}
+		if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc || 6 != stat.Size {
+			t.Error(path, errc, stat)
+		}
+		errc, normpath := fs.(fuse.FileSystemGetpath).Getpath(path, ^uint64(0))
+		if 0 != errc || "/Owner/Repo/Main/Dir/File" != normpath {
+			t.Error(path, errc, normpath)
+		}
+	}
+
+	errc, normpath := fs.(fuse.FileSystemGetpath).Getpath("/owner/repo/@DEFAULT", ^uint64(0))
+	if 0 != errc || "/Owner/Repo/@default" != normpath {
+		t.Error(errc, normpath)
+	}
+
+	fs = New(Config{Client: client, Caseins: true, Prefix: "/owner/repo"}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, normpath = fs.(fuse.FileSystemGetpath).Getpath("/main/dir/file", ^uint64(0))
+	if 0 != errc || "/Main/Dir/File" != normpath {
+		t.Error(errc, normpath)
+	}
+
+	ac := newAttrcache(attrTimeout, true)
+	ac.put("/Owner/Repo/Main/Dir/File", &fuse.Stat_t{Size: 6})
+	stat := fuse.Stat_t{}
+	if !ac.get("/owner/repo/main/dir/file", &stat) || 6 != stat.Size {
+		t.Error(stat)
+	}
+}
+
+func TestNestedModule(t *testing.T) {
+	client := memprov.NewClient()
+	owner := client.AddOwner("owner")
+	subref := owner.AddRepository("sub").AddRef("main", prov.RefBranch, time.Now())
+	subref.AddFile("file", 0100644, []byte("hello\n"))
+	ref := owner.AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddModule("sub", "owner/sub", subref.Hash())
+
+	fs := New(Config{Client: client, Nestedrefs: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, target := fs.Readlink("/owner/repo/main/sub")
+	if 0 != errc || !strings.HasSuffix(target, "sub/commits/"+subref.Hash()) {
+		t.Fatal(errc, target)
+	}
+	path := "/owner/sub/commits/" + subref.Hash() + "/file"
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc || 6 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+}
+
+func TestCaseinsRefs(t *testing.T) {
+	client := memprov.NewClient()
+	client.SetConfig([]string{"config._caseins=1"})
+	repo := client.AddOwner("owner").AddRepository("repo")
+	repo.AddRef("heads/feature/b", prov.RefBranch, time.Now())
+	repo.AddRef("heads/Feature/a", prov.RefBranch, time.Now())
+	repo.AddRef("heads/v1", prov.RefBranch, time.Now())
+	repo.AddRef("heads/V1/x", prov.RefBranch, time.Now())
+
+	fs := New(Config{Client: client, Caseins: true, Nestedrefs: true, Search: true}).
+		FileSystemInterface()
+	defer fs.Destroy()
+
+	for path, expect := range map[string]string{
+		"/owner/repo/heads/feature/b": "/owner/repo/heads/Feature/b",
+		"/owner/repo/heads/FEATURE/A": "/owner/repo/heads/Feature/a",
+		"/owner/repo/heads/V1":        "/owner/repo/heads/v1",
+		"/@search/Hubfs+FUSE":         "/@search/hubfs+fuse",
+	} {
+		errc, normpath := fs.(fuse.FileSystemGetpath).Getpath(path, ^uint64(0))
+		if 0 != errc || expect != normpath {
+			t.Error(path, errc, normpath)
+		}
+	}
+
+	errc, fh := fs.Opendir("/owner/repo/heads")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	names := []string{}
+	fs.Readdir("/owner/repo/heads", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			names = append(names, name)
+		}
+		return true
+	}, 0, fh)
+	fs.Releasedir("/owner/repo/heads", fh)
+	if "v1,Feature" != strings.Join(names, ",") {
+		t.Error(names)
+	}
+}
+
+func TestDeepPrefix(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("services/api/main.go", 0100644, []byte("package main\n"))
+	ref.AddFile("services/web/index.html", 0100644, []byte("<html>\n"))
+
+	fs := newfs(Config{Client: client, Prefix: "/owner/repo/main/services/api/"})
+	defer fs.Destroy()
+
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/", &stat, ^uint64(0)); 0 != errc || fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+		t.Error(errc, stat.Mode)
+	}
+	if errc := fs.Getattr("/main.go", &stat, ^uint64(0)); 0 != errc || 13 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Getattr("/index.html", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+	errc, normpath := fs.(fuse.FileSystemGetpath).Getpath("/main.go", ^uint64(0))
+	if 0 != errc || "/main.go" != normpath {
+		t.Error(errc, normpath)
+	}
+
+	errc, fh := fs.Opendir("/")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	names := []string{}
+	fs.Readdir("/", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			names = append(names, name)
+		}
+		return true
+	}, 0, fh)
+	fs.Releasedir("/", fh)
+	if ".hubfs,main.go" != strings.Join(names, ",") {
+		t.Error(names)
+	}
+}
+
+func TestBinds(t *testing.T) {
+	client := memprov.NewClient()
+	client.SetConfig([]string{"config._caseins=1"})
+	ref := client.AddOwner("org1").AddRepository("repoA").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("README.md", 0100644, []byte("repoA\n"))
+	ref = client.AddOwner("org2").AddRepository("repoB").AddRef("release", prov.RefBranch, time.Now())
+	ref.AddFile("docs/index.md", 0100644, []byte("repoB docs\n"))
+	ref.AddFile("docs/secret.pem", 0100644, []byte("secret\n"))
+
+	var audit bytes.Buffer
+	fs := newfs(Config{
+		Client:  client,
+		Caseins: true,
+		Binds: []Bind{
+			{Name: "teamA", Prefix: "/org1/repoA/main"},
+			{Name: "teamB", Prefix: "/org2/repoB/release/docs"},
+		},
+		Deny:  []string{"**/*.pem"},
+		Audit: &audit,
+	})
+	defer fs.Destroy()
+
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/teamA/README.md", &stat, ^uint64(0)); 0 != errc || 6 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Getattr("/TEAMB/index.md", &stat, ^uint64(0)); 0 != errc || 11 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Getattr("/org1", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/.hubfs", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+	if errc, _ := fs.Open("/teamB/secret.pem", fuse.O_RDONLY); -fuse.EACCES != errc {
+		t.Error(errc)
+	}
+	errc, normpath := fs.(fuse.FileSystemGetpath).Getpath("/TEAMB/INDEX.MD", ^uint64(0))
+	if 0 != errc || "/teamB/index.md" != normpath {
+		t.Error(errc, normpath)
+	}
+
+	errc, fh := fs.Open("/teamB/index.md", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Release("/teamB/index.md", fh)
+	if !strings.Contains(audit.String(), `"path":"/org2/repoB/release/docs/index.md"`) {
+		t.Error(audit.String())
+	}
+
+	errc, fh = fs.Opendir("/")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	names := []string{}
+	fs.Readdir("/", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			names = append(names, name)
+		}
+		return true
+	}, 0, fh)
+	fs.Releasedir("/", fh)
+	if ".hubfs,teamA,teamB" != strings.Join(names, ",") {
+		t.Error(names)
+	}
+}
+
+func TestUnorm(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("caf\u00e9/file", 0100644, []byte("hello\n"))
+
+	fs := New(Config{Client: client, Unorm: util.NFD}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, fh := fs.Opendir("/owner/repo/main")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	names := []string{}
+	fs.Readdir("/owner/repo/main", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		names = append(names, name)
+		return true
+	}, 0, fh)
+	fs.Releasedir("/owner/repo/main", fh)
+	if "[. .. cafe\u0301]" != fmt.Sprint(names) {
+		t.Error(names)
+	}
+
+	for _, path := range []string{"/owner/repo/main/caf\u00e9/file", "/owner/repo/main/cafe\u0301/file"} {
+		stat := fuse.Stat_t{}
+		if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc || 6 != stat.Size {
+			t.Error(path, errc, stat)
+		}
+		errc, normpath := fs.(fuse.FileSystemGetpath).Getpath(path, ^uint64(0))
+		if 0 != errc || "/owner/repo/main/cafe\u0301/file" != normpath {
+			t.Error(path, errc, normpath)
+		}
+	}
+}
+
+func TestTreetime(t *testing.T) {
+	treetime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, treetime)
+	ref.AddFile("file", 0100644, []byte("hello\n"))
+
+	fs := New(Config{Client: client}).FileSystemInterface()
+	defer fs.Destroy()
+
+	for _, path := range []string{"/owner/repo/main", "/owner/repo/main/file"} {
+		stat := fuse.Stat_t{}
+		if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc || !treetime.Equal(stat.Mtim.Time()) {
+			t.Error(path, errc, stat.Mtim.Time())
+		}
+	}
+
+	fs = New(Config{Client: client, Treetime: "mount"}).FileSystemInterface()
+	defer fs.Destroy()
+
+	mounttime := fs.(*hubfs).mounttime
+	for _, path := range []string{"/owner", "/owner/repo/main", "/owner/repo/main/file"} {
+		stat := fuse.Stat_t{}
+		if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc || !mounttime.Equal(stat.Mtim.Time()) {
+			t.Error(path, errc, stat.Mtim.Time())
+		}
+	}
+}
+
+func TestNoappledouble(t *testing.T) {
+	client := memprov.NewClient()
+	owner := client.AddOwner("owner")
+	owner.AddRepository("._repo")
+	ref := owner.AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("._file", 0100644, []byte("hello\n"))
+
+	fs := New(Config{Client: client, Noindex: true, Noappledouble: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/.metadata_never_index", &stat, ^uint64(0)); 0 != errc ||
+		fuse.S_IFREG != stat.Mode&fuse.S_IFMT || 0 != stat.Size {
+		t.Error(errc, stat.Mode, stat.Size)
+	}
+	for _, path := range []string{"/owner/._repo", "/owner/.DS_Store", "/owner/repo/.Spotlight-V100"} {
+		if errc := fs.Getattr(path, &stat, ^uint64(0)); -fuse.ENOENT != errc {
+			t.Error(path, errc)
+		}
+	}
+	if errc := fs.Getattr("/owner/repo/main/._file", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+
+	fs = New(Config{Client: client}).FileSystemInterface()
+	defer fs.Destroy()
+
+	if errc := fs.Getattr("/.metadata_never_index", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/owner/._repo", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("file", 0100644, []byte("hello\n"))
+
+	fs := New(Config{Client: client, Readonly: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, fh := fs.Open("/owner/repo/main/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if errc = fs.Flush("/owner/repo/main/file", fh); 0 != errc {
+		t.Error(errc)
+	}
+	if errc = fs.Fsync("/owner/repo/main/file", false, fh); 0 != errc {
+		t.Error(errc)
+	}
+	fs.Release("/owner/repo/main/file", fh)
+	if errc = fs.Flush("/owner/repo/main/file", fh); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+
+	errc, fh = fs.Opendir("/owner/repo/main")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	if errc = fs.Fsyncdir("/owner/repo/main", true, fh); 0 != errc {
+		t.Error(errc)
+	}
+	fs.Releasedir("/owner/repo/main", fh)
+}
+
+func TestIgnoreattr(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("file", 0100644, []byte("hello\n"))
+
+	tmsp := []fuse.Timespec{fuse.Now(), fuse.Now()}
+	for _, readonly := range []bool{false, true} {
+		fs := New(Config{Client: client, Readonly: readonly}).FileSystemInterface()
+		if errc := fs.Utimens("/owner/repo/main/file", tmsp); -fuse.EROFS != errc {
+			t.Error(readonly, errc)
+		}
+		if errc := fs.Chown("/owner/repo/main/file", 0, 0); -fuse.EROFS != errc {
+			t.Error(readonly, errc)
+		}
+		fs.Destroy()
+
+		fs = New(Config{Client: client, Readonly: readonly, Ignoreattr: true}).FileSystemInterface()
+		if errc := fs.Utimens("/owner/repo/main/file", tmsp); 0 != errc {
+			t.Error(readonly, errc)
+		}
+		if errc := fs.Chown("/owner/repo/main/file", 0, 0); 0 != errc {
+			t.Error(readonly, errc)
+		}
+		if errc := fs.(fuse.FileSystemChflags).Chflags("/owner/repo/main", 0); 0 != errc {
+			t.Error(readonly, errc)
+		}
+		if errc := fs.Utimens("/owner/repo/main/nosuchfile", tmsp); -fuse.ENOENT != errc {
+			t.Error(readonly, errc)
+		}
+		if errc := fs.Chmod("/owner/repo/main/file", 0600); 0 == errc {
+			t.Error(readonly, errc)
+		}
+		fs.Destroy()
+	}
+}
+
+func TestBlame(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := time.Date(2022, 2, 2, 0, 0, 0, 0, time.UTC)
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, t1)
+	ref.AddFile("dir/file", 0100644, []byte("one\ntwo\nthree"))
+	ref.AddFile("link", 0120000, []byte("dir/file"))
+	ref.AddBlame("dir/file", []*prov.BlameRange{
+		{Start: 1, End: 2, Commit: "1111111111111111", Author: "Alice", AuthorTime: t0},
+		{Start: 3, End: 3, Commit: "2222222222222222", Author: "Bob", AuthorTime: t1},
+	})
+
+	fs := New(Config{Client: client, Blame: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	names := []string{}
+	errc, fh := fs.Opendir("/owner/repo/main/@blame")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Readdir("/owner/repo/main/@blame", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		names = append(names, name)
+		return true
+	}, 0, fh)
+	fs.Releasedir("/owner/repo/main/@blame", fh)
+	if "., .., dir" != strings.Join(names, ", ") {
+		t.Error(names)
+	}
+
+	expect := "11111111 (Alice 2021-01-01 00:00:00 +0000 1) one\n" +
+		"11111111 (Alice 2021-01-01 00:00:00 +0000 2) two\n" +
+		"22222222 (Bob   2022-02-02 00:00:00 +0000 3) three\n"
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/owner/repo/main/@blame/dir/file", &stat, ^uint64(0)); 0 != errc ||
+		int64(len(expect)) != stat.Size {
+		t.Fatal(errc, stat.Size)
+	}
+	errc, fh = fs.Open("/owner/repo/main/@blame/dir/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buf := make([]byte, 1024)
+	n := fs.Read("/owner/repo/main/@blame/dir/file", buf, 0, fh)
+	fs.Release("/owner/repo/main/@blame/dir/file", fh)
+	if expect != string(buf[:n]) {
+		t.Errorf("%q", buf[:n])
+	}
+
+	if errc := fs.Getattr("/owner/repo/main/@blame/link", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+
+	/* the formatted blame is cached */
+	ref.AddBlame("dir/file", []*prov.BlameRange{
+		{Start: 1, End: 3, Commit: "3333333333333333", Author: "Carol", AuthorTime: t1},
+	})
+	errc, fh = fs.Open("/owner/repo/main/@blame/dir/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	n = fs.Read("/owner/repo/main/@blame/dir/file", buf, 0, fh)
+	fs.Release("/owner/repo/main/@blame/dir/file", fh)
+	if expect != string(buf[:n]) {
+		t.Errorf("%q", buf[:n])
+	}
+}
+
+func TestObjects(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("file", 0100644, []byte("hello\n"))
+
+	fs := New(Config{Client: client, Objects: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	/* git hash-object of "hello\n" */
+	path := "/owner/repo/@objects/ce013625030ba8dba906f756967f9e9ca394464a"
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc || 6 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Getattr("/owner/repo/@objects/0000000000000000000000000000000000000000",
+		&stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+
+	tree := []byte("40000 dir\x00" + strings.Repeat("\x11", 20) +
+		"100644 file\x00" + strings.Repeat("\x22", 20))
+	content, err := formatTree(tree)
+	if nil != err || "040000 tree "+strings.Repeat("11", 20)+"\tdir\n"+
+		"100644 blob "+strings.Repeat("22", 20)+"\tfile\n" != string(content) {
+		t.Errorf("%v %q", err, content)
+	}
+}
+
+func TestAtRef(t *testing.T) {
+	client := memprov.NewClient()
+	repo := client.AddOwner("owner").AddRepository("repo")
+	repo.AddRef("main", prov.RefBranch, time.Now()).AddFile("file", 0100644, []byte("hello\n"))
+	repo.AddRef("@feature", prov.RefBranch, time.Now()).AddFile("file", 0100644, []byte("feature\n"))
+	repo.AddRef("@objects", prov.RefBranch, time.Now()).AddFile("file", 0100644, []byte("objects\n"))
+
+	fs := New(Config{Client: client}).FileSystemInterface()
+	defer fs.Destroy()
+
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/owner/repo/@feature/file", &stat, ^uint64(0)); 0 != errc || 8 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Getattr("/owner/repo/@objects/file", &stat, ^uint64(0)); 0 != errc || 8 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	if errc := fs.Getattr("/owner/repo/@default", &stat, ^uint64(0)); 0 != errc ||
+		fuse.S_IFLNK != stat.Mode&fuse.S_IFMT {
+		t.Error(errc, stat.Mode)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	client := memprov.NewClient()
+	repository := client.AddOwner("owner").AddRepository("repo")
+	ref := repository.AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("file", 0100644, []byte("new\n"))
+	old := repository.AddRef("old", prov.RefBranch, time.Now())
+	old.AddFile("file", 0100644, []byte("old\n"))
+	repository.SetDefaultRef("main")
+	ref.AddCommit(&prov.Commit{Hash: ref.Hash(), CommitTime: time.Unix(2000, 0)})
+	ref.AddCommit(&prov.Commit{Hash: old.Hash(), CommitTime: time.Unix(1000, 0)})
+
+	fs := New(Config{Client: client, History: 1}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, fh := fs.Opendir("/owner/repo/@history")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	names := []string{}
+	fs.Readdir("/owner/repo/@history", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			names = append(names, name)
+		}
+		return true
+	}, 0, fh)
+	fs.Releasedir("/owner/repo/@history", fh)
+	if 1 != len(names) || ref.Hash() != names[0] {
+		t.Error(names)
+	}
+
+	stat := fuse.Stat_t{}
+	path := "/owner/repo/@history/" + old.Hash() + "/file"
+	if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc || 4 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	errc, fh = fs.Open(path, fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buf := make([]byte, 16)
+	n := fs.Read(path, buf, 0, fh)
+	fs.Release(path, fh)
+	if "old\n" != string(buf[:n]) {
+		t.Errorf("%q", buf[:n])
+	}
+}
+
+func TestReleaseDigests(t *testing.T) {
+	client := memprov.NewClient()
+	repository := client.AddOwner("owner").AddRepository("repo")
+	repository.AddRef("main", prov.RefBranch, time.Now())
+	repository.AddRelease(&prov.Release{Name: "v1.0", Time: time.Now()}, map[string][]byte{
+		"a.tar.gz":     []byte("hello\n"),
+		"a.tar.gz.asc": []byte("signature\n"),
+	})
+
+	fs := New(Config{Client: client, Releases: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	/* sha256sum of "hello\n" */
+	sum := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	path := "/owner/repo/@releases/v1.0/a.tar.gz"
+	if errc, value := fs.Getxattr(path, "user.hubfs.digest"); 0 != errc || "sha256:"+sum != string(value) {
+		t.Error(errc, string(value))
+	}
+	if errc, value := fs.Getxattr(path, "user.hubfs.signature"); 0 != errc || "a.tar.gz.asc" != string(value) {
+		t.Error(errc, string(value))
+	}
+	if errc, _ := fs.Getxattr(path+".asc", "user.hubfs.signature"); -fuse.ENOATTR != errc {
+		t.Error(errc)
+	}
+
+	path = "/owner/repo/@releases/v1.0/RELEASE_SHA256SUMS"
+	errc, fh := fs.Open(path, fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buf := make([]byte, 256)
+	n := fs.Read(path, buf, 0, fh)
+	fs.Release(path, fh)
+	if !strings.HasPrefix(string(buf[:n]), sum+"  a.tar.gz\n") || 2 != strings.Count(string(buf[:n]), "\n") {
+		t.Errorf("%q", buf[:n])
+	}
+}
+
+func TestVerification(t *testing.T) {
+	client := memprov.NewClient()
+	repository := client.AddOwner("owner").AddRepository("repo")
+	ref := repository.AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("file", 0100644, []byte("hello\n"))
+	ref.AddCommit(&prov.Commit{Hash: ref.Hash(), Message: "signed\n",
+		Verification: &prov.CommitVerification{Signed: true, Verified: true, Format: "gpg", Reason: "valid"}})
+	ref.AddCommit(&prov.Commit{Hash: strings.Repeat("1", 40), Message: "unsigned\n",
+		Verification: &prov.CommitVerification{Reason: "unsigned"}})
+	other := repository.AddRef("other", prov.RefBranch, time.Now())
+
+	fs := New(Config{Client: client, Log: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	if errc, value := fs.Getxattr("/owner/repo/main", "user.hubfs.verification"); 0 != errc ||
+		"verified gpg" != string(value) {
+		t.Error(errc, string(value))
+	}
+	if errc, _ := fs.Getxattr("/owner/repo/main/file", "user.hubfs.verification"); -fuse.ENOATTR != errc {
+		t.Error(errc)
+	}
+	if errc, _ := fs.Getxattr("/owner/repo/"+other.Name(), "user.hubfs.verification"); -fuse.ENOATTR != errc {
+		t.Error(errc)
+	}
+
+	path := "/owner/repo/main/@log"
+	errc, fh := fs.Open(path, fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buf := make([]byte, 1024)
+	n := fs.Read(path, buf, 0, fh)
+	fs.Release(path, fh)
+	if 1 != strings.Count(string(buf[:n]), "Signature: verified gpg\n") ||
+		1 != strings.Count(string(buf[:n]), "Signature:") {
+		t.Errorf("%q", buf[:n])
+	}
+}
+
+func TestFormatVerification(t *testing.T) {
+	for _, c := range []struct {
+		v *prov.CommitVerification
+		s string
+	}{
+		{nil, ""},
+		{&prov.CommitVerification{Reason: "unsigned"}, "unsigned"},
+		{&prov.CommitVerification{Signed: true, Verified: true, Format: "ssh", Reason: "valid"}, "verified ssh"},
+		{&prov.CommitVerification{Signed: true, Format: "gpg", Reason: "unknown_key"}, "unverified gpg (unknown_key)"},
+	} {
+		if s := formatVerification(c.v); c.s != s {
+			t.Errorf("%q != %q", c.s, s)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("file", 0100644, []byte("hello\n"))
+
+	var audit bytes.Buffer
+	fs := New(Config{Client: client, Prefix: "/owner/repo", Audit: &audit}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, fh := fs.Open("/main/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buf := make([]byte, 3)
+	fs.Read("/main/file", buf, 0, fh)
+	fs.Read("/main/file", buf, 3, fh)
+	if 0 != audit.Len() {
+		t.Error("record written before release")
+	}
+	fs.Release("/main/file", fh)
+	errc, fh = fs.Opendir("/main")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Releasedir("/main", fh)
+
+	var recs []auditRecord
+	for _, line := range strings.Split(strings.TrimSuffix(audit.String(), "\n"), "\n") {
+		rec := auditRecord{}
+		if err := json.Unmarshal([]byte(line), &rec); nil != err {
+			t.Fatal(err, line)
+		}
+		recs = append(recs, rec)
+	}
+	if 2 != len(recs) ||
+		"read" != recs[0].Op || "/owner/repo/main/file" != recs[0].Path || 6 != recs[0].Bytes ||
+		"list" != recs[1].Op || "/owner/repo/main" != recs[1].Path || 0 != recs[1].Bytes ||
+		-1 != recs[0].Uid || "" == recs[0].Time {
+		t.Error(recs)
+	}
+}
+
+func TestDeny(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("README.md", 0100644, []byte("hello\n"))
+	ref.AddFile("key.pem", 0100644, []byte("secret\n"))
+	ref.AddFile("config/secrets/token", 0100644, []byte("secret\n"))
+
+	deny := []string{"**/*.pem", "**/secrets/**"}
+	fs := New(Config{Client: client, Deny: deny}).FileSystemInterface()
+	defer fs.Destroy()
+
+	for path, result := range map[string]int{
+		"/owner/repo/main/README.md":            0,
+		"/owner/repo/main/key.pem":              -fuse.EACCES,
+		"/owner/repo/main/config/secrets/token": -fuse.EACCES,
+	} {
+		errc, fh := fs.Open(path, fuse.O_RDONLY)
+		if result != errc {
+			t.Error(path, errc)
+		}
+		if 0 == errc {
+			fs.Release(path, fh)
+		}
+	}
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/owner/repo/main/key.pem", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+
+	fs = New(Config{Client: client, Caseins: true, Prefix: "/owner/repo", Deny: deny}).FileSystemInterface()
+	defer fs.Destroy()
+	if errc, _ := fs.Open("/main/KEY.PEM", fuse.O_RDONLY); -fuse.EACCES != errc {
+		t.Error(errc)
+	}
+}
+
+func TestCollab(t *testing.T) {
+	client := memprov.NewClient()
+	client.AddOwner("other").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	client.AddCollaborator("other/repo")
+
+	fs := New(Config{Client: client, Collab: true}).FileSystemInterface()
+	defer fs.Destroy()
+
+	errc, target := fs.Readlink("/@collab/other+repo")
+	if 0 != errc || "../other/repo" != target {
+		t.Error(errc, target)
+	}
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/@collab/missing+repo", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+}
+
+func TestAccessDiagnoses(t *testing.T) {
+	client := memprov.NewClient()
+	client.AddOwner("org").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	client.AddAccessDiagnosis(&prov.AccessDiagnosis{
+		Repository: "org/private",
+		Reason:     prov.AccessSSO,
+		Message:    "token is not authorized for the organization's SAML single sign-on",
+		Time:       time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+
+	fs := New(Config{Client: client}).FileSystemInterface()
+	defer fs.Destroy()
+
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/org/private", &stat, ^uint64(0)); -fuse.EACCES != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/org/typo", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+
+	path := "/.hubfs/access"
+	errc, fh := fs.Open(path, fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buf := make([]byte, 256)
+	n := fs.Read(path, buf, 0, fh)
+	fs.Release(path, fh)
+	if e := "2022-01-02T03:04:05Z org/private sso: " +
+		"token is not authorized for the organization's SAML single sign-on\n"; e != string(buf[:n]) {
+		t.Errorf("expect %q got %q", e, buf[:n])
+	}
+}
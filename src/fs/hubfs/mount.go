@@ -0,0 +1,144 @@
+/*
+ * mount.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// FileSystem is a hubfs file system that can be mounted by programs that embed hubfs.
+// A FileSystem can be mounted once; it is destroyed when it is unmounted.
+type FileSystem struct {
+	fs      fuse.FileSystemInterface
+	caseins bool
+	lock    sync.Mutex
+	host    *fuse.FileSystemHost
+}
+
+// New creates a file system. If the Config does not have an Unmount function, writing
+// to the .hubfs/unmount control file unmounts the file system.
+func New(c Config) *FileSystem {
+	fsys := &FileSystem{caseins: c.Caseins}
+	if nil == c.Unmount {
+		c.Unmount = func() {
+			fsys.Unmount()
+		}
+	}
+	c.notify = fsys.notify
+	fsys.fs = newfs(c)
+	return fsys
+}
+
+// FileSystemInterface returns the FUSE file system, for use with a FUSE host that is
+// managed by the caller.
+func (fsys *FileSystem) FileSystemInterface() fuse.FileSystemInterface {
+	return fsys.fs
+}
+
+// Mount mounts the file system with FUSE mount options (e.g. "ro", "allow_other") and
+// waits until it is unmounted, either by Unmount, by the system or by cancelling ctx.
+func (fsys *FileSystem) Mount(ctx context.Context, mountpoint string, opts []string) error {
+	fsys.lock.Lock()
+	if nil != fsys.host {
+		fsys.lock.Unlock()
+		return errors.New("file system has already been mounted")
+	}
+	if a, ok := fsys.fs.(*auditfs); ok {
+		a.usefuse = true
+	}
+	host := fuse.NewFileSystemHost(fsys.fs)
+	host.SetCapCaseInsensitive(fsys.caseins)
+	host.SetCapReaddirPlus(true)
+	fsys.host = host
+	fsys.lock.Unlock()
+
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+
+	stopc := make(chan struct{})
+	defer close(stopc)
+	go func() {
+		select {
+		case <-ctx.Done():
+			host.Unmount()
+		case <-stopc:
+		}
+	}()
+
+	mntopt := []string{}
+	for _, s := range opts {
+		mntopt = append(mntopt, "-o"+s)
+	}
+	if !host.Mount(mountpoint, mntopt) {
+		return errors.New("cannot mount file system at " + mountpoint)
+	}
+	return nil
+}
+
+// Unmount unmounts the file system. It reports false if the file system is not mounted.
+func (fsys *FileSystem) Unmount() bool {
+	fsys.lock.Lock()
+	host := fsys.host
+	fsys.lock.Unlock()
+	if nil == host {
+		return false
+	}
+	return host.Unmount()
+}
+
+// notify invalidates the kernel caches of a directory whose content has changed. This is
+// only supported by WinFsp; FUSE on Linux and macOS expires its caches after a short time.
+func (fsys *FileSystem) notify(path string) {
+	fsys.lock.Lock()
+	host := fsys.host
+	fsys.lock.Unlock()
+	if nil != host {
+		host.Notify(path, fuse.NOTIFY_RMDIR|fuse.NOTIFY_MKDIR)
+	}
+}
+
+// Prefetch looks up a path so that the repository content along the path is fetched and
+// cached. If the path is a directory its content is listed as well. The file system must
+// have been mounted (or initialized by the caller).
+func (fsys *FileSystem) Prefetch(path string) error {
+	fs := fsys.fs
+	if a, ok := fs.(*auditfs); ok {
+		// prefetching is not an access by a user (and is outside of a FUSE request)
+		fs = a.FileSystemInterface
+	}
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr(path, &stat, ^uint64(0)); 0 != errc {
+		return fuse.Error(errc)
+	}
+	if fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+		return nil
+	}
+	errc, fh := fs.Opendir(path)
+	if 0 != errc {
+		return fuse.Error(errc)
+	}
+	defer fs.Releasedir(path, fh)
+	fill := func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		return true
+	}
+	if errc = fs.Readdir(path, fill, 0, fh); 0 != errc {
+		return fuse.Error(errc)
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ import (
 	pathutil "path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/winfsp/cgofuse/fuse"
@@ -25,31 +26,38 @@ import (
 	"github.com/winfsp/hubfs/fs/port"
 	"github.com/winfsp/hubfs/fs/ptfs"
 	"github.com/winfsp/hubfs/fs/unionfs"
+	"github.com/winfsp/hubfs/prov"
 )
 
-func New(c Config) fuse.FileSystemInterface {
-	/* if have Prefix, clean it up and make sure it does not have more than 3 components */
+func newfs(c Config) fuse.FileSystemInterface {
+	/* if have Prefix, clean it up; it may extend into the tree of a ref */
 	c.Prefix = pathutil.Clean(c.Prefix)
 	switch c.Prefix {
 	case "/", ".":
 		c.Prefix = ""
 	}
-	slashes := 0
-	for i := 0; len(c.Prefix) > i; i++ {
-		if '/' == c.Prefix[i] {
-			slashes++
-			if 4 == slashes {
-				c.Prefix = c.Prefix[:i]
-				break
-			}
-		}
-	}
 
-	if c.Overlay {
-		return newOverlay(c)
+	var fs fuse.FileSystemInterface
+	fullpath := func(path string) string {
+		return c.Prefix + path
+	}
+	if 0 < len(c.Binds) {
+		fs = newBindfs(c)
+		fullpath = bindpath(c)
+	} else if c.Readonly {
+		fs = newReadonlyfs(new(c), c.Ignoreattr)
+	} else if c.Overlay {
+		fs = newOverlay(c)
 	} else {
-		return new(c)
+		fs = new(c)
 	}
+	if 0 < len(c.Deny) {
+		fs = newDenyfs(fs, fullpath, c.Caseins, c.Deny)
+	}
+	if nil != c.Audit {
+		fs = newAuditfs(fs, fullpath, c.Audit)
+	}
+	return fs
 }
 
 func newOverlay(c Config) fuse.FileSystemInterface {
@@ -58,16 +66,125 @@ func newOverlay(c Config) fuse.FileSystemInterface {
 	caseins := c.Caseins
 
 	topfs := new(Config{
-		Client:  c.Client,
-		Prefix:  c.Prefix,
-		Caseins: c.Caseins,
+		Client:        c.Client,
+		Prefix:        c.Prefix,
+		Caseins:       c.Caseins,
+		Unorm:         c.Unorm,
+		Treetime:      c.Treetime,
+		Ignoreattr:    c.Ignoreattr,
+		Noindex:       c.Noindex,
+		Noappledouble: c.Noappledouble,
+		Nestedrefs:    c.Nestedrefs,
+		Latest:        c.Latest,
+		Submodules:    c.Submodules,
+		Info:          c.Info,
+		Releases:      c.Releases,
+		Issues:        c.Issues,
+		Pulls:         c.Pulls,
+		Diff:          c.Diff,
+		Objects:       c.Objects,
+		Actions:       c.Actions,
+		Wiki:          c.Wiki,
+		Gists:         c.Gists,
+		Starred:       c.Starred,
+		Collab:        c.Collab,
+		Search:        c.Search,
+		Notifications: c.Notifications,
+		Log:           c.Log,
+		Archive:       c.Archive,
+		Blame:         c.Blame,
+		History:       c.History,
+		Provider:      c.Provider,
+		Uid:           c.Uid,
+		Gid:           c.Gid,
+		Fmask:         c.Fmask,
+		Dmask:         c.Dmask,
+		CacheQuota:    c.CacheQuota,
+		Timeout:       c.Timeout,
+		Unmount:       c.Unmount,
+		Reload:        c.Reload,
+		notify:        c.notify,
+		control:       c.control,
+		mounttime:     c.mounttime,
+		bind:          c.bind,
+		bindscope:     c.bindscope,
 	}).(*hubfs)
 
+	iscontrol := func(path string) bool {
+		if i := strings.IndexByte(path, '/'); -1 != i {
+			path = path[:i]
+		}
+		if caseins {
+			return strings.EqualFold(path, controlName)
+		}
+		return path == controlName
+	}
+
+	/* if the scope is at or below the root of a ref, the whole file system is one shard */
+	/* (determined on first use and retried until the scope can be resolved) */
+	inrefmux := sync.Mutex{}
+	inrefok, inrefval := false, false
+	inref := func() (bool, bool) {
+		inrefmux.Lock()
+		defer inrefmux.Unlock()
+		if !inrefok {
+			depth, errc := topfs.refdepth("/")
+			if 0 != errc {
+				return false, false
+			}
+			inrefok, inrefval = true, 0 < depth
+		}
+		return inrefval, true
+	}
+	scopelst := split(scope)
+
 	split := func(path string) (string, string) {
+		if iscontrol(strings.TrimPrefix(path, "/")) {
+			return "", path
+		}
+		if val, ok := inref(); !ok {
+			/* the scope cannot be resolved (yet); let topfs report the error */
+			return "", path
+		} else if val {
+			return "/", path
+		}
+		if c.Nestedrefs && 3 <= scopeSlashes {
+			/* the scope is within the components of a nested ref name */
+			depth, errc := topfs.refdepth(path)
+			if 0 != errc || 0 == depth {
+				return "", path
+			}
+			n := 2 + depth - len(scopelst)
+			for i := 1; len(path) > i; i++ {
+				if '/' == path[i] {
+					n--
+					if 0 == n {
+						return path[:i], path[i:]
+					}
+				}
+			}
+			return path, "/"
+		}
 		slashes := scopeSlashes
 		for i := 0; len(path) > i; i++ {
 			if '/' == path[i] {
 				slashes++
+				if scopeSlashes+1 == slashes && iscontrol(path[i+1:]) {
+					return "", path
+				}
+				if 1 == slashes && strings.HasPrefix(path[i+1:], "@") {
+					return "", path
+				}
+				if 3 == slashes && strings.HasPrefix(path[i+1:], "@") {
+					return "", path
+				}
+				if 3 == slashes && c.Nestedrefs {
+					depth, _ := topfs.refdepth(path)
+					if 0 == depth {
+						return "", path
+					}
+					slashes -= depth - 1
+				}
 				if 4 == slashes {
 					if 0 == i {
 						return "/", path
@@ -90,7 +207,10 @@ func newOverlay(c Config) fuse.FileSystemInterface {
 			}
 		}()
 
-		errc, obs := topfs.open(prefix)
+		ctx, cancel := topfs.context(prefix)
+		defer cancel()
+
+		errc, obs := topfs.open(ctx, prefix)
 		if 0 != errc {
 			return nil
 		}
@@ -102,7 +222,12 @@ func newOverlay(c Config) fuse.FileSystemInterface {
 			return nil
 		}
 
-		root = filepath.Join(root, obs.ref.Name())
+		root = filepath.Join(root,
+			strings.ReplaceAll(obs.refpath, "/", string(prov.AltPathSeparator)))
+		if len(scopelst) > obs.rootidx {
+			/* the scope is below the root of the ref */
+			root = filepath.Join(root, filepath.Join(scopelst[obs.rootidx:]...))
+		}
 		err = os.MkdirAll(root, 0755)
 		if nil != err {
 			topfs.release(obs)
@@ -117,9 +242,26 @@ func newOverlay(c Config) fuse.FileSystemInterface {
 
 		upfs := ptfs.New(root)
 		lofs := new(Config{
-			Client:  topfs.client,
-			Prefix:  pathutil.Join(scope, prefix),
-			Caseins: caseins,
+			Client:     topfs.client,
+			Prefix:     pathutil.Join(scope, prefix),
+			Caseins:    caseins,
+			Unorm:      c.Unorm,
+			Treetime:   c.Treetime,
+			Nestedrefs: c.Nestedrefs,
+			Submodules: c.Submodules,
+			Log:        c.Log,
+			Archive:    c.Archive,
+			Blame:      c.Blame,
+			Provider:   c.Provider,
+			Uid:        c.Uid,
+			Gid:        c.Gid,
+			Fmask:      c.Fmask,
+			Dmask:      c.Dmask,
+			Timeout:    c.Timeout,
+			control:    topfs.control,
+			mounttime:  topfs.mounttime,
+			bind:       c.bind,
+			bindscope:  c.bindscope,
 		})
 		unfs := unionfs.New(unionfs.Config{
 			Fslist:  []fuse.FileSystemInterface{upfs, lofs},
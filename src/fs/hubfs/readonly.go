@@ -0,0 +1,147 @@
+/*
+ * readonly.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// ST_RDONLY
+const statfsRdonly = 1
+
+// readonlyfs fails all operations that modify the file system with EROFS. This includes
+// writes to the control files. With ignoreattr the operations that only change times,
+// owner or flags succeed without doing anything.
+type readonlyfs struct {
+	fuse.FileSystemInterface
+	fuse.FileSystemGetpath
+	ignoreattr bool
+}
+
+func newReadonlyfs(fs fuse.FileSystemInterface, ignoreattr bool) fuse.FileSystemInterface {
+	return &readonlyfs{
+		FileSystemInterface: fs,
+		FileSystemGetpath:   fs.(fuse.FileSystemGetpath),
+		ignoreattr:          ignoreattr,
+	}
+}
+
+func (fs *readonlyfs) setattr(path string) int {
+	if !fs.ignoreattr {
+		return -fuse.EROFS
+	}
+	stat := fuse.Stat_t{}
+	return fs.FileSystemInterface.Getattr(path, &stat, ^uint64(0))
+}
+
+func (fs *readonlyfs) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	errc = fs.FileSystemInterface.Statfs(path, stat)
+	if 0 == errc {
+		stat.Flag |= statfsRdonly
+	}
+	return
+}
+
+func (fs *readonlyfs) Access(path string, mask uint32) (errc int) {
+	errc = fs.FileSystemInterface.Access(path, mask)
+	if (0 == errc || -fuse.ENOSYS == errc) && 0 != mask&2 /* W_OK */ {
+		errc = -fuse.EROFS
+	}
+	return
+}
+
+func (fs *readonlyfs) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Mkdir(path string, mode uint32) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Unlink(path string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Rmdir(path string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Link(oldpath string, newpath string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Symlink(target string, newpath string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Rename(oldpath string, newpath string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Chmod(path string, mode uint32) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Chown(path string, uid uint32, gid uint32) (errc int) {
+	return fs.setattr(path)
+}
+
+func (fs *readonlyfs) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	return fs.setattr(path)
+}
+
+func (fs *readonlyfs) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	return -fuse.EROFS, ^uint64(0)
+}
+
+func (fs *readonlyfs) Open(path string, flags int) (errc int, fh uint64) {
+	if fuse.O_RDONLY != flags&fuse.O_ACCMODE || 0 != flags&(fuse.O_CREAT|fuse.O_TRUNC) {
+		return -fuse.EROFS, ^uint64(0)
+	}
+	return fs.FileSystemInterface.Open(path, flags)
+}
+
+func (fs *readonlyfs) Truncate(path string, size int64, fh uint64) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Removexattr(path string, name string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *readonlyfs) Chflags(path string, flags uint32) (errc int) {
+	return fs.setattr(path)
+}
+
+func (fs *readonlyfs) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	return fs.setattr(path)
+}
+
+func (fs *readonlyfs) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	return fs.setattr(path)
+}
+
+var _ fuse.FileSystemInterface = (*readonlyfs)(nil)
+var _ fuse.FileSystemGetpath = (*readonlyfs)(nil)
+var _ fuse.FileSystemChflags = (*readonlyfs)(nil)
+var _ fuse.FileSystemSetcrtime = (*readonlyfs)(nil)
+var _ fuse.FileSystemSetchgtime = (*readonlyfs)(nil)
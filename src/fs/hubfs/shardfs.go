@@ -60,6 +60,10 @@ func (fs *shardfs) Destroy() {
 	fs.topfs.release(fs.obs)
 }
 
+func (fs *shardfs) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	return fs.topfs.Statfs("/", stat)
+}
+
 func (fs *shardfs) Mknod(path string, mode uint32, dev uint64) (errc int) {
 	errc = fs.FileSystemInterface.Mknod(path, mode, dev)
 	if 0 == errc {
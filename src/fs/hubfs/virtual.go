@@ -0,0 +1,1855 @@
+/*
+ * virtual.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/git"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// Virtual nodes are file system entries that do not exist in any repository tree.
+// They are named with a leading '@' so that they are easily distinguished from refs.
+type vnode interface {
+	Name() string
+	Mode() uint32
+	Size() int64
+	Target() string
+	Time() time.Time
+}
+
+// vdir is implemented by virtual nodes that are directories.
+type vdir interface {
+	vnode
+	lookup(ctx context.Context, name string) (vnode, error)
+	list(ctx context.Context) ([]vnode, error)
+}
+
+// vreader is implemented by virtual nodes that are files.
+type vreader interface {
+	vnode
+	reader(ctx context.Context) (io.ReaderAt, error)
+}
+
+// vremover is implemented by virtual nodes that can be unlinked.
+type vremover interface {
+	vnode
+	remove(ctx context.Context) error
+}
+
+// vxattrer is implemented by virtual nodes that have extended attributes.
+type vxattrer interface {
+	vnode
+	xattrs(add func(name, value string))
+}
+
+type vlink struct {
+	name   string
+	target string
+	time   time.Time
+}
+
+func (v *vlink) Name() string {
+	return v.name
+}
+
+func (v *vlink) Mode() uint32 {
+	return fuse.S_IFLNK
+}
+
+func (v *vlink) Size() int64 {
+	return int64(len(v.target))
+}
+
+func (v *vlink) Target() string {
+	return v.target
+}
+
+func (v *vlink) Time() time.Time {
+	return v.time
+}
+
+type vfile struct {
+	name    string
+	content []byte
+	time    time.Time
+}
+
+func (v *vfile) Name() string {
+	return v.name
+}
+
+func (v *vfile) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *vfile) Size() int64 {
+	return int64(len(v.content))
+}
+
+func (v *vfile) Target() string {
+	return ""
+}
+
+func (v *vfile) Time() time.Time {
+	return v.time
+}
+
+func (v *vfile) reader(ctx context.Context) (io.ReaderAt, error) {
+	return bytes.NewReader(v.content), nil
+}
+
+// vreleases is the @releases directory; it contains a directory for every release.
+type vreleases struct {
+	fs         *hubfs
+	repository prov.Repository
+	time       time.Time
+}
+
+func (v *vreleases) Name() string {
+	return "@releases"
+}
+
+func (v *vreleases) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vreleases) Size() int64 {
+	return 0
+}
+
+func (v *vreleases) Target() string {
+	return ""
+}
+
+func (v *vreleases) Time() time.Time {
+	return v.time
+}
+
+func (v *vreleases) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, err := v.repository.GetReleases(ctx)
+	if nil != err {
+		return nil, err
+	}
+	for _, release := range lst {
+		if v.fs.equal(release.Name, name) {
+			return &vrelease{fs: v.fs, repository: v.repository, release: release}, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (v *vreleases) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.repository.GetReleases(ctx)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, len(lst))
+	for i, release := range lst {
+		res[i] = &vrelease{fs: v.fs, repository: v.repository, release: release}
+	}
+	return res, nil
+}
+
+// vrelease is the directory of a single release; it contains the release notes
+// and the release assets.
+type vrelease struct {
+	fs         *hubfs
+	repository prov.Repository
+	release    *prov.Release
+}
+
+func (v *vrelease) Name() string {
+	return v.release.Name
+}
+
+func (v *vrelease) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vrelease) Size() int64 {
+	return 0
+}
+
+func (v *vrelease) Target() string {
+	return ""
+}
+
+func (v *vrelease) Time() time.Time {
+	return v.release.Time
+}
+
+func (v *vrelease) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, _ := v.list(ctx)
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vrelease) list(ctx context.Context) ([]vnode, error) {
+	var notes bytes.Buffer
+	if "" != v.release.Title {
+		notes.WriteString("# " + v.release.Title + "\n\n")
+	}
+	if "" != v.release.Notes {
+		notes.WriteString(v.release.Notes + "\n")
+	}
+	names := make(map[string]bool, len(v.release.Assets))
+	for _, asset := range v.release.Assets {
+		names[asset.Name] = true
+	}
+	var sums bytes.Buffer
+	res := make([]vnode, 0, 2+len(v.release.Assets))
+	res = append(res, &vfile{name: "RELEASE_NOTES.md", content: notes.Bytes(), time: v.release.Time})
+	for _, asset := range v.release.Assets {
+		a := &vasset{repository: v.repository, asset: asset}
+		for _, ext := range signatureExts {
+			if names[asset.Name+ext] {
+				a.signature = asset.Name + ext
+				break
+			}
+		}
+		res = append(res, a)
+		if strings.HasPrefix(asset.Digest, "sha256:") {
+			fmt.Fprintf(&sums, "%s  %s\n", asset.Digest[len("sha256:"):], asset.Name)
+		}
+	}
+	if 0 < sums.Len() && !names["RELEASE_SHA256SUMS"] {
+		res = append(res, &vfile{name: "RELEASE_SHA256SUMS", content: sums.Bytes(), time: v.release.Time})
+	}
+	return res, nil
+}
+
+// signatureExts are the extensions of detached signatures that are published as release
+// assets next to the assets that they sign.
+var signatureExts = []string{".asc", ".sig", ".minisig", ".sigstore.json", ".sigstore"}
+
+// vasset is a release asset; its content is downloaded from the provider when read.
+type vasset struct {
+	repository prov.Repository
+	asset      *prov.ReleaseAsset
+	signature  string // name of the detached signature asset, if any
+}
+
+func (v *vasset) Name() string {
+	return v.asset.Name
+}
+
+func (v *vasset) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *vasset) Size() int64 {
+	return v.asset.Size
+}
+
+func (v *vasset) Target() string {
+	return ""
+}
+
+func (v *vasset) Time() time.Time {
+	return v.asset.Time
+}
+
+func (v *vasset) reader(ctx context.Context) (io.ReaderAt, error) {
+	return v.repository.GetReleaseAssetReader(ctx, v.asset)
+}
+
+func (v *vasset) xattrs(add func(name, value string)) {
+	add("user.hubfs.digest", v.asset.Digest)
+	add("user.hubfs.signature", v.signature)
+}
+
+// vissues is the @issues directory; it contains the open and closed directories.
+type vissues struct {
+	fs         *hubfs
+	repository prov.Repository
+	time       time.Time
+}
+
+func (v *vissues) Name() string {
+	return "@issues"
+}
+
+func (v *vissues) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vissues) Size() int64 {
+	return 0
+}
+
+func (v *vissues) Target() string {
+	return ""
+}
+
+func (v *vissues) Time() time.Time {
+	return v.time
+}
+
+func (v *vissues) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, _ := v.list(ctx)
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vissues) list(ctx context.Context) ([]vnode, error) {
+	return []vnode{
+		&vissuestate{fs: v.fs, repository: v.repository, state: prov.IssueOpen, time: v.time},
+		&vissuestate{fs: v.fs, repository: v.repository, state: prov.IssueClosed, time: v.time},
+	}, nil
+}
+
+// vissuestate is the directory of the issues in a particular state; it contains a
+// markdown file for every issue.
+type vissuestate struct {
+	fs         *hubfs
+	repository prov.Repository
+	state      string
+	time       time.Time
+}
+
+func (v *vissuestate) Name() string {
+	return v.state
+}
+
+func (v *vissuestate) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vissuestate) Size() int64 {
+	return 0
+}
+
+func (v *vissuestate) Target() string {
+	return ""
+}
+
+func (v *vissuestate) Time() time.Time {
+	return v.time
+}
+
+// lookup fetches a single issue by the number at the start of name, so that issue files
+// can be accessed without listing all issues.
+func (v *vissuestate) lookup(ctx context.Context, name string) (vnode, error) {
+	i := strings.IndexFunc(name, func(r rune) bool { return '0' > r || '9' < r })
+	if -1 == i {
+		i = len(name)
+	}
+	number, err := strconv.Atoi(name[:i])
+	if nil != err {
+		return nil, prov.ErrNotFound
+	}
+	issue, err := v.repository.GetIssue(ctx, number)
+	if nil != err {
+		return nil, err
+	}
+	if v.state != issue.State || !v.fs.equal(issueFileName(issue), name) {
+		return nil, prov.ErrNotFound
+	}
+	return issueFile(issue), nil
+}
+
+func (v *vissuestate) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.repository.GetIssues(ctx, v.state)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, len(lst))
+	for i, issue := range lst {
+		res[i] = issueFile(issue)
+	}
+	return res, nil
+}
+
+// issueFileName returns the file name of an issue: its number followed by a slug
+// of its title (e.g. "123-crash-on-startup.md").
+func issueFileName(issue *prov.Issue) string {
+	return slugFileName(strconv.Itoa(issue.Number), issue.Title, ".md")
+}
+
+// slugFileName returns a file name made of an id followed by a slug of a title.
+func slugFileName(id string, title string, ext string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && 0 < slug.Len() {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if 60 <= slug.Len() {
+			break
+		}
+	}
+	if 0 == slug.Len() {
+		return id + ext
+	}
+	return id + "-" + slug.String() + ext
+}
+
+func issueFile(issue *prov.Issue) *vfile {
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "# %s (#%d)\n\n", issue.Title, issue.Number)
+	fmt.Fprintf(&content, "- State: %s\n", issue.State)
+	fmt.Fprintf(&content, "- Author: @%s\n", issue.Author)
+	if 0 < len(issue.Labels) {
+		fmt.Fprintf(&content, "- Labels: %s\n", strings.Join(issue.Labels, ", "))
+	}
+	fmt.Fprintf(&content, "- Created: %s\n", issue.Created.Format(time.RFC3339))
+	fmt.Fprintf(&content, "- Updated: %s\n", issue.Updated.Format(time.RFC3339))
+	if "" != issue.WebURL {
+		fmt.Fprintf(&content, "- URL: %s\n", issue.WebURL)
+	}
+	if "" != issue.Body {
+		fmt.Fprintf(&content, "\n%s\n", strings.TrimRight(issue.Body, "\n"))
+	}
+	return &vfile{name: issueFileName(issue), content: content.Bytes(), time: issue.Updated}
+}
+
+// vpulls is the @pulls directory; it contains a directory for every open pull request.
+// Pull requests that are not open may still be accessed by number.
+type vpulls struct {
+	fs         *hubfs
+	repository prov.Repository
+	time       time.Time
+}
+
+func (v *vpulls) Name() string {
+	return "@pulls"
+}
+
+func (v *vpulls) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vpulls) Size() int64 {
+	return 0
+}
+
+func (v *vpulls) Target() string {
+	return ""
+}
+
+func (v *vpulls) Time() time.Time {
+	return v.time
+}
+
+func (v *vpulls) lookup(ctx context.Context, name string) (vnode, error) {
+	number, err := strconv.Atoi(name)
+	if nil != err || strconv.Itoa(number) != name {
+		return nil, prov.ErrNotFound
+	}
+	pull, err := v.repository.GetPullRequest(ctx, number)
+	if nil != err {
+		return nil, err
+	}
+	return &vpull{fs: v.fs, repository: v.repository, pull: pull}, nil
+}
+
+func (v *vpulls) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.repository.GetPullRequests(ctx)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, len(lst))
+	for i, pull := range lst {
+		res[i] = &vpull{fs: v.fs, repository: v.repository, pull: pull}
+	}
+	return res, nil
+}
+
+// vpull is the directory of a single pull request; it contains the pull request
+// metadata, its description and its changes as a patch.
+type vpull struct {
+	fs         *hubfs
+	repository prov.Repository
+	pull       *prov.PullRequest
+}
+
+func (v *vpull) Name() string {
+	return strconv.Itoa(v.pull.Number)
+}
+
+func (v *vpull) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vpull) Size() int64 {
+	return 0
+}
+
+func (v *vpull) Target() string {
+	return ""
+}
+
+func (v *vpull) Time() time.Time {
+	return v.pull.Updated
+}
+
+func (v *vpull) lookup(ctx context.Context, name string) (vnode, error) {
+	switch {
+	case v.fs.equal("meta.json", name):
+		content, err := json.MarshalIndent(v.pull, "", "  ")
+		if nil != err {
+			return nil, err
+		}
+		content = append(content, '\n')
+		return &vfile{name: "meta.json", content: content, time: v.pull.Updated}, nil
+	case v.fs.equal("description.md", name):
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "# %s (#%d)\n", v.pull.Title, v.pull.Number)
+		if "" != v.pull.Body {
+			fmt.Fprintf(&content, "\n%s\n", strings.TrimRight(v.pull.Body, "\n"))
+		}
+		return &vfile{name: "description.md", content: content.Bytes(), time: v.pull.Updated}, nil
+	case v.fs.equal("changes.patch", name):
+		content, err := v.repository.GetPullRequestPatch(ctx, v.pull.Number)
+		if nil != err {
+			return nil, err
+		}
+		return &vfile{name: "changes.patch", content: content, time: v.pull.Updated}, nil
+	}
+	return nil, prov.ErrNotFound
+}
+
+// list returns the patch with unknown size (-1); it is not downloaded until looked up.
+func (v *vpull) list(ctx context.Context) ([]vnode, error) {
+	res := make([]vnode, 0, 3)
+	for _, n := range []string{"meta.json", "description.md"} {
+		if c, err := v.lookup(ctx, n); nil == err {
+			res = append(res, c)
+		}
+	}
+	res = append(res, &vpatch{repository: v.repository, pull: v.pull})
+	return res, nil
+}
+
+// vpatch is the changes.patch file of a pull request, as listed in its directory.
+type vpatch struct {
+	repository prov.Repository
+	pull       *prov.PullRequest
+}
+
+func (v *vpatch) Name() string {
+	return "changes.patch"
+}
+
+func (v *vpatch) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *vpatch) Size() int64 {
+	return -1
+}
+
+func (v *vpatch) Target() string {
+	return ""
+}
+
+func (v *vpatch) Time() time.Time {
+	return v.pull.Updated
+}
+
+func (v *vpatch) reader(ctx context.Context) (io.ReaderAt, error) {
+	content, err := v.repository.GetPullRequestPatch(ctx, v.pull.Number)
+	if nil != err {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+// varchives is the @archive directory; it contains an archive of the ref content for
+// every supported archive format.
+type varchives struct {
+	fs         *hubfs
+	repository prov.Repository
+	ref        prov.Ref
+}
+
+func (v *varchives) Name() string {
+	return "@archive"
+}
+
+func (v *varchives) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *varchives) Size() int64 {
+	return 0
+}
+
+func (v *varchives) Target() string {
+	return ""
+}
+
+func (v *varchives) Time() time.Time {
+	return v.ref.TreeTime()
+}
+
+// lookup downloads the archive, because its size is not known until then.
+func (v *varchives) lookup(ctx context.Context, name string) (vnode, error) {
+	for _, format := range prov.ArchiveFormats {
+		if !v.fs.equal("source."+format, name) {
+			continue
+		}
+		reader, size, err := v.repository.GetArchiveReader(ctx, v.ref, format)
+		if nil != err {
+			return nil, err
+		}
+		if c, ok := reader.(io.Closer); ok {
+			c.Close()
+		}
+		return &varchive{repository: v.repository, ref: v.ref, format: format, size: size}, nil
+	}
+	return nil, prov.ErrNotFound
+}
+
+// list returns archives of unknown size (-1); they are not downloaded until looked up.
+func (v *varchives) list(ctx context.Context) ([]vnode, error) {
+	res := make([]vnode, len(prov.ArchiveFormats))
+	for i, format := range prov.ArchiveFormats {
+		res[i] = &varchive{repository: v.repository, ref: v.ref, format: format, size: -1}
+	}
+	return res, nil
+}
+
+// varchive is an archive of the content of a ref.
+type varchive struct {
+	repository prov.Repository
+	ref        prov.Ref
+	format     string
+	size       int64
+}
+
+func (v *varchive) Name() string {
+	return "source." + v.format
+}
+
+func (v *varchive) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *varchive) Size() int64 {
+	return v.size
+}
+
+func (v *varchive) Target() string {
+	return ""
+}
+
+func (v *varchive) Time() time.Time {
+	return v.ref.TreeTime()
+}
+
+func (v *varchive) reader(ctx context.Context) (io.ReaderAt, error) {
+	reader, _, err := v.repository.GetArchiveReader(ctx, v.ref, v.format)
+	return reader, err
+}
+
+// vstarred is the @starred directory at the root; it contains a symlink for every
+// repository starred by the authenticated user. Symlinks are named owner+repository.
+type vstarred struct {
+	fs   *hubfs
+	time time.Time
+}
+
+func (v *vstarred) Name() string {
+	return "@starred"
+}
+
+func (v *vstarred) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vstarred) Size() int64 {
+	return 0
+}
+
+func (v *vstarred) Target() string {
+	return ""
+}
+
+func (v *vstarred) Time() time.Time {
+	return v.time
+}
+
+func (v *vstarred) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, err := v.list(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vstarred) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.fs.client.GetStarredRepositories(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return repolinks(lst, "../", v.time), nil
+}
+
+// vcollab is the @collab directory at the root; it contains a symlink for every
+// repository that the authenticated user collaborates on, named like those in @starred.
+type vcollab struct {
+	fs   *hubfs
+	time time.Time
+}
+
+func (v *vcollab) Name() string {
+	return "@collab"
+}
+
+func (v *vcollab) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vcollab) Size() int64 {
+	return 0
+}
+
+func (v *vcollab) Target() string {
+	return ""
+}
+
+func (v *vcollab) Time() time.Time {
+	return v.time
+}
+
+func (v *vcollab) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, err := v.list(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vcollab) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.fs.client.GetCollaboratorRepositories(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return repolinks(lst, "../", v.time), nil
+}
+
+// vsearch is the @search directory at the root. It cannot be listed; every name in it
+// is a search query, with '+' standing for a space (e.g. language:go+topic:fuse).
+type vsearch struct {
+	fs   *hubfs
+	time time.Time
+}
+
+func (v *vsearch) Name() string {
+	return "@search"
+}
+
+func (v *vsearch) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vsearch) Size() int64 {
+	return 0
+}
+
+func (v *vsearch) Target() string {
+	return ""
+}
+
+func (v *vsearch) Time() time.Time {
+	return v.time
+}
+
+func (v *vsearch) lookup(ctx context.Context, name string) (vnode, error) {
+	query := strings.TrimSpace(strings.ReplaceAll(name, "+", " "))
+	if "" == query {
+		return nil, prov.ErrNotFound
+	}
+	if v.fs.caseins {
+		// searches ignore case; a single name keeps the file system caches consistent
+		name = strings.ToLower(name)
+	}
+	return &vsearchresult{fs: v.fs, name: name, query: query, time: v.time}, nil
+}
+
+func (v *vsearch) list(ctx context.Context) ([]vnode, error) {
+	return []vnode{}, nil
+}
+
+// vsearchresult is the directory of a search query; it contains a symlink for every
+// matching repository. Symlinks are named owner+repository.
+type vsearchresult struct {
+	fs    *hubfs
+	name  string
+	query string
+	time  time.Time
+}
+
+func (v *vsearchresult) Name() string {
+	return v.name
+}
+
+func (v *vsearchresult) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vsearchresult) Size() int64 {
+	return 0
+}
+
+func (v *vsearchresult) Target() string {
+	return ""
+}
+
+func (v *vsearchresult) Time() time.Time {
+	return v.time
+}
+
+func (v *vsearchresult) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, err := v.list(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vsearchresult) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.fs.client.SearchRepositories(ctx, v.query)
+	if nil != err {
+		return nil, err
+	}
+	return repolinks(lst, "../../", v.time), nil
+}
+
+// repolinks returns symlinks to repositories from their full names (owner/repository).
+// The targets are relative to the root through the specified path prefix.
+func repolinks(names []string, prefix string, t time.Time) []vnode {
+	res := make([]vnode, len(names))
+	for i, n := range names {
+		res[i] = &vlink{
+			name:   strings.Replace(n, "/", string(prov.AltPathSeparator), 1),
+			target: prefix + n,
+			time:   t,
+		}
+	}
+	return res
+}
+
+// vnotifications is the @notifications directory at the root; it contains a markdown
+// file for every unread notification of the authenticated user.
+type vnotifications struct {
+	fs   *hubfs
+	time time.Time
+}
+
+func (v *vnotifications) Name() string {
+	return "@notifications"
+}
+
+func (v *vnotifications) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vnotifications) Size() int64 {
+	return 0
+}
+
+func (v *vnotifications) Target() string {
+	return ""
+}
+
+func (v *vnotifications) Time() time.Time {
+	return v.time
+}
+
+func (v *vnotifications) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, err := v.list(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vnotifications) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.fs.client.GetNotifications(ctx)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, len(lst))
+	for i, note := range lst {
+		res[i] = notificationFile(v.fs, note)
+	}
+	return res, nil
+}
+
+// vnotification is a notification file; unlinking it marks the notification as read.
+type vnotification struct {
+	vfile
+	fs *hubfs
+	id string
+}
+
+func (v *vnotification) remove(ctx context.Context) error {
+	return v.fs.client.MarkNotificationRead(ctx, v.id)
+}
+
+func notificationFile(fs *hubfs, note *prov.Notification) *vnotification {
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "# %s\n\n", note.Title)
+	fmt.Fprintf(&content, "- Repository: %s\n", note.Repository)
+	fmt.Fprintf(&content, "- Type: %s\n", note.Type)
+	fmt.Fprintf(&content, "- Reason: %s\n", note.Reason)
+	fmt.Fprintf(&content, "- Updated: %s\n", note.Updated.Format(time.RFC3339))
+	if "" != note.WebURL {
+		fmt.Fprintf(&content, "- URL: %s\n", note.WebURL)
+	}
+	return &vnotification{
+		vfile: vfile{
+			name:    slugFileName(note.ID, note.Title, ".md"),
+			content: content.Bytes(),
+			time:    note.Updated,
+		},
+		fs: fs,
+		id: note.ID,
+	}
+}
+
+// blameCacheSize is the total size of the formatted blames that are kept in memory, so
+// that the blame of a file is not fetched and formatted again on every lookup.
+const blameCacheSize = 16 << 20
+
+// vblame is the @blame directory or one of its subdirectories. It mirrors the tree of
+// the ref; every file in it is the blame of the file with the same path in the tree.
+type vblame struct {
+	fs         *hubfs
+	repository prov.Repository
+	ref        prov.Ref
+	entry      prov.TreeEntry // nil for the @blame directory
+	path       string
+}
+
+func (v *vblame) Name() string {
+	if nil == v.entry {
+		return "@blame"
+	}
+	return v.fs.normname(v.entry.Name())
+}
+
+func (v *vblame) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vblame) Size() int64 {
+	return 0
+}
+
+func (v *vblame) Target() string {
+	return ""
+}
+
+func (v *vblame) Time() time.Time {
+	return v.ref.TreeTime()
+}
+
+// lookup fetches the blame of a file, because its size is not known until then.
+func (v *vblame) lookup(ctx context.Context, name string) (vnode, error) {
+	var entry prov.TreeEntry
+	err := v.fs.unormlookup(name, func(n string) (err error) {
+		entry, err = v.repository.GetTreeEntry(ctx, v.ref, v.entry, n)
+		return
+	})
+	if nil != err {
+		return nil, err
+	}
+	n := v.node(entry)
+	if nil == n {
+		return nil, prov.ErrNotFound
+	}
+	if f, ok := n.(*vblamefile); ok {
+		content, err := f.content(ctx)
+		if nil != err {
+			return nil, err
+		}
+		return &vfile{name: f.Name(), content: content, time: f.Time()}, nil
+	}
+	return n, nil
+}
+
+// list returns blame files of unknown size (-1); they are not fetched until looked up.
+func (v *vblame) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.repository.GetTree(ctx, v.ref, v.entry)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, 0, len(lst))
+	for _, entry := range lst {
+		if n := v.node(entry); nil != n {
+			res = append(res, n)
+		}
+	}
+	return res, nil
+}
+
+// node returns the node for a tree entry; there is no blame for symlinks and submodules.
+func (v *vblame) node(entry prov.TreeEntry) vnode {
+	path := entry.Name()
+	if "" != v.path {
+		path = v.path + "/" + path
+	}
+	switch entry.Mode() & fuse.S_IFMT {
+	case fuse.S_IFDIR:
+		return &vblame{fs: v.fs, repository: v.repository, ref: v.ref, entry: entry, path: path}
+	case fuse.S_IFREG:
+		return &vblamefile{fs: v.fs, repository: v.repository, ref: v.ref, entry: entry, path: path}
+	}
+	return nil
+}
+
+// vblamefile is the blame of a file.
+type vblamefile struct {
+	fs         *hubfs
+	repository prov.Repository
+	ref        prov.Ref
+	entry      prov.TreeEntry
+	path       string
+}
+
+func (v *vblamefile) Name() string {
+	return v.fs.normname(v.entry.Name())
+}
+
+func (v *vblamefile) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *vblamefile) Size() int64 {
+	return -1
+}
+
+func (v *vblamefile) Target() string {
+	return ""
+}
+
+func (v *vblamefile) Time() time.Time {
+	return v.ref.TreeTime()
+}
+
+func (v *vblamefile) reader(ctx context.Context) (io.ReaderAt, error) {
+	content, err := v.content(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+func (v *vblamefile) content(ctx context.Context) ([]byte, error) {
+	key := v.ref.Hash() + ":" + v.path
+	if content, ok := v.fs.blames.Get(key); ok {
+		return content, nil
+	}
+	blame, err := v.repository.GetBlame(ctx, v.ref, v.path)
+	if nil != err {
+		return nil, err
+	}
+	reader, err := v.repository.GetBlobReader(ctx, v.entry)
+	if nil != err {
+		return nil, err
+	}
+	if c, ok := reader.(io.Closer); ok {
+		defer c.Close()
+	}
+	data := make([]byte, v.entry.Size())
+	n, err := reader.ReadAt(data, 0)
+	if nil != err && io.EOF != err {
+		return nil, err
+	}
+	content := formatBlame(blame, data[:n])
+	v.fs.blames.Set(key, content)
+	return content, nil
+}
+
+// formatBlame formats the blame of a file similar to the default format of "git blame".
+func formatBlame(blame []*prov.BlameRange, data []byte) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	if "" == lines[len(lines)-1] {
+		lines = lines[:len(lines)-1]
+	}
+	width, awidth := len(strconv.Itoa(len(lines))), 0
+	for _, r := range blame {
+		if awidth < len(r.Author) {
+			awidth = len(r.Author)
+		}
+	}
+	var content bytes.Buffer
+	for _, r := range blame {
+		commit := r.Commit
+		if 8 < len(commit) {
+			commit = commit[:8]
+		}
+		for i := r.Start; r.End >= i && len(lines) >= i; i++ {
+			if 1 > i {
+				continue
+			}
+			fmt.Fprintf(&content, "%s (%-*s %s %*d) %s", commit, awidth, r.Author,
+				r.AuthorTime.Format("2006-01-02 15:04:05 -0700"), width, i, lines[i-1])
+			if !strings.HasSuffix(lines[i-1], "\n") {
+				content.WriteByte('\n')
+			}
+		}
+	}
+	return content.Bytes()
+}
+
+// vdiff is the @diff directory. It cannot be listed; every name of the form
+// base..head.patch in it is a file with the changes between the base and head refs
+// (or commits). Ref names use AltPathSeparator like ref directories.
+type vdiff struct {
+	fs         *hubfs
+	repository prov.Repository
+	time       time.Time
+}
+
+func (v *vdiff) Name() string {
+	return "@diff"
+}
+
+func (v *vdiff) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vdiff) Size() int64 {
+	return 0
+}
+
+func (v *vdiff) Target() string {
+	return ""
+}
+
+func (v *vdiff) Time() time.Time {
+	return v.time
+}
+
+func (v *vdiff) lookup(ctx context.Context, name string) (vnode, error) {
+	base, head, ok := splitDiffName(name)
+	if !ok {
+		return nil, prov.ErrNotFound
+	}
+	content, err := v.repository.GetDiff(ctx, base, head)
+	if nil != err {
+		return nil, err
+	}
+	return &vfile{name: name, content: content, time: v.time}, nil
+}
+
+func (v *vdiff) list(ctx context.Context) ([]vnode, error) {
+	return []vnode{}, nil
+}
+
+// splitDiffName splits a name of the form base..head.patch into its base and head
+// ref names.
+func splitDiffName(name string) (base string, head string, ok bool) {
+	if !strings.HasSuffix(name, ".patch") {
+		return "", "", false
+	}
+	name = strings.TrimSuffix(name, ".patch")
+	i := strings.Index(name, "..")
+	if -1 == i {
+		return "", "", false
+	}
+	base, head = name[:i], name[i+2:]
+	if "" == base || "" == head || strings.Contains(head, "..") {
+		return "", "", false
+	}
+	base = strings.ReplaceAll(base, string(prov.AltPathSeparator), "/")
+	head = strings.ReplaceAll(head, string(prov.AltPathSeparator), "/")
+	return base, head, true
+}
+
+// vobjects is the @objects directory. It cannot be listed; every name in it that is the
+// hash of a git object of the repository is a file with the content of the object. Trees
+// are formatted like "git cat-file -p" does; the other objects are already text or are
+// blobs, whose content is returned as is.
+type vobjects struct {
+	fs         *hubfs
+	repository prov.Repository
+	time       time.Time
+}
+
+func (v *vobjects) Name() string {
+	return "@objects"
+}
+
+func (v *vobjects) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vobjects) Size() int64 {
+	return 0
+}
+
+func (v *vobjects) Target() string {
+	return ""
+}
+
+func (v *vobjects) Time() time.Time {
+	return v.time
+}
+
+func (v *vobjects) lookup(ctx context.Context, name string) (vnode, error) {
+	kind, content, err := v.repository.GetObject(ctx, name)
+	if nil != err {
+		return nil, err
+	}
+	if "tree" == kind {
+		content, err = formatTree(content)
+		if nil != err {
+			return nil, err
+		}
+	}
+	return &vfile{name: strings.ToLower(name), content: content, time: v.time}, nil
+}
+
+func (v *vobjects) list(ctx context.Context) ([]vnode, error) {
+	return []vnode{}, nil
+}
+
+// vhistory is the @history directory; it contains a directory for each of the recent
+// commits of the default ref, named after the commit hash. The commit directories are
+// not virtual nodes: openex opens them as refs (see enterhistory).
+type vhistory struct {
+	fs         *hubfs
+	repository prov.Repository
+	time       time.Time
+}
+
+func (v *vhistory) Name() string {
+	return "@history"
+}
+
+func (v *vhistory) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vhistory) Size() int64 {
+	return 0
+}
+
+func (v *vhistory) Target() string {
+	return ""
+}
+
+func (v *vhistory) Time() time.Time {
+	return v.time
+}
+
+func (v *vhistory) lookup(ctx context.Context, name string) (vnode, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (v *vhistory) list(ctx context.Context) ([]vnode, error) {
+	ref, err := v.repository.GetDefaultRef(ctx)
+	if nil != err {
+		return nil, err
+	}
+	commits, err := v.repository.GetRecentCommits(ctx, ref, v.fs.history)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, 0, len(commits))
+	for _, c := range commits {
+		res = append(res, &vcommit{commit: c})
+	}
+	return res, nil
+}
+
+// vcommit is a commit directory in the @history listing.
+type vcommit struct {
+	commit *prov.Commit
+}
+
+func (v *vcommit) Name() string {
+	return v.commit.Hash
+}
+
+func (v *vcommit) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vcommit) Size() int64 {
+	return 0
+}
+
+func (v *vcommit) Target() string {
+	return ""
+}
+
+func (v *vcommit) Time() time.Time {
+	return v.commit.CommitTime
+}
+
+// formatTree formats a tree object like "git cat-file -p" does.
+func formatTree(data []byte) ([]byte, error) {
+	entries, err := git.DecodeTree(data)
+	if nil != err {
+		return nil, err
+	}
+	var content bytes.Buffer
+	for _, e := range entries {
+		kind := "blob"
+		switch e.Mode {
+		case 0040000:
+			kind = "tree"
+		case 0160000:
+			kind = "commit"
+		}
+		fmt.Fprintf(&content, "%06o %s %s\t%s\n", e.Mode, kind, e.Hash, e.Name)
+	}
+	return content.Bytes(), nil
+}
+
+// vactions is the @actions directory; it contains a directory for every workflow,
+// named after the workflow file.
+type vactions struct {
+	fs         *hubfs
+	repository prov.Repository
+	time       time.Time
+}
+
+func (v *vactions) Name() string {
+	return "@actions"
+}
+
+func (v *vactions) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vactions) Size() int64 {
+	return 0
+}
+
+func (v *vactions) Target() string {
+	return ""
+}
+
+func (v *vactions) Time() time.Time {
+	return v.time
+}
+
+func (v *vactions) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, err := v.list(ctx)
+	if nil != err {
+		return nil, err
+	}
+	return v.fs.vfind(lst, name)
+}
+
+func (v *vactions) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.repository.GetWorkflows(ctx)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, len(lst))
+	for i, workflow := range lst {
+		res[i] = &vworkflow{fs: v.fs, repository: v.repository, workflow: workflow, time: v.time}
+	}
+	return res, nil
+}
+
+// vworkflow is the directory of a single workflow; it contains a directory for each of
+// the most recent runs of the workflow, named after the run id. Older runs may still be
+// accessed by id.
+type vworkflow struct {
+	fs         *hubfs
+	repository prov.Repository
+	workflow   *prov.Workflow
+	time       time.Time
+}
+
+func (v *vworkflow) Name() string {
+	return pathutil.Base(v.workflow.Path)
+}
+
+func (v *vworkflow) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vworkflow) Size() int64 {
+	return 0
+}
+
+func (v *vworkflow) Target() string {
+	return ""
+}
+
+func (v *vworkflow) Time() time.Time {
+	return v.time
+}
+
+func (v *vworkflow) lookup(ctx context.Context, name string) (vnode, error) {
+	id, err := strconv.ParseInt(name, 10, 64)
+	if nil != err || strconv.FormatInt(id, 10) != name {
+		return nil, prov.ErrNotFound
+	}
+	run, err := v.repository.GetWorkflowRun(ctx, id)
+	if nil != err {
+		return nil, err
+	}
+	if run.Workflow != v.workflow.ID {
+		return nil, prov.ErrNotFound
+	}
+	return &vrun{fs: v.fs, repository: v.repository, run: run}, nil
+}
+
+func (v *vworkflow) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.repository.GetWorkflowRuns(ctx, v.workflow)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, len(lst))
+	for i, run := range lst {
+		res[i] = &vrun{fs: v.fs, repository: v.repository, run: run}
+	}
+	return res, nil
+}
+
+// vrun is the directory of a single workflow run; it contains the run metadata, a log
+// file for every job and the artifacts directory.
+type vrun struct {
+	fs         *hubfs
+	repository prov.Repository
+	run        *prov.WorkflowRun
+}
+
+func (v *vrun) Name() string {
+	return strconv.FormatInt(v.run.ID, 10)
+}
+
+func (v *vrun) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vrun) Size() int64 {
+	return 0
+}
+
+func (v *vrun) Target() string {
+	return ""
+}
+
+func (v *vrun) Time() time.Time {
+	return v.run.Updated
+}
+
+// lookup downloads a job log, because its size is not known until then.
+func (v *vrun) lookup(ctx context.Context, name string) (vnode, error) {
+	switch {
+	case v.fs.equal("run.json", name):
+		return v.meta()
+	case v.fs.equal("artifacts", name):
+		return &vartifacts{fs: v.fs, repository: v.repository, run: v.run}, nil
+	}
+	jobs, err := v.repository.GetWorkflowJobs(ctx, v.run)
+	if nil != err {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if !v.fs.equal(job.Name+".log", name) {
+			continue
+		}
+		reader, size, err := v.repository.GetWorkflowJobLogReader(ctx, job)
+		if nil != err {
+			return nil, err
+		}
+		if c, ok := reader.(io.Closer); ok {
+			c.Close()
+		}
+		return &vjoblog{repository: v.repository, job: job, size: size}, nil
+	}
+	return nil, prov.ErrNotFound
+}
+
+// list returns job logs of unknown size (-1); they are not downloaded until looked up.
+func (v *vrun) list(ctx context.Context) ([]vnode, error) {
+	jobs, err := v.repository.GetWorkflowJobs(ctx, v.run)
+	if nil != err {
+		return nil, err
+	}
+	meta, err := v.meta()
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, 0, 2+len(jobs))
+	res = append(res, meta)
+	for _, job := range jobs {
+		res = append(res, &vjoblog{repository: v.repository, job: job, size: -1})
+	}
+	res = append(res, &vartifacts{fs: v.fs, repository: v.repository, run: v.run})
+	return res, nil
+}
+
+func (v *vrun) meta() (vnode, error) {
+	content, err := json.MarshalIndent(v.run, "", "  ")
+	if nil != err {
+		return nil, err
+	}
+	content = append(content, '\n')
+	return &vfile{name: "run.json", content: content, time: v.run.Updated}, nil
+}
+
+// vjoblog is the log of a workflow job.
+type vjoblog struct {
+	repository prov.Repository
+	job        *prov.WorkflowJob
+	size       int64
+}
+
+func (v *vjoblog) Name() string {
+	return v.job.Name + ".log"
+}
+
+func (v *vjoblog) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *vjoblog) Size() int64 {
+	return v.size
+}
+
+func (v *vjoblog) Target() string {
+	return ""
+}
+
+func (v *vjoblog) Time() time.Time {
+	if !v.job.Completed.IsZero() {
+		return v.job.Completed
+	}
+	return v.job.Started
+}
+
+func (v *vjoblog) reader(ctx context.Context) (io.ReaderAt, error) {
+	reader, _, err := v.repository.GetWorkflowJobLogReader(ctx, v.job)
+	return reader, err
+}
+
+// vartifacts is the artifacts directory of a workflow run; it contains the zip archive
+// of every artifact that has not expired.
+type vartifacts struct {
+	fs         *hubfs
+	repository prov.Repository
+	run        *prov.WorkflowRun
+}
+
+func (v *vartifacts) Name() string {
+	return "artifacts"
+}
+
+func (v *vartifacts) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (v *vartifacts) Size() int64 {
+	return 0
+}
+
+func (v *vartifacts) Target() string {
+	return ""
+}
+
+func (v *vartifacts) Time() time.Time {
+	return v.run.Updated
+}
+
+// lookup downloads the artifact, because its size is not known until then.
+func (v *vartifacts) lookup(ctx context.Context, name string) (vnode, error) {
+	lst, err := v.repository.GetArtifacts(ctx, v.run)
+	if nil != err {
+		return nil, err
+	}
+	for _, artifact := range lst {
+		if artifact.Expired || !v.fs.equal(artifact.Name+".zip", name) {
+			continue
+		}
+		reader, size, err := v.repository.GetArtifactReader(ctx, artifact)
+		if nil != err {
+			return nil, err
+		}
+		if c, ok := reader.(io.Closer); ok {
+			c.Close()
+		}
+		return &vartifact{repository: v.repository, artifact: artifact, size: size}, nil
+	}
+	return nil, prov.ErrNotFound
+}
+
+// list returns artifacts of unknown size (-1); they are not downloaded until looked up.
+func (v *vartifacts) list(ctx context.Context) ([]vnode, error) {
+	lst, err := v.repository.GetArtifacts(ctx, v.run)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]vnode, 0, len(lst))
+	for _, artifact := range lst {
+		if !artifact.Expired {
+			res = append(res, &vartifact{repository: v.repository, artifact: artifact, size: -1})
+		}
+	}
+	return res, nil
+}
+
+// vartifact is the zip archive of a workflow artifact.
+type vartifact struct {
+	repository prov.Repository
+	artifact   *prov.Artifact
+	size       int64
+}
+
+func (v *vartifact) Name() string {
+	return v.artifact.Name + ".zip"
+}
+
+func (v *vartifact) Mode() uint32 {
+	return fuse.S_IFREG
+}
+
+func (v *vartifact) Size() int64 {
+	return v.size
+}
+
+func (v *vartifact) Target() string {
+	return ""
+}
+
+func (v *vartifact) Time() time.Time {
+	return v.artifact.Time
+}
+
+func (v *vartifact) reader(ctx context.Context) (io.ReaderAt, error) {
+	reader, _, err := v.repository.GetArtifactReader(ctx, v.artifact)
+	return reader, err
+}
+
+// vfind returns the virtual node with the specified name from a directory listing.
+func (fs *hubfs) vfind(lst []vnode, name string) (vnode, error) {
+	for _, n := range lst {
+		if fs.equal(n.Name(), name) {
+			return n, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func vlookup(ctx context.Context, v vnode, name string) (vnode, error) {
+	if d, ok := v.(vdir); ok {
+		return d.lookup(ctx, name)
+	}
+	return nil, prov.ErrNotFound
+}
+
+// repovirtual returns the virtual node with the specified name at the repository level.
+func (fs *hubfs) repovirtual(ctx context.Context, obs *obstack, name string) (res vnode, err error) {
+	switch {
+	case fs.equal("@default", name):
+		ref, err := obs.repository.GetDefaultRef(ctx)
+		if nil != err {
+			return nil, err
+		}
+		return &vlink{name: "@default", target: ref.Name(), time: time.Now()}, nil
+	case fs.latest && fs.equal("@latest", name):
+		ref, err := obs.repository.GetLatestRef(ctx)
+		if nil != err {
+			return nil, err
+		}
+		return &vlink{name: "@latest", target: ref.Name(), time: time.Now()}, nil
+	case fs.info && fs.equal("@info.json", name):
+		info, err := obs.repository.GetInfo(ctx)
+		if nil != err {
+			return nil, err
+		}
+		content, err := json.MarshalIndent(info, "", "  ")
+		if nil != err {
+			return nil, err
+		}
+		content = append(content, '\n')
+		return &vfile{name: "@info.json", content: content, time: time.Now()}, nil
+	case fs.releases && fs.equal("@releases", name):
+		return &vreleases{fs: fs, repository: obs.repository, time: time.Now()}, nil
+	case fs.issues && fs.equal("@issues", name):
+		return &vissues{fs: fs, repository: obs.repository, time: time.Now()}, nil
+	case fs.pulls && fs.equal("@pulls", name):
+		return &vpulls{fs: fs, repository: obs.repository, time: time.Now()}, nil
+	case fs.diff && fs.equal("@diff", name):
+		return &vdiff{fs: fs, repository: obs.repository, time: time.Now()}, nil
+	case fs.actions && fs.equal("@actions", name):
+		return &vactions{fs: fs, repository: obs.repository, time: time.Now()}, nil
+	case fs.objects && fs.equal("@objects", name):
+		return &vobjects{fs: fs, repository: obs.repository, time: time.Now()}, nil
+	case 0 < fs.history && fs.equal("@history", name):
+		return &vhistory{fs: fs, repository: obs.repository, time: time.Now()}, nil
+	}
+	return nil, prov.ErrNotFound
+}
+
+// isrepovirtual determines if name is the name of a virtual node at the repository level.
+// Such names hide any ref with the same name.
+func (fs *hubfs) isrepovirtual(name string) bool {
+	return fs.equal("@default", name) ||
+		(fs.latest && fs.equal("@latest", name)) ||
+		(fs.info && fs.equal("@info.json", name)) ||
+		(fs.releases && fs.equal("@releases", name)) ||
+		(fs.issues && fs.equal("@issues", name)) ||
+		(fs.pulls && fs.equal("@pulls", name)) ||
+		(fs.diff && fs.equal("@diff", name)) ||
+		(fs.actions && fs.equal("@actions", name)) ||
+		(fs.objects && fs.equal("@objects", name)) ||
+		(0 < fs.history && fs.equal("@history", name))
+}
+
+// logCommits is the maximum number of commits in the @log files. It bounds the number of
+// history pages that are fetched when the files are looked up (e.g. by "ls -l").
+const logCommits = 1000
+
+// refvirtual returns the virtual node with the specified name at the ref root level.
+func (fs *hubfs) refvirtual(ctx context.Context, obs *obstack, name string) (res vnode, err error) {
+	switch {
+	case fs.log && fs.equal("@log", name):
+		commits, err := obs.repository.GetRecentCommits(ctx, obs.ref, logCommits)
+		if nil != err {
+			return nil, err
+		}
+		return &vfile{name: "@log", content: formatLog(commits), time: obs.ref.TreeTime()}, nil
+	case fs.log && fs.equal("@log.json", name):
+		commits, err := obs.repository.GetRecentCommits(ctx, obs.ref, logCommits)
+		if nil != err {
+			return nil, err
+		}
+		content, err := json.MarshalIndent(commits, "", "  ")
+		if nil != err {
+			return nil, err
+		}
+		content = append(content, '\n')
+		return &vfile{name: "@log.json", content: content, time: obs.ref.TreeTime()}, nil
+	case fs.archive && fs.equal("@archive", name):
+		return &varchives{fs: fs, repository: obs.repository, ref: obs.ref}, nil
+	case fs.blame && fs.equal("@blame", name):
+		return &vblame{fs: fs, repository: obs.repository, ref: obs.ref}, nil
+	}
+	return nil, prov.ErrNotFound
+}
+
+// isrefvirtual determines if name is the name of a virtual node at the ref root level.
+// Such names hide any repository file with the same name.
+func (fs *hubfs) isrefvirtual(name string) bool {
+	return (fs.log && (fs.equal("@log", name) || fs.equal("@log.json", name))) ||
+		(fs.archive && fs.equal("@archive", name)) ||
+		(fs.blame && fs.equal("@blame", name))
+}
+
+// formatVerification formats the signature verification of a commit, e.g. "verified gpg",
+// "unverified ssh (unknown_key)" or "unsigned".
+func formatVerification(v *prov.CommitVerification) string {
+	switch {
+	case nil == v:
+		return ""
+	case !v.Signed:
+		return "unsigned"
+	}
+	res := "unverified"
+	if v.Verified {
+		res = "verified"
+	}
+	if "" != v.Format {
+		res += " " + v.Format
+	}
+	if !v.Verified && "" != v.Reason {
+		res += " (" + v.Reason + ")"
+	}
+	return res
+}
+
+// formatLog formats commits similar to the default format of "git log".
+func formatLog(commits []*prov.Commit) []byte {
+	var content bytes.Buffer
+	for i, commit := range commits {
+		if 0 < i {
+			content.WriteByte('\n')
+		}
+		fmt.Fprintf(&content, "commit %s\n", commit.Hash)
+		if 1 < len(commit.Parents) {
+			abbrev := make([]string, len(commit.Parents))
+			for j, p := range commit.Parents {
+				if 7 < len(p) {
+					p = p[:7]
+				}
+				abbrev[j] = p
+			}
+			fmt.Fprintf(&content, "Merge: %s\n", strings.Join(abbrev, " "))
+		}
+		if v := commit.Verification; nil != v && v.Signed {
+			fmt.Fprintf(&content, "Signature: %s\n", formatVerification(v))
+		}
+		fmt.Fprintf(&content, "Author: %s <%s>\n", commit.Author, commit.AuthorEmail)
+		fmt.Fprintf(&content, "Date:   %s\n\n",
+			commit.AuthorTime.Format("Mon Jan 2 15:04:05 2006 -0700"))
+		for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+			if "" == line {
+				content.WriteByte('\n')
+			} else {
+				fmt.Fprintf(&content, "    %s\n", line)
+			}
+		}
+	}
+	return content.Bytes()
+}
+
+// fillvirtual lists the virtual nodes at the repository level.
+func (fs *hubfs) fillvirtual(ctx context.Context, obs *obstack,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool) bool {
+	stat := fuse.Stat_t{}
+	for _, n := range []string{"@default", "@latest", "@releases", "@issues", "@pulls",
+		"@diff", "@actions", "@objects", "@history"} {
+		if v, err := fs.repovirtual(ctx, obs, n); nil == err {
+			fs.vgetattr(v, &stat)
+			if !fill(v.Name(), &stat, 0) {
+				return false
+			}
+		}
+	}
+	// stat is not filled to avoid fetching the repository metadata on every listing
+	if fs.info && !fill("@info.json", nil, 0) {
+		return false
+	}
+	if fs.wiki {
+		if wiki, err := obs.repository.GetWiki(ctx); nil == err {
+			if _, err := wiki.GetDefaultRef(ctx); nil == err {
+				fs.fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+				if !fill("@wiki", &stat, 0) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+/*
+ * wrap.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// wrapfs is embedded by file systems that wrap another file system and override some of
+// its operations. It forwards the optional interfaces of the wrapped file system.
+type wrapfs struct {
+	fuse.FileSystemInterface
+	fuse.FileSystemGetpath
+}
+
+func newWrapfs(fs fuse.FileSystemInterface) wrapfs {
+	return wrapfs{
+		FileSystemInterface: fs,
+		FileSystemGetpath:   fs.(fuse.FileSystemGetpath),
+	}
+}
+
+func (fs *wrapfs) Chflags(path string, flags uint32) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemChflags)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Chflags(path, flags)
+}
+
+func (fs *wrapfs) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetcrtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setcrtime(path, tmsp)
+}
+
+func (fs *wrapfs) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetchgtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setchgtime(path, tmsp)
+}
@@ -0,0 +1,374 @@
+//go:build go1.16
+// +build go1.16
+
+/*
+ * iofs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package iofs adapts a FUSE file system to io/fs, so that it can be accessed by Go
+// programs (e.g. using fs.WalkDir or http.FS) without mounting it.
+package iofs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	pathutil "path"
+	"sort"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// FS is a read-only io/fs view of a FUSE file system. It implements fs.FS, fs.StatFS,
+// fs.ReadDirFS and fs.ReadFileFS.
+type FS struct {
+	fs fuse.FileSystemInterface
+}
+
+// New creates an io/fs view of a FUSE file system and initializes the file system.
+// Close destroys the file system.
+func New(fs fuse.FileSystemInterface) *FS {
+	fs.Init()
+	return &FS{fs: fs}
+}
+
+func (fsys *FS) Close() error {
+	fsys.fs.Destroy()
+	return nil
+}
+
+// fusePath converts an io/fs name (e.g. "." or "a/b") to a FUSE path (e.g. "/" or "/a/b").
+func fusePath(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if "." == name {
+		return "/", nil
+	}
+	return "/" + name, nil
+}
+
+func fuseError(op string, name string, errc int) error {
+	var err error
+	switch errc {
+	case -fuse.ENOENT, -fuse.ENOTDIR:
+		err = fs.ErrNotExist
+	case -fuse.EACCES, -fuse.EPERM:
+		err = fs.ErrPermission
+	case -fuse.EEXIST:
+		err = fs.ErrExist
+	default:
+		err = fuse.Error(errc)
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	path, err := fusePath("open", name)
+	if nil != err {
+		return nil, err
+	}
+
+	f := &file{fs: fsys.fs, path: path}
+	errc := fsys.fs.Getattr(path, &f.stat, ^uint64(0))
+	if 0 != errc {
+		return nil, fuseError("open", name, errc)
+	}
+
+	if fuse.S_IFDIR == f.stat.Mode&fuse.S_IFMT {
+		errc, f.fh = fsys.fs.Opendir(path)
+		if 0 != errc {
+			return nil, fuseError("open", name, errc)
+		}
+		return &dir{file: f}, nil
+	}
+
+	errc, f.fh = fsys.fs.Open(path, fuse.O_RDONLY)
+	if 0 != errc {
+		return nil, fuseError("open", name, errc)
+	}
+	return f, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	path, err := fusePath("stat", name)
+	if nil != err {
+		return nil, err
+	}
+
+	info := &fileInfo{name: pathutil.Base(path)}
+	errc := fsys.fs.Getattr(path, &info.stat, ^uint64(0))
+	if 0 != errc {
+		return nil, fuseError("stat", name, errc)
+	}
+	return info, nil
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	d, ok := f.(*dir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := d.ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, err
+}
+
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	data := make([]byte, 0, info.Size())
+	for {
+		if len(data) == cap(data) {
+			data = append(data, 0)[:len(data)]
+		}
+		n, err := f.Read(data[len(data):cap(data)])
+		data = data[:len(data)+n]
+		if io.EOF == err {
+			return data, nil
+		} else if nil != err {
+			return nil, err
+		}
+	}
+}
+
+// file is an open regular file. It implements io.ReaderAt and io.Seeker, which
+// http.FS requires to serve file content.
+type file struct {
+	fs     fuse.FileSystemInterface
+	path   string
+	fh     uint64
+	stat   fuse.Stat_t
+	offset int64
+	closed bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: pathutil.Base(f.path), stat: f.stat}, nil
+}
+
+func (f *file) ReadAt(buff []byte, ofst int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.path, Err: fs.ErrClosed}
+	}
+	if 0 > ofst {
+		return 0, &fs.PathError{Op: "read", Path: f.path, Err: fs.ErrInvalid}
+	}
+	tot := 0
+	for len(buff) > tot {
+		n := f.fs.Read(f.path, buff[tot:], ofst+int64(tot), f.fh)
+		if 0 > n {
+			return tot, fuseError("read", f.path, n)
+		} else if 0 == n {
+			return tot, io.EOF
+		}
+		tot += n
+	}
+	return tot, nil
+}
+
+func (f *file) Read(buff []byte) (int, error) {
+	if 0 == len(buff) {
+		return 0, nil
+	}
+	n, err := f.ReadAt(buff, f.offset)
+	f.offset += int64(n)
+	if 0 < n && io.EOF == err {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.path, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.stat.Size
+	default:
+		offset = -1
+	}
+	if 0 > offset {
+		return 0, &fs.PathError{Op: "seek", Path: f.path, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.path, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	f.fs.Release(f.path, f.fh)
+	return nil
+}
+
+// dir is an open directory. Its entries are read in full on the first call to ReadDir.
+type dir struct {
+	*file
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dir) Read(buff []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadAt(buff []byte, ofst int64) (int, error) {
+	return d.Read(buff)
+}
+
+func (d *dir) Seek(offset int64, whence int) (int64, error) {
+	/* http.FS seeks directories to the start to read them again */
+	if io.SeekStart == whence && 0 == offset {
+		d.entries, d.read = nil, false
+		return 0, nil
+	}
+	return 0, &fs.PathError{Op: "seek", Path: d.path, Err: fs.ErrInvalid}
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.path, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	d.fs.Releasedir(d.path, d.fh)
+	return nil
+}
+
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.path, Err: fs.ErrClosed}
+	}
+	if !d.read {
+		d.read = true
+		errc := d.fs.Readdir(d.path, func(name string, stat *fuse.Stat_t, ofst int64) bool {
+			if "." == name || ".." == name {
+				return true
+			}
+			info := &fileInfo{name: name}
+			if nil != stat {
+				info.stat = *stat
+			} else if 0 != d.fs.Getattr(pathutil.Join(d.path, name), &info.stat, ^uint64(0)) {
+				/* the entry disappeared or cannot be accessed; skip it */
+				return true
+			}
+			d.entries = append(d.entries, dirEntry{info})
+			return true
+		}, 0, d.fh)
+		if 0 != errc {
+			return nil, fuseError("readdir", d.path, errc)
+		}
+	}
+
+	entries := d.entries
+	if 0 < count {
+		if 0 == len(entries) {
+			return nil, io.EOF
+		}
+		if count < len(entries) {
+			entries = entries[:count]
+		}
+	}
+	d.entries = d.entries[len(entries):]
+	return entries, nil
+}
+
+type dirEntry struct {
+	info *fileInfo
+}
+
+func (e dirEntry) Name() string {
+	return e.info.name
+}
+
+func (e dirEntry) IsDir() bool {
+	return e.info.IsDir()
+}
+
+func (e dirEntry) Type() fs.FileMode {
+	return e.info.Mode().Type()
+}
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	return e.info, nil
+}
+
+type fileInfo struct {
+	name string
+	stat fuse.Stat_t
+}
+
+func (info *fileInfo) Name() string {
+	return info.name
+}
+
+func (info *fileInfo) Size() int64 {
+	return info.stat.Size
+}
+
+func (info *fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(info.stat.Mode & 0777)
+	switch info.stat.Mode & fuse.S_IFMT {
+	case fuse.S_IFDIR:
+		mode |= fs.ModeDir
+	case fuse.S_IFLNK:
+		mode |= fs.ModeSymlink
+	case fuse.S_IFIFO:
+		mode |= fs.ModeNamedPipe
+	case fuse.S_IFSOCK:
+		mode |= fs.ModeSocket
+	case fuse.S_IFCHR:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case fuse.S_IFBLK:
+		mode |= fs.ModeDevice
+	}
+	return mode
+}
+
+func (info *fileInfo) ModTime() time.Time {
+	return info.stat.Mtim.Time()
+}
+
+func (info *fileInfo) IsDir() bool {
+	return fuse.S_IFDIR == info.stat.Mode&fuse.S_IFMT
+}
+
+// Sys returns the *fuse.Stat_t of the file.
+func (info *fileInfo) Sys() interface{} {
+	return &info.stat
+}
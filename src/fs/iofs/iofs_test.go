@@ -0,0 +1,81 @@
+//go:build go1.16
+// +build go1.16
+
+/*
+ * iofs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package iofs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/memfs"
+)
+
+func newTestfs(t *testing.T) *FS {
+	memfs := memfs.New()
+	memfs.Mkdir("/a", 0755)
+	memfs.Mkdir("/a/b", 0755)
+	for _, e := range []struct{ path, content string }{
+		{"/f", "file f"},
+		{"/a/g", "file g"},
+		{"/a/b/h", ""},
+	} {
+		memfs.Mknod(e.path, fuse.S_IFREG|0644, 0)
+		errc, fh := memfs.Open(e.path, fuse.O_RDWR)
+		if 0 != errc {
+			t.Fatal(e.path, errc)
+		}
+		memfs.Write(e.path, []byte(e.content), 0, fh)
+		memfs.Release(e.path, fh)
+	}
+	return New(memfs)
+}
+
+func TestFS(t *testing.T) {
+	fsys := newTestfs(t)
+	defer fsys.Close()
+
+	err := fstest.TestFS(fsys, "f", "a/g", "a/b/h")
+	if nil != err {
+		t.Error(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "a/g")
+	if nil != err || "file g" != string(data) {
+		t.Error(err, string(data))
+	}
+
+	_, err = fsys.Open("a/x")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error(err)
+	}
+	_, err = fsys.Open("/a")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Error(err)
+	}
+
+	paths := []string{}
+	fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	if "[. a a/b a/b/h a/g f]" != fmt.Sprint(paths) {
+		t.Error(paths)
+	}
+}
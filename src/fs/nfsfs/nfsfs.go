@@ -0,0 +1,764 @@
+/*
+ * nfsfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package nfsfs serves a FUSE file system over NFSv3 (RFC 1813). The MOUNT and NFS
+// programs are served over TCP on the same port; there is no portmapper and no lock
+// manager, so clients must specify the port and the nolock option. The file system is
+// served read-only: requests that modify it fail with NFS3ERR_ROFS.
+package nfsfs
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	pathutil "path"
+	"strings"
+	"sync"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/util"
+)
+
+const (
+	progMount = 100005
+	progNFS   = 100003
+
+	// maxData is the maximum size of READ data.
+	maxData = 1024 * 1024
+
+	// maxHandles is the maximum number of paths that are too long to be stored in their
+	// file handles and are remembered by the server.
+	maxHandles = 64 * 1024
+)
+
+const (
+	nfs3OK             = 0
+	nfs3ErrPerm        = 1
+	nfs3ErrNoent       = 2
+	nfs3ErrIO          = 5
+	nfs3ErrAcces       = 13
+	nfs3ErrExist       = 17
+	nfs3ErrNotdir      = 20
+	nfs3ErrIsdir       = 21
+	nfs3ErrInval       = 22
+	nfs3ErrRofs        = 30
+	nfs3ErrNametoolong = 63
+	nfs3ErrNotempty    = 66
+	nfs3ErrStale       = 70
+	nfs3ErrBadhndl     = 10001
+	nfs3ErrToosmall    = 10005
+)
+
+// Server serves a file system over NFSv3.
+type Server struct {
+	fs     fuse.FileSystemInterface
+	verf   [8]byte
+	lock   sync.Mutex
+	paths  map[uint64]*list.Element
+	plist  *list.List
+	lns    map[net.Listener]struct{}
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type dirEntry struct {
+	name string
+	stat *fuse.Stat_t
+}
+
+// New creates a server and initializes the file system. Close destroys the file system.
+func New(fs fuse.FileSystemInterface) *Server {
+	srv := &Server{
+		fs:    fs,
+		paths: map[uint64]*list.Element{},
+		plist: list.New(),
+		lns:   map[net.Listener]struct{}{},
+		conns: map[net.Conn]struct{}{},
+	}
+	rand.Read(srv.verf[:])
+	fs.Init()
+	return srv
+}
+
+// Serve accepts connections on the listener ln and serves NFS requests on them. It
+// returns when the listener fails or the server is closed.
+func (srv *Server) Serve(ln net.Listener) error {
+	srv.lock.Lock()
+	if srv.closed {
+		srv.lock.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	srv.lns[ln] = struct{}{}
+	srv.lock.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if nil != err {
+			srv.lock.Lock()
+			delete(srv.lns, ln)
+			srv.lock.Unlock()
+			return err
+		}
+		srv.lock.Lock()
+		if srv.closed {
+			srv.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		srv.conns[conn] = struct{}{}
+		srv.wg.Add(1)
+		srv.lock.Unlock()
+		go srv.serveConn(conn)
+	}
+}
+
+// Close closes all listeners and connections, waits for the requests in flight and
+// destroys the file system.
+func (srv *Server) Close() error {
+	srv.lock.Lock()
+	if srv.closed {
+		srv.lock.Unlock()
+		return nil
+	}
+	srv.closed = true
+	for ln := range srv.lns {
+		ln.Close()
+	}
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.lock.Unlock()
+	srv.wg.Wait()
+	srv.fs.Destroy()
+	return nil
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+	defer srv.wg.Done()
+	defer func() {
+		srv.lock.Lock()
+		delete(srv.conns, conn)
+		srv.lock.Unlock()
+		conn.Close()
+	}()
+
+	wlock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	for {
+		rec, err := readRecord(conn)
+		if nil != err {
+			if errRecord == err {
+				util.Log(util.LogDebug, "fs/nfsfs", "connection error",
+					"remote", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rep := srv.call(rec); nil != rep {
+				wlock.Lock()
+				writeRecord(conn, rep)
+				wlock.Unlock()
+			}
+		}()
+	}
+}
+
+// call processes an RPC call message (RFC 5531) and returns the reply message.
+func (srv *Server) call(rec []byte) []byte {
+	args := &decoder{buf: rec}
+	xid := args.uint32()
+	if 0 != args.uint32() {
+		return nil
+	}
+	rpcvers := args.uint32()
+	prog := args.uint32()
+	vers := args.uint32()
+	proc := args.uint32()
+	args.uint32()
+	args.opaque(400)
+	args.uint32()
+	args.opaque(400)
+	if args.err {
+		return nil
+	}
+
+	rep := &encoder{}
+	rep.uint32(xid)
+	rep.uint32(1) // REPLY
+	if 2 != rpcvers {
+		rep.uint32(1) // MSG_DENIED
+		rep.uint32(0) // RPC_MISMATCH
+		rep.uint32(2)
+		rep.uint32(2)
+		return rep.buf
+	}
+	rep.uint32(0) // MSG_ACCEPTED
+	rep.uint32(0) // AUTH_NONE
+	rep.uint32(0)
+
+	var procs map[uint32]func(*Server, *decoder, *encoder)
+	switch prog {
+	case progMount:
+		procs = mountProcs
+	case progNFS:
+		procs = nfsProcs
+	default:
+		rep.uint32(1) // PROG_UNAVAIL
+		return rep.buf
+	}
+	if 3 != vers {
+		rep.uint32(2) // PROG_MISMATCH
+		rep.uint32(3)
+		rep.uint32(3)
+		return rep.buf
+	}
+	fn, ok := procs[proc]
+	if !ok {
+		rep.uint32(3) // PROC_UNAVAIL
+		return rep.buf
+	}
+
+	res := &encoder{}
+	fn(srv, args, res)
+	if args.err {
+		rep.uint32(4) // GARBAGE_ARGS
+		return rep.buf
+	}
+	rep.uint32(0) // SUCCESS
+	rep.buf = append(rep.buf, res.buf...)
+	return rep.buf
+}
+
+type handlePath struct {
+	id   uint64
+	path string
+}
+
+// handle returns the file handle of a path. A path that fits is stored in the file handle
+// itself, which remains valid for the life of the server. A longer path is stored in a
+// table of the most recently used paths and its file handle contains its file id; the
+// file handle becomes stale when the path is evicted from the table.
+func (srv *Server) handle(path string) []byte {
+	if 64-8 >= len(path) {
+		return append(srv.verf[:len(srv.verf):len(srv.verf)], path...)
+	}
+	id := fileid(path)
+	srv.lock.Lock()
+	if e, ok := srv.paths[id]; ok {
+		e.Value = handlePath{id, path}
+		srv.plist.MoveToFront(e)
+	} else {
+		srv.paths[id] = srv.plist.PushFront(handlePath{id, path})
+		if maxHandles < srv.plist.Len() {
+			delete(srv.paths, srv.plist.Remove(srv.plist.Back()).(handlePath).id)
+		}
+	}
+	srv.lock.Unlock()
+	fh := make([]byte, 17)
+	copy(fh, srv.verf[:])
+	binary.BigEndian.PutUint64(fh[9:], id)
+	return fh
+}
+
+// fileid returns the file id of a path, which is a hash of the path.
+func fileid(path string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	if id := h.Sum64(); 0 != id {
+		return id
+	}
+	return 1
+}
+
+func (srv *Server) path(fh []byte) (string, uint32) {
+	if 9 > len(fh) {
+		return "", nfs3ErrBadhndl
+	}
+	if string(srv.verf[:]) != string(fh[:8]) {
+		return "", nfs3ErrStale
+	}
+	if '/' == fh[8] {
+		path := string(fh[8:])
+		if pathutil.Clean(path) != path {
+			return "", nfs3ErrBadhndl
+		}
+		return path, nfs3OK
+	}
+	if 17 != len(fh) || 0 != fh[8] {
+		return "", nfs3ErrBadhndl
+	}
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	e, ok := srv.paths[binary.BigEndian.Uint64(fh[9:])]
+	if !ok {
+		return "", nfs3ErrStale
+	}
+	srv.plist.MoveToFront(e)
+	return e.Value.(handlePath).path, nfs3OK
+}
+
+func (srv *Server) getattr(path string, stat *fuse.Stat_t) uint32 {
+	return nfsStatus(srv.fs.Getattr(path, stat, ^uint64(0)))
+}
+
+func (srv *Server) readdir(path string) ([]dirEntry, uint32) {
+	errc, fh := srv.fs.Opendir(path)
+	if 0 != errc {
+		return nil, nfsStatus(errc)
+	}
+	defer srv.fs.Releasedir(path, fh)
+	entries := []dirEntry{{name: "."}, {name: ".."}}
+	fill := func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		if "." == name || ".." == name {
+			return true
+		}
+		e := dirEntry{name: name}
+		if nil != stat {
+			s := *stat
+			e.stat = &s
+		}
+		entries = append(entries, e)
+		return true
+	}
+	errc = srv.fs.Readdir(path, fill, 0, fh)
+	if 0 != errc {
+		return nil, nfsStatus(errc)
+	}
+	return entries, nfs3OK
+}
+
+// entryPath returns the path of a directory entry.
+func entryPath(dir string, name string) string {
+	switch name {
+	case ".":
+		return dir
+	case "..":
+		return pathutil.Dir(dir)
+	}
+	return pathutil.Join(dir, name)
+}
+
+func nfsStatus(errc int) uint32 {
+	switch errc {
+	case 0:
+		return nfs3OK
+	case -fuse.EPERM:
+		return nfs3ErrPerm
+	case -fuse.ENOENT:
+		return nfs3ErrNoent
+	case -fuse.EACCES:
+		return nfs3ErrAcces
+	case -fuse.EEXIST:
+		return nfs3ErrExist
+	case -fuse.ENOTDIR:
+		return nfs3ErrNotdir
+	case -fuse.EISDIR:
+		return nfs3ErrIsdir
+	case -fuse.EINVAL:
+		return nfs3ErrInval
+	case -fuse.EROFS:
+		return nfs3ErrRofs
+	case -fuse.ENAMETOOLONG:
+		return nfs3ErrNametoolong
+	case -fuse.ENOTEMPTY:
+		return nfs3ErrNotempty
+	default:
+		return nfs3ErrIO
+	}
+}
+
+func (srv *Server) fattr(res *encoder, path string, stat *fuse.Stat_t) {
+	var ftype uint32
+	switch stat.Mode & fuse.S_IFMT {
+	case fuse.S_IFREG:
+		ftype = 1
+	case fuse.S_IFDIR:
+		ftype = 2
+	case fuse.S_IFBLK:
+		ftype = 3
+	case fuse.S_IFCHR:
+		ftype = 4
+	case fuse.S_IFLNK:
+		ftype = 5
+	case fuse.S_IFSOCK:
+		ftype = 6
+	case fuse.S_IFIFO:
+		ftype = 7
+	}
+	res.uint32(ftype)
+	res.uint32(stat.Mode & 07777)
+	res.uint32(stat.Nlink)
+	res.uint32(stat.Uid)
+	res.uint32(stat.Gid)
+	res.uint64(uint64(stat.Size))
+	res.uint64(uint64(stat.Size))
+	res.uint32(uint32(stat.Rdev >> 32))
+	res.uint32(uint32(stat.Rdev))
+	res.uint64(1)
+	res.uint64(fileid(path))
+	for _, t := range []fuse.Timespec{stat.Atim, stat.Mtim, stat.Ctim} {
+		res.uint32(uint32(t.Sec))
+		res.uint32(uint32(t.Nsec))
+	}
+}
+
+// postOpAttr encodes the attributes of a path if they can be retrieved.
+func (srv *Server) postOpAttr(res *encoder, path string) {
+	stat := fuse.Stat_t{}
+	if "" == path || nfs3OK != srv.getattr(path, &stat) {
+		res.bool(false)
+		return
+	}
+	res.bool(true)
+	srv.fattr(res, path, &stat)
+}
+
+// rofs encodes the result of a request that would modify the file system.
+func rofs(res *encoder, wccs int) {
+	res.uint32(nfs3ErrRofs)
+	for i := 0; wccs > i; i++ {
+		res.bool(false)
+		res.bool(false)
+	}
+}
+
+var mountProcs = map[uint32]func(*Server, *decoder, *encoder){
+	0: func(*Server, *decoder, *encoder) {},
+	1: (*Server).mountMnt,
+	2: func(srv *Server, args *decoder, res *encoder) {
+		res.bool(false)
+	},
+	3: func(srv *Server, args *decoder, res *encoder) {
+		args.string(1024)
+	},
+	4: func(*Server, *decoder, *encoder) {},
+	5: func(srv *Server, args *decoder, res *encoder) {
+		res.bool(true)
+		res.string("/")
+		res.bool(false)
+		res.bool(false)
+	},
+}
+
+func (srv *Server) mountMnt(args *decoder, res *encoder) {
+	path := pathutil.Clean("/" + args.string(1024))
+	stat := fuse.Stat_t{}
+	status := srv.getattr(path, &stat)
+	if nfs3OK == status && fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+		status = nfs3ErrNotdir
+	}
+	res.uint32(status)
+	if nfs3OK == status {
+		res.opaque(srv.handle(path))
+		res.uint32(1)
+		res.uint32(1) // AUTH_UNIX
+	}
+}
+
+var nfsProcs = map[uint32]func(*Server, *decoder, *encoder){
+	0:  func(*Server, *decoder, *encoder) {},
+	1:  (*Server).nfsGetattr,
+	2:  func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	3:  (*Server).nfsLookup,
+	4:  (*Server).nfsAccess,
+	5:  (*Server).nfsReadlink,
+	6:  (*Server).nfsRead,
+	7:  func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	8:  func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	9:  func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	10: func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	11: func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	12: func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	13: func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+	14: func(srv *Server, args *decoder, res *encoder) { rofs(res, 2) },
+	15: func(srv *Server, args *decoder, res *encoder) {
+		res.uint32(nfs3ErrRofs)
+		res.bool(false)
+		res.bool(false)
+		res.bool(false)
+	},
+	16: (*Server).nfsReaddir,
+	17: (*Server).nfsReaddirplus,
+	18: (*Server).nfsFsstat,
+	19: (*Server).nfsFsinfo,
+	20: (*Server).nfsPathconf,
+	21: func(srv *Server, args *decoder, res *encoder) { rofs(res, 1) },
+}
+
+func (srv *Server) nfsGetattr(args *decoder, res *encoder) {
+	path, status := srv.path(args.opaque(64))
+	stat := fuse.Stat_t{}
+	if nfs3OK == status {
+		status = srv.getattr(path, &stat)
+	}
+	res.uint32(status)
+	if nfs3OK == status {
+		srv.fattr(res, path, &stat)
+	}
+}
+
+func (srv *Server) nfsLookup(args *decoder, res *encoder) {
+	dir, status := srv.path(args.opaque(64))
+	name := args.string(255)
+	path := ""
+	stat := fuse.Stat_t{}
+	if nfs3OK == status {
+		if "" == name || strings.Contains(name, "/") {
+			status = nfs3ErrNoent
+		} else {
+			path = entryPath(dir, name)
+			status = srv.getattr(path, &stat)
+		}
+	}
+	res.uint32(status)
+	if nfs3OK == status {
+		res.opaque(srv.handle(path))
+		res.bool(true)
+		srv.fattr(res, path, &stat)
+	}
+	srv.postOpAttr(res, dir)
+}
+
+func (srv *Server) nfsAccess(args *decoder, res *encoder) {
+	const (
+		accessRead    = 0x01
+		accessLookup  = 0x02
+		accessExecute = 0x20
+	)
+	path, status := srv.path(args.opaque(64))
+	access := args.uint32()
+	stat := fuse.Stat_t{}
+	if nfs3OK == status {
+		status = srv.getattr(path, &stat)
+	}
+	res.uint32(status)
+	if nfs3OK != status {
+		res.bool(false)
+		return
+	}
+	res.bool(true)
+	srv.fattr(res, path, &stat)
+	allowed := uint32(accessRead)
+	if fuse.S_IFDIR == stat.Mode&fuse.S_IFMT {
+		allowed |= accessLookup | accessExecute
+	} else if 0 != stat.Mode&0111 {
+		allowed |= accessExecute
+	}
+	res.uint32(access & allowed)
+}
+
+func (srv *Server) nfsReadlink(args *decoder, res *encoder) {
+	path, status := srv.path(args.opaque(64))
+	target := ""
+	if nfs3OK == status {
+		var errc int
+		errc, target = srv.fs.Readlink(path)
+		status = nfsStatus(errc)
+	}
+	res.uint32(status)
+	srv.postOpAttr(res, path)
+	if nfs3OK == status {
+		res.string(target)
+	}
+}
+
+func (srv *Server) nfsRead(args *decoder, res *encoder) {
+	path, status := srv.path(args.opaque(64))
+	ofst := int64(args.uint64())
+	count := args.uint32()
+	if maxData < count {
+		count = maxData
+	}
+	if args.err {
+		return
+	}
+	stat := fuse.Stat_t{}
+	if nfs3OK == status {
+		status = srv.getattr(path, &stat)
+	}
+	if nfs3OK == status && fuse.S_IFDIR == stat.Mode&fuse.S_IFMT {
+		status = nfs3ErrIsdir
+	}
+	buf := make([]byte, count)
+	n := 0
+	if nfs3OK == status {
+		errc, fh := srv.fs.Open(path, fuse.O_RDONLY)
+		status = nfsStatus(errc)
+		if nfs3OK == status {
+			for len(buf) > n {
+				m := srv.fs.Read(path, buf[n:], ofst+int64(n), fh)
+				if 0 > m {
+					status = nfsStatus(m)
+					break
+				}
+				if 0 == m {
+					break
+				}
+				n += m
+			}
+			srv.fs.Release(path, fh)
+		}
+	}
+	res.uint32(status)
+	if nfs3OK != status {
+		res.bool(false)
+		return
+	}
+	res.bool(true)
+	srv.fattr(res, path, &stat)
+	res.uint32(uint32(n))
+	res.bool(ofst+int64(n) >= stat.Size)
+	res.opaque(buf[:n])
+}
+
+func (srv *Server) nfsReaddir(args *decoder, res *encoder) {
+	srv.readdirReply(args, res, false)
+}
+
+func (srv *Server) nfsReaddirplus(args *decoder, res *encoder) {
+	srv.readdirReply(args, res, true)
+}
+
+// readdirReply encodes the result of READDIR or READDIRPLUS. Cookies are positions in
+// the directory listing, which is ordered by the file system.
+func (srv *Server) readdirReply(args *decoder, res *encoder, plus bool) {
+	path, status := srv.path(args.opaque(64))
+	cookie := args.uint64()
+	args.fixed(8)
+	if plus {
+		args.uint32()
+	}
+	count := int(args.uint32())
+	if args.err {
+		return
+	}
+	var entries []dirEntry
+	if nfs3OK == status {
+		entries, status = srv.readdir(path)
+	}
+	res.uint32(status)
+	srv.postOpAttr(res, path)
+	if nfs3OK != status {
+		return
+	}
+	res.fixed(make([]byte, 8))
+
+	size := len(res.buf) + 8
+	n := 0
+	for i := int(cookie); len(entries) > i; i++ {
+		e := entries[i]
+		epath := entryPath(path, e.name)
+		ent := &encoder{}
+		ent.bool(true)
+		ent.uint64(fileid(epath))
+		ent.string(e.name)
+		ent.uint64(uint64(i + 1))
+		if plus {
+			stat := e.stat
+			if nil == stat || "." == e.name || ".." == e.name {
+				stat = &fuse.Stat_t{}
+				if nfs3OK != srv.getattr(epath, stat) {
+					stat = nil
+				}
+			}
+			ent.bool(nil != stat)
+			if nil != stat {
+				srv.fattr(ent, epath, stat)
+			}
+			ent.bool(true)
+			ent.opaque(srv.handle(epath))
+		}
+		if count < size+len(ent.buf) {
+			if 0 == n {
+				res.buf = res.buf[:0]
+				res.uint32(nfs3ErrToosmall)
+				srv.postOpAttr(res, path)
+				return
+			}
+			res.bool(false)
+			res.bool(false)
+			return
+		}
+		res.buf = append(res.buf, ent.buf...)
+		size += len(ent.buf)
+		n++
+	}
+	res.bool(false)
+	res.bool(true)
+}
+
+func (srv *Server) nfsFsstat(args *decoder, res *encoder) {
+	path, status := srv.path(args.opaque(64))
+	stat := fuse.Statfs_t{}
+	if nfs3OK == status {
+		status = nfsStatus(srv.fs.Statfs(path, &stat))
+	}
+	res.uint32(status)
+	srv.postOpAttr(res, path)
+	if nfs3OK != status {
+		return
+	}
+	bsize := stat.Frsize
+	if 0 == bsize {
+		bsize = stat.Bsize
+	}
+	res.uint64(stat.Blocks * bsize)
+	res.uint64(stat.Bfree * bsize)
+	res.uint64(stat.Bavail * bsize)
+	res.uint64(stat.Files)
+	res.uint64(stat.Ffree)
+	res.uint64(stat.Favail)
+	res.uint32(0)
+}
+
+func (srv *Server) nfsFsinfo(args *decoder, res *encoder) {
+	path, status := srv.path(args.opaque(64))
+	res.uint32(status)
+	srv.postOpAttr(res, path)
+	if nfs3OK != status {
+		return
+	}
+	res.uint32(maxData)
+	res.uint32(maxData)
+	res.uint32(4096)
+	res.uint32(maxData)
+	res.uint32(maxData)
+	res.uint32(4096)
+	res.uint32(64 * 1024)
+	res.uint64(1<<63 - 1)
+	res.uint32(0)
+	res.uint32(1)
+	res.uint32(0x0002 | 0x0008) // FSF3_SYMLINK | FSF3_HOMOGENEOUS
+}
+
+func (srv *Server) nfsPathconf(args *decoder, res *encoder) {
+	path, status := srv.path(args.opaque(64))
+	res.uint32(status)
+	srv.postOpAttr(res, path)
+	if nfs3OK != status {
+		return
+	}
+	res.uint32(1)
+	res.uint32(255)
+	res.bool(true)
+	res.bool(true)
+	res.bool(false)
+	res.bool(true)
+}
@@ -0,0 +1,206 @@
+/*
+ * nfsfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package nfsfs
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/prov/memprov"
+)
+
+func TestServer(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("dir/file.txt", 0100644, []byte("hello\n"))
+	ref.AddFile("link", 0120000, []byte("dir/file.txt"))
+
+	srv := New(hubfs.New(hubfs.Config{Client: client, Fmask: 022, Dmask: 022}).FileSystemInterface())
+	defer srv.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	xid := uint32(0)
+	call := func(prog, proc uint32, args func(e *encoder)) *decoder {
+		xid++
+		e := &encoder{}
+		for _, v := range []uint32{xid, 0, 2, prog, 3, proc, 0, 0, 0, 0} {
+			e.uint32(v)
+		}
+		args(e)
+		if err := writeRecord(conn, e.buf); nil != err {
+			t.Fatal(err)
+		}
+		rec, err := readRecord(conn)
+		if nil != err {
+			t.Fatal(err)
+		}
+		d := &decoder{buf: rec}
+		for _, v := range []uint32{xid, 1, 0, 0, 0, 0} {
+			if v != d.uint32() {
+				t.Fatal(rec)
+			}
+		}
+		return d
+	}
+
+	d := call(progMount, 1, func(e *encoder) { e.string("/owner/repo/main") })
+	if nfs3OK != d.uint32() {
+		t.Fatal()
+	}
+	root := d.opaque(64)
+
+	lookup := func(dir []byte, name string) (uint32, []byte) {
+		d := call(progNFS, 3, func(e *encoder) {
+			e.opaque(dir)
+			e.string(name)
+		})
+		status := d.uint32()
+		if nfs3OK != status {
+			return status, nil
+		}
+		return status, d.opaque(64)
+	}
+	_, dir := lookup(root, "dir")
+	_, file := lookup(dir, "file.txt")
+	if status, _ := lookup(root, "nonexistent"); nfs3ErrNoent != status {
+		t.Error(status)
+	}
+
+	d = call(progNFS, 1, func(e *encoder) { e.opaque(file) })
+	if nfs3OK != d.uint32() || 1 != d.uint32() || 0644 != d.uint32() {
+		t.Error()
+	}
+	d.fixed(12)
+	if 6 != d.uint64() {
+		t.Error()
+	}
+
+	d = call(progNFS, 6, func(e *encoder) {
+		e.opaque(file)
+		e.uint64(1)
+		e.uint32(100)
+	})
+	if nfs3OK != d.uint32() || !(1 == d.uint32() && nil != d.fixed(84)) ||
+		5 != d.uint32() || 1 != d.uint32() || "ello\n" != d.string(100) {
+		t.Error()
+	}
+
+	_, link := lookup(root, "link")
+	d = call(progNFS, 5, func(e *encoder) { e.opaque(link) })
+	if nfs3OK != d.uint32() || 1 != d.uint32() || nil == d.fixed(84) || "dir/file.txt" != d.string(100) {
+		t.Error()
+	}
+
+	d = call(progNFS, 16, func(e *encoder) {
+		e.opaque(root)
+		e.uint64(0)
+		e.fixed(make([]byte, 8))
+		e.uint32(4096)
+	})
+	if nfs3OK != d.uint32() || 1 != d.uint32() || nil == d.fixed(84) || nil == d.fixed(8) {
+		t.Fatal()
+	}
+	names := []string{}
+	for 1 == d.uint32() {
+		d.uint64()
+		names = append(names, d.string(255))
+		d.uint64()
+	}
+	if "[. .. dir link]" != fmt.Sprint(names) || 1 != d.uint32() || d.err {
+		t.Error(names)
+	}
+
+	d = call(progNFS, 16, func(e *encoder) {
+		e.opaque(root)
+		e.uint64(0)
+		e.fixed(make([]byte, 8))
+		e.uint32(16)
+	})
+	if nfs3ErrToosmall != d.uint32() {
+		t.Error()
+	}
+
+	d = call(progNFS, 17, func(e *encoder) {
+		e.opaque(dir)
+		e.uint64(2)
+		e.fixed(make([]byte, 8))
+		e.uint32(4096)
+		e.uint32(4096)
+	})
+	if nfs3OK != d.uint32() || 1 != d.uint32() || nil == d.fixed(84) || nil == d.fixed(8) ||
+		1 != d.uint32() {
+		t.Fatal()
+	}
+	d.uint64()
+	if "file.txt" != d.string(255) || 3 != d.uint64() || 1 != d.uint32() || nil == d.fixed(84) ||
+		1 != d.uint32() || string(file) != string(d.opaque(64)) || 0 != d.uint32() || 1 != d.uint32() {
+		t.Error()
+	}
+
+	d = call(progNFS, 7, func(e *encoder) {
+		e.opaque(file)
+		e.uint64(0)
+		e.uint32(1)
+		e.uint32(0)
+		e.opaque([]byte("x"))
+	})
+	if nfs3ErrRofs != d.uint32() {
+		t.Error()
+	}
+
+	d = call(progNFS, 1, func(e *encoder) { e.opaque(make([]byte, 16)) })
+	if nfs3ErrStale != d.uint32() {
+		t.Error()
+	}
+}
+
+func TestHandle(t *testing.T) {
+	srv := &Server{paths: map[uint64]*list.Element{}, plist: list.New()}
+	short, long := "/owner/repo/main/file", "/owner/repo/main/"+strings.Repeat("d/", 32)+"file"
+	if p, status := srv.path(srv.handle(short)); nfs3OK != status || short != p || 0 != len(srv.paths) {
+		t.Error(p, status)
+	}
+	fh := srv.handle(long)
+	if 64 < len(fh) || fileid(short) == fileid(long) {
+		t.Error(fh)
+	}
+	if p, status := srv.path(fh); nfs3OK != status || long != p {
+		t.Error(p, status)
+	}
+	for i := 0; maxHandles > i; i++ {
+		srv.handle(fmt.Sprintf("%s%d", long, i))
+	}
+	if _, status := srv.path(fh); nfs3ErrStale != status || maxHandles != len(srv.paths) {
+		t.Error(status)
+	}
+	if _, status := srv.path(append(srv.verf[:], "/owner/../x"...)); nfs3ErrBadhndl != status {
+		t.Error(status)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * xdr.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package nfsfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxRecord is the maximum size of an RPC record; it leaves room for the largest
+// READ reply.
+const maxRecord = maxData + 4096
+
+var errRecord = errors.New("invalid RPC record")
+
+// decoder decodes XDR data (RFC 4506). Decoding past the end of the data sets err and
+// returns zero values.
+type decoder struct {
+	buf []byte
+	err bool
+}
+
+// encoder encodes XDR data (RFC 4506).
+type encoder struct {
+	buf []byte
+}
+
+func (d *decoder) fixed(n int) []byte {
+	m := (n + 3) &^ 3
+	if d.err || 0 > n || len(d.buf) < m {
+		d.err = true
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[m:]
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.fixed(4)
+	if nil == b {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.fixed(8)
+	if nil == b {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) opaque(max int) []byte {
+	n := d.uint32()
+	if uint32(max) < n {
+		d.err = true
+		return nil
+	}
+	return d.fixed(int(n))
+}
+
+func (d *decoder) string(max int) string {
+	return string(d.opaque(max))
+}
+
+func (e *encoder) fixed(b []byte) {
+	e.buf = append(e.buf, b...)
+	if n := len(b) & 3; 0 != n {
+		e.buf = append(e.buf, make([]byte, 4-n)...)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.uint32(uint32(v >> 32))
+	e.uint32(uint32(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.uint32(1)
+	} else {
+		e.uint32(0)
+	}
+}
+
+func (e *encoder) opaque(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.fixed(b)
+}
+
+func (e *encoder) string(s string) {
+	e.opaque([]byte(s))
+}
+
+// readRecord reads an RPC record that may consist of multiple fragments (RFC 5531).
+func readRecord(r io.Reader) ([]byte, error) {
+	var rec []byte
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); nil != err {
+			return nil, err
+		}
+		h := binary.BigEndian.Uint32(hdr[:])
+		n := int(h & 0x7fffffff)
+		if maxRecord < len(rec)+n {
+			return nil, errRecord
+		}
+		l := len(rec)
+		rec = append(rec, make([]byte, n)...)
+		if _, err := io.ReadFull(r, rec[l:]); nil != err {
+			return nil, err
+		}
+		if 0 != h&0x80000000 {
+			return rec, nil
+		}
+	}
+}
+
+// writeRecord writes an RPC record as a single fragment.
+func writeRecord(w io.Writer, rec []byte) error {
+	buf := make([]byte, 4+len(rec))
+	binary.BigEndian.PutUint32(buf, 0x80000000|uint32(len(rec)))
+	copy(buf[4:], rec)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,141 @@
+/*
+ * msg.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package p9fs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errMsg = errors.New("invalid 9P message")
+
+// decoder decodes the fields of a 9P message. Decoding past the end of the message
+// sets err and returns zero values.
+type decoder struct {
+	buf []byte
+	err bool
+}
+
+// encoder encodes the fields of a 9P message.
+type encoder struct {
+	buf []byte
+}
+
+type qid struct {
+	typ     uint8
+	version uint32
+	path    uint64
+}
+
+func (d *decoder) fixed(n int) []byte {
+	if d.err || len(d.buf) < n {
+		d.err = true
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	b := d.fixed(1)
+	if nil == b {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.fixed(2)
+	if nil == b {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.fixed(4)
+	if nil == b {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.fixed(8)
+	if nil == b {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (d *decoder) string() string {
+	return string(d.fixed(int(d.uint16())))
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = append(e.buf, byte(v), byte(v>>8))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.uint32(uint32(v))
+	e.uint32(uint32(v >> 32))
+}
+
+func (e *encoder) string(s string) {
+	e.uint16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.uint8(q.typ)
+	e.uint32(q.version)
+	e.uint64(q.path)
+}
+
+// readMsg reads a 9P message of up to msize bytes.
+func readMsg(r io.Reader, msize uint32) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); nil != err {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[:])
+	if 7 > n || msize < n {
+		return nil, errMsg
+	}
+	msg := make([]byte, n)
+	copy(msg, hdr[:])
+	if _, err := io.ReadFull(r, msg[4:]); nil != err {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// newMsg creates a 9P message with the specified type, tag and body.
+func newMsg(typ uint8, tag uint16, body []byte) []byte {
+	msg := make([]byte, 7, 7+len(body))
+	binary.LittleEndian.PutUint32(msg, uint32(7+len(body)))
+	msg[4] = typ
+	binary.LittleEndian.PutUint16(msg[5:], tag)
+	return append(msg, body...)
+}
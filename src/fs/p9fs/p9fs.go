@@ -0,0 +1,752 @@
+/*
+ * p9fs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package p9fs serves a FUSE file system over 9P2000.L, the 9P dialect of the Linux v9fs
+// client (also used by WSL2 and QEMU virtio-9p). The file system is served read-only:
+// requests that modify it fail with EROFS. Errors are reported as Linux errno values.
+package p9fs
+
+import (
+	"net"
+	pathutil "path"
+	"strings"
+	"sync"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/util"
+)
+
+const (
+	// maxMsize is the maximum message size that the server negotiates.
+	maxMsize = 1024 * 1024
+
+	// maxWelem is the maximum number of names in a walk request.
+	maxWelem = 16
+)
+
+const (
+	tlerror      = 6
+	tstatfs      = 8
+	tlopen       = 12
+	tlcreate     = 14
+	tsymlink     = 16
+	tmknod       = 18
+	trename      = 20
+	treadlink    = 22
+	tgetattr     = 24
+	tsetattr     = 26
+	txattrwalk   = 30
+	txattrcreate = 32
+	treaddir     = 40
+	tfsync       = 50
+	tlock        = 52
+	tgetlock     = 54
+	tlink        = 70
+	tmkdir       = 72
+	trenameat    = 74
+	tunlinkat    = 76
+	tversion     = 100
+	tauth        = 102
+	tattach      = 104
+	tflush       = 108
+	twalk        = 110
+	tread        = 116
+	twrite       = 118
+	tclunk       = 120
+	tremove      = 122
+)
+
+// Linux errno values.
+const (
+	ePERM        = 1
+	eNOENT       = 2
+	eIO          = 5
+	eBADF        = 9
+	eACCES       = 13
+	eEXIST       = 17
+	eNOTDIR      = 20
+	eISDIR       = 21
+	eINVAL       = 22
+	eROFS        = 30
+	eNAMETOOLONG = 36
+	eNOSYS       = 38
+	eNOTEMPTY    = 39
+	eLOOP        = 40
+	eOPNOTSUPP   = 95
+)
+
+// Server serves a file system over 9P2000.L.
+type Server struct {
+	fs     fuse.FileSystemInterface
+	lock   sync.Mutex
+	ids    map[string]uint64
+	lns    map[net.Listener]struct{}
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type conn struct {
+	srv   *Server
+	nc    net.Conn
+	lock  sync.Mutex
+	wlock sync.Mutex
+	msize uint32
+	fids  map[uint32]*fid
+	tags  map[uint16]chan struct{}
+}
+
+type fid struct {
+	lock    sync.Mutex
+	path    string
+	open    bool
+	dir     bool
+	fh      uint64
+	entries []dirEntry
+}
+
+type dirEntry struct {
+	name string
+	stat fuse.Stat_t
+}
+
+// New creates a server and initializes the file system. Close destroys the file system.
+func New(fs fuse.FileSystemInterface) *Server {
+	srv := &Server{
+		fs:    fs,
+		ids:   map[string]uint64{},
+		lns:   map[net.Listener]struct{}{},
+		conns: map[net.Conn]struct{}{},
+	}
+	fs.Init()
+	return srv
+}
+
+// Serve accepts connections on the listener ln and serves 9P requests on them. It
+// returns when the listener fails or the server is closed.
+func (srv *Server) Serve(ln net.Listener) error {
+	srv.lock.Lock()
+	if srv.closed {
+		srv.lock.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	srv.lns[ln] = struct{}{}
+	srv.lock.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if nil != err {
+			srv.lock.Lock()
+			delete(srv.lns, ln)
+			srv.lock.Unlock()
+			return err
+		}
+		srv.lock.Lock()
+		if srv.closed {
+			srv.lock.Unlock()
+			nc.Close()
+			continue
+		}
+		srv.conns[nc] = struct{}{}
+		srv.wg.Add(1)
+		srv.lock.Unlock()
+		c := &conn{
+			srv:   srv,
+			nc:    nc,
+			msize: maxMsize,
+			fids:  map[uint32]*fid{},
+			tags:  map[uint16]chan struct{}{},
+		}
+		go c.serve()
+	}
+}
+
+// Close closes all listeners and connections, waits for the requests in flight and
+// destroys the file system.
+func (srv *Server) Close() error {
+	srv.lock.Lock()
+	if srv.closed {
+		srv.lock.Unlock()
+		return nil
+	}
+	srv.closed = true
+	for ln := range srv.lns {
+		ln.Close()
+	}
+	for nc := range srv.conns {
+		nc.Close()
+	}
+	srv.lock.Unlock()
+	srv.wg.Wait()
+	srv.fs.Destroy()
+	return nil
+}
+
+// qid returns the qid of a path. The qid path remains the same for the life of the
+// server.
+func (srv *Server) qid(path string, stat *fuse.Stat_t) qid {
+	srv.lock.Lock()
+	id, ok := srv.ids[path]
+	if !ok {
+		id = uint64(len(srv.ids)) + 1
+		srv.ids[path] = id
+	}
+	srv.lock.Unlock()
+	q := qid{path: id}
+	switch stat.Mode & fuse.S_IFMT {
+	case fuse.S_IFDIR:
+		q.typ = 0x80
+	case fuse.S_IFLNK:
+		q.typ = 0x02
+	}
+	return q
+}
+
+func (srv *Server) getattr(path string, stat *fuse.Stat_t) uint32 {
+	return linuxErrno(srv.fs.Getattr(path, stat, ^uint64(0)))
+}
+
+func linuxErrno(errc int) uint32 {
+	switch errc {
+	case 0:
+		return 0
+	case -fuse.EPERM:
+		return ePERM
+	case -fuse.ENOENT:
+		return eNOENT
+	case -fuse.EBADF:
+		return eBADF
+	case -fuse.EACCES:
+		return eACCES
+	case -fuse.EEXIST:
+		return eEXIST
+	case -fuse.ENOTDIR:
+		return eNOTDIR
+	case -fuse.EISDIR:
+		return eISDIR
+	case -fuse.EINVAL:
+		return eINVAL
+	case -fuse.EROFS:
+		return eROFS
+	case -fuse.ENAMETOOLONG:
+		return eNAMETOOLONG
+	case -fuse.ENOSYS:
+		return eNOSYS
+	case -fuse.ENOTEMPTY:
+		return eNOTEMPTY
+	case -fuse.ELOOP:
+		return eLOOP
+	default:
+		return eIO
+	}
+}
+
+func (c *conn) serve() {
+	srv := c.srv
+	defer srv.wg.Done()
+	defer func() {
+		srv.lock.Lock()
+		delete(srv.conns, c.nc)
+		srv.lock.Unlock()
+		c.nc.Close()
+	}()
+
+	wg := sync.WaitGroup{}
+	defer func() {
+		wg.Wait()
+		for _, f := range c.fids {
+			c.release(f)
+		}
+	}()
+	for {
+		msg, err := readMsg(c.nc, c.msize)
+		if nil != err {
+			if errMsg == err {
+				util.Log(util.LogDebug, "fs/p9fs", "connection error",
+					"remote", c.nc.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		typ := msg[4]
+		tag := uint16(msg[5]) | uint16(msg[6])<<8
+		args := &decoder{buf: msg[7:]}
+
+		if tversion == typ {
+			/* version negotiation resets the session and must complete before reading on */
+			wg.Wait()
+			c.reply(typ, tag, c.version(args))
+			continue
+		}
+
+		done := make(chan struct{})
+		c.lock.Lock()
+		c.tags[tag] = done
+		c.lock.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.reply(typ, tag, c.call(typ, args))
+			c.lock.Lock()
+			if c.tags[tag] == done {
+				delete(c.tags, tag)
+			}
+			c.lock.Unlock()
+			close(done)
+		}()
+	}
+}
+
+// call processes a request and returns the body of the reply or an errno.
+func (c *conn) call(typ uint8, args *decoder) interface{} {
+	res := &encoder{}
+	var errno uint32
+	switch typ {
+	case tattach:
+		errno = c.attach(args, res)
+	case tauth:
+		args.uint32()
+		args.string()
+		args.string()
+		args.uint32()
+		errno = eOPNOTSUPP
+	case tflush:
+		c.flush(args)
+	case twalk:
+		errno = c.walk(args, res)
+	case tclunk:
+		errno = c.clunk(args)
+	case tremove:
+		errno = c.clunk(args)
+		if 0 == errno {
+			errno = eROFS
+		}
+	case tgetattr:
+		errno = c.getattr(args, res)
+	case tlopen:
+		errno = c.lopen(args, res)
+	case tread:
+		errno = c.read(args, res)
+	case treaddir:
+		errno = c.readdir(args, res)
+	case treadlink:
+		errno = c.readlink(args, res)
+	case tstatfs:
+		errno = c.statfs(args, res)
+	case tfsync:
+		args.uint32()
+	case tlock:
+		args.uint32()
+		res.uint8(0) // P9_LOCK_SUCCESS
+	case tgetlock:
+		args.uint32()
+		args.uint8()
+		start := args.uint64()
+		length := args.uint64()
+		procid := args.uint32()
+		client := args.string()
+		res.uint8(2) // F_UNLCK
+		res.uint64(start)
+		res.uint64(length)
+		res.uint32(procid)
+		res.string(client)
+	case tlcreate, tsymlink, tmknod, trename, tsetattr, txattrcreate,
+		tlink, tmkdir, trenameat, tunlinkat, twrite:
+		errno = eROFS
+	default:
+		errno = eOPNOTSUPP
+	}
+	if args.err && 0 == errno {
+		errno = eINVAL
+	}
+	if 0 != errno {
+		return errno
+	}
+	return res.buf
+}
+
+func (c *conn) reply(typ uint8, tag uint16, res interface{}) {
+	var msg []byte
+	switch r := res.(type) {
+	case uint32:
+		e := &encoder{}
+		e.uint32(r)
+		msg = newMsg(tlerror+1, tag, e.buf)
+	case []byte:
+		msg = newMsg(typ+1, tag, r)
+	}
+	c.wlock.Lock()
+	c.nc.Write(msg)
+	c.wlock.Unlock()
+}
+
+func (c *conn) version(args *decoder) interface{} {
+	msize := args.uint32()
+	version := args.string()
+	if args.err {
+		return uint32(eINVAL)
+	}
+	c.lock.Lock()
+	for n, f := range c.fids {
+		c.release(f)
+		delete(c.fids, n)
+	}
+	c.lock.Unlock()
+	if maxMsize < msize {
+		msize = maxMsize
+	}
+	if 4096 > msize {
+		msize = 4096
+	}
+	c.msize = msize
+	if "9P2000.L" != version {
+		version = "unknown"
+	}
+	res := &encoder{}
+	res.uint32(msize)
+	res.string(version)
+	return res.buf
+}
+
+// flush waits for the request with the old tag to complete; its reply is always sent
+// before the reply to the flush.
+func (c *conn) flush(args *decoder) {
+	oldtag := args.uint16()
+	c.lock.Lock()
+	done := c.tags[oldtag]
+	c.lock.Unlock()
+	if nil != done {
+		<-done
+	}
+}
+
+func (c *conn) getfid(n uint32) (*fid, uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	f, ok := c.fids[n]
+	if !ok {
+		return nil, eBADF
+	}
+	return f, 0
+}
+
+func (c *conn) setfid(n uint32, f *fid) uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if old, ok := c.fids[n]; ok {
+		if old.open {
+			return eBADF
+		}
+	}
+	c.fids[n] = f
+	return 0
+}
+
+func (c *conn) release(f *fid) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.open {
+		if f.dir {
+			c.srv.fs.Releasedir(f.path, f.fh)
+		} else {
+			c.srv.fs.Release(f.path, f.fh)
+		}
+		f.open = false
+	}
+}
+
+func (c *conn) attach(args *decoder, res *encoder) uint32 {
+	n := args.uint32()
+	args.uint32()
+	args.string()
+	aname := args.string()
+	args.uint32()
+	if args.err {
+		return eINVAL
+	}
+	path := pathutil.Clean("/" + aname)
+	stat := fuse.Stat_t{}
+	if errno := c.srv.getattr(path, &stat); 0 != errno {
+		return errno
+	}
+	if fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+		return eNOTDIR
+	}
+	if errno := c.setfid(n, &fid{path: path}); 0 != errno {
+		return errno
+	}
+	res.qid(c.srv.qid(path, &stat))
+	return 0
+}
+
+func (c *conn) walk(args *decoder, res *encoder) uint32 {
+	n := args.uint32()
+	newn := args.uint32()
+	nwname := int(args.uint16())
+	if maxWelem < nwname {
+		return eINVAL
+	}
+	names := make([]string, nwname)
+	for i := range names {
+		names[i] = args.string()
+	}
+	if args.err {
+		return eINVAL
+	}
+	f, errno := c.getfid(n)
+	if 0 != errno {
+		return errno
+	}
+	path := f.path
+	qids := make([]qid, 0, nwname)
+	for _, name := range names {
+		if "" == name || strings.Contains(name, "/") {
+			errno = eNOENT
+			break
+		}
+		next := pathutil.Join(path, name)
+		stat := fuse.Stat_t{}
+		if errno = c.srv.getattr(next, &stat); 0 != errno {
+			break
+		}
+		path = next
+		qids = append(qids, c.srv.qid(path, &stat))
+	}
+	if 0 != errno {
+		if 0 == len(qids) {
+			return errno
+		}
+	} else if errno = c.setfid(newn, &fid{path: path}); 0 != errno {
+		return errno
+	}
+	res.uint16(uint16(len(qids)))
+	for _, q := range qids {
+		res.qid(q)
+	}
+	return 0
+}
+
+func (c *conn) clunk(args *decoder) uint32 {
+	n := args.uint32()
+	c.lock.Lock()
+	f, ok := c.fids[n]
+	delete(c.fids, n)
+	c.lock.Unlock()
+	if !ok {
+		return eBADF
+	}
+	c.release(f)
+	return 0
+}
+
+func (c *conn) getattr(args *decoder, res *encoder) uint32 {
+	f, errno := c.getfid(args.uint32())
+	args.uint64()
+	if 0 != errno {
+		return errno
+	}
+	stat := fuse.Stat_t{}
+	if errno = c.srv.getattr(f.path, &stat); 0 != errno {
+		return errno
+	}
+	res.uint64(0x000007ff) // P9_GETATTR_BASIC
+	res.qid(c.srv.qid(f.path, &stat))
+	res.uint32(stat.Mode)
+	res.uint32(stat.Uid)
+	res.uint32(stat.Gid)
+	res.uint64(uint64(stat.Nlink))
+	res.uint64(stat.Rdev)
+	res.uint64(uint64(stat.Size))
+	res.uint64(4096)
+	res.uint64((uint64(stat.Size) + 511) / 512)
+	for _, t := range []fuse.Timespec{stat.Atim, stat.Mtim, stat.Ctim, stat.Birthtim} {
+		res.uint64(uint64(t.Sec))
+		res.uint64(uint64(t.Nsec))
+	}
+	res.uint64(0)
+	res.uint64(0)
+	return 0
+}
+
+func (c *conn) lopen(args *decoder, res *encoder) uint32 {
+	const (
+		oACCMODE = 03
+		oCREAT   = 0100
+		oTRUNC   = 01000
+		oAPPEND  = 02000
+	)
+	f, errno := c.getfid(args.uint32())
+	flags := args.uint32()
+	if 0 != errno {
+		return errno
+	}
+	if 0 != flags&(oACCMODE|oCREAT|oTRUNC|oAPPEND) {
+		return eROFS
+	}
+	stat := fuse.Stat_t{}
+	if errno = c.srv.getattr(f.path, &stat); 0 != errno {
+		return errno
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.open {
+		return eBADF
+	}
+	var errc int
+	if fuse.S_IFDIR == stat.Mode&fuse.S_IFMT {
+		errc, f.fh = c.srv.fs.Opendir(f.path)
+		f.dir = true
+	} else {
+		errc, f.fh = c.srv.fs.Open(f.path, fuse.O_RDONLY)
+		f.dir = false
+	}
+	if 0 != errc {
+		return linuxErrno(errc)
+	}
+	f.open = true
+	res.qid(c.srv.qid(f.path, &stat))
+	res.uint32(0)
+	return 0
+}
+
+func (c *conn) read(args *decoder, res *encoder) uint32 {
+	f, errno := c.getfid(args.uint32())
+	ofst := int64(args.uint64())
+	count := args.uint32()
+	if 0 != errno {
+		return errno
+	}
+	if c.msize-11 < count {
+		count = c.msize - 11
+	}
+	f.lock.Lock()
+	open, dir, fh := f.open, f.dir, f.fh
+	f.lock.Unlock()
+	if !open {
+		return eBADF
+	}
+	if dir {
+		return eISDIR
+	}
+	buf := make([]byte, count)
+	n := 0
+	for len(buf) > n {
+		m := c.srv.fs.Read(f.path, buf[n:], ofst+int64(n), fh)
+		if 0 > m {
+			return linuxErrno(m)
+		}
+		if 0 == m {
+			break
+		}
+		n += m
+	}
+	res.uint32(uint32(n))
+	res.buf = append(res.buf, buf[:n]...)
+	return 0
+}
+
+// readdir encodes directory entries starting at an offset. Offsets are positions in
+// the directory listing, which is read again when a client starts at offset 0.
+func (c *conn) readdir(args *decoder, res *encoder) uint32 {
+	f, errno := c.getfid(args.uint32())
+	ofst := args.uint64()
+	count := args.uint32()
+	if 0 != errno {
+		return errno
+	}
+	if c.msize-11 < count {
+		count = c.msize - 11
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.open || !f.dir {
+		return eBADF
+	}
+	if 0 == ofst || nil == f.entries {
+		entries := []dirEntry{}
+		fill := func(name string, stat *fuse.Stat_t, ofst int64) bool {
+			e := dirEntry{name: name}
+			if nil != stat && "." != name && ".." != name {
+				e.stat = *stat
+			} else {
+				c.srv.getattr(entryPath(f.path, name), &e.stat)
+			}
+			entries = append(entries, e)
+			return true
+		}
+		if errc := c.srv.fs.Readdir(f.path, fill, 0, f.fh); 0 != errc {
+			return linuxErrno(errc)
+		}
+		f.entries = entries
+	}
+
+	data := &encoder{}
+	for i := ofst; uint64(len(f.entries)) > i; i++ {
+		e := f.entries[i]
+		if int(count) < len(data.buf)+13+8+1+2+len(e.name) {
+			break
+		}
+		data.qid(c.srv.qid(entryPath(f.path, e.name), &e.stat))
+		data.uint64(i + 1)
+		data.uint8(uint8((e.stat.Mode & fuse.S_IFMT) >> 12))
+		data.string(e.name)
+	}
+	res.uint32(uint32(len(data.buf)))
+	res.buf = append(res.buf, data.buf...)
+	return 0
+}
+
+func (c *conn) readlink(args *decoder, res *encoder) uint32 {
+	f, errno := c.getfid(args.uint32())
+	if 0 != errno {
+		return errno
+	}
+	errc, target := c.srv.fs.Readlink(f.path)
+	if 0 != errc {
+		return linuxErrno(errc)
+	}
+	res.string(target)
+	return 0
+}
+
+func (c *conn) statfs(args *decoder, res *encoder) uint32 {
+	f, errno := c.getfid(args.uint32())
+	if 0 != errno {
+		return errno
+	}
+	stat := fuse.Statfs_t{}
+	if errc := c.srv.fs.Statfs(f.path, &stat); 0 != errc {
+		return linuxErrno(errc)
+	}
+	res.uint32(0x01021997) // V9FS_MAGIC
+	res.uint32(uint32(stat.Bsize))
+	res.uint64(stat.Blocks)
+	res.uint64(stat.Bfree)
+	res.uint64(stat.Bavail)
+	res.uint64(stat.Files)
+	res.uint64(stat.Ffree)
+	res.uint64(stat.Fsid)
+	res.uint32(255)
+	return 0
+}
+
+// entryPath returns the path of a directory entry.
+func entryPath(dir string, name string) string {
+	switch name {
+	case ".":
+		return dir
+	case "..":
+		return pathutil.Dir(dir)
+	}
+	return pathutil.Join(dir, name)
+}
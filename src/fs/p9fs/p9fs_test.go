@@ -0,0 +1,194 @@
+/*
+ * p9fs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package p9fs
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/prov/memprov"
+)
+
+func TestServer(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("dir/file.txt", 0100644, []byte("hello\n"))
+	ref.AddFile("link", 0120000, []byte("dir/file.txt"))
+
+	srv := New(hubfs.New(hubfs.Config{Client: client, Fmask: 022, Dmask: 022}).FileSystemInterface())
+	defer srv.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	call := func(typ uint8, args func(e *encoder)) (uint8, *decoder) {
+		e := &encoder{}
+		args(e)
+		if _, err := conn.Write(newMsg(typ, 1, e.buf)); nil != err {
+			t.Fatal(err)
+		}
+		msg, err := readMsg(conn, maxMsize)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return msg[4], &decoder{buf: msg[7:]}
+	}
+	walk := func(fid, newfid uint32, names ...string) (uint8, *decoder) {
+		return call(twalk, func(e *encoder) {
+			e.uint32(fid)
+			e.uint32(newfid)
+			e.uint16(uint16(len(names)))
+			for _, n := range names {
+				e.string(n)
+			}
+		})
+	}
+
+	typ, d := call(tversion, func(e *encoder) {
+		e.uint32(65536)
+		e.string("9P2000.L")
+	})
+	if tversion+1 != typ || 65536 != d.uint32() || "9P2000.L" != d.string() {
+		t.Fatal(typ)
+	}
+
+	typ, d = call(tattach, func(e *encoder) {
+		e.uint32(1)
+		e.uint32(^uint32(0))
+		e.string("user")
+		e.string("/owner/repo/main")
+		e.uint32(0)
+	})
+	if tattach+1 != typ || 0x80 != d.uint8() {
+		t.Fatal(typ)
+	}
+
+	typ, d = walk(1, 2, "dir", "file.txt")
+	if twalk+1 != typ || 2 != d.uint16() {
+		t.Fatal(typ)
+	}
+	typ, d = walk(1, 3, "dir", "nonexistent")
+	if twalk+1 != typ || 1 != d.uint16() {
+		t.Error(typ)
+	}
+	typ, d = walk(1, 3, "nonexistent")
+	if tlerror+1 != typ || eNOENT != d.uint32() {
+		t.Error(typ)
+	}
+
+	typ, d = call(tgetattr, func(e *encoder) {
+		e.uint32(2)
+		e.uint64(0x7ff)
+	})
+	d.fixed(8 + 13)
+	if tgetattr+1 != typ || 0100644 != d.uint32() {
+		t.Error(typ)
+	}
+	d.fixed(4 + 4 + 8 + 8)
+	if 6 != d.uint64() {
+		t.Error()
+	}
+
+	typ, _ = call(tlopen, func(e *encoder) {
+		e.uint32(2)
+		e.uint32(02)
+	})
+	if tlerror+1 != typ {
+		t.Error(typ)
+	}
+	typ, _ = call(tlopen, func(e *encoder) {
+		e.uint32(2)
+		e.uint32(0)
+	})
+	if tlopen+1 != typ {
+		t.Error(typ)
+	}
+	typ, d = call(tread, func(e *encoder) {
+		e.uint32(2)
+		e.uint64(1)
+		e.uint32(100)
+	})
+	if tread+1 != typ || "ello\n" != string(d.fixed(int(d.uint32()))) {
+		t.Error(typ)
+	}
+
+	walk(1, 4)
+	call(tlopen, func(e *encoder) {
+		e.uint32(4)
+		e.uint32(0)
+	})
+	names := []string{}
+	ofst := uint64(0)
+	for {
+		typ, d = call(treaddir, func(e *encoder) {
+			e.uint32(4)
+			e.uint64(ofst)
+			e.uint32(40)
+		})
+		if treaddir+1 != typ {
+			t.Fatal(typ)
+		}
+		data := &decoder{buf: d.fixed(int(d.uint32()))}
+		if 0 == len(data.buf) {
+			break
+		}
+		for 0 != len(data.buf) {
+			data.fixed(13)
+			ofst = data.uint64()
+			data.uint8()
+			names = append(names, data.string())
+		}
+	}
+	if "[. .. dir link]" != fmt.Sprint(names) {
+		t.Error(names)
+	}
+
+	walk(1, 5, "link")
+	typ, d = call(treadlink, func(e *encoder) { e.uint32(5) })
+	if treadlink+1 != typ || "dir/file.txt" != d.string() {
+		t.Error(typ)
+	}
+
+	typ, d = call(tmkdir, func(e *encoder) {
+		e.uint32(1)
+		e.string("newdir")
+		e.uint32(0755)
+		e.uint32(0)
+	})
+	if tlerror+1 != typ || eROFS != d.uint32() {
+		t.Error(typ)
+	}
+
+	for _, fid := range []uint32{1, 2, 4, 5} {
+		if typ, _ = call(tclunk, func(e *encoder) { e.uint32(fid) }); tclunk+1 != typ {
+			t.Error(typ)
+		}
+	}
+	typ, d = call(tclunk, func(e *encoder) { e.uint32(1) })
+	if tlerror+1 != typ || eBADF != d.uint32() {
+		t.Error(typ)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !cgo || !(darwin || linux)
+// +build !cgo !darwin,!linux
+
+/*
+ * interrupt.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package port
+
+// Interrupted reports whether the FUSE operation that is executing on the current
+// thread has been interrupted. Interrupts are not supported on this platform.
+func Interrupted() bool {
+	return false
+}
+
+const Interruptible = false
@@ -0,0 +1,78 @@
+//go:build cgo && (darwin || linux)
+// +build cgo
+// +build darwin linux
+
+/*
+ * interrupt_cgo.go
+ *
+ * Copyright 2017-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package port
+
+/*
+#cgo linux LDFLAGS: -ldl
+
+#include <dlfcn.h>
+#include <stddef.h>
+
+static int (*pfn_fuse_interrupted)(void);
+
+// The FUSE library has already been loaded by cgofuse; we only look it up.
+static void port_interrupt_init(void)
+{
+	static const char *names[] =
+	{
+#if defined(__APPLE__)
+		"/usr/local/lib/libfuse.2.dylib",
+		"/usr/local/lib/libosxfuse.2.dylib",
+		"/usr/local/lib/libfuse-t.dylib",
+#else
+		"libfuse.so.2",
+		"libfuse3.so.3",
+#endif
+	};
+	for (size_t i = 0; sizeof names / sizeof names[0] > i; i++)
+	{
+		void *h = dlopen(names[i], RTLD_NOW | RTLD_NOLOAD);
+		if (0 != h)
+		{
+			*(void **)&pfn_fuse_interrupted = dlsym(h, "fuse_interrupted");
+			if (0 != pfn_fuse_interrupted)
+				break;
+		}
+	}
+}
+
+static int port_interrupted(void)
+{
+	return 0 != pfn_fuse_interrupted ? pfn_fuse_interrupted() : 0;
+}
+*/
+import "C"
+
+import (
+	"runtime"
+	"sync"
+)
+
+var interruptOnce sync.Once
+
+// Interrupted reports whether the FUSE operation that is executing on the current
+// thread has been interrupted. It must be called from the goroutine that services
+// the FUSE operation.
+func Interrupted() bool {
+	interruptOnce.Do(func() { C.port_interrupt_init() })
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	return 0 != C.port_interrupted()
+}
+
+const Interruptible = true
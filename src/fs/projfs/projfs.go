@@ -0,0 +1,33 @@
+/*
+ * projfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package projfs projects a FUSE file system into a directory using the Windows
+// Projected File System (ProjFS). Directories and files are projected on demand and
+// file content is hydrated into the directory when it is first read; hydrated files are
+// real NTFS files that remain in the directory after the projection stops. Local changes
+// are kept in the directory and are not written to the file system. Symbolic links and
+// the .hubfs control directory are not projected.
+package projfs
+
+import (
+	"context"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// Mount projects the file system into the root directory, which is created if it does
+// not exist, and waits until ctx is done. It initializes the file system and destroys it
+// when it returns.
+func Mount(ctx context.Context, fs fuse.FileSystemInterface, root string) error {
+	return mount(ctx, fs, root)
+}
@@ -0,0 +1,28 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * projfs_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package projfs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+func mount(ctx context.Context, fs fuse.FileSystemInterface, root string) error {
+	return errors.New("ProjFS is only supported on Windows")
+}
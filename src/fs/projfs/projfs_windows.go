@@ -0,0 +1,493 @@
+//go:build windows
+// +build windows
+
+/*
+ * projfs_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package projfs
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+var (
+	projfslib = syscall.NewLazyDLL("ProjectedFSLib.dll")
+
+	procPrjMarkDirectoryAsPlaceholder = projfslib.NewProc("PrjMarkDirectoryAsPlaceholder")
+	procPrjStartVirtualizing          = projfslib.NewProc("PrjStartVirtualizing")
+	procPrjStopVirtualizing           = projfslib.NewProc("PrjStopVirtualizing")
+	procPrjWritePlaceholderInfo       = projfslib.NewProc("PrjWritePlaceholderInfo")
+	procPrjFillDirEntryBuffer         = projfslib.NewProc("PrjFillDirEntryBuffer")
+	procPrjFileNameMatch              = projfslib.NewProc("PrjFileNameMatch")
+	procPrjFileNameCompare            = projfslib.NewProc("PrjFileNameCompare")
+	procPrjAllocateAlignedBuffer      = projfslib.NewProc("PrjAllocateAlignedBuffer")
+	procPrjFreeAlignedBuffer          = projfslib.NewProc("PrjFreeAlignedBuffer")
+	procPrjWriteFileData              = projfslib.NewProc("PrjWriteFileData")
+)
+
+const (
+	sOK                     = 0
+	eFAIL                   = 0x80004005
+	eOUTOFMEMORY            = 0x8007000E
+	hrFileNotFound          = 0x80070002 // HRESULT_FROM_WIN32(ERROR_FILE_NOT_FOUND)
+	hrAccessDenied          = 0x80070005 // HRESULT_FROM_WIN32(ERROR_ACCESS_DENIED)
+	hrInsufficientBuffer    = 0x8007007A // HRESULT_FROM_WIN32(ERROR_INSUFFICIENT_BUFFER)
+	prjCbDataFlagRestart    = 0x00000001 // PRJ_CB_DATA_FLAG_ENUM_RESTART_SCAN
+	fileAttributeDirectory  = 0x00000010
+	fileAttributeReadonly   = 0x00000001
+	fileTimeUnixEpochOffset = 116444736000000000
+
+	// dataChunk is the size of the data that is written to a file in one go.
+	dataChunk = 1024 * 1024
+)
+
+type guid struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// prjCallbackData is PRJ_CALLBACK_DATA.
+type prjCallbackData struct {
+	Size                           uint32
+	Flags                          uint32
+	NamespaceVirtualizationContext uintptr
+	CommandId                      int32
+	FileId                         guid
+	DataStreamId                   guid
+	FilePathName                   *uint16
+	VersionInfo                    uintptr
+	TriggeringProcessId            uint32
+	TriggeringProcessImageFileName *uint16
+	InstanceContext                uintptr
+}
+
+// prjCallbacks is PRJ_CALLBACKS.
+type prjCallbacks struct {
+	StartDirectoryEnumerationCallback uintptr
+	EndDirectoryEnumerationCallback   uintptr
+	GetDirectoryEnumerationCallback   uintptr
+	GetPlaceholderInfoCallback        uintptr
+	GetFileDataCallback               uintptr
+	QueryFileNameCallback             uintptr
+	NotificationCallback              uintptr
+	CancelCommandCallback             uintptr
+}
+
+// prjFileBasicInfo is PRJ_FILE_BASIC_INFO.
+type prjFileBasicInfo struct {
+	IsDirectory    uint8
+	FileSize       int64
+	CreationTime   int64
+	LastAccessTime int64
+	LastWriteTime  int64
+	ChangeTime     int64
+	FileAttributes uint32
+}
+
+// prjPlaceholderInfo is PRJ_PLACEHOLDER_INFO.
+type prjPlaceholderInfo struct {
+	FileBasicInfo        prjFileBasicInfo
+	EaInformation        [2]uint32
+	SecurityInformation  [2]uint32
+	StreamsInformation   [2]uint32
+	VersionInfoProvider  [128]byte
+	VersionInfoContentID [128]byte
+	VariableData         [1]byte
+}
+
+var (
+	callbacks     prjCallbacks
+	callbacksOnce sync.Once
+)
+
+var instances = struct {
+	lock sync.Mutex
+	next uintptr
+	m    map[uintptr]*instance
+}{m: map[uintptr]*instance{}}
+
+// instance is a running virtualization instance.
+type instance struct {
+	fs    fuse.FileSystemInterface
+	lock  sync.Mutex
+	enums map[guid]*enumeration
+}
+
+// enumeration is a directory enumeration session.
+type enumeration struct {
+	entries []dirEntry
+	index   int
+	pattern string
+	started bool
+}
+
+type dirEntry struct {
+	name string
+	stat fuse.Stat_t
+}
+
+func mount(ctx context.Context, fs fuse.FileSystemInterface, root string) error {
+	if err := procPrjStartVirtualizing.Find(); nil != err {
+		return errors.New("ProjFS is not available (enable the Windows Projected File System feature)")
+	}
+
+	root, err := filepath.Abs(root)
+	if nil != err {
+		return err
+	}
+	if err = os.MkdirAll(root, 0755); nil != err {
+		return err
+	}
+	rootp, err := syscall.UTF16PtrFromString(root)
+	if nil != err {
+		return err
+	}
+
+	/* a root that has already been marked keeps its instance id; the error is ignored */
+	id := guid{}
+	rand.Read((*[16]byte)(unsafe.Pointer(&id))[:])
+	procPrjMarkDirectoryAsPlaceholder.Call(
+		uintptr(unsafe.Pointer(rootp)), 0, 0, uintptr(unsafe.Pointer(&id)))
+
+	fs.Init()
+	defer fs.Destroy()
+
+	inst := &instance{fs: fs, enums: map[guid]*enumeration{}}
+	instances.lock.Lock()
+	instances.next++
+	key := instances.next
+	instances.m[key] = inst
+	instances.lock.Unlock()
+	defer func() {
+		instances.lock.Lock()
+		delete(instances.m, key)
+		instances.lock.Unlock()
+	}()
+
+	/* callbacks are created on first use, because their number is limited */
+	callbacksOnce.Do(func() {
+		callbacks = prjCallbacks{
+			StartDirectoryEnumerationCallback: syscall.NewCallback(startDirectoryEnumeration),
+			EndDirectoryEnumerationCallback:   syscall.NewCallback(endDirectoryEnumeration),
+			GetDirectoryEnumerationCallback:   syscall.NewCallback(getDirectoryEnumeration),
+			GetPlaceholderInfoCallback:        syscall.NewCallback(getPlaceholderInfo),
+			GetFileDataCallback:               syscall.NewCallback(getFileData),
+		}
+	})
+
+	var nsctx uintptr
+	hr, _, _ := procPrjStartVirtualizing.Call(
+		uintptr(unsafe.Pointer(rootp)),
+		uintptr(unsafe.Pointer(&callbacks)),
+		key,
+		0,
+		uintptr(unsafe.Pointer(&nsctx)))
+	if sOK != int32(hr) {
+		return fmt.Errorf("cannot start virtualization of %s (HRESULT 0x%08x)", root, uint32(hr))
+	}
+
+	<-ctx.Done()
+	procPrjStopVirtualizing.Call(nsctx)
+	return nil
+}
+
+func getInstance(data *prjCallbackData) *instance {
+	instances.lock.Lock()
+	defer instances.lock.Unlock()
+	return instances.m[data.InstanceContext]
+}
+
+// fusePath converts a path relative to the virtualization root to a FUSE path.
+func fusePath(p *uint16) string {
+	return pathutil.Clean("/" + strings.ReplaceAll(utf16PtrToString(p), `\`, "/"))
+}
+
+// hidden determines if a path is hidden. The control directory is hidden, because
+// ProjFS keeps the files that it has hydrated and their content would not be updated.
+func hidden(path string) bool {
+	return strings.EqualFold(path, "/.hubfs")
+}
+
+func utf16PtrToString(p *uint16) string {
+	if nil == p {
+		return ""
+	}
+	n := 0
+	for ptr := unsafe.Pointer(p); 0 != *(*uint16)(ptr); n++ {
+		ptr = unsafe.Pointer(uintptr(ptr) + 2)
+	}
+	return syscall.UTF16ToString((*[1 << 29]uint16)(unsafe.Pointer(p))[:n:n])
+}
+
+func hresult(errc int) uintptr {
+	switch errc {
+	case 0:
+		return sOK
+	case -fuse.ENOENT, -fuse.ENOTDIR:
+		return hrFileNotFound
+	case -fuse.EACCES, -fuse.EPERM:
+		return hrAccessDenied
+	default:
+		return eFAIL
+	}
+}
+
+func fileTime(t fuse.Timespec) int64 {
+	return t.Sec*10000000 + t.Nsec/100 + fileTimeUnixEpochOffset
+}
+
+func basicInfo(stat *fuse.Stat_t) prjFileBasicInfo {
+	info := prjFileBasicInfo{
+		FileSize:       stat.Size,
+		CreationTime:   fileTime(stat.Birthtim),
+		LastAccessTime: fileTime(stat.Atim),
+		LastWriteTime:  fileTime(stat.Mtim),
+		ChangeTime:     fileTime(stat.Ctim),
+	}
+	if fuse.S_IFDIR == stat.Mode&fuse.S_IFMT {
+		info.IsDirectory = 1
+		info.FileSize = 0
+		info.FileAttributes = fileAttributeDirectory
+	} else if 0 == stat.Mode&0222 {
+		info.FileAttributes = fileAttributeReadonly
+	}
+	return info
+}
+
+// projected determines if a file is projected; symbolic links and special files are not.
+func projected(stat *fuse.Stat_t) bool {
+	switch stat.Mode & fuse.S_IFMT {
+	case fuse.S_IFDIR, fuse.S_IFREG:
+		return true
+	}
+	return false
+}
+
+func startDirectoryEnumeration(data *prjCallbackData, enumid *guid) uintptr {
+	inst := getInstance(data)
+	if nil == inst {
+		return eFAIL
+	}
+	path := fusePath(data.FilePathName)
+	errc, fh := inst.fs.Opendir(path)
+	if 0 != errc {
+		return hresult(errc)
+	}
+	defer inst.fs.Releasedir(path, fh)
+	entries := []dirEntry{}
+	fill := func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		if "." == name || ".." == name {
+			return true
+		}
+		e := dirEntry{name: name}
+		if nil != stat {
+			e.stat = *stat
+		} else if 0 != inst.fs.Getattr(pathutil.Join(path, name), &e.stat, ^uint64(0)) {
+			return true
+		}
+		if projected(&e.stat) && !hidden(pathutil.Join(path, name)) {
+			entries = append(entries, e)
+		}
+		return true
+	}
+	if errc = inst.fs.Readdir(path, fill, 0, fh); 0 != errc {
+		return hresult(errc)
+	}
+
+	/* ProjFS requires the entries in PrjFileNameCompare order */
+	names := make([]*uint16, len(entries))
+	for i, e := range entries {
+		names[i], _ = syscall.UTF16PtrFromString(e.name)
+	}
+	sort.Sort(&byPrjName{entries, names})
+
+	inst.lock.Lock()
+	inst.enums[*enumid] = &enumeration{entries: entries}
+	inst.lock.Unlock()
+	return sOK
+}
+
+func endDirectoryEnumeration(data *prjCallbackData, enumid *guid) uintptr {
+	inst := getInstance(data)
+	if nil == inst {
+		return eFAIL
+	}
+	inst.lock.Lock()
+	delete(inst.enums, *enumid)
+	inst.lock.Unlock()
+	return sOK
+}
+
+func getDirectoryEnumeration(data *prjCallbackData, enumid *guid, expr *uint16, buffer uintptr) uintptr {
+	inst := getInstance(data)
+	if nil == inst {
+		return eFAIL
+	}
+	inst.lock.Lock()
+	enum, ok := inst.enums[*enumid]
+	inst.lock.Unlock()
+	if !ok {
+		return eFAIL
+	}
+
+	/* the search expression of the first call (or of a restart) applies to the session */
+	if !enum.started || 0 != data.Flags&prjCbDataFlagRestart {
+		enum.started = true
+		enum.index = 0
+		enum.pattern = utf16PtrToString(expr)
+	}
+	var pattern *uint16
+	if "" != enum.pattern {
+		pattern, _ = syscall.UTF16PtrFromString(enum.pattern)
+	}
+
+	added := 0
+	for ; len(enum.entries) > enum.index; enum.index++ {
+		e := &enum.entries[enum.index]
+		name, _ := syscall.UTF16PtrFromString(e.name)
+		if nil != pattern {
+			if r, _, _ := procPrjFileNameMatch.Call(
+				uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(pattern))); 0 == uint8(r) {
+				continue
+			}
+		}
+		info := basicInfo(&e.stat)
+		hr, _, _ := procPrjFillDirEntryBuffer.Call(
+			uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&info)), buffer)
+		if sOK != int32(hr) {
+			if hrInsufficientBuffer == uint32(hr) && 0 < added {
+				break
+			}
+			return hr
+		}
+		added++
+	}
+	return sOK
+}
+
+func getPlaceholderInfo(data *prjCallbackData) uintptr {
+	inst := getInstance(data)
+	if nil == inst {
+		return eFAIL
+	}
+	path := fusePath(data.FilePathName)
+	if hidden(path) {
+		return hrFileNotFound
+	}
+	stat := fuse.Stat_t{}
+	if errc := inst.fs.Getattr(path, &stat, ^uint64(0)); 0 != errc {
+		return hresult(errc)
+	}
+	if !projected(&stat) {
+		return hrFileNotFound
+	}
+	info := prjPlaceholderInfo{FileBasicInfo: basicInfo(&stat)}
+	hr, _, _ := procPrjWritePlaceholderInfo.Call(
+		data.NamespaceVirtualizationContext,
+		uintptr(unsafe.Pointer(data.FilePathName)),
+		uintptr(unsafe.Pointer(&info)),
+		unsafe.Sizeof(info))
+	return hr
+}
+
+func getFileData(data *prjCallbackData, ofst uint64, length uint32) uintptr {
+	inst := getInstance(data)
+	if nil == inst {
+		return eFAIL
+	}
+	path := fusePath(data.FilePathName)
+	errc, fh := inst.fs.Open(path, fuse.O_RDONLY)
+	if 0 != errc {
+		return hresult(errc)
+	}
+	defer inst.fs.Release(path, fh)
+
+	size := uint32(dataChunk)
+	if length < size {
+		size = length
+	}
+	ptr, _, _ := procPrjAllocateAlignedBuffer.Call(data.NamespaceVirtualizationContext, uintptr(size))
+	if 0 == ptr {
+		return eOUTOFMEMORY
+	}
+	defer procPrjFreeAlignedBuffer.Call(ptr)
+	buf := (*[1 << 30]byte)(*(*unsafe.Pointer)(unsafe.Pointer(&ptr)))[:size:size]
+
+	for 0 < length {
+		n := size
+		if length < n {
+			n = length
+		}
+		m := 0
+		for int(n) > m {
+			r := inst.fs.Read(path, buf[m:n], int64(ofst)+int64(m), fh)
+			if 0 > r {
+				return hresult(r)
+			}
+			if 0 == r {
+				break
+			}
+			m += r
+		}
+		if 0 == m {
+			break
+		}
+		hr, _, _ := procPrjWriteFileData.Call(
+			data.NamespaceVirtualizationContext,
+			uintptr(unsafe.Pointer(&data.DataStreamId)),
+			ptr,
+			uintptr(ofst),
+			uintptr(m))
+		if sOK != int32(hr) {
+			return hr
+		}
+		ofst += uint64(m)
+		length -= uint32(m)
+	}
+	return sOK
+}
+
+type byPrjName struct {
+	entries []dirEntry
+	names   []*uint16
+}
+
+func (s *byPrjName) Len() int {
+	return len(s.entries)
+}
+
+func (s *byPrjName) Less(i, j int) bool {
+	r, _, _ := procPrjFileNameCompare.Call(
+		uintptr(unsafe.Pointer(s.names[i])), uintptr(unsafe.Pointer(s.names[j])))
+	return 0 > int32(r)
+}
+
+func (s *byPrjName) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.names[i], s.names[j] = s.names[j], s.names[i]
+}
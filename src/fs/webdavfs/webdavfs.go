@@ -0,0 +1,169 @@
+/*
+ * webdavfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package webdavfs serves a FUSE file system over WebDAV. The file system is served
+// read-only: requests that modify it fail with a permission error.
+package webdavfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	pathutil "path"
+	"strings"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/iofs"
+	"github.com/winfsp/hubfs/util"
+	"golang.org/x/net/webdav"
+)
+
+// xattrOid is the extended attribute that holds the object id of a file; if present it
+// is used as the file ETag.
+const xattrOid = "user.hubfs.oid"
+
+// Handler is an http.Handler that serves a file system over WebDAV.
+type Handler struct {
+	webdav.Handler
+	fs *filesystem
+}
+
+type filesystem struct {
+	fs   fuse.FileSystemInterface
+	iofs *iofs.FS
+}
+
+type file struct {
+	fs.File
+	fs   *filesystem
+	path string
+}
+
+type fileInfo struct {
+	fs.FileInfo
+	fs   *filesystem
+	path string
+}
+
+// New creates a handler and initializes the file system. Close destroys the file system.
+func New(fs fuse.FileSystemInterface) *Handler {
+	h := &Handler{
+		fs: &filesystem{
+			fs:   fs,
+			iofs: iofs.New(fs),
+		},
+	}
+	h.Handler = webdav.Handler{
+		FileSystem: h.fs,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if nil != err {
+				util.Log(util.LogDebug, "fs/webdavfs", r.Method, "path", r.URL.Path, "error", err)
+			}
+		},
+	}
+	return h
+}
+
+func (h *Handler) Close() error {
+	return h.fs.iofs.Close()
+}
+
+// ioName converts a WebDAV name (e.g. "/" or "/a/b/") to an io/fs name (e.g. "." or "a/b").
+func ioName(name string) string {
+	name = strings.TrimPrefix(pathutil.Clean("/"+name), "/")
+	if "" == name {
+		name = "."
+	}
+	return name
+}
+
+func (fsys *filesystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+func (fsys *filesystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (
+	webdav.File, error) {
+	if 0 != flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	name = ioName(name)
+	f, err := fsys.iofs.Open(name)
+	if nil != err {
+		return nil, err
+	}
+	return &file{File: f, fs: fsys, path: name}, nil
+}
+
+func (fsys *filesystem) RemoveAll(ctx context.Context, name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (fsys *filesystem) Rename(ctx context.Context, oldName, newName string) error {
+	return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+}
+
+func (fsys *filesystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = ioName(name)
+	info, err := fsys.iofs.Stat(name)
+	if nil != err {
+		return nil, err
+	}
+	return &fileInfo{FileInfo: info, fs: fsys, path: name}, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.path, Err: fs.ErrPermission}
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.path, Err: fs.ErrInvalid}
+	}
+	entries, err := d.ReadDir(count)
+	res := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if nil != err {
+			continue
+		}
+		res = append(res, &fileInfo{FileInfo: info, fs: f.fs, path: pathutil.Join(f.path, e.Name())})
+	}
+	return res, err
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if nil != err {
+		return nil, err
+	}
+	return &fileInfo{FileInfo: info, fs: f.fs, path: f.path}, nil
+}
+
+// ETag returns the object id of the file as its ETag. It implements webdav.ETager.
+func (info *fileInfo) ETag(ctx context.Context) (string, error) {
+	if !info.IsDir() {
+		errc, oid := info.fs.fs.Getxattr(pathutil.Join("/", info.path), xattrOid)
+		if 0 == errc && 0 != len(oid) {
+			return `"` + string(oid) + `"`, nil
+		}
+	}
+	return "", webdav.ErrNotImplemented
+}
@@ -0,0 +1,84 @@
+/*
+ * webdavfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package webdavfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/prov/memprov"
+)
+
+func TestHandler(t *testing.T) {
+	client := memprov.NewClient()
+	ref := client.AddOwner("owner").AddRepository("repo").AddRef("main", prov.RefBranch, time.Now())
+	ref.AddFile("dir/file.txt", 0100644, []byte("hello\n"))
+
+	h := New(hubfs.New(hubfs.Config{Client: client}).FileSystemInterface())
+	defer h.Close()
+
+	do := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; len(header) > i+1; i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/owner/repo/main/dir/file.txt", "")
+	if 200 != w.Code || "hello\n" != w.Body.String() ||
+		`"ce013625030ba8dba906f756967f9e9ca394464a"` != w.Header().Get("ETag") {
+		t.Error(w.Code, w.Body.String(), w.Header())
+	}
+
+	w = do("PROPFIND", "/owner/repo/main/", "", "Depth", "1")
+	if http.StatusMultiStatus != w.Code ||
+		!strings.Contains(w.Body.String(), "<D:href>/owner/repo/main/dir/</D:href>") {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	w = do("PROPFIND", "/owner/repo/main/dir/file.txt", "", "Depth", "0")
+	if http.StatusMultiStatus != w.Code ||
+		!strings.Contains(w.Body.String(), "<D:getcontentlength>6</D:getcontentlength>") ||
+		!strings.Contains(w.Body.String(), "ce013625030ba8dba906f756967f9e9ca394464a") {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	w = do("PROPFIND", "/owner/repo/main/nonexistent", "", "Depth", "0")
+	if http.StatusNotFound != w.Code {
+		t.Error(w.Code)
+	}
+
+	w = do("PUT", "/owner/repo/main/new.txt", "data")
+	if 200 <= w.Code && 300 > w.Code {
+		t.Error(w.Code)
+	}
+
+	w = do("DELETE", "/owner/repo/main/dir/file.txt", "")
+	if 200 <= w.Code && 300 > w.Code {
+		t.Error(w.Code)
+	}
+
+	w = do("MKCOL", "/owner/repo/main/newdir", "")
+	if 200 <= w.Code && 300 > w.Code {
+		t.Error(w.Code)
+	}
+}
@@ -15,21 +15,28 @@ package git
 
 import (
 	"context"
+	"errors"
 	"io"
+	nethttp "net/http"
+	"sort"
+	"strings"
 	"time"
 
-	libtrace "github.com/billziss-gh/golib/trace"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/winfsp/hubfs/httputil"
+	"github.com/winfsp/hubfs/util"
 )
 
+var ErrUnsupported = errors.New("unsupported by remote")
+
 type ObjectType int
 
 const (
@@ -52,6 +59,7 @@ type Signature struct {
 
 type Tag struct {
 	Tagger     Signature
+	TargetType ObjectType
 	TargetHash string
 }
 
@@ -68,17 +76,28 @@ type TreeEntry struct {
 }
 
 func OpenRepository(remote string, username string, password string) (res *Repository, err error) {
+	var credentials func() (string, string)
+	if "" != username || "" != password {
+		credentials = func() (string, string) {
+			return username, password
+		}
+	}
+	return OpenRepositoryWithCredentials(remote, credentials)
+}
+
+// OpenRepositoryWithCredentials opens a remote repository. The credentials function is
+// called for every request to the remote, so that the credentials can be changed while
+// the repository is open.
+func OpenRepositoryWithCredentials(remote string, credentials func() (string, string)) (
+	res *Repository, err error) {
 	endpoint, err := transport.NewEndpoint(remote)
 	if nil != err {
 		return nil, err
 	}
 
 	var auth transport.AuthMethod
-	if "" != username || "" != password {
-		auth = &http.BasicAuth{
-			Username: username,
-			Password: password,
-		}
+	if nil != credentials {
+		auth = credentialsAuth(credentials)
 	}
 
 	client := http.NewClient(httputil.DefaultClient)
@@ -99,6 +118,23 @@ func OpenRepository(remote string, username string, password string) (res *Repos
 	}, nil
 }
 
+// credentialsAuth is an HTTP basic auth method that gets its credentials on every request.
+type credentialsAuth func() (string, string)
+
+func (a credentialsAuth) Name() string {
+	return "http-basic-auth"
+}
+
+func (a credentialsAuth) String() string {
+	return a.Name()
+}
+
+func (a credentialsAuth) SetAuth(r *nethttp.Request) {
+	if username, password := a(); "" != username || "" != password {
+		r.SetBasicAuth(username, password)
+	}
+}
+
 func (repository *Repository) Close() (err error) {
 	return repository.session.Close()
 }
@@ -111,12 +147,47 @@ func (repository *Repository) GetRefs() (res map[string]string, err error) {
 
 	res = make(map[string]string, len(stg))
 	for n, r := range stg {
-		res[string(n)] = r.Hash().String()
+		if h, ok := repository.advrefs.Peeled[string(n)]; ok {
+			/* annotated tag: use the peeled object that the tag points to */
+			res[string(n)] = h.String()
+		} else {
+			res[string(n)] = r.Hash().String()
+		}
 	}
 
 	return res, nil
 }
 
+func (repository *Repository) GetHead() (res string, err error) {
+	for _, symref := range repository.advrefs.Capabilities.Get(capability.SymRef) {
+		chunks := strings.Split(symref, ":")
+		if 2 == len(chunks) && "HEAD" == chunks[0] {
+			return chunks[1], nil
+		}
+	}
+
+	/* server did not advertise symref=HEAD; guess by matching the HEAD hash */
+	if nil != repository.advrefs.Head {
+		names := make([]string, 0, 1)
+		for n, h := range repository.advrefs.References {
+			if h == *repository.advrefs.Head && strings.HasPrefix(n, "refs/heads/") {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			if "refs/heads/main" == n || "refs/heads/master" == n {
+				return n, nil
+			}
+		}
+		if 0 < len(names) {
+			return names[0], nil
+		}
+	}
+
+	return "", plumbing.ErrReferenceNotFound
+}
+
 type storemap map[plumbing.Hash]plumbing.EncodedObject
 
 func (m storemap) NewEncodedObject() plumbing.EncodedObject {
@@ -187,9 +258,9 @@ func (obs *observer) OnFooter(h plumbing.Hash) error {
 	return nil
 }
 
-func (repository *Repository) fetchObjects(wants []string,
+func (repository *Repository) fetchObjects(ctx context.Context, wants []string, filter string,
 	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
-	defer trace(len(wants))(&err)
+	defer trace(len(wants), filter)(&err)
 
 	req := packp.NewUploadPackRequestFromCapabilities(repository.advrefs.Capabilities)
 
@@ -201,7 +272,7 @@ func (repository *Repository) fetchObjects(wants []string,
 	}
 	if repository.advrefs.Capabilities.Supports("filter") {
 		req.Capabilities.Set("filter")
-		req.Filter = "tree:0"
+		req.Filter = filter
 	}
 
 	req.Wants = make([]plumbing.Hash, len(wants))
@@ -209,9 +280,9 @@ func (repository *Repository) fetchObjects(wants []string,
 		req.Wants[i] = plumbing.NewHash(w)
 	}
 
-	rsp, err := repository.session.UploadPack(context.Background(), req)
+	rsp, err := repository.session.UploadPack(httputil.WithDownload(ctx), req)
 	if nil != err {
-		return err
+		return unwrapError(err)
 	}
 	defer rsp.Close()
 
@@ -241,7 +312,19 @@ func (repository *Repository) fetchObjects(wants []string,
 	return nil
 }
 
-func (repository *Repository) FetchObjects(wants []string,
+// unwrapError returns the error that go-git has wrapped, so that it can be tested with
+// errors.Is (e.g. for httputil.ErrUnavailable).
+func unwrapError(err error) error {
+	switch e := err.(type) {
+	case *plumbing.UnexpectedError:
+		return e.Err
+	case *plumbing.PermanentError:
+		return e.Err
+	}
+	return err
+}
+
+func (repository *Repository) FetchObjects(ctx context.Context, wants []string,
 	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
 
 	for i, j := 0, 0; len(wants) > i; i = j {
@@ -249,7 +332,7 @@ func (repository *Repository) FetchObjects(wants []string,
 		if len(wants) < j {
 			j = len(wants)
 		}
-		err = repository.fetchObjects(wants[i:j], fn)
+		err = repository.fetchObjects(ctx, wants[i:j], "tree:0", fn)
 		if nil != err {
 			return err
 		}
@@ -258,6 +341,20 @@ func (repository *Repository) FetchObjects(wants []string,
 	return nil
 }
 
+// FetchTrees fetches a commit with all of its trees, but without its blobs or history (as
+// a partial clone with filter=blob:none does), so that the trees arrive in a single pack
+// rather than one request at a time. It fails with ErrUnsupported if the remote does not
+// support filters, because the pack would then include all blobs.
+func (repository *Repository) FetchTrees(ctx context.Context, commit string,
+	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
+
+	if !repository.advrefs.Capabilities.Supports("filter") {
+		return ErrUnsupported
+	}
+
+	return repository.fetchObjects(ctx, []string{commit}, "blob:none", fn)
+}
+
 func DecodeTag(content []byte) (res *Tag, err error) {
 	obj := &plumbing.MemoryObject{}
 	obj.SetType(plumbing.TagObject)
@@ -267,7 +364,7 @@ func DecodeTag(content []byte) (res *Tag, err error) {
 	if nil != err {
 		return
 	}
-	if plumbing.CommitObject != t.TargetType {
+	if plumbing.CommitObject != t.TargetType && plumbing.TagObject != t.TargetType {
 		err = plumbing.ErrInvalidType
 		return
 	}
@@ -277,6 +374,7 @@ func DecodeTag(content []byte) (res *Tag, err error) {
 			Email: t.Tagger.Email,
 			Time:  t.Tagger.When,
 		},
+		TargetType: ObjectType(t.TargetType),
 		TargetHash: t.Target.String(),
 	}
 	return
@@ -328,5 +426,5 @@ func DecodeTree(content []byte) (res []*TreeEntry, err error) {
 }
 
 func trace(vals ...interface{}) func(vals ...interface{}) {
-	return libtrace.Trace(1, "", vals...)
+	return util.Trace(1, "git", vals...)
 }
@@ -14,11 +14,14 @@
 package git
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"testing"
 
 	"github.com/billziss-gh/golib/keyring"
-	libtrace "github.com/billziss-gh/golib/trace"
+	"github.com/winfsp/hubfs/util"
 )
 
 const remote = "https://github.com/winfsp/hubfs"
@@ -83,7 +86,7 @@ func TestFetchObjects(t *testing.T) {
 	found0 := false
 	found1 := false
 	found2 := false
-	err = repository.FetchObjects(wants,
+	err = repository.FetchObjects(context.Background(), wants,
 		func(hash string, ot ObjectType, content []byte) error {
 			if hash0 == hash {
 				found0 = true
@@ -119,7 +122,7 @@ func TestFetchObjects(t *testing.T) {
 		hash0,
 	}
 	found0 = false
-	err = repository.FetchObjects(wants,
+	err = repository.FetchObjects(context.Background(), wants,
 		func(hash string, ot ObjectType, content []byte) error {
 			if hash0 == hash {
 				found0 = true
@@ -141,7 +144,7 @@ func TestFetchObjects(t *testing.T) {
 		hash1,
 	}
 	found1 = false
-	err = repository.FetchObjects(wants,
+	err = repository.FetchObjects(context.Background(), wants,
 		func(hash string, ot ObjectType, content []byte) error {
 			if hash1 == hash {
 				found1 = true
@@ -160,9 +163,92 @@ func TestFetchObjects(t *testing.T) {
 	}
 }
 
+func TestFetchTrees(t *testing.T) {
+	repository, err := OpenRepository(remote, token, "x-oauth-basic")
+	if nil != err {
+		t.Error(err)
+	}
+	defer repository.Close()
+
+	trees := 0
+	err = repository.FetchTrees(context.Background(), hash0,
+		func(hash string, ot ObjectType, content []byte) error {
+			switch ot {
+			case TreeObject:
+				trees++
+			case BlobObject:
+				t.Error(hash)
+			}
+			return nil
+		})
+	if nil != err {
+		t.Error(err)
+	}
+	if 1 >= trees {
+		t.Error(trees)
+	}
+}
+
+func TestFetchMirror(t *testing.T) {
+	repository, err := OpenRepository(remote, token, "x-oauth-basic")
+	if nil != err {
+		t.Error(err)
+	}
+	defer repository.Close()
+
+	dir, err := ioutil.TempDir("", "mirror")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirror, err := OpenMirror(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if mirror.HasObject(hash0) {
+		t.Error()
+	}
+	err = repository.FetchMirror(context.Background(), mirror, refName, hash0)
+	if nil != err {
+		t.Error(err)
+	}
+	mirror.Close()
+
+	mirror, err = OpenMirror(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer mirror.Close()
+	if ot, content, err := mirror.ReadObject(hash0); nil != err || CommitObject != ot {
+		t.Error(err)
+	} else if c, err := DecodeCommit(content); nil != err || !mirror.HasObject(c.TreeHash) {
+		t.Error(err)
+	}
+}
+
+func TestCredentialsAuth(t *testing.T) {
+	password := "token1"
+	auth := credentialsAuth(func() (string, string) {
+		return "user", password
+	})
+
+	req, _ := http.NewRequest("GET", remote, nil)
+	auth.SetAuth(req)
+	if u, p, ok := req.BasicAuth(); !ok || "user" != u || "token1" != p {
+		t.Error()
+	}
+
+	password = "token2"
+	req, _ = http.NewRequest("GET", remote, nil)
+	auth.SetAuth(req)
+	if u, p, ok := req.BasicAuth(); !ok || "user" != u || "token2" != p {
+		t.Error()
+	}
+}
+
 func TestMain(m *testing.M) {
-	libtrace.Verbose = true
-	libtrace.Pattern = "github.com/winfsp/hubfs/*"
+	util.SetLogLevel("debug")
 
 	var err error
 	token, err = keyring.Get("hubfs", "github.com")
@@ -0,0 +1,191 @@
+/*
+ * mirror.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package git
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/winfsp/hubfs/httputil"
+)
+
+// Mirror is a local bare repository that keeps the objects of the commits that have been
+// fetched from a remote, so that their trees and blobs can be read without contacting the
+// remote. It is a regular bare repository that can also be used with git.
+type Mirror struct {
+	lock    sync.Mutex
+	storage *filesystem.Storage
+}
+
+// OpenMirror opens the bare repository at path, creating it if it does not exist.
+func OpenMirror(path string) (res *Mirror, err error) {
+	defer trace(path)(&err)
+
+	err = os.MkdirAll(path, 0700)
+	if nil != err {
+		return nil, err
+	}
+
+	storage := filesystem.NewStorage(osfs.New(path), cache.NewObjectLRUDefault())
+	err = storage.Init()
+	if nil != err {
+		return nil, err
+	}
+
+	if _, err = storage.Reference(plumbing.HEAD); nil != err {
+		cfg := config.NewConfig()
+		cfg.Core.IsBare = true
+		err = storage.SetConfig(cfg)
+		if nil == err {
+			err = storage.SetReference(
+				plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master))
+		}
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	return &Mirror{storage: storage}, nil
+}
+
+func (m *Mirror) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.storage.Close()
+}
+
+// HasObject determines if the mirror has an object.
+func (m *Mirror) HasObject(hash string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return nil == m.storage.HasEncodedObject(plumbing.NewHash(hash))
+}
+
+// ObjectSize returns the size of an object in the mirror.
+func (m *Mirror) ObjectSize(hash string) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.storage.EncodedObjectSize(plumbing.NewHash(hash))
+}
+
+// ReadObject returns the type and content of an object in the mirror.
+func (m *Mirror) ReadObject(hash string) (ObjectType, []byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	obj, err := m.storage.EncodedObject(plumbing.AnyObject, plumbing.NewHash(hash))
+	if nil != err {
+		return 0, nil, err
+	}
+	reader, err := obj.Reader()
+	if nil != err {
+		return 0, nil, err
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if nil != err {
+		return 0, nil, err
+	}
+	return ObjectType(obj.Type()), content, nil
+}
+
+// FetchMirror fetches a commit (with its trees and blobs, but not its history) from the
+// remote into a mirror and points the named ref (unless empty) at it. The refs of the
+// mirror are sent to the remote as the commits that the mirror already has, so that only
+// the objects that have changed since are transferred.
+func (repository *Repository) FetchMirror(ctx context.Context, m *Mirror, name string, hash string) (
+	err error) {
+	defer trace(name, hash)(&err)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	req := packp.NewUploadPackRequestFromCapabilities(repository.advrefs.Capabilities)
+
+	/* the pack is stored as is, so it must not refer to objects outside of it */
+	req.Capabilities.Delete(capability.ThinPack)
+	if nil == req.Capabilities.Set("shallow") {
+		req.Depth = packp.DepthCommits(1)
+		req.Shallows, _ = m.storage.Shallow()
+	}
+	if repository.advrefs.Capabilities.Supports("no-progress") {
+		req.Capabilities.Set("no-progress")
+	}
+
+	if nil != m.storage.HasEncodedObject(plumbing.NewHash(hash)) {
+		req.Wants = append(req.Wants, plumbing.NewHash(hash))
+	}
+	if iter, e := m.storage.IterReferences(); nil == e {
+		iter.ForEach(func(ref *plumbing.Reference) error {
+			if plumbing.HashReference == ref.Type() {
+				req.Haves = append(req.Haves, ref.Hash())
+			}
+			return nil
+		})
+	}
+
+	if 0 < len(req.Wants) {
+		rsp, err := repository.session.UploadPack(httputil.WithDownload(ctx), req)
+		if nil != err {
+			return unwrapError(err)
+		}
+		defer rsp.Close()
+
+		var reader io.Reader
+		switch {
+		case req.Capabilities.Supports("side-band-64k"):
+			reader = sideband.NewDemuxer(sideband.Sideband64k, rsp)
+		case req.Capabilities.Supports("side-band"):
+			reader = sideband.NewDemuxer(sideband.Sideband, rsp)
+		default:
+			reader = rsp
+		}
+
+		writer, err := m.storage.PackfileWriter()
+		if nil != err {
+			return err
+		}
+		_, err = io.Copy(writer, reader)
+		if e := writer.Close(); nil == err {
+			err = e
+		}
+		if nil != err {
+			return err
+		}
+
+		if 0 < len(rsp.Shallows) {
+			err = m.storage.SetShallow(append(req.Shallows, rsp.Shallows...))
+			if nil != err {
+				return err
+			}
+		}
+	}
+
+	if "" != name {
+		err = m.storage.SetReference(
+			plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash)))
+	}
+
+	return err
+}
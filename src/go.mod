@@ -6,8 +6,11 @@ require (
 	github.com/billziss-gh/golib v0.2.0
 	github.com/cli/browser v1.0.0
 	github.com/cli/oauth v0.9.0
+	github.com/go-git/go-billy/v5 v5.0.0
 	github.com/go-git/go-git/v5 v5.2.0
 	github.com/winfsp/cgofuse v1.6.0
+	golang.org/x/net v0.0.0-20200301022130-244492dfa37a
+	golang.org/x/text v0.3.2
 )
 
 replace github.com/go-git/go-git/v5 v5.2.0 => github.com/billziss-gh/go-git/v5 v5.2.1-0.20210325075736-c1624bffeb12
@@ -14,11 +14,23 @@
 package httputil
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/billziss-gh/golib/retry"
+	"github.com/winfsp/hubfs/otlp"
+	"github.com/winfsp/hubfs/util"
 )
 
 var (
@@ -27,8 +39,23 @@ var (
 	DefaultMaxSleep   = time.Second * 30
 	DefaultClient     *http.Client
 	DefaultTransport  *http.Transport
+
+	// DefaultBreakerThreshold is the number of consecutive failed requests to a host that
+	// open its circuit breaker (0 to disable); DefaultBreakerCooldown is the time that the
+	// breaker stays open.
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Second * 30
+
+	// DefaultMaxRequests and DefaultMaxDownloads limit the concurrent requests and the
+	// concurrent downloads (see WithDownload) to a host (0 for no limit).
+	DefaultMaxRequests  = 16
+	DefaultMaxDownloads = 4
 )
 
+// ErrUnavailable is returned without sending a request when recent requests to the host
+// have failed and its circuit breaker is open.
+var ErrUnavailable = errors.New("host is unavailable")
+
 func init() {
 	DefaultTransport = http.DefaultTransport.(*http.Transport).Clone()
 	if nil == DefaultTransport.TLSClientConfig {
@@ -41,32 +68,339 @@ func init() {
 	}
 }
 
+// Configure configures the DefaultTransport with a list of name=value options: proxy,
+// cacert, cert, key, maxconns, maxrequests, maxdownloads, idletimeout, keepalive and http2.
+// An element of the list may contain several comma separated options.
+func Configure(options []string) error {
+	return configure(DefaultTransport, options)
+}
+
+func configure(t *http.Transport, options []string) error {
+	certfile, keyfile := "", ""
+	for _, elm := range options {
+		for _, o := range strings.Split(elm, ",") {
+			n, v := o, ""
+			if i := strings.IndexByte(o, '='); -1 != i {
+				n, v = o[:i], o[i+1:]
+			}
+			var err error
+			switch n {
+			case "proxy":
+				if "direct" == v {
+					t.Proxy = nil
+					break
+				}
+				var u *url.URL
+				u, err = url.Parse(v)
+				if nil == err && "http" != u.Scheme && "https" != u.Scheme && "socks5" != u.Scheme {
+					err = errors.New("unsupported proxy scheme")
+				}
+				if nil == err {
+					t.Proxy = http.ProxyURL(u)
+				}
+			case "cacert":
+				var pem []byte
+				pem, err = ioutil.ReadFile(v)
+				if nil != err {
+					break
+				}
+				pool, e := x509.SystemCertPool()
+				if nil != e || nil == pool {
+					pool = x509.NewCertPool()
+				}
+				if !pool.AppendCertsFromPEM(pem) {
+					err = errors.New("no certificates found")
+					break
+				}
+				t.TLSClientConfig.RootCAs = pool
+			case "cert":
+				certfile = v
+			case "key":
+				keyfile = v
+			case "maxconns":
+				var m int
+				m, err = strconv.Atoi(v)
+				if nil == err && 0 > m {
+					err = strconv.ErrRange
+				}
+				if nil == err {
+					t.MaxConnsPerHost = m
+					if t.MaxIdleConnsPerHost < m {
+						t.MaxIdleConnsPerHost = m
+					}
+				}
+			case "maxrequests":
+				DefaultMaxRequests, err = parseLimit(v)
+			case "maxdownloads":
+				DefaultMaxDownloads, err = parseLimit(v)
+			case "idletimeout":
+				t.IdleConnTimeout, err = time.ParseDuration(v)
+			case "keepalive":
+				var d time.Duration
+				d, err = time.ParseDuration(v)
+				if nil == err {
+					t.DialContext = (&net.Dialer{
+						Timeout:   30 * time.Second,
+						KeepAlive: d,
+					}).DialContext
+				}
+			case "http2":
+				var b bool
+				b, err = strconv.ParseBool(v)
+				if nil == err && !b {
+					/* a non-nil empty map disables HTTP/2 */
+					t.ForceAttemptHTTP2 = false
+					t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+				}
+			default:
+				err = errors.New("unknown option")
+			}
+			if nil != err {
+				return errors.New("invalid transport option " + o + ": " + err.Error())
+			}
+		}
+	}
+
+	if "" != certfile || "" != keyfile {
+		if "" == keyfile {
+			keyfile = certfile
+		}
+		cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+		if nil != err {
+			return errors.New("invalid client certificate: " + err.Error())
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return nil
+}
+
+func parseLimit(v string) (int, error) {
+	m, err := strconv.Atoi(v)
+	if nil == err && 0 > m {
+		err = strconv.ErrRange
+	}
+	return m, err
+}
+
+type downloadKey struct{}
+
+// WithDownload returns a context for requests that download file content (e.g. Git packs
+// and LFS objects). Such requests are limited by DefaultMaxDownloads rather than by
+// DefaultMaxRequests.
+func WithDownload(ctx context.Context) context.Context {
+	return context.WithValue(ctx, downloadKey{}, true)
+}
+
 type transport struct {
 	http.RoundTripper
 }
 
 func (t *transport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
-	retry.Retry(
-		retry.Count(DefaultRetryCount),
-		retry.Backoff(DefaultSleep, DefaultMaxSleep),
-		func(i int) bool {
+	/* the query is not recorded because it may contain credentials */
+	url := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	_, span := otlp.Start(req.Context(), "HTTP "+req.Method, otlp.KindClient,
+		"http.method", req.Method,
+		"http.url", url)
+	done := util.StartRequest(req.Context(), "httputil", req.Method+" "+url)
+	retries := 0
+	defer func() {
+		done()
+		if 0 < retries {
+			span.SetAttr("http.retries", retries)
+		}
+		if nil != rsp {
+			span.SetAttr("http.status_code", rsp.StatusCode)
+		}
+		span.End(err)
+	}()
 
-			rsp, err = t.RoundTripper.RoundTrip(req)
-
-			// retry on connection errors without body
-			if nil != err {
-				return nil == req.Body
+	host := req.URL.Host
+	sleep := DefaultSleep
+	for i := 0; ; i++ {
+		retries = i
+		if !breakers.allow(host) {
+			return nil, ErrUnavailable
+		}
+		release, e := acquire(req.Context(), host)
+		if nil != e {
+			return nil, e
+		}
+		if 0 < i && nil != req.Body {
+			/* rewind the body for the retry */
+			body, e := req.GetBody()
+			if nil != e {
+				release()
+				return nil, e
 			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
 
-			// retry on HTTP 429, 503, 509
+		rsp, err = t.RoundTripper.RoundTrip(req)
+		if nil != err {
+			release()
+		} else {
+			/* the slot is held until the response body has been read or closed */
+			rsp.Body = &releaseBody{ReadCloser: rsp.Body, release: release}
+		}
+
+		retry := false
+		if nil != err {
+			// retry on connection errors
+			if nil != req.Context().Err() {
+				return
+			}
+			breakers.record(host, true)
+			retry = true
+		} else {
+			// retry on HTTP 429, 500, 502, 503, 504, 509; only server errors count as failures
 			switch rsp.StatusCode {
-			case 429, 503, 509:
-				rsp.Body.Close()
-				return true
+			case 429:
+				retry = true
+			case 500, 502, 503, 504, 509:
+				breakers.record(host, true)
+				retry = true
+			default:
+				breakers.record(host, false)
 			}
+		}
+		if !retry || DefaultRetryCount <= i+1 || (nil != req.Body && nil == req.GetBody) {
+			return
+		}
+		if nil != rsp {
+			rsp.Body.Close()
+			rsp = nil
+		}
+
+		/* jittered exponential backoff */
+		timer := time.NewTimer(sleep)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		sleep = time.Duration((1.5 + rand.Float64()) * float64(sleep))
+		if DefaultMaxSleep < sleep {
+			sleep = DefaultMaxSleep
+		}
+	}
+}
+
+// limiter limits the concurrent requests to a host: a request holds a slot until its
+// response body has been read or closed.
+type limiter struct {
+	lock sync.Mutex
+	m    map[string]chan struct{}
+}
 
-			return false
-		})
+var (
+	requestLimiter  = limiter{m: make(map[string]chan struct{})}
+	downloadLimiter = limiter{m: make(map[string]chan struct{})}
+)
+
+func acquire(ctx context.Context, host string) (func(), error) {
+	if nil != ctx.Value(downloadKey{}) {
+		return downloadLimiter.acquire(ctx, host, DefaultMaxDownloads)
+	}
+	return requestLimiter.acquire(ctx, host, DefaultMaxRequests)
+}
 
+func (l *limiter) acquire(ctx context.Context, host string, n int) (func(), error) {
+	if 0 >= n {
+		return func() {}, nil
+	}
+	l.lock.Lock()
+	c := l.m[host]
+	if nil == c || n != cap(c) {
+		c = make(chan struct{}, n)
+		l.m[host] = c
+	}
+	l.lock.Unlock()
+	select {
+	case c <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { <-c })
+	}, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if nil != err {
+		b.release()
+	}
 	return
 }
+
+func (b *releaseBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
+
+// breaker is a circuit breaker for a host. It opens after a number of consecutive failed
+// requests; while it is open, requests to the host fail with ErrUnavailable without being
+// sent. After a cooldown period a single probe request is let through while all others
+// still fail; if the probe fails, the breaker opens again, otherwise it closes. A probe whose
+// outcome is never recorded (e.g. because it was canceled) is superseded after another cooldown.
+type breaker struct {
+	failures int
+	opened   time.Time
+	probing  bool
+}
+
+type breakerMap struct {
+	lock sync.Mutex
+	m    map[string]*breaker
+}
+
+var breakers = breakerMap{m: make(map[string]*breaker)}
+
+func (bm *breakerMap) allow(host string) bool {
+	bm.lock.Lock()
+	defer bm.lock.Unlock()
+	b := bm.m[host]
+	if nil == b || 0 >= DefaultBreakerThreshold || DefaultBreakerThreshold > b.failures {
+		return true
+	}
+	if time.Since(b.opened) < DefaultBreakerCooldown {
+		return false
+	}
+	b.opened = time.Now()
+	b.probing = true
+	return true
+}
+
+func (bm *breakerMap) record(host string, failed bool) {
+	bm.lock.Lock()
+	defer bm.lock.Unlock()
+	if !failed {
+		delete(bm.m, host)
+		return
+	}
+	b := bm.m[host]
+	if nil == b {
+		b = &breaker{}
+		bm.m[host] = b
+	}
+	if b.probing {
+		b.opened = time.Now()
+		b.probing = false
+		return
+	}
+	b.failures++
+	if DefaultBreakerThreshold == b.failures {
+		b.opened = time.Now()
+		util.Log(util.LogWarn, "httputil", "host unavailable",
+			"host", host, "cooldown", DefaultBreakerCooldown)
+	}
+}
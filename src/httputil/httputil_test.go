@@ -0,0 +1,154 @@
+/*
+ * httputil_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package httputil
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigure(t *testing.T) {
+	tr := DefaultTransport.Clone()
+	err := configure(tr, []string{"proxy=socks5://localhost:1080,maxconns=8", "idletimeout=5s"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "https://api.github.com", nil)
+	if u, err := tr.Proxy(req); nil != err || "socks5://localhost:1080" != u.String() {
+		t.Error(u, err)
+	}
+	if 8 != tr.MaxConnsPerHost || 8 > tr.MaxIdleConnsPerHost || 5*time.Second != tr.IdleConnTimeout {
+		t.Error()
+	}
+
+	err = configure(tr, []string{"proxy=direct", "http2=false"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil != tr.Proxy || tr.ForceAttemptHTTP2 || nil == tr.TLSNextProto {
+		t.Error()
+	}
+
+	for _, o := range []string{"proxy=ftp://host", "maxconns=-1", "keepalive=x",
+		"http2=maybe", "cacert=nonexistent", "cert=nonexistent", "unknown=1"} {
+		if nil == configure(DefaultTransport.Clone(), []string{o}) {
+			t.Error(o)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	count, status := 0, 503
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	defer func(c int, s time.Duration, th int, cd time.Duration) {
+		DefaultRetryCount, DefaultSleep, DefaultBreakerThreshold, DefaultBreakerCooldown = c, s, th, cd
+	}(DefaultRetryCount, DefaultSleep, DefaultBreakerThreshold, DefaultBreakerCooldown)
+	DefaultRetryCount, DefaultSleep = 3, time.Millisecond
+	DefaultBreakerThreshold, DefaultBreakerCooldown = 5, 100*time.Millisecond
+
+	rsp, err := DefaultClient.Get(srv.URL)
+	if nil != err || 503 != rsp.StatusCode || 3 != count {
+		t.Fatal(err, count)
+	}
+	rsp.Body.Close()
+
+	_, err = DefaultClient.Get(srv.URL)
+	if !errors.Is(err, ErrUnavailable) || 5 != count {
+		t.Fatal(err, count)
+	}
+	_, err = DefaultClient.Get(srv.URL)
+	if !errors.Is(err, ErrUnavailable) || 5 != count {
+		t.Fatal(err, count)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	_, err = DefaultClient.Get(srv.URL)
+	if !errors.Is(err, ErrUnavailable) || 6 != count {
+		t.Fatal(err, count)
+	}
+	_, err = DefaultClient.Get(srv.URL)
+	if !errors.Is(err, ErrUnavailable) || 6 != count {
+		t.Fatal(err, count)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	host := srv.Listener.Addr().String()
+	if !breakers.allow(host) || breakers.allow(host) {
+		t.Fatal()
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	status = 200
+	rsp, err = DefaultClient.Get(srv.URL)
+	if nil != err || 200 != rsp.StatusCode || 7 != count {
+		t.Fatal(err, count)
+	}
+	rsp.Body.Close()
+}
+
+func TestLimiter(t *testing.T) {
+	lock, active, maxactive := sync.Mutex{}, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		active++
+		if maxactive < active {
+			maxactive = active
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		active--
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	defer func(r, d int) {
+		DefaultMaxRequests, DefaultMaxDownloads = r, d
+	}(DefaultMaxRequests, DefaultMaxDownloads)
+	DefaultMaxRequests, DefaultMaxDownloads = 3, 1
+
+	test := func(ctx context.Context, max int) {
+		maxactive = 0
+		wg := sync.WaitGroup{}
+		for i := 0; 10 > i; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+				rsp, err := DefaultClient.Do(req)
+				if nil == err {
+					ioutil.ReadAll(rsp.Body)
+					rsp.Body.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		if 0 == maxactive || max < maxactive {
+			t.Error(maxactive)
+		}
+	}
+	test(context.Background(), 3)
+	test(WithDownload(context.Background()), 1)
+}
@@ -14,22 +14,38 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/billziss-gh/golib/keyring"
-	libtrace "github.com/billziss-gh/golib/trace"
-	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/httpfs"
 	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/fs/nfsfs"
+	"github.com/winfsp/hubfs/fs/p9fs"
 	"github.com/winfsp/hubfs/fs/port"
+	"github.com/winfsp/hubfs/fs/projfs"
+	"github.com/winfsp/hubfs/fs/webdavfs"
+	"github.com/winfsp/hubfs/httputil"
+	"github.com/winfsp/hubfs/otlp"
 	"github.com/winfsp/hubfs/prov"
 	"github.com/winfsp/hubfs/util"
 )
@@ -46,18 +62,16 @@ var (
 var progname = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
 
 func warn(format string, a ...interface{}) {
-	format = "%s: " + format + "\n"
-	a = append([]interface{}{progname}, a...)
-	fmt.Fprintf(os.Stderr, format, a...)
+	util.Log(util.LogWarn, "main", fmt.Sprintf(format, a...))
 }
 
 func newClientWithKey(provider prov.Provider, authkey string) (
 	client prov.Client, err error) {
-	token, err := keyring.Get(MyProductName, authkey)
+	token, err := prov.DefaultTokenStore.GetToken(authkey)
 	if nil == err {
 		client, err = provider.NewClient(token)
 		if nil != err {
-			keyring.Delete(MyProductName, authkey)
+			prov.DefaultTokenStore.DeleteToken(authkey)
 		}
 	}
 	return
@@ -69,20 +83,104 @@ func oauthNewClientWithKey(provider prov.Provider, authkey string) (
 	if nil == err {
 		client, err = provider.NewClient(token)
 		if nil == err {
-			keyring.Set(MyProductName, authkey, token)
+			prov.DefaultTokenStore.SetToken(authkey, token)
 		}
 	}
 	return
 }
 
+func deviceauthNewClientWithKey(provider prov.Provider, authkey string) (
+	client prov.Client, err error) {
+	p, ok := provider.(prov.DeviceAuthProvider)
+	if !ok {
+		return nil, errors.New("device auth not supported by provider")
+	}
+	token, err := p.DeviceAuth(func(code string, uri string) error {
+		fmt.Printf("To authorize, visit %s and enter the one-time code: %s\n", uri, code)
+		return nil
+	})
+	if nil == err {
+		client, err = provider.NewClient(token)
+		if nil == err {
+			prov.DefaultTokenStore.SetToken(authkey, token)
+		}
+	}
+	return
+}
+
+// multiNewClientWithKeys creates a client that selects the auth token by owner. Every
+// authmap rule has the form owner=authkey, where owner may contain wildcards.
+func multiNewClientWithKeys(provider prov.Provider, client prov.Client, authmap []string) (
+	prov.Client, error) {
+	clients := []prov.OwnerClient{}
+	keyclients := make(map[string]prov.Client)
+	for _, m := range authmap {
+		for _, s := range strings.Split(m, ",") {
+			i := strings.IndexByte(s, '=')
+			if -1 == i {
+				return nil, errors.New("invalid authmap rule: " + s)
+			}
+			patt, key := s[:i], s[i+1:]
+			c, ok := keyclients[key]
+			if !ok {
+				var err error
+				c, err = newClientWithKey(provider, key)
+				if nil != err {
+					return nil, errors.New(fmt.Sprintf("authkey %s: %v", key, err))
+				}
+				keyclients[key] = c
+			}
+			clients = append(clients, prov.OwnerClient{Pattern: patt, Client: c})
+		}
+	}
+	return prov.NewMultiClient(client, clients), nil
+}
+
+// poolNewClientWithKeys adds the tokens of a token pool to a client, which switches among
+// them when the rate limit of one is exhausted. Every element of authpool is the name of
+// a key that stores an auth token or token=T.
+func poolNewClientWithKeys(client prov.Client, authpool []string) error {
+	p, ok := client.(prov.TokenPoolClient)
+	if !ok {
+		return errors.New("token pool not supported by provider")
+	}
+	n := 0
+	for _, m := range authpool {
+		for _, s := range strings.Split(m, ",") {
+			n++
+			name := "authkey " + s
+			token := strings.TrimPrefix(s, "token=")
+			if token != s {
+				name = fmt.Sprintf("authpool token %d", n)
+			} else {
+				var err error
+				token, err = prov.DefaultTokenStore.GetToken(s)
+				if nil != err {
+					return errors.New(fmt.Sprintf("%s: %v", name, err))
+				}
+			}
+			err := p.AddPoolToken(context.Background(), token)
+			if nil != err {
+				return errors.New(fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+	return nil
+}
+
+// gitauthNewClientWithUri gets the auth token from the git credential helpers (e.g. gh,
+// Git Credential Manager, osxkeychain) using the `git credential` protocol. A user name
+// in the remote URI (e.g. user@github.com) selects among multiple accounts.
 func gitauthNewClientWithUri(provider prov.Provider, uri *url.URL) (
 	client prov.Client, err error) {
-	cmd := exec.Command("git", "credential", "fill")
-	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\n", uri.Host))
-	out, err := cmd.Output()
+	input := fmt.Sprintf("protocol=%s\nhost=%s\n", uri.Scheme, uri.Host)
+	if nil != uri.User && "" != uri.User.Username() {
+		input += fmt.Sprintf("username=%s\n", uri.User.Username())
+	}
+	out, err := gitCredential("fill", input)
 	if nil == err {
 		token := ""
-		for _, line := range strings.Split(string(out), "\n") {
+		for _, line := range strings.Split(out, "\n") {
 			t := strings.TrimPrefix(line, "password=")
 			if line != t {
 				token = t
@@ -93,39 +191,328 @@ func gitauthNewClientWithUri(provider prov.Provider, uri *url.URL) (
 			return nil, errors.New("gitauth: no password")
 		}
 		client, err = provider.NewClient(token)
+		if nil == err {
+			gitCredential("approve", out)
+		}
 	}
 	return
 }
 
-func mount(client prov.Client, overlay bool, prefix string, mntpnt string, config []string) bool {
-	mntopt := []string{}
-	for _, s := range config {
-		mntopt = append(mntopt, "-o"+s)
+func gitCredential(action string, input string) (string, error) {
+	cmd := exec.Command("git", "credential", action)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// cacheKeyName is the name of the key in the token store that stores the key that
+// encrypts the cache directory with -cachecrypt.
+const cacheKeyName = "@cachekey"
+
+// setCacheKey sets the key that encrypts the cache directory. The key is created the
+// first time and kept in the system keyring.
+func setCacheKey() error {
+	var key []byte
+	s, err := prov.DefaultTokenStore.GetToken(cacheKeyName)
+	if nil == err {
+		key, err = hex.DecodeString(s)
+	}
+	if nil != err || 32 != len(key) {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+		if nil != err {
+			return err
+		}
+		err = prov.DefaultTokenStore.SetToken(cacheKeyName, hex.EncodeToString(key))
+		if nil != err {
+			return err
+		}
+	}
+	return prov.SetCacheKey(key)
+}
+
+// defaultConfigFile returns the path of the configuration file that is used when the
+// -config option is not specified.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if nil != err {
+		return ""
+	}
+	return filepath.Join(dir, progname, "config")
+}
+
+// configure applies the options in a configuration file. Options that were set on the
+// command line are not changed, except for list options (e.g. -o, -filter), which are
+// extended. The names "remote" and "mountpoint" set the remote and mountpoint that are
+// used when they are not specified on the command line.
+func configure(path string, required bool, remote *string, mntpnt *string) error {
+	file, err := os.Open(path)
+	if nil != err {
+		if !required && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	entries, err := util.ReadConfig(file)
+	if nil != err {
+		return errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+
+	cmdline := cmdlineFlags()
+	for _, e := range entries {
+		switch e.Name {
+		case "remote":
+			*remote = e.Value
+			continue
+		case "mountpoint":
+			*mntpnt = e.Value
+			continue
+		case "config":
+			return errors.New(fmt.Sprintf("%s: invalid option %s", path, e.Name))
+		}
+		f := flag.Lookup(e.Name)
+		if nil == f {
+			return errors.New(fmt.Sprintf("%s: unknown option %s", path, e.Name))
+		}
+		if _, ok := f.Value.(*util.Optlist); !ok && cmdline[e.Name] {
+			continue
+		}
+		err = f.Value.Set(e.Value)
+		if nil != err {
+			return errors.New(fmt.Sprintf("%s: option %s: %v", path, e.Name, err))
+		}
+	}
+	return nil
+}
+
+// newClient creates a client for a provider using the specified auth method.
+func newClient(provider prov.Provider, uri *url.URL, authmeth string, authkey string,
+	authmap []string, authpool []string) (client prov.Client, err error) {
+	switch authmeth {
+	case "force":
+		client, err = oauthNewClientWithKey(provider, authkey)
+	case "full":
+		client, err = newClientWithKey(provider, authkey)
+		if nil != err {
+			client, err = oauthNewClientWithKey(provider, authkey)
+		}
+	case "device":
+		client, err = deviceauthNewClientWithKey(provider, authkey)
+	case "required":
+		client, err = newClientWithKey(provider, authkey)
+	case "optional":
+		client, err = newClientWithKey(provider, authkey)
+		if nil != err {
+			client, err = provider.NewClient("")
+		}
+	case "none":
+		client, err = provider.NewClient("")
+	case "git":
+		client, err = gitauthNewClientWithUri(provider, uri)
+	default:
+		if strings.HasPrefix(authmeth, "token=") {
+			client, err = provider.NewClient(strings.TrimPrefix(authmeth, "token="))
+		}
+	}
+	if nil == err && 0 < len(authpool) {
+		err = poolNewClientWithKeys(client, authpool)
 	}
+	if nil == err && 0 < len(authmap) {
+		client, err = multiNewClientWithKeys(provider, client, authmap)
+	}
+	return
+}
+
+// mountSpec is a remote and the mountpoint where it is mounted.
+type mountSpec struct {
+	remote   string
+	mntpnt   string
+	uri      *url.URL
+	provider string
+	fs       *hubfs.FileSystem
+}
+
+// serveSpec is a protocol (http, webdav, nfs, 9p) and the address where it is served.
+type serveSpec struct {
+	proto string
+	addr  string
+}
+
+// httpHandler is an http.Handler that serves a file system (e.g. httpfs.Handler).
+type httpHandler interface {
+	http.Handler
+	Close() error
+}
+
+// httpServer is an HTTP server whose handler serves a file system.
+type httpServer struct {
+	http.Server
+	handler httpHandler
+}
+
+func newHTTPServer(handler httpHandler) *httpServer {
+	srv := &httpServer{handler: handler}
+	srv.Server.Handler = handler
+	return srv
+}
 
+// Close shuts down the server and then closes its handler.
+func (srv *httpServer) Close() error {
+	srv.Server.Shutdown(context.Background())
+	return srv.handler.Close()
+}
+
+// mount mounts the file systems of one or more remotes and waits until all of them have
+// been unmounted. Remotes of the same provider share a client and therefore its cache
+// and rate limit. SIGINT and SIGTERM unmount all file systems (the FUSE host handles
+// them); each file system completes its operations in flight before it is destroyed.
+// The file system of the first remote is also served over each of the servers; remotes
+// without a mountpoint are only served over the network. If ctlsock is set, the control
+// API is served over that unix socket. The backend is "fuse" to mount the file systems
+// with FUSE, "projfs" to project them into their mountpoints with ProjFS or "nfs" to
+// mount them read-only over NFS on the loopback interface.
+func mount(mounts []*mountSpec, clients map[string]prov.Client, fsconfig hubfs.Config,
+	config []string, volume *volumeSpec, servers []serveSpec, ctlsock string, backend string) bool {
 	caseins := false
 	if "windows" == runtime.GOOS || "darwin" == runtime.GOOS {
 		caseins = true
 	}
+	if "" != volume.caseins {
+		caseins = "1" == volume.caseins
+	}
 
-	if caseins {
-		client.SetConfig([]string{"config._caseins=1"})
-	} else {
-		client.SetConfig([]string{"config._caseins=0"})
+	for _, client := range clients {
+		if caseins {
+			client.SetConfig([]string{"config._caseins=1"})
+		} else {
+			client.SetConfig([]string{"config._caseins=0"})
+		}
+		client.StartExpiration()
+		defer func(client prov.Client) {
+			// close the repositories that are still open before the cache goes away
+			client.FlushCache()
+			client.StopExpiration()
+		}(client)
 	}
-	client.StartExpiration()
-	defer client.StopExpiration()
 
-	fs := hubfs.New(hubfs.Config{
-		Client:  client,
-		Prefix:  prefix,
-		Caseins: caseins,
-		Overlay: overlay,
-	})
-	host := fuse.NewFileSystemHost(fs)
-	host.SetCapCaseInsensitive(caseins)
-	host.SetCapReaddirPlus(true)
-	return host.Mount(mntpnt, mntopt)
+	newfs := func(m *mountSpec) *hubfs.FileSystem {
+		fsconfig.Prefix = m.uri.Path
+		fsconfig.Provider = m.provider
+		fsconfig.Client = clients[m.provider]
+		fsconfig.Caseins = caseins
+		return hubfs.New(fsconfig)
+	}
+
+	/* the served file systems are read-only and do not need an overlay */
+	overlay := fsconfig.Overlay
+	fsconfig.Overlay = false
+	for _, s := range servers {
+		ln, err := net.Listen("tcp", s.addr)
+		if nil != err {
+			warn("%s error: %v", s.proto, err)
+			return false
+		}
+		if a, ok := ln.Addr().(*net.TCPAddr); !ok || !a.IP.IsLoopback() {
+			/* the servers have no authentication and serve what the auth token can access */
+			warn("warning: %s server at %s is not limited to the loopback interface "+
+				"and has no authentication", s.proto, ln.Addr())
+		}
+		fs := newfs(mounts[0]).FileSystemInterface()
+		var srv interface {
+			Serve(ln net.Listener) error
+			Close() error
+		}
+		switch s.proto {
+		case "http":
+			srv = newHTTPServer(httpfs.New(fs))
+		case "webdav":
+			srv = newHTTPServer(webdavfs.New(fs))
+		case "nfs":
+			srv = nfsfs.New(fs)
+		case "9p":
+			srv = p9fs.New(fs)
+		}
+		go srv.Serve(ln)
+		defer srv.Close()
+		fmt.Printf("%s -%s %s %s\n", progname, s.proto, ln.Addr(), mounts[0].remote)
+	}
+	fsconfig.Overlay = overlay
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if "fuse" != backend {
+		/* there is no FUSE host to handle SIGINT and SIGTERM or .hubfs/unmount */
+		fsconfig.Unmount = cancel
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigc)
+		go func() {
+			select {
+			case <-sigc:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	for _, m := range mounts {
+		if "" != m.mntpnt {
+			m.fs = newfs(m)
+		}
+	}
+
+	if "" != ctlsock {
+		ctl, err := startCtlServer(ctlsock, mounts, clients, servers, fsconfig.Reload)
+		if nil != err {
+			warn("ctl error: %v", err)
+			return false
+		}
+		defer ctl.stop()
+	}
+
+	res := true
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, m := range mounts {
+		if nil == m.fs {
+			continue
+		}
+		wg.Add(1)
+		go func(fs *hubfs.FileSystem, mntpnt string, mntopt []string) {
+			defer wg.Done()
+			var err error
+			switch backend {
+			case "projfs":
+				err = projfs.Mount(ctx, fs.FileSystemInterface(), mntpnt)
+			case "nfs":
+				err = nfsMount(ctx, fs.FileSystemInterface(), mntpnt)
+			default:
+				err = fs.Mount(ctx, mntpnt, mntopt)
+			}
+			if nil != err && "fuse" != backend {
+				warn("%s error: %v", backend, err)
+			}
+			if nil != err {
+				lock.Lock()
+				res = false
+				lock.Unlock()
+			}
+		}(m.fs, m.mntpnt, append(config[:len(config):len(config)], volume.mountOptions(m)...))
+	}
+	wg.Wait()
+
+	if 0 != len(servers) && "" == mounts[0].mntpnt && 1 == len(mounts) {
+		/* only serving over the network: wait for SIGINT or SIGTERM */
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+		<-sigc
+		signal.Stop(sigc)
+	}
+
+	return res
 }
 
 func run() int {
@@ -141,19 +528,85 @@ func run() int {
 
 	debug := false
 	printver := false
+	configfile := ""
 	authmeth := "full"
 	authkey := ""
 	authonly := false
+	authmap := util.Optlist{}
+	authpool := util.Optlist{}
+	mountlist := util.Optlist{}
+	mountrepo := ""
+	mountref := ""
+	bindlist := util.Optlist{}
+	httpaddr := ""
+	webdavaddr := ""
+	nfsaddr := ""
+	p9addr := ""
+	ctlsock := ""
+	projection := false
+	nfsmount := false
+	daemon := false
+	pidfile := ""
+	logfile := ""
+	logsize := util.Size(0)
+	auditfile := ""
+	loglevel := "warn"
+	logformat := "text"
 	readonly := false
+	rofs := false
+	ignoreattr := false
+	fmask := uint32(022)
+	dmask := uint32(022)
 	fullrefs := false
+	nestedrefs := false
+	pullrefs := false
+	latest := false
+	submodules := false
+	unorm := ""
+	treetime := "commit"
+	info := false
+	releases := false
+	issues := false
+	pulls := false
+	diff := false
+	objects := false
+	actions := false
+	wiki := false
+	gists := false
+	starred := false
+	collab := false
+	search := false
+	notifications := false
+	logfiles := false
+	archive := false
+	blame := false
+	history := 0
+	lfs := false
+	mirror := false
+	partial := false
+	forks := true
+	archived := true
+	cachequota := util.Size(0)
+	cachecrypt := false
+	timeout := 10 * time.Minute
+	otlpurl := ""
+	transport := util.Optlist{}
+	slowlog := time.Duration(0)
 	filter := util.Optlist{}
+	pins := util.Optlist{}
+	cachepins := util.Optlist{}
+	refpatts := util.Optlist{}
+	denypatts := util.Optlist{}
 	mntopt := util.Optlist{}
+	volopt := util.Optlist{}
 	remote := "github.com"
 	mntpnt := ""
 	config := []string{"config.dir=:"}
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [options] [remote] mountpoint\n\n", progname)
+		fmt.Fprintf(os.Stderr, "usage: %s [options] [remote] mountpoint\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s umount mountpoint...\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s ctl socket command [args]\n\n", progname)
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nremotes:\n")
 		for _, n := range prov.GetProviderClassNames() {
@@ -163,10 +616,13 @@ func run() int {
 
 	flag.BoolVar(&debug, "d", debug, "debug output")
 	flag.BoolVar(&printver, "version", printver, "print version information")
+	flag.StringVar(&configfile, "config", configfile,
+		"configuration `file` with default options (default: "+defaultConfigFile()+")")
 	flag.StringVar(&authmeth, "auth", "",
 		"`method` is from list below; auth tokens are stored in system keyring\n"+
 			"- force     perform interactive auth even if token present\n"+
 			"- full      perform interactive auth if token not present (default)\n"+
+			"- device    perform device flow auth; do not open browser (GitHub only)\n"+
 			"- required  auth token required to be present\n"+
 			"- optional  auth token will be used if present\n"+
 			"- none      do not use auth token even if present\n"+
@@ -174,14 +630,138 @@ func run() int {
 			"- token=T   use specified auth token T; do not use system keyring")
 	flag.StringVar(&authkey, "authkey", authkey, "`name` of key that stores auth token in system keyring")
 	flag.BoolVar(&authonly, "authonly", authonly, "perform auth only; do not mount")
+	flag.Var(&authmap, "authmap",
+		"list of `rules` that select the auth token by owner\n"+
+			"- list form: rule1,rule2,...\n"+
+			"- rule form: owner=name (name of key that stores auth token)\n"+
+			"- rule owner can use wildcards for pattern matching")
+	flag.Var(&authpool, "authpool",
+		"list of additional auth `tokens` to use when the rate limit is exhausted\n"+
+			"- list form: token1,token2,...\n"+
+			"- token form: name (name of key that stores auth token) or token=T")
+	flag.Var(&mountlist, "mount",
+		"additional `remote=mountpoint` to mount in the same process (may be repeated)")
+	flag.StringVar(&mountrepo, "mount-repo", mountrepo,
+		"mount a single repository `owner/repo` at the mount root")
+	flag.StringVar(&mountref, "mount-ref", mountref,
+		"mount the tree of a single ref `owner/repo/ref` (or a directory in it) at the mount root")
+	flag.Var(&bindlist, "bind",
+		"present `name=owner/repo/ref[/path]` as a directory at the mount root (may be repeated)")
+	flag.StringVar(&httpaddr, "http", httpaddr,
+		"serve the file system over HTTP at `address` (e.g. localhost:8080); the mountpoint is optional")
+	flag.StringVar(&webdavaddr, "webdav", webdavaddr,
+		"serve the file system read-only over WebDAV at `address`; the mountpoint is optional")
+	flag.StringVar(&nfsaddr, "nfs", nfsaddr,
+		"serve the file system read-only over NFSv3 at `address` (e.g. localhost:2049); the mountpoint is optional")
+	flag.StringVar(&p9addr, "9p", p9addr,
+		"serve the file system read-only over 9P2000.L at `address` (e.g. localhost:564); the mountpoint is optional")
+	flag.StringVar(&ctlsock, "ctl", ctlsock,
+		"unix `socket` that serves the control API (see \"hubfs ctl\")")
+	flag.BoolVar(&daemon, "daemon", daemon,
+		"run in the background once mounted; remount if the file system fails")
+	flag.StringVar(&pidfile, "pidfile", pidfile, "`file` that stores the process id of -daemon")
+	flag.StringVar(&logfile, "logfile", logfile, "log `file` (syslog to use the system log)")
+	flag.Var(&logsize, "logsize", "log file `size` that causes the log file to be rotated (e.g. 10M)")
+	flag.StringVar(&auditfile, "audit", auditfile,
+		"append a record of every file read and directory listed to the audit log `file`")
+	flag.StringVar(&loglevel, "loglevel", loglevel,
+		"log level `spec` of the form level,module=level,...\n"+
+			"- level is one of error, warn, info, debug\n"+
+			"- module is one of main, prov, git, fs/hubfs and can use wildcards")
+	flag.StringVar(&logformat, "logformat", logformat, "log `format` (text, logfmt, json)")
 	flag.BoolVar(&readonly, "readonly", readonly, "read only file system")
 	flag.BoolVar(&fullrefs, "fullrefs", fullrefs, "full format refs (refs+heads+master instead of master)")
+	flag.BoolVar(&nestedrefs, "nestedrefs", nestedrefs,
+		"nested format refs (heads/release/1.x instead of release+1.x)")
+	flag.BoolVar(&pullrefs, "pullrefs", pullrefs, "pull request refs (pr-123 for refs/pull/123/head)")
+	flag.BoolVar(&latest, "latest", latest, "@latest symlink to the highest semantic version tag")
+	flag.BoolVar(&lfs, "lfs", lfs, "resolve git LFS pointers to their content")
+	flag.BoolVar(&mirror, "mirror", mirror,
+		"keep a bare mirror of accessed repositories in the cache directory and read trees and blobs from it")
+	flag.BoolVar(&partial, "partial", partial,
+		"fetch the trees of a ref in a single pack when it is first listed")
+	flag.BoolVar(&submodules, "submodules", submodules, "present submodules as directories instead of symlinks")
+	flag.StringVar(&treetime, "treetime", treetime,
+		"`source` of file times (commit: committer date, author: author date, mount: mount time)")
+	flag.StringVar(&unorm, "unorm", unorm,
+		"list file names in unicode normalization `form` (nfc, nfd) and look them up in either form")
+	flag.BoolVar(&info, "info", info, "@info.json file with repository metadata")
+	flag.BoolVar(&releases, "releases", releases, "@releases directory with release notes and assets")
+	flag.BoolVar(&issues, "issues", issues, "@issues directory with issues as markdown files")
+	flag.BoolVar(&pulls, "pulls", pulls, "@pulls directory with pull request descriptions and patches")
+	flag.BoolVar(&diff, "diff", diff, "@diff directory with the changes between refs")
+	flag.BoolVar(&objects, "objects", objects, "@objects directory with git objects by hash")
+	flag.BoolVar(&actions, "actions", actions, "@actions directory with workflow runs (GitHub only)")
+	flag.BoolVar(&wiki, "wiki", wiki, "@wiki directory with the repository wiki")
+	flag.BoolVar(&gists, "gists", gists, "@gists directory with user gists (GitHub only)")
+	flag.BoolVar(&starred, "starred", starred, "@starred directory with links to starred repositories")
+	flag.BoolVar(&collab, "collab", collab,
+		"@collab directory with links to repositories of others that you collaborate on")
+	flag.BoolVar(&search, "search", search, "@search directory with repository search results")
+	flag.BoolVar(&notifications, "notifications", notifications,
+		"@notifications directory with unread notifications")
+	flag.BoolVar(&logfiles, "log", logfiles, "@log and @log.json files with the commit history of a ref")
+	flag.BoolVar(&archive, "archive", archive, "@archive directory with tar.gz and zip archives of a ref")
+	flag.BoolVar(&blame, "blame", blame, "@blame directory with the blame of every file of a ref")
+	flag.IntVar(&history, "history", history,
+		"@history directory with the `count` most recent commits of the default branch (0 to disable)")
+	flag.Var(&cachequota, "cachequota", "cache `size` reported as file system capacity (e.g. 10G)")
+	flag.BoolVar(&cachecrypt, "cachecrypt", cachecrypt,
+		"encrypt the cache directory with a key that is kept in the system keyring")
+	flag.DurationVar(&timeout, "timeout", timeout,
+		"timeout for a single file system operation (0 to disable)")
+	flag.DurationVar(&slowlog, "slowlog", slowlog,
+		"log operations and API requests that take longer than `duration` (0 to disable)")
+	flag.StringVar(&otlpurl, "otlp", otlpurl,
+		"OpenTelemetry collector `URL` that receives traces of operations and API requests\n"+
+			"(default: $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.Var(&transport, "transport",
+		"list of HTTP transport `options` (may be repeated)\n"+
+			"- list form: opt1,opt2,...\n"+
+			"- proxy=URL (http, https or socks5; direct for none; default: $HTTPS_PROXY)\n"+
+			"- cacert=FILE (additional CA certificates), cert=FILE,key=FILE (client certificate)\n"+
+			"- maxconns=N, idletimeout=DURATION, keepalive=DURATION, http2=BOOL\n"+
+			"- maxrequests=N, maxdownloads=N (concurrent API requests and downloads per host)")
+	flag.Var(&volopt, "volume",
+		"list of volume `options` (may be repeated; Windows and macOS)\n"+
+			"- list form: opt1,opt2,...\n"+
+			"- label=NAME (volume label; {name} is replaced by the owner or repository name)\n"+
+			"- caseins=BOOL (case-insensitive file names; default: true on Windows and macOS)\n"+
+			"- icon=FILE (volume icon; macOS only)\n"+
+			"- noindex=BOOL (ask Spotlight not to index the volume)\n"+
+			"- noappledouble=BOOL (do not look up .DS_Store and ._* files through the API)\n"+
+			"- sectorsize=N, allocunit=N (sectors per allocation unit), infotimeout=MS (Windows only)")
+	flag.BoolVar(&forks, "forks", forks, "show forked repositories (-forks=false to hide)")
+	flag.BoolVar(&archived, "archived", archived, "show archived repositories (-archived=false to hide)")
 	flag.Var(&filter, "filter",
 		"list of `rules` that determine repo availability\n"+
 			"- list form: rule1,rule2,...\n"+
 			"- rule form: [+-]owner or [+-]owner/repo\n"+
 			"- rule is include (+) or exclude (-) (default: include)\n"+
 			"- rule owner/repo can use wildcards for pattern matching")
+	flag.Var(&refpatts, "refs",
+		"list of ref `patterns` that determine ref availability\n"+
+			"- list form: patt1,patt2,...\n"+
+			"- pattern form: [+-]pattern (matched against branch or tag name)\n"+
+			"- pattern is include (+) or exclude (-) (default: include)\n"+
+			"- pattern can use wildcards for pattern matching")
+	flag.Var(&denypatts, "deny",
+		"list of path `patterns` of files that cannot be read (EACCES)\n"+
+			"- list form: patt1,patt2,...\n"+
+			"- pattern is matched against owner/repo/ref/path\n"+
+			"- pattern can use wildcards; ** matches any number of path components")
+	flag.Var(&pins, "pin",
+		"list of `pins` that freeze refs to commits for the life of the mount\n"+
+			"- list form: pin1,pin2,...\n"+
+			"- pin form: owner/repo/ref=hash (full commit hash)")
+	flag.Var(&cachepins, "cachepin",
+		"list of repositories or refs whose cached content is kept\n"+
+			"- list form: pin1,pin2,...\n"+
+			"- pin form: owner/repo or owner/repo/ref")
+	flag.BoolVar(&projection, "projfs", projection,
+		"project into a directory with ProjFS instead of mounting with FUSE (Windows only)")
+	flag.BoolVar(&nfsmount, "nfsmount", nfsmount,
+		"mount read-only over NFS on the loopback interface instead of with FUSE (macOS and Linux)")
 	flag.Var(&mntopt, "o", "FUSE mount `options`\n(default: "+strings.Join(default_mntopt, ",")+")")
 
 	util.InvokeEvent("main.Flagvar", nil)
@@ -203,6 +783,40 @@ func run() int {
 		return 0
 	}
 
+	cmdline := cmdlineFlags()
+	cmdfilter := append([]string{}, filter...)
+	required := "" != configfile
+	if !required {
+		configfile = defaultConfigFile()
+	}
+	if "" != configfile {
+		err := configure(configfile, required, &remote, &mntpnt)
+		if nil != err {
+			warn("config error: %v", err)
+			return 2
+		}
+	}
+
+	mntopt, err := applyMountOptions(mntopt)
+	if nil != err {
+		warn("config error: %v", err)
+		return 2
+	}
+
+	servers := []serveSpec{}
+	if "" != httpaddr {
+		servers = append(servers, serveSpec{"http", httpaddr})
+	}
+	if "" != webdavaddr {
+		servers = append(servers, serveSpec{"webdav", webdavaddr})
+	}
+	if "" != nfsaddr {
+		servers = append(servers, serveSpec{"nfs", nfsaddr})
+	}
+	if "" != p9addr {
+		servers = append(servers, serveSpec{"9p", p9addr})
+	}
+
 	switch flag.NArg() {
 	case 1:
 		mntpnt = flag.Arg(0)
@@ -210,15 +824,67 @@ func run() int {
 		remote = flag.Arg(0)
 		mntpnt = flag.Arg(1)
 	default:
-		if !authonly {
+		if !authonly && (0 != flag.NArg() || ("" == mntpnt && 0 == len(mountlist) && 0 == len(servers))) {
 			flag.Usage()
 			return 2
 		}
 	}
+	if (fullrefs && nestedrefs) || (daemon && "" == mntpnt && 0 == len(mountlist)) {
+		flag.Usage()
+		return 2
+	}
+
+	/* -mount-repo and -mount-ref set the prefix of the remotes */
+	mountpath := ""
+	switch {
+	case "" != mountrepo && "" != mountref:
+		flag.Usage()
+		return 2
+	case "" != mountrepo:
+		mountpath = strings.Trim(mountrepo, "/")
+		if 2 != len(strings.Split(mountpath, "/")) {
+			warn("invalid repository: %s (expected owner/repo)", mountrepo)
+			return 2
+		}
+	case "" != mountref:
+		mountpath = strings.Trim(mountref, "/")
+		if 3 > len(strings.Split(mountpath, "/")) {
+			warn("invalid ref: %s (expected owner/repo/ref or owner/repo/ref/path)", mountref)
+			return 2
+		}
+		if nestedrefs {
+			warn("-mount-ref cannot be used with -nestedrefs")
+			return 2
+		}
+	}
+
+	/* -bind presents prefixes relative to the remote path as directories at the mount root */
+	var binds []hubfs.Bind
+	for _, b := range bindlist {
+		i := strings.IndexByte(b, '=')
+		if -1 == i {
+			warn("invalid bind: %s (expected name=owner/repo/ref)", b)
+			return 2
+		}
+		name, target := b[:i], strings.Trim(b[i+1:], "/")
+		if "" == name || "." == name || ".." == name || strings.ContainsAny(name, "/\\") ||
+			strings.EqualFold(".hubfs", name) || "" == target {
+			warn("invalid bind: %s (expected name=owner/repo/ref)", b)
+			return 2
+		}
+		for _, e := range binds {
+			if strings.EqualFold(e.Name, name) {
+				warn("duplicate bind: %s", name)
+				return 2
+			}
+		}
+		binds = append(binds, hubfs.Bind{Name: name, Prefix: "/" + target})
+	}
+
 	switch authmeth {
 	case "":
 		authmeth = "full"
-	case "force", "full", "required", "optional", "git":
+	case "force", "full", "device", "required", "optional", "git":
 	case "none":
 		if authonly {
 			flag.Usage()
@@ -232,72 +898,186 @@ func run() int {
 		return 2
 	}
 
-	if debug {
-		libtrace.Verbose = true
-		libtrace.Pattern = "*,github.com/winfsp/hubfs/*,github.com/winfsp/hubfs/fs/*"
+	err = util.SetLogFormat(logformat)
+	if nil == err {
+		err = util.SetLogLevel(logLevelSpec(debug, loglevel))
+	}
+	if nil != err {
+		warn("config error: %v", err)
+		return 2
+	}
+	util.SetSlowThreshold(slowlog)
+	if "" != logfile && !daemon {
+		w, err := openLogFile(logfile, int64(logsize))
+		if nil != err {
+			warn("logfile error: %v", err)
+			return 1
+		}
+		util.SetLogOutput(w)
 	}
 
-	util.InvokeEvent("main.Flagrun", nil)
+	err = httputil.Configure(transport)
+	if nil != err {
+		warn("config error: %v", err)
+		return 2
+	}
 
-	uri, err := url.Parse(remote)
-	if nil != uri && "" == uri.Scheme {
-		uri, err = url.Parse("https://" + remote)
+	volume, err := parseVolume(volopt)
+	if nil != err {
+		warn("config error: %v", err)
+		return 2
 	}
+
+	unormfn, err := util.Unorm(unorm)
 	if nil != err {
-		warn("invalid remote: %s", remote)
-		return 1
+		warn("config error: %v", err)
+		return 2
+	}
+
+	switch treetime {
+	case "commit", "author", "mount":
+	default:
+		warn("config error: invalid -treetime: %s", treetime)
+		return 2
 	}
 
-	provider := prov.NewProviderInstance(uri)
-	if nil == provider {
-		warn("unknown provider: %s", prov.GetProviderInstanceName(uri))
-		return 1
+	var deny []string
+	for _, d := range denypatts {
+		for _, s := range strings.Split(d, ",") {
+			if _, err := path.Match(s, ""); nil != err {
+				warn("config error: invalid -deny pattern: %s", s)
+				return 2
+			}
+			deny = append(deny, s)
+		}
 	}
 
-	if "" == authkey {
-		authkey = prov.GetProviderInstanceName(uri)
+	if cachecrypt && mirror {
+		warn("config error: -mirror cannot be used with -cachecrypt")
+		return 2
 	}
 
-	var client prov.Client
-	switch authmeth {
-	case "force":
-		client, err = oauthNewClientWithKey(provider, authkey)
-	case "full":
-		client, err = newClientWithKey(provider, authkey)
+	if cachecrypt {
+		err = setCacheKey()
 		if nil != err {
-			client, err = oauthNewClientWithKey(provider, authkey)
+			warn("cachecrypt error: %v", err)
+			return 1
 		}
-	case "required":
-		client, err = newClientWithKey(provider, authkey)
-	case "optional":
-		client, err = newClientWithKey(provider, authkey)
-		if nil != err {
-			client, err = provider.NewClient("")
+	}
+
+	util.InvokeEvent("main.Flagrun", nil)
+
+	mounts := []*mountSpec{}
+	if "" != mntpnt {
+		mounts = append(mounts, &mountSpec{remote: remote, mntpnt: mntpnt})
+	}
+	for _, m := range mountlist {
+		i := strings.IndexByte(m, '=')
+		if -1 == i {
+			warn("invalid mount: %s", m)
+			return 2
 		}
-	case "none":
-		client, err = provider.NewClient("")
-	case "git":
-		client, err = gitauthNewClientWithUri(provider, uri)
-	default:
-		if strings.HasPrefix(authmeth, "token=") {
-			client, err = provider.NewClient(strings.TrimPrefix(authmeth, "token="))
+		mounts = append(mounts, &mountSpec{remote: m[:i], mntpnt: m[i+1:]})
+	}
+	if 0 == len(mounts) {
+		mounts = append(mounts, &mountSpec{remote: remote})
+	}
+
+	if daemon && "supervisor" == daemonRole() {
+		mntpnts := []string{}
+		for _, m := range mounts {
+			mntpnts = append(mntpnts, m.mntpnt)
 		}
+		return supervise(mntpnts, pidfile, logfile, int64(logsize))
 	}
-	if nil != err {
-		warn("client error: %v", err)
-		return 1
+
+	clients := make(map[string]prov.Client)
+	for _, m := range mounts {
+		var err error
+		alias := false
+		m.uri, err = url.Parse(m.remote)
+		if nil != m.uri && "" == m.uri.Scheme {
+			/* aliases only apply without a scheme; https://github/ is a host named github */
+			m.uri, err = url.Parse("https://" + m.remote)
+			alias = true
+		}
+		if nil != err {
+			warn("invalid remote: %s", m.remote)
+			return 1
+		}
+		if alias {
+			m.uri.Host = prov.ResolveProviderAlias(m.uri.Host)
+		}
+		if "" != mountpath {
+			if "" != strings.Trim(m.uri.Path, "/") {
+				warn("remote %s cannot be used with -mount-repo or -mount-ref", m.remote)
+				return 2
+			}
+			m.uri.Path = "/" + mountpath
+			m.remote = strings.TrimSuffix(m.remote, "/") + "/" + mountpath
+		}
+
+		m.provider = prov.GetProviderInstanceName(m.uri)
+		if _, ok := clients[m.provider]; ok {
+			continue
+		}
+
+		provider := prov.NewProviderInstance(m.uri)
+		if nil == provider {
+			warn("unknown provider: %s", m.provider)
+			return 1
+		}
+
+		key := authkey
+		if "" == key {
+			key = m.provider
+		}
+
+		pool := []string(nil)
+		if mounts[0] == m {
+			pool = authpool
+		}
+		client, err := newClient(provider, m.uri, authmeth, key, authmap, pool)
+		if nil != err {
+			warn("client error: %v", err)
+			if errors.Is(err, prov.ErrAuth) {
+				warn("use -auth force to authorize again or -auth none to access public repositories")
+			}
+			return 1
+		}
+		clients[m.provider] = client
 	}
 
 	if !authonly {
+		if daemon && "" == daemonRole() {
+			mntpnts := []string{}
+			for _, m := range mounts {
+				mntpnts = append(mntpnts, m.mntpnt)
+			}
+			err := daemonize(mntpnts)
+			if nil != err {
+				warn("daemon error: %v", err)
+				return 1
+			}
+			return 0
+		}
+
 		if 0 == len(mntopt) {
 			mntopt = default_mntopt
 		}
-		fmt.Printf("%s -o %s %s %s\n", progname, strings.Join(mntopt, ","), remote, mntpnt)
+		for _, m := range mounts {
+			if "" != m.mntpnt {
+				fmt.Printf("%s -o %s %s %s\n", progname, strings.Join(mntopt, ","), m.remote, m.mntpnt)
+			}
+		}
 
 		if debug {
 			mntopt = append(mntopt, "debug")
 		}
 
+		/* files are owned by the user/group that launches the file system unless uid/gid are set */
+		fsuid, fsgid := uint32(os.Getuid()), uint32(os.Getgid())
+		allowother, defperms := false, false
 		for _, m := range mntopt {
 			for _, s := range strings.Split(m, ",") {
 				if "windows" != runtime.GOOS {
@@ -310,29 +1090,191 @@ func run() int {
 						s = "gid=" + u.Gid
 					}
 				}
+				switch {
+				case "ro" == s:
+					/* -o ro: fail all writes, including writes to the control files */
+					rofs = true
+				case "ignoreattr" == s:
+					/* -o ignoreattr: changes of times, owner and flags succeed but do nothing */
+					ignoreattr = true
+					continue
+				case strings.HasPrefix(s, "fmask="), strings.HasPrefix(s, "dmask="):
+					/* -o fmask=M,dmask=M: handled by hubfs rather than FUSE */
+					m, err := strconv.ParseUint(s[len("fmask="):], 8, 32)
+					if nil != err || 0 != m&^0777 {
+						warn("config error: invalid mount option %s", s)
+						return 1
+					}
+					if 'f' == s[0] {
+						fmask = uint32(m)
+					} else {
+						dmask = uint32(m)
+					}
+					continue
+				case strings.HasPrefix(s, "uid="), strings.HasPrefix(s, "gid="):
+					if n, err := strconv.ParseUint(s[len("uid="):], 10, 32); nil == err {
+						if 'u' == s[0] {
+							fsuid = uint32(n)
+						} else {
+							fsgid = uint32(n)
+						}
+					}
+				case "allow_other" == s:
+					allowother = true
+				case "default_permissions" == s:
+					defperms = true
+				}
 				config = append(config, s)
 			}
 		}
+		if allowother && !defperms && "windows" != runtime.GOOS {
+			/* other users may access the file system: have the kernel check permissions */
+			config = append(config, "default_permissions")
+		}
 
 		if fullrefs {
 			config = append(config, "config._fullrefs=1")
 		}
+		if nestedrefs {
+			config = append(config, "config._nestedrefs=1")
+		}
+		if pullrefs {
+			config = append(config, "config._pullrefs=1")
+		}
+		if lfs {
+			config = append(config, "config._lfs=1")
+		}
+		if mirror {
+			config = append(config, "config._mirror=1")
+		}
+		if partial {
+			config = append(config, "config._partial=1")
+		}
+		if "commit" != treetime {
+			config = append(config, "config._treetime="+treetime)
+		}
+		if !forks {
+			config = append(config, "config._forks=0")
+		}
+		if !archived {
+			config = append(config, "config._archived=0")
+		}
 
 		for _, f := range filter {
 			for _, s := range strings.Split(f, ",") {
 				config = append(config, "config._filter="+s)
 			}
 		}
+		for _, r := range refpatts {
+			for _, s := range strings.Split(r, ",") {
+				config = append(config, "config._refs="+s)
+			}
+		}
+		for _, p := range pins {
+			for _, s := range strings.Split(p, ",") {
+				config = append(config, "config._pin="+s)
+			}
+		}
+		for _, p := range cachepins {
+			for _, s := range strings.Split(p, ",") {
+				config = append(config, "config._cachepin="+s)
+			}
+		}
 
-		config, err = client.SetConfig(config)
-		if nil != err {
-			warn("config error: %v", err)
-			return 1
+		/* the options that no client consumes are mount options; all clients must agree */
+		var mntconfig []string
+		seen := make(map[string]bool)
+		for _, m := range mounts {
+			if seen[m.provider] {
+				continue
+			}
+			rest, err := clients[m.provider].SetConfig(config)
+			if nil != err {
+				warn("config error: %v", err)
+				return 1
+			}
+			if 0 == len(seen) {
+				mntconfig = rest
+			} else if strings.Join(rest, ",") != strings.Join(mntconfig, ",") {
+				warn("config error: options are not recognized by all providers: %s and %s",
+					strings.Join(mntconfig, ","), strings.Join(rest, ","))
+				return 2
+			}
+			seen[m.provider] = true
+		}
+
+		if "" == otlpurl {
+			otlpurl = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+		if "" != otlpurl {
+			otlp.Init(otlpurl, progname)
+			defer otlp.Shutdown()
+		}
+
+		var audit io.Writer
+		if "" != auditfile {
+			f, err := os.OpenFile(auditfile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if nil != err {
+				warn("audit error: %v", err)
+				return 1
+			}
+			defer f.Close()
+			audit = f
 		}
 
 		port.Umask(0)
 
-		if !mount(client, !readonly, uri.Path, mntpnt, config) {
+		reloader := newReloader(configfile, required, cmdline, cmdfilter,
+			authmeth, authkey, int64(cachequota), debug, loglevel, clients)
+		reloader.notify()
+		defer reloader.stop()
+
+		fsconfig := hubfs.Config{
+			Overlay:       !readonly,
+			Readonly:      rofs,
+			Ignoreattr:    ignoreattr,
+			Nestedrefs:    nestedrefs,
+			Latest:        latest,
+			Submodules:    submodules,
+			Unorm:         unormfn,
+			Treetime:      treetime,
+			Noindex:       volume.noindex,
+			Noappledouble: volume.noappledouble,
+			Info:          info,
+			Releases:      releases,
+			Issues:        issues,
+			Pulls:         pulls,
+			Diff:          diff,
+			Objects:       objects,
+			Actions:       actions,
+			Wiki:          wiki,
+			Gists:         gists,
+			Starred:       starred,
+			Collab:        collab,
+			Search:        search,
+			Notifications: notifications,
+			Log:           logfiles,
+			Archive:       archive,
+			Blame:         blame,
+			History:       history,
+			Uid:           fsuid,
+			Gid:           fsgid,
+			Fmask:         fmask,
+			Dmask:         dmask,
+			CacheQuota:    reloader.getCacheQuota,
+			Timeout:       timeout,
+			Audit:         audit,
+			Deny:          deny,
+			Binds:         binds,
+			Reload:        reloader.reload,
+		}
+		backend := "fuse"
+		if projection {
+			backend = "projfs"
+		} else if nfsmount {
+			backend = "nfs"
+		}
+		if !mount(mounts, clients, fsconfig, mntconfig, volume, servers, ctlsock, backend) {
 			return 1
 		}
 	}
@@ -341,6 +1283,18 @@ func run() int {
 }
 
 func main() {
+	if isUmount() {
+		os.Exit(umount(os.Args[2:]))
+	}
+	if isCtl() {
+		os.Exit(ctl(os.Args[2:]))
+	}
+	if isCache() {
+		os.Exit(cache(os.Args[2:]))
+	}
+	if isMountHelper() {
+		os.Exit(mountHelper(os.Args[1:]))
+	}
 	ec := run()
 	os.Exit(ec)
 }
@@ -0,0 +1,166 @@
+/*
+ * mounthelper.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/winfsp/hubfs/util"
+)
+
+// Mount options that are meant for mount(8) or systemd and must not be passed to FUSE.
+var ignoredMountOptions = map[string]bool{
+	"defaults": true,
+	"auto":     true,
+	"noauto":   true,
+	"user":     true,
+	"nouser":   true,
+	"users":    true,
+	"_netdev":  true,
+	"nofail":   true,
+}
+
+// isMountHelper determines if the program was invoked as a mount helper: either as
+// mount.hubfs or mount.fuse.hubfs, or by mount.fuse, which passes the options after the
+// remote and mountpoint.
+func isMountHelper() bool {
+	if "mount.hubfs" == progname || "mount.fuse.hubfs" == progname {
+		return true
+	}
+	return 3 < len(os.Args) &&
+		!strings.HasPrefix(os.Args[1], "-") &&
+		!strings.HasPrefix(os.Args[2], "-") &&
+		strings.HasPrefix(os.Args[3], "-")
+}
+
+// mountHelper implements the mount helper protocol used by mount(8) and autofs:
+//
+//	mount.hubfs remote mountpoint [-fnsv] [-o options]
+//
+// The remote may be written as hubfs#remote (as in fstab entries of type fuse). The file
+// system is started in the background and mountHelper returns once it has been mounted,
+// unless -f is specified.
+func mountHelper(args []string) int {
+	remote, mntpnt := "", ""
+	foreground := false
+	options := []string{}
+	for i := 0; len(args) > i; i++ {
+		a := args[i]
+		switch {
+		case "-o" == a && len(args) > i+1:
+			i++
+			options = append(options, strings.Split(args[i], ",")...)
+		case strings.HasPrefix(a, "-o"):
+			options = append(options, strings.Split(a[2:], ",")...)
+		case "-t" == a:
+			i++
+		case strings.HasPrefix(a, "-") && 1 < len(a):
+			foreground = foreground || strings.ContainsRune(a, 'f')
+		case "" == remote:
+			remote = strings.TrimPrefix(a, "hubfs#")
+		case "" == mntpnt:
+			mntpnt = a
+		default:
+			warn("invalid argument: %s", a)
+			return 2
+		}
+	}
+	if "" == remote || "" == mntpnt {
+		fmt.Fprintf(os.Stderr, "usage: %s remote mountpoint [-f] [-o options]\n", progname)
+		return 2
+	}
+
+	/* interactive auth is not possible; use the token from the keyring if present */
+	auth := "-auth=optional"
+	mntopt := []string{}
+	for _, s := range options {
+		n := s
+		if i := strings.IndexByte(s, '='); -1 != i {
+			n = s[:i]
+		}
+		if "auth" == n || "token" == n {
+			auth = ""
+		}
+		if "" != n && !ignoredMountOptions[n] && !strings.HasPrefix(n, "x-") && "comment" != n {
+			mntopt = append(mntopt, s)
+		}
+	}
+	mntopt = append(mntopt, "fsname=hubfs#"+remote)
+	if "linux" == runtime.GOOS {
+		mntopt = append(mntopt, "subtype=hubfs")
+	}
+
+	hargs := []string{"-o", strings.Join(mntopt, ","), remote, mntpnt}
+	if "" != auth {
+		hargs = append([]string{auth}, hargs...)
+	}
+	if foreground {
+		os.Args = append([]string{os.Args[0]}, hargs...)
+		return run()
+	}
+
+	err := startDetached(hargs, nil, []string{mntpnt})
+	if nil != err {
+		warn("%v", err)
+		return 1
+	}
+	return 0
+}
+
+// applyMountOptions sets the command line options that are specified as mount options
+// (e.g. -o releases,filter=winfsp) and returns the remaining mount options. The mount
+// option token=T is the same as -auth token=T and cache=DIR is the same as the mount
+// option config.dir=DIR.
+func applyMountOptions(mntopt util.Optlist) (util.Optlist, error) {
+	res := util.Optlist{}
+	for _, m := range mntopt {
+		for _, s := range strings.Split(m, ",") {
+			n, v, hasv := s, "", false
+			if i := strings.IndexByte(s, '='); -1 != i {
+				n, v, hasv = s[:i], s[i+1:], true
+			}
+			switch n {
+			case "token":
+				n, v, hasv = "auth", s, true
+			case "cache":
+				res = append(res, "config.dir="+v)
+				continue
+			case "o", "mount", "config", "authonly", "version":
+				res = append(res, s)
+				continue
+			}
+			f := flag.Lookup(n)
+			if nil == f {
+				res = append(res, s)
+				continue
+			}
+			if !hasv {
+				if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+					return nil, errors.New("option requires a value: " + n)
+				}
+				v = "true"
+			}
+			err := f.Value.Set(v)
+			if nil != err {
+				return nil, errors.New(fmt.Sprintf("option %s: %v", n, err))
+			}
+		}
+	}
+	return res, nil
+}
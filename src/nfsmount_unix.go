@@ -0,0 +1,71 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * nfsmount_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/nfsfs"
+)
+
+// nfsMount serves the file system over NFS on the loopback interface and mounts it at
+// mntpnt with the system NFS client; it waits until ctx is done and then unmounts it.
+// This does not need a FUSE library or kernel extension, but the mount is read-only.
+func nfsMount(ctx context.Context, fs fuse.FileSystemInterface, mntpnt string) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		return err
+	}
+	srv := nfsfs.New(fs)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	var cmd *exec.Cmd
+	if "darwin" == runtime.GOOS {
+		cmd = exec.Command("/sbin/mount_nfs", "-o",
+			fmt.Sprintf("vers=3,tcp,port=%d,mountport=%d,noresvport,locallocks,rdonly,nobrowse",
+				port, port),
+			"127.0.0.1:/", mntpnt)
+	} else {
+		cmd = exec.Command("mount", "-t", "nfs", "-o",
+			fmt.Sprintf("vers=3,proto=tcp,port=%d,mountport=%d,mountproto=tcp,nolock,ro",
+				port, port),
+			"127.0.0.1:/", mntpnt)
+	}
+	if out, err := cmd.CombinedOutput(); nil != err {
+		return fmt.Errorf("cannot mount NFS file system at %s: %v: %s",
+			mntpnt, err, strings.TrimSpace(string(out)))
+	}
+
+	<-ctx.Done()
+
+	if nil != exec.Command("umount", mntpnt).Run() {
+		/* the file system is busy; the server goes away anyway */
+		if out, err := exec.Command("umount", "-f", mntpnt).CombinedOutput(); nil != err {
+			return fmt.Errorf("cannot unmount NFS file system at %s: %v: %s",
+				mntpnt, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+/*
+ * nfsmount_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+func nfsMount(ctx context.Context, fs fuse.FileSystemInterface, mntpnt string) error {
+	return errors.New("mounting over NFS is not supported on Windows")
+}
@@ -0,0 +1,249 @@
+/*
+ * otlp.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package otlp records spans of operations and exports them to an OpenTelemetry
+// collector using OTLP over HTTP with JSON encoding.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Span kinds.
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+const (
+	batchSize     = 512
+	queueSize     = 4096
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// Span is an operation in progress. The methods of a nil Span do nothing, which is what
+// Start returns when tracing is not enabled.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []interface{}
+	err      error
+}
+
+type spanKey struct{}
+
+type exporter struct {
+	url     string
+	service string
+	client  *http.Client
+	spanc   chan *Span
+	stopc   chan struct{}
+	done    chan struct{}
+}
+
+// exp is set by Init before any spans are started.
+var exp *exporter
+
+// Init enables tracing and starts exporting spans to the collector at endpoint. The
+// endpoint is the base URL of the collector (e.g. http://localhost:4318) or the full URL
+// of its traces resource.
+func Init(endpoint string, service string) {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	exp = &exporter{
+		url:     url,
+		service: service,
+		client:  &http.Client{Timeout: exportTimeout},
+		spanc:   make(chan *Span, queueSize),
+		stopc:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go exp.run()
+}
+
+// Shutdown exports the remaining spans. Spans that end after Shutdown are not exported.
+func Shutdown() {
+	if nil == exp {
+		return
+	}
+	close(exp.stopc)
+	select {
+	case <-exp.done:
+	case <-time.After(exportTimeout):
+	}
+}
+
+// Start starts a span that is a child of the span in ctx, if any. The attrs are pairs of
+// attribute names and values.
+func Start(ctx context.Context, name string, kind int, attrs ...interface{}) (
+	context.Context, *Span) {
+	if nil == exp {
+		return ctx, nil
+	}
+	s := &Span{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: attrs,
+	}
+	if p, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.traceID = p.traceID
+		s.parentID = p.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttr adds an attribute to the span.
+func (s *Span) SetAttr(name string, value interface{}) {
+	if nil == s {
+		return
+	}
+	s.attrs = append(s.attrs, name, value)
+}
+
+// End ends the span and queues it for export. A non-nil err marks the span as failed.
+func (s *Span) End(err error) {
+	if nil == s {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	select {
+	case exp.spanc <- s:
+	default:
+		/* never block the operation; drop the span instead */
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := []*Span{}
+	for {
+		select {
+		case s := <-e.spanc:
+			batch = append(batch, s)
+			if batchSize > len(batch) {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.stopc:
+			for 0 < len(e.spanc) {
+				batch = append(batch, <-e.spanc)
+			}
+			e.export(batch)
+			return
+		}
+		e.export(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *exporter) export(batch []*Span) {
+	if 0 == len(batch) {
+		return
+	}
+	body, err := json.Marshal(encode(e.service, batch))
+	if nil != err {
+		return
+	}
+	rsp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if nil != err {
+		return
+	}
+	rsp.Body.Close()
+}
+
+// encode converts spans to an OTLP ExportTraceServiceRequest.
+func encode(service string, batch []*Span) interface{} {
+	spans := make([]interface{}, 0, len(batch))
+	for _, s := range batch {
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        encodeAttrs(s.attrs),
+		}
+		if [8]byte{} != s.parentID {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if nil != s.err {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+		spans = append(spans, span)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": encodeAttrs([]interface{}{"service.name", service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": service},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func encodeAttrs(attrs []interface{}) []interface{} {
+	res := []interface{}{}
+	for i := 0; len(attrs) > i+1; i += 2 {
+		var value map[string]interface{}
+		switch v := attrs[i+1].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case uint64:
+			value = map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		res = append(res, map[string]interface{}{"key": fmt.Sprint(attrs[i]), "value": value})
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+/*
+ * otlp_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	ctx, span := Start(context.Background(), "a", KindInternal)
+	if nil != span || nil != ctx.Value(spanKey{}) {
+		t.Error()
+	}
+	span.SetAttr("k", "v")
+	span.End(nil)
+
+	bodyc := make(chan []byte, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/v1/traces" != r.URL.Path {
+			t.Error(r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodyc <- body
+	}))
+	defer srv.Close()
+
+	Init(srv.URL+"/", "test")
+	defer func() {
+		exp = nil
+	}()
+
+	ctx, parent := Start(context.Background(), "parent", KindInternal, "path", "/a")
+	_, child := Start(ctx, "child", KindClient)
+	child.SetAttr("status", 200)
+	child.End(nil)
+	parent.End(errors.New("failed"))
+	Shutdown()
+
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceId      string
+					SpanId       string
+					ParentSpanId string
+					Name         string
+					Kind         int
+					Attributes   []struct {
+						Key   string
+						Value map[string]interface{}
+					}
+					Status struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	err := json.Unmarshal(<-bodyc, &req)
+	if nil != err {
+		t.Fatal(err)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if 2 != len(spans) {
+		t.Fatal(len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if "child" != c.Name || KindClient != c.Kind || 0 != c.Status.Code ||
+		"status" != c.Attributes[0].Key || "200" != c.Attributes[0].Value["intValue"] {
+		t.Error(c)
+	}
+	if "parent" != p.Name || "" != p.ParentSpanId || 2 != p.Status.Code || "failed" != p.Status.Message ||
+		"path" != p.Attributes[0].Key || "/a" != p.Attributes[0].Value["stringValue"] {
+		t.Error(p)
+	}
+	if c.TraceId != p.TraceId || c.ParentSpanId != p.SpanId || 32 != len(c.TraceId) {
+		t.Error()
+	}
+}
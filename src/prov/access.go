@@ -0,0 +1,163 @@
+/*
+ * access.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Access diagnosis reasons.
+const (
+	AccessNoAuth   = "noauth"   // no auth token; the repository may be private
+	AccessToken    = "token"    // the token is invalid, has expired or has been revoked
+	AccessScope    = "scope"    // the token lacks a required scope
+	AccessSSO      = "sso"      // the token is not authorized for SAML single sign-on
+	AccessNotFound = "notfound" // the repository does not exist or the user has no access
+	AccessFiltered = "filtered" // the repository is excluded by the filter or options
+	AccessUnlisted = "unlisted" // the repository exists but is not in the owner's list
+)
+
+// AuthError is the error returned when the provider rejects the auth token. It wraps
+// ErrAuth; Reason is one of AccessToken, AccessScope or AccessSSO.
+type AuthError struct {
+	Reason  string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return ErrAuth.Error() + ": " + e.Message
+}
+
+func (e *AuthError) Unwrap() error {
+	return ErrAuth
+}
+
+// AccessDiagnosis explains why a repository could not be opened.
+type AccessDiagnosis struct {
+	Repository string // owner/repository
+	Reason     string
+	Message    string
+	Time       time.Time
+}
+
+const maxAccessDiagnoses = 100
+
+// GetAccessDiagnoses returns the diagnoses of the repositories that could not be opened
+// recently, most recent last.
+func (c *client) GetAccessDiagnoses() []*AccessDiagnosis {
+	c.lock.Lock()
+	res := append([]*AccessDiagnosis(nil), c.access...)
+	c.lock.Unlock()
+	return res
+}
+
+// diagnose determines why a repository is not in the list of repositories of its owner
+// and records the reason. It returns an AuthError if the provider rejects the auth token
+// for the repository and ErrNotFound otherwise. The provider is only asked about the
+// repositories of the authenticated user and the organizations that the user is a member
+// of, so that looking up names in other owners does not spend the rate limit.
+func (c *client) diagnose(ctx context.Context, o *owner, name string) error {
+	if gistKind == o.FKind || isProbeName(name) {
+		return ErrNotFound
+	}
+
+	full := o.FName + "/" + name
+	c.lock.Lock()
+	for _, d := range c.access {
+		if d.Repository == full && time.Now().Before(d.Time.Add(c.expiration())) {
+			c.lock.Unlock()
+			return accessError(d)
+		}
+	}
+	c.lock.Unlock()
+
+	d := &AccessDiagnosis{Repository: full}
+	if nil != c.filter && !c.filter.match(full) {
+		d.Reason = AccessFiltered
+		d.Message = "repository is excluded by the filter"
+	} else if c.anonymous {
+		d.Reason = AccessNoAuth
+		d.Message = "repository does not exist or is private; no auth token"
+	} else if !c.isUserOwner(ctx, o.FName) {
+		return ErrNotFound
+	} else if _, err := c.api.getRepositoryInfo(ctx, o.FName, name); nil == err {
+		d.Reason = AccessUnlisted
+		d.Message = "repository exists but is not listed for the owner " +
+			"(it may be a fork or archived, or the list may be stale)"
+	} else if e := (*AuthError)(nil); errors.As(err, &e) {
+		d.Reason = e.Reason
+		d.Message = e.Message
+	} else if ErrNotFound == err {
+		d.Reason = AccessNotFound
+		d.Message = "repository does not exist or the user is not a collaborator " +
+			"(or the token has not been granted access to it)"
+	} else {
+		return ErrNotFound
+	}
+	d.Time = time.Now()
+
+	c.lock.Lock()
+	for i, e := range c.access {
+		if e.Repository == full {
+			c.access = append(c.access[:i], c.access[i+1:]...)
+			break
+		}
+	}
+	if maxAccessDiagnoses <= len(c.access) {
+		c.access = c.access[1:]
+	}
+	c.access = append(c.access, d)
+	c.lock.Unlock()
+
+	return accessError(d)
+}
+
+// isUserOwner determines if an owner is the authenticated user or an organization that the
+// user is a member of. The list of these owners is cached.
+func (c *client) isUserOwner(ctx context.Context, name string) bool {
+	lst, err := c.GetOwners(ctx)
+	if nil != err {
+		return false
+	}
+	for _, o := range lst {
+		if strings.EqualFold(o.Name(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProbeName determines if a name is one that Windows Explorer, macOS Finder and other
+// programs look for in every directory that they visit. Such names are not diagnosed.
+func isProbeName(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	switch strings.ToLower(name) {
+	case "desktop.ini", "autorun.inf", "thumbs.db", "folder.jpg", "folder.gif":
+		return true
+	}
+	return false
+}
+
+func accessError(d *AccessDiagnosis) error {
+	switch d.Reason {
+	case AccessToken, AccessScope, AccessSSO:
+		return &AuthError{Reason: d.Reason, Message: d.Message}
+	}
+	return ErrNotFound
+}
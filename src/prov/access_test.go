@@ -0,0 +1,98 @@
+/*
+ * access_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// accessApi answers the requests of diagnose; the other client API methods are not used.
+type accessApi struct {
+	clientApi
+	owners   []*owner
+	infos    map[string]error
+	requests int
+}
+
+func (a *accessApi) getOwners(ctx context.Context) ([]*owner, error) {
+	return a.owners, nil
+}
+
+func (a *accessApi) getRepositoryInfo(ctx context.Context, owner string, repository string) (
+	*RepositoryInfo, error) {
+	a.requests++
+	err, ok := a.infos[owner+"/"+repository]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if nil != err {
+		return nil, err
+	}
+	return &RepositoryInfo{}, nil
+}
+
+func TestDiagnose(t *testing.T) {
+	ctx := context.Background()
+	api := &accessApi{
+		owners: []*owner{{FName: "user"}, {FName: "org"}},
+		infos: map[string]error{
+			"user/unlisted": nil,
+			"org/sso":       &AuthError{Reason: AccessSSO, Message: "sso"},
+		},
+	}
+	c := &client{api: api}
+	user, org, other := &owner{FName: "user"}, &owner{FName: "org"}, &owner{FName: "other"}
+
+	if err := c.diagnose(ctx, user, "unlisted"); ErrNotFound != err {
+		t.Error(err)
+	}
+	e := (*AuthError)(nil)
+	if err := c.diagnose(ctx, org, "sso"); !errors.As(err, &e) || AccessSSO != e.Reason {
+		t.Error(err)
+	}
+	if err := c.diagnose(ctx, user, "missing"); ErrNotFound != err {
+		t.Error(err)
+	}
+	if 3 != api.requests {
+		t.Error(api.requests)
+	}
+
+	/* probe names and the repositories of other owners are not diagnosed */
+	for _, name := range []string{"desktop.ini", "Autorun.inf", ".DS_Store"} {
+		if err := c.diagnose(ctx, user, name); ErrNotFound != err {
+			t.Error(err)
+		}
+	}
+	if err := c.diagnose(ctx, other, "repo"); ErrNotFound != err {
+		t.Error(err)
+	}
+	if 3 != api.requests {
+		t.Error(api.requests)
+	}
+
+	/* a recent diagnosis is reused */
+	if err := c.diagnose(ctx, org, "sso"); !errors.As(err, &e) || 3 != api.requests {
+		t.Error(err, api.requests)
+	}
+
+	reasons := ""
+	for _, d := range c.GetAccessDiagnoses() {
+		reasons += d.Repository + ":" + d.Reason + " "
+	}
+	if "user/unlisted:unlisted org/sso:sso user/missing:notfound " != reasons {
+		t.Error(reasons)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * actions.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GetWorkflows returns the CI workflows of the repository.
+func (r *gitRepository) GetWorkflows(ctx context.Context) (res []*Workflow, err error) {
+	r.lock.RLock()
+	res = r.workflows
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getWorkflows(ctx)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	r.workflows = res
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetWorkflowRuns returns the most recent runs of a workflow.
+func (r *gitRepository) GetWorkflowRuns(ctx context.Context, workflow *Workflow) (
+	res []*WorkflowRun, err error) {
+	r.lock.RLock()
+	res = r.runs[workflow.ID]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getWorkflowRuns(ctx, workflow.ID)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	if nil == r.runs {
+		r.runs = make(map[int64][]*WorkflowRun)
+		r.runmap = make(map[int64]*WorkflowRun)
+	}
+	r.runs[workflow.ID] = res
+	for _, run := range res {
+		r.runmap[run.ID] = run
+	}
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetWorkflowRun returns a single workflow run, including runs that are too old to be
+// reported by GetWorkflowRuns.
+func (r *gitRepository) GetWorkflowRun(ctx context.Context, id int64) (
+	res *WorkflowRun, err error) {
+	r.lock.RLock()
+	res = r.runmap[id]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getWorkflowRun(ctx, id)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	if nil == r.runs {
+		r.runs = make(map[int64][]*WorkflowRun)
+		r.runmap = make(map[int64]*WorkflowRun)
+	}
+	r.runmap[id] = res
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetWorkflowJobs returns the jobs of a workflow run. Job names are changed so that
+// they can be used as file names. The jobs of a run are only cached once the run has
+// completed.
+func (r *gitRepository) GetWorkflowJobs(ctx context.Context, run *WorkflowRun) (
+	res []*WorkflowJob, err error) {
+	r.lock.RLock()
+	res = r.jobs[run.ID]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getWorkflowJobs(ctx, run.ID)
+	if nil != err {
+		return nil, err
+	}
+	for _, job := range res {
+		job.Name = strings.ReplaceAll(job.Name, "/", string(AltPathSeparator))
+	}
+
+	if WorkflowCompleted == run.Status {
+		r.lock.Lock()
+		if nil == r.jobs {
+			r.jobs = make(map[int64][]*WorkflowJob)
+		}
+		r.jobs[run.ID] = res
+		r.lock.Unlock()
+	}
+	return res, nil
+}
+
+// GetWorkflowJobLogReader returns a reader for the log of a workflow job and the size
+// of the log. The log of a job is only cached once the job has completed.
+func (r *gitRepository) GetWorkflowJobLogReader(ctx context.Context, job *WorkflowJob) (
+	io.ReaderAt, int64, error) {
+	return r.fetchActionsFile(ctx, "logs", strconv.FormatInt(job.ID, 10)+".log",
+		WorkflowCompleted == job.Status, func(w io.Writer) error {
+			return r.api.getWorkflowJobLog(ctx, job.ID, w)
+		})
+}
+
+// GetArtifacts returns the artifacts of a workflow run.
+func (r *gitRepository) GetArtifacts(ctx context.Context, run *WorkflowRun) (
+	res []*Artifact, err error) {
+	r.lock.RLock()
+	res = r.artifacts[run.ID]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getArtifacts(ctx, run.ID)
+	if nil != err {
+		return nil, err
+	}
+
+	if WorkflowCompleted == run.Status {
+		r.lock.Lock()
+		if nil == r.artifacts {
+			r.artifacts = make(map[int64][]*Artifact)
+		}
+		r.artifacts[run.ID] = res
+		r.lock.Unlock()
+	}
+	return res, nil
+}
+
+// GetArtifactReader returns a reader for the zip archive of an artifact and the size
+// of the archive.
+func (r *gitRepository) GetArtifactReader(ctx context.Context, artifact *Artifact) (
+	io.ReaderAt, int64, error) {
+	if artifact.Expired {
+		return nil, 0, ErrNotFound
+	}
+	return r.fetchActionsFile(ctx, "artifacts", strconv.FormatInt(artifact.ID, 10)+".zip",
+		true, func(w io.Writer) error {
+			return r.api.download(ctx, artifact.URL, w)
+		})
+}
+
+// fetchActionsFile fetches a file and returns a reader for it and its size. Cacheable
+// files are kept in the cache directory; when no cache directory is set they are kept
+// in memory.
+func (r *gitRepository) fetchActionsFile(ctx context.Context, kind string, name string,
+	cacheable bool, fetch func(w io.Writer) error) (res io.ReaderAt, size int64, err error) {
+
+	if nil == r.api {
+		return nil, 0, ErrNotFound
+	}
+
+	r.lock.RLock()
+	dir := r.dir
+	content := r.actions[kind+"/"+name]
+	r.lock.RUnlock()
+
+	if "" == dir || !cacheable {
+		if nil == content {
+			var buf bytes.Buffer
+			err = fetch(&buf)
+			if nil != err {
+				return nil, 0, err
+			}
+			content = buf.Bytes()
+
+			if cacheable {
+				r.lock.Lock()
+				if nil == r.actions {
+					r.actions = make(map[string][]byte)
+				}
+				r.actions[kind+"/"+name] = content
+				r.lock.Unlock()
+			}
+		}
+		return readerAtNopCloser{bytes.NewReader(content)}, int64(len(content)), nil
+	}
+
+	file, err := fetchFile(filepath.Join(dir, "actions", kind, name), fetch)
+	if nil != err {
+		return nil, 0, err
+	}
+	size, err = file.Size()
+	if nil != err {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, size, nil
+}
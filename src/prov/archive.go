@@ -0,0 +1,84 @@
+/*
+ * archive.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/winfsp/hubfs/util"
+)
+
+// archiveCacheSize is the total size of the archives that a repository without a cache
+// directory keeps in memory.
+const archiveCacheSize = 64 << 20
+
+// GetArchiveReader returns a reader for an archive of the content of a ref in the
+// specified format (one of ArchiveFormats) and the size of the archive. The archive is
+// downloaded from the provider once and kept in the cache directory; when no cache
+// directory is set it is kept in memory along with other recently used archives.
+func (r *gitRepository) GetArchiveReader(ctx context.Context, ref Ref, format string) (
+	res io.ReaderAt, size int64, err error) {
+
+	if !containsString(ArchiveFormats, format) {
+		return nil, 0, ErrNotFound
+	}
+	if nil == r.api {
+		return nil, 0, ErrNotFound
+	}
+
+	err = r.peel(ctx, ref)
+	if nil != err {
+		return nil, 0, err
+	}
+	hash := ref.Hash()
+
+	r.lock.Lock()
+	dir := r.dir
+	if "" == dir && nil == r.archives {
+		r.archives = util.NewByteCache(archiveCacheSize)
+	}
+	archives := r.archives
+	r.lock.Unlock()
+
+	if "" == dir {
+		content, ok := archives.Get(hash + "." + format)
+		if !ok {
+			var buf bytes.Buffer
+			err = r.api.getArchive(ctx, hash, format, &buf)
+			if nil != err {
+				return nil, 0, err
+			}
+			content = buf.Bytes()
+			archives.Set(hash+"."+format, content)
+		}
+		return readerAtNopCloser{bytes.NewReader(content)}, int64(len(content)), nil
+	}
+
+	file, err := fetchFile(filepath.Join(dir, "archive", hash+"."+format), func(w io.Writer) error {
+		return r.api.getArchive(ctx, hash, format, w)
+	})
+	if nil != err {
+		return nil, 0, err
+	}
+	size, err = file.Size()
+	if nil != err {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, size, nil
+}
@@ -0,0 +1,54 @@
+/*
+ * blame.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+)
+
+// GetBlame returns the blame of a file (path relative to the repository root) at a ref
+// as reported by the provider. The blame of a file at a commit never changes, so it is
+// kept for the lifetime of the repository.
+func (r *gitRepository) GetBlame(ctx context.Context, ref Ref, path string) (
+	res []*BlameRange, err error) {
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	err = r.peel(ctx, ref)
+	if nil != err {
+		return nil, err
+	}
+	key := ref.Hash() + ":" + path
+
+	r.lock.RLock()
+	res = r.blames[key]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	res, err = r.api.getBlame(ctx, ref.Hash(), path)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	if nil == r.blames {
+		r.blames = make(map[string][]*BlameRange)
+	}
+	r.blames[key] = res
+	r.lock.Unlock()
+	return res, nil
+}
@@ -14,26 +14,74 @@
 package prov
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/billziss-gh/golib/appdata"
+	libcache "github.com/billziss-gh/golib/cache"
 )
 
 type client struct {
-	api      clientApi
-	dir      string
-	keepdir  bool
-	caseins  bool
-	fullrefs bool
-	ttl      time.Duration
-	lock     sync.Mutex
-	cache    *cache
-	owners   *cacheImap
-	filter   *filterType
+	api        clientApi
+	dir        string
+	keepdir    bool
+	openrepos  int
+	caseins    bool
+	fullrefs   bool
+	nestedrefs bool
+	pullrefs   bool
+	lfs        bool
+	mirror     bool
+	partial    bool
+	treetime   string
+	mounttime  time.Time
+	noforks    bool
+	noarchived bool
+	ttl        time.Duration
+	tempttl    time.Duration
+	idlettl    time.Duration
+	gcstop     chan struct{}
+	inuse      string
+	token      string
+	login      string
+	anonymous  bool
+	tokenlock  sync.Mutex
+	lock       sync.Mutex
+	cache      *cache
+	owners     *cacheImap
+	gists      *cacheImap
+	filter     *filterType
+	refpatts   []string
+	pins       map[string]string
+	cachepins  map[string]bool
+	ratelimit  RateLimit
+	pool       []*poolToken
+	poolidx    int
+	starred    []string
+	starredexp time.Time
+	collab     []string
+	collabexp  time.Time
+	ownerlist  []Owner
+	ownerexp   time.Time
+	searches   map[string]*search
+	notes      []*Notification
+	notesexp   time.Time
+	access     []*AccessDiagnosis
+}
+
+type search struct {
+	result []string
+	exp    time.Time
 }
 
 type owner struct {
@@ -43,25 +91,140 @@ type owner struct {
 	FKind        string
 }
 
+// blobDirName is the directory in the cache directory that contains the blobs of all
+// repositories, keyed by their object id, so that identical files in forks, mirrors and
+// vendored copies are downloaded and stored once.
+const blobDirName = "@objects"
+
+// inuseDirName is the directory in the cache directory that contains a file for every
+// client that uses the cache directory, so that "hubfs cache" can tell that it is in use.
+const inuseDirName = "@inuse"
+
+// inuseSeq makes the names of the files in inuseDirName unique within a process.
+var inuseSeq uint32
+
+// pinMarkerName is the file in the directory of a repository that marks its cached content
+// as pinned.
+const pinMarkerName = "pinned"
+
+// gistKind is the kind of owners opened by OpenGistOwner.
+const gistKind = "Gists"
+
 type repository struct {
 	cacheItem
 	Repository
-	keepdir bool
-	FName   string
-	FRemote string
+	keepdir  bool
+	pinned   func() bool // called with the client lock held
+	fork     bool
+	archived bool
+	FName    string
+	FRemote  string
 }
 
 type clientApi interface {
 	getIdent() string
+	getLogin(ctx context.Context, token string) (string, error)
 	getGitCredentials() (string, string)
-	getOwner(owner string) (res *owner, err error)
-	getRepositories(owner string, kind string) (res []*repository, err error)
+	getOwner(ctx context.Context, owner string) (res *owner, err error)
+	getRepositories(ctx context.Context, owner string, kind string) (res []*repository, err error)
+	resolveCommit(ctx context.Context, owner string, repository string, abbrev string) (
+		res string, err error)
+	getRepositoryInfo(ctx context.Context, owner string, repository string) (
+		res *RepositoryInfo, err error)
+	getReleases(ctx context.Context, owner string, repository string) (
+		res []*Release, err error)
+	download(ctx context.Context, url string, w io.Writer) (err error)
+	getIssuePage(ctx context.Context, owner string, repository string, state string, page int) (
+		res []*Issue, more bool, err error)
+	getIssue(ctx context.Context, owner string, repository string, number int) (
+		res *Issue, err error)
+	getPullRequestPage(ctx context.Context, owner string, repository string, page int) (
+		res []*PullRequest, more bool, err error)
+	getPullRequest(ctx context.Context, owner string, repository string, number int) (
+		res *PullRequest, err error)
+	getPullRequestPatch(ctx context.Context, owner string, repository string, number int) (
+		res []byte, err error)
+	getCommitPage(ctx context.Context, owner string, repository string, hash string, page int) (
+		res []*Commit, more bool, err error)
+	getBlame(ctx context.Context, owner string, repository string, hash string, path string) (
+		res []*BlameRange, err error)
+	getArchive(ctx context.Context, owner string, repository string, hash string, format string,
+		w io.Writer) (err error)
+	getBlob(ctx context.Context, owner string, repository string, hash string, w io.Writer) (err error)
+	getOwners(ctx context.Context) (res []*owner, err error)
+	getStarred(ctx context.Context) (res []string, err error)
+	getCollaborator(ctx context.Context) (res []string, err error)
+	searchRepositories(ctx context.Context, query string) (res []string, err error)
+	getNotifications(ctx context.Context) (res []*Notification, err error)
+	markNotificationRead(ctx context.Context, id string) (err error)
+	getDiff(ctx context.Context, owner string, repository string, base string, head string) (
+		res []byte, err error)
+	getWorkflows(ctx context.Context, owner string, repository string) (
+		res []*Workflow, err error)
+	getWorkflowRuns(ctx context.Context, owner string, repository string, workflow int64) (
+		res []*WorkflowRun, err error)
+	getWorkflowRun(ctx context.Context, owner string, repository string, id int64) (
+		res *WorkflowRun, err error)
+	getWorkflowJobs(ctx context.Context, owner string, repository string, run int64) (
+		res []*WorkflowJob, err error)
+	getWorkflowJobLog(ctx context.Context, owner string, repository string, job int64,
+		w io.Writer) (err error)
+	getArtifacts(ctx context.Context, owner string, repository string, run int64) (
+		res []*Artifact, err error)
 }
 
 func (c *client) init(api clientApi) {
 	c.api = api
 	c.cache = newCache(&c.lock)
 	c.cache.Value = c
+	c.tempttl = 10 * time.Minute
+	c.idlettl = time.Hour
+}
+
+// initToken sets the initial auth token of the client and the user that it belongs to.
+func (c *client) initToken(token string) (err error) {
+	c.token = token
+	c.anonymous = "" == token
+	if !c.anonymous {
+		c.login, err = c.api.getLogin(context.Background(), token)
+	}
+	return
+}
+
+func (c *client) getToken() string {
+	c.tokenlock.Lock()
+	token := c.token
+	c.tokenlock.Unlock()
+	return token
+}
+
+// SetToken replaces the auth token of the client, e.g. when the current token is about
+// to expire. The new token must belong to the same user as the current token. Open
+// repositories use the new token for subsequent requests. With a token pool the new
+// token replaces the initial token of the pool.
+func (c *client) SetToken(ctx context.Context, token string) error {
+	if c.anonymous {
+		return errors.New("client is not authenticated")
+	}
+	login, err := c.api.getLogin(ctx, token)
+	if nil != err {
+		return err
+	}
+	if login != c.login {
+		return errors.New("token belongs to a different user: " + login)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if 0 != len(c.pool) {
+		c.pool[0].token = token
+		if 0 != c.poolidx {
+			return nil
+		}
+	}
+	c.tokenlock.Lock()
+	c.token = token
+	c.tokenlock.Unlock()
+	return nil
 }
 
 func configValue(s string, k string, v *string) bool {
@@ -72,8 +235,13 @@ func configValue(s string, k string, v *string) bool {
 	return false
 }
 
+// SetConfig applies the config options that the client understands and returns the
+// others. The filter rules and ref patterns of a call replace those of previous calls;
+// an empty rule or pattern (e.g. config._filter=) removes all of them.
 func (c *client) SetConfig(config []string) ([]string, error) {
 	res := []string{}
+	var filter *filterType
+	var refpatts []string
 	for _, s := range config {
 		v := ""
 		switch {
@@ -95,6 +263,14 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 			if ttl, e := time.ParseDuration(v); nil == e && 0 < ttl {
 				c.ttl = ttl
 			}
+		case configValue(s, "config.tempttl=", &v):
+			if ttl, e := time.ParseDuration(v); nil == e && 0 <= ttl {
+				c.tempttl = ttl
+			}
+		case configValue(s, "config.idlettl=", &v):
+			if ttl, e := time.ParseDuration(v); nil == e && 0 <= ttl {
+				c.idlettl = ttl
+			}
 		case configValue(s, "config._caseins=", &v):
 			if "1" == v {
 				c.caseins = true
@@ -107,19 +283,204 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 			} else {
 				c.fullrefs = false
 			}
+		case configValue(s, "config._nestedrefs=", &v):
+			if "1" == v {
+				c.nestedrefs = true
+			} else {
+				c.nestedrefs = false
+			}
+		case configValue(s, "config._pullrefs=", &v):
+			if "1" == v {
+				c.pullrefs = true
+			} else {
+				c.pullrefs = false
+			}
+		case configValue(s, "config._lfs=", &v):
+			if "1" == v {
+				c.lfs = true
+			} else {
+				c.lfs = false
+			}
+		case configValue(s, "config._mirror=", &v):
+			if "1" == v {
+				c.mirror = true
+			} else {
+				c.mirror = false
+			}
+		case configValue(s, "config._partial=", &v):
+			if "1" == v {
+				c.partial = true
+			} else {
+				c.partial = false
+			}
+		case configValue(s, "config._treetime=", &v):
+			c.treetime = v
+			c.mounttime = time.Now()
+		case configValue(s, "config._forks=", &v):
+			if "0" == v {
+				c.noforks = true
+			} else {
+				c.noforks = false
+			}
+		case configValue(s, "config._archived=", &v):
+			if "0" == v {
+				c.noarchived = true
+			} else {
+				c.noarchived = false
+			}
+		case configValue(s, "config._refs=", &v):
+			if nil == refpatts {
+				refpatts = []string{}
+			}
+			if "" != v {
+				refpatts = append(refpatts, v)
+			}
+		case configValue(s, "config._pin=", &v):
+			err := c.setPin(v)
+			if nil != err {
+				return nil, err
+			}
+		case configValue(s, "config._cachepin=", &v):
+			err := c.setCachePin(v)
+			if nil != err {
+				return nil, err
+			}
 		case configValue(s, "config._filter=", &v):
-			if nil == c.filter {
-				c.filter = &filterType{}
+			if nil == filter {
+				filter = &filterType{}
+			}
+			if "" != v {
+				filter.addRule(v)
 			}
-			c.filter.addRule(v)
 		default:
 			res = append(res, s)
 		}
 	}
 
+	if nil != refpatts {
+		c.lock.Lock()
+		c.refpatts = refpatts
+		c.lock.Unlock()
+	}
+
+	if nil != filter {
+		if 0 == len(filter[0]) && 0 == len(filter[1]) {
+			filter = nil
+		}
+		c.lock.Lock()
+		c.filter = filter
+		c.lock.Unlock()
+	}
+
 	return res, nil
 }
 
+// setPin pins a ref to a commit. The pin has the form owner/repo/ref=hash; an empty hash
+// removes the pin.
+func (c *client) setPin(pin string) error {
+	i := strings.LastIndexByte(pin, '=')
+	if -1 == i {
+		return errors.New("invalid pin: " + pin)
+	}
+	path, hash := strings.Trim(pin[:i], "/"), strings.ToLower(pin[i+1:])
+	lst := strings.SplitN(path, "/", 3)
+	if 3 != len(lst) || "" == lst[0] || "" == lst[1] || "" == lst[2] {
+		return errors.New("invalid pin: " + pin + " (expected owner/repo/ref=hash)")
+	}
+	if "" != hash && (40 != len(hash) || !isHash(hash)) {
+		return errors.New("invalid pin: " + pin + " (expected full commit hash)")
+	}
+
+	c.lock.Lock()
+	if "" == hash {
+		delete(c.pins, path)
+	} else {
+		if nil == c.pins {
+			c.pins = make(map[string]string)
+		}
+		c.pins[path] = hash
+	}
+	c.lock.Unlock()
+	return nil
+}
+
+// getPins returns the pinned refs of a repository (ref name to commit hash).
+func (c *client) getPins(owner string, name string) map[string]string {
+	res := make(map[string]string)
+	c.lock.Lock()
+	for path, hash := range c.pins {
+		lst := strings.SplitN(path, "/", 3)
+		if strings.EqualFold(owner, lst[0]) && strings.EqualFold(name, lst[1]) {
+			res[lst[2]] = hash
+		}
+	}
+	c.lock.Unlock()
+	return res
+}
+
+// setCachePin pins the cached content of a repository or ref. The pin has the form
+// owner/repo or owner/repo/ref; a pin that starts with - removes the pin.
+func (c *client) setCachePin(pin string) error {
+	remove := strings.HasPrefix(pin, "-")
+	path := strings.Trim(strings.TrimPrefix(pin, "-"), "/")
+	lst := strings.SplitN(path, "/", 3)
+	if 2 > len(lst) || "" == lst[0] || "" == lst[1] || (3 == len(lst) && "" == lst[2]) {
+		return errors.New("invalid cache pin: " + pin + " (expected owner/repo or owner/repo/ref)")
+	}
+
+	c.lock.Lock()
+	if remove {
+		delete(c.cachepins, path)
+	} else {
+		if nil == c.cachepins {
+			c.cachepins = make(map[string]bool)
+		}
+		c.cachepins[path] = true
+	}
+	c.lock.Unlock()
+	return nil
+}
+
+// isCachePinned determines if the cached content of a ref is pinned. With an empty ref
+// name it determines if any content of the repository is pinned.
+func (c *client) isCachePinned(owner string, name string, ref string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c._isCachePinned(owner, name, ref)
+}
+
+func (c *client) _isCachePinned(owner string, name string, ref string) bool {
+	for path := range c.cachepins {
+		lst := strings.SplitN(path, "/", 3)
+		if !strings.EqualFold(owner, lst[0]) || !strings.EqualFold(name, lst[1]) {
+			continue
+		}
+		if 2 == len(lst) || "" == ref {
+			return true
+		}
+		n := lst[2]
+		if !c.nestedrefs {
+			n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
+		}
+		if n == ref || (c.caseins && strings.EqualFold(n, ref)) {
+			return true
+		}
+	}
+	return false
+}
+
+// writePinMarker creates or removes the file that marks the cache directory of a
+// repository as pinned, so that "hubfs cache prune" keeps it.
+func writePinMarker(dir string, pinned bool) {
+	path := filepath.Join(dir, pinMarkerName)
+	if !pinned {
+		os.Remove(path)
+	} else if _, err := os.Stat(path); nil != err {
+		os.MkdirAll(dir, 0700)
+		ioutil.WriteFile(path, nil, 0600)
+	}
+}
+
 func (c *client) GetDirectory() string {
 	c.lock.Lock()
 	dir := c.dir
@@ -127,11 +488,46 @@ func (c *client) GetDirectory() string {
 	return dir
 }
 
-func (c *client) GetOwners() ([]Owner, error) {
-	return []Owner{}, nil
+// GetOwners returns the authenticated user and the organizations that the user is a
+// member of. The returned owners are not opened; they should only be used for their
+// names. The list is cached for the cache expiration time.
+func (c *client) GetOwners(ctx context.Context) ([]Owner, error) {
+	c.lock.Lock()
+	res := c.ownerlist
+	exp := c.ownerexp
+	c.lock.Unlock()
+	if nil != res && time.Now().Before(exp) {
+		return res, nil
+	}
+
+	lst, err := c.api.getOwners(ctx)
+	if nil != err {
+		return nil, err
+	}
+	res = make([]Owner, 0, len(lst))
+	for _, o := range lst {
+		if nil == c.filter || c.filter.match(o.FName) {
+			res = append(res, o)
+		}
+	}
+
+	c.lock.Lock()
+	c.ownerlist = res
+	c.ownerexp = time.Now().Add(c.expiration())
+	c.lock.Unlock()
+	return res, nil
 }
 
-func (c *client) OpenOwner(name string) (Owner, error) {
+func (c *client) OpenOwner(ctx context.Context, name string) (Owner, error) {
+	return c.openOwner(ctx, name, false)
+}
+
+// OpenGistOwner opens an owner whose repositories are the gists of the named user.
+func (c *client) OpenGistOwner(ctx context.Context, name string) (Owner, error) {
+	return c.openOwner(ctx, name, true)
+}
+
+func (c *client) openOwner(ctx context.Context, name string, gists bool) (Owner, error) {
 	var res *owner
 	var err error
 
@@ -139,9 +535,14 @@ func (c *client) OpenOwner(name string) (Owner, error) {
 		return nil, ErrNotFound
 	}
 
+	owners := &c.owners
+	if gists {
+		owners = &c.gists
+	}
+
 	c.lock.Lock()
-	if nil != c.owners {
-		item, ok := c.owners.Get(name)
+	if nil != *owners {
+		item, ok := (*owners).Get(name)
 		if ok {
 			res = item.Value.(*owner)
 			c.cache.touchCacheItem(&res.cacheItem, +1)
@@ -151,33 +552,230 @@ func (c *client) OpenOwner(name string) (Owner, error) {
 	}
 	c.lock.Unlock()
 
-	res, err = c.api.getOwner(name)
+	res, err = c.api.getOwner(ctx, name)
 	if nil != err {
 		return nil, err
 	}
+	if gists {
+		res.FKind = gistKind
+	}
 
 	c.lock.Lock()
-	if nil == c.owners {
-		c.owners = c.cache.newCacheImap()
+	if nil == *owners {
+		*owners = c.cache.newCacheImap()
 	}
-	item, ok := c.owners.Get(name)
+	item, ok := (*owners).Get(name)
 	if ok {
 		res = item.Value.(*owner)
 	} else {
-		c.owners.Set(name, &res.MapItem, true)
+		(*owners).Set(name, &res.MapItem, true)
 	}
 	c.cache.touchCacheItem(&res.cacheItem, +1)
 	c.lock.Unlock()
 	return res, nil
 }
 
+// GetStarredRepositories returns the full names (owner/repository) of the repositories
+// starred by the authenticated user. The list is cached for the cache expiration time.
+func (c *client) GetStarredRepositories(ctx context.Context) ([]string, error) {
+	c.lock.Lock()
+	res := c.starred
+	exp := c.starredexp
+	c.lock.Unlock()
+	if nil != res && time.Now().Before(exp) {
+		return res, nil
+	}
+
+	lst, err := c.api.getStarred(ctx)
+	if nil != err {
+		return nil, err
+	}
+	res = make([]string, 0, len(lst))
+	for _, n := range lst {
+		if nil == c.filter || c.filter.match(n) {
+			res = append(res, n)
+		}
+	}
+
+	c.lock.Lock()
+	c.starred = res
+	c.starredexp = time.Now().Add(c.expiration())
+	c.lock.Unlock()
+	return res, nil
+}
+
+// GetCollaboratorRepositories returns the full names (owner/repository) of the
+// repositories that the authenticated user has access to as a collaborator, but that
+// are not owned by the user or by one of the user's organizations. The list is cached
+// for the cache expiration time.
+func (c *client) GetCollaboratorRepositories(ctx context.Context) ([]string, error) {
+	c.lock.Lock()
+	res := c.collab
+	exp := c.collabexp
+	c.lock.Unlock()
+	if nil != res && time.Now().Before(exp) {
+		return res, nil
+	}
+
+	lst, err := c.api.getCollaborator(ctx)
+	if nil != err {
+		return nil, err
+	}
+	res = make([]string, 0, len(lst))
+	for _, n := range lst {
+		if nil == c.filter || c.filter.match(n) {
+			res = append(res, n)
+		}
+	}
+
+	c.lock.Lock()
+	c.collab = res
+	c.collabexp = time.Now().Add(c.expiration())
+	c.lock.Unlock()
+	return res, nil
+}
+
+// SearchRepositories returns the full names (owner/repository) of the repositories that
+// match a query in the provider's search syntax. Results are cached for the cache
+// expiration time.
+func (c *client) SearchRepositories(ctx context.Context, query string) ([]string, error) {
+	c.lock.Lock()
+	s := c.searches[query]
+	c.lock.Unlock()
+	if nil != s && time.Now().Before(s.exp) {
+		return s.result, nil
+	}
+
+	lst, err := c.api.searchRepositories(ctx, query)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]string, 0, len(lst))
+	for _, n := range lst {
+		if nil == c.filter || c.filter.match(n) {
+			res = append(res, n)
+		}
+	}
+
+	currentTime := time.Now()
+	c.lock.Lock()
+	if nil == c.searches {
+		c.searches = make(map[string]*search)
+	}
+	for k, s := range c.searches {
+		if !currentTime.Before(s.exp) {
+			delete(c.searches, k)
+		}
+	}
+	c.searches[query] = &search{result: res, exp: currentTime.Add(c.expiration())}
+	c.lock.Unlock()
+	return res, nil
+}
+
+// GetNotifications returns the unread notifications of the authenticated user. The list
+// is cached for the cache expiration time.
+func (c *client) GetNotifications(ctx context.Context) ([]*Notification, error) {
+	c.lock.Lock()
+	res := c.notes
+	exp := c.notesexp
+	c.lock.Unlock()
+	if nil != res && time.Now().Before(exp) {
+		return res, nil
+	}
+
+	res, err := c.api.getNotifications(ctx)
+	if nil != err {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.notes = res
+	c.notesexp = time.Now().Add(c.expiration())
+	c.lock.Unlock()
+	return res, nil
+}
+
+// MarkNotificationRead marks a notification as read and removes it from the cached list
+// of unread notifications.
+func (c *client) MarkNotificationRead(ctx context.Context, id string) error {
+	err := c.api.markNotificationRead(ctx, id)
+	if nil != err {
+		return err
+	}
+
+	c.lock.Lock()
+	notes := make([]*Notification, 0, len(c.notes))
+	for _, n := range c.notes {
+		if id != n.ID {
+			notes = append(notes, n)
+		}
+	}
+	c.notes = notes
+	c.lock.Unlock()
+	return nil
+}
+
+// FlushCache expires all repositories and owners that are not in use, regardless of
+// when they were last used.
+func (c *client) FlushCache() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.starred = nil
+	c.collab = nil
+	c.ownerlist = nil
+	c.searches = nil
+	c.notes = nil
+	c.access = nil
+
+	var repositories []*repository
+	var owners []*owner
+	c.cache.lrulist.Iterate(func(list, item *libcache.MapItem) bool {
+		switch v := item.Value.(type) {
+		case *repository:
+			repositories = append(repositories, v)
+		case *owner:
+			owners = append(owners, v)
+		}
+		return true
+	})
+
+	// owners are only expired when their repositories have been expired
+	currentTime := time.Now()
+	for _, r := range repositories {
+		r.lastUsedTime = time.Time{}
+		r.expire(c.cache, currentTime)
+	}
+	for _, o := range owners {
+		o.lastUsedTime = time.Time{}
+		o.expire(c.cache, currentTime)
+	}
+}
+
+// GetOpenDirectories returns the directories of the repositories that are open.
+func (c *client) GetOpenDirectories() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	res := []string{}
+	c.cache.lrulist.Iterate(func(list, item *libcache.MapItem) bool {
+		if r, ok := item.Value.(*repository); ok && emptyRepository != r.Repository {
+			if dir := r.GetDirectory(); "" != dir {
+				res = append(res, dir)
+			}
+		}
+		return true
+	})
+	return res
+}
+
 func (c *client) CloseOwner(O Owner) {
 	c.lock.Lock()
 	c.cache.touchCacheItem(&O.(*owner).cacheItem, -1)
 	c.lock.Unlock()
 }
 
-func (c *client) ensureRepositories(o *owner, fn func() error) error {
+func (c *client) ensureRepositories(ctx context.Context, o *owner, fn func() error) error {
 	c.lock.Lock()
 	if nil != o.repositories {
 		err := fn()
@@ -186,7 +784,7 @@ func (c *client) ensureRepositories(o *owner, fn func() error) error {
 	}
 	c.lock.Unlock()
 
-	repositories, err := c.api.getRepositories(o.FName, o.FKind)
+	repositories, err := c.api.getRepositories(ctx, o.FName, o.FKind)
 	if nil != err {
 		return err
 	}
@@ -198,6 +796,9 @@ func (c *client) ensureRepositories(o *owner, fn func() error) error {
 			if nil != c.filter && !c.filter.match(o.FName+"/"+elm.FName) {
 				continue
 			}
+			if (c.noforks && elm.fork) || (c.noarchived && elm.archived) {
+				continue
+			}
 			o.repositories.Set(elm.FName, &elm.MapItem, true)
 			c.cache.touchCacheItem(&elm.cacheItem, 0)
 		}
@@ -207,12 +808,12 @@ func (c *client) ensureRepositories(o *owner, fn func() error) error {
 	return err
 }
 
-func (c *client) GetRepositories(O Owner) ([]Repository, error) {
+func (c *client) GetRepositories(ctx context.Context, O Owner) ([]Repository, error) {
 	var res []Repository
 	var err error
 
 	o := O.(*owner)
-	err = c.ensureRepositories(o, func() error {
+	err = c.ensureRepositories(ctx, o, func() error {
 		res = make([]Repository, len(o.repositories.Items()))
 		i := 0
 		for _, elm := range o.repositories.Items() {
@@ -225,31 +826,65 @@ func (c *client) GetRepositories(O Owner) ([]Repository, error) {
 	return res, err
 }
 
-func (c *client) OpenRepository(O Owner, name string) (Repository, error) {
+func (c *client) OpenRepository(ctx context.Context, O Owner, name string) (Repository, error) {
 	var res *repository
 	var err error
 
 	o := O.(*owner)
-	err = c.ensureRepositories(o, func() error {
+	err = c.ensureRepositories(ctx, o, func() error {
 		item, ok := o.repositories.Get(name)
 		if !ok {
 			return ErrNotFound
 		}
 		res = item.Value.(*repository)
 		if emptyRepository == res.Repository {
-			u, p := c.api.getGitCredentials()
-			r := newGitRepository(res.FRemote, u, p, c.caseins, c.fullrefs)
+			config := GitConfig{
+				Caseins:    c.caseins,
+				Fullrefs:   c.fullrefs,
+				Nestedrefs: c.nestedrefs,
+				Pullrefs:   c.pullrefs,
+				Lfs:        c.lfs,
+				Mirror:     c.mirror,
+				Partial:    c.partial,
+				Treetime:   c.treetime,
+				Mounttime:  c.mounttime,
+				Refs:       c.refpatts,
+			}
+			if gistKind != o.FKind {
+				oname, rname := o.FName, res.FName
+				config.Pins = func() map[string]string {
+					return c.getPins(oname, rname)
+				}
+				config.Cachepin = func(ref string) bool {
+					return c.isCachePinned(oname, rname, ref)
+				}
+				res.pinned = func() bool {
+					return c._isCachePinned(oname, rname, "")
+				}
+			}
+			r := newGitRepository(res.FRemote, c.api.getGitCredentials, config)
+			r.api = &repositoryApiT{api: c.api, owner: o.FName, name: res.FName}
 			if "" != c.dir {
-				err = r.SetDirectory(filepath.Join(c.dir, o.FName, res.FName))
+				dir := filepath.Join(c.dir, o.FName, res.FName)
+				if gistKind == o.FKind {
+					dir = filepath.Join(c.dir, "@gists", o.FName, res.FName)
+				}
+				err = r.SetDirectory(dir)
 				if nil != err {
 					return err
 				}
+				r.blobdir = filepath.Join(c.dir, blobDirName)
+				writePinMarker(dir, nil != res.pinned && res.pinned())
 			}
 			res.Repository = r
+			c.openrepos++
 		}
 		c.cache.touchCacheItem(&res.cacheItem, +1)
 		return nil
 	})
+	if ErrNotFound == err {
+		err = c.diagnose(ctx, o, name)
+	}
 	if nil != err {
 		return nil, err
 	}
@@ -257,24 +892,142 @@ func (c *client) OpenRepository(O Owner, name string) (Repository, error) {
 	return res, nil
 }
 
+type repositoryApiT struct {
+	api   clientApi
+	owner string
+	name  string
+}
+
+func (a *repositoryApiT) resolveCommit(ctx context.Context, abbrev string) (string, error) {
+	return a.api.resolveCommit(ctx, a.owner, a.name, abbrev)
+}
+
+func (a *repositoryApiT) getInfo(ctx context.Context) (*RepositoryInfo, error) {
+	return a.api.getRepositoryInfo(ctx, a.owner, a.name)
+}
+
+func (a *repositoryApiT) getReleases(ctx context.Context) ([]*Release, error) {
+	return a.api.getReleases(ctx, a.owner, a.name)
+}
+
+func (a *repositoryApiT) download(ctx context.Context, url string, w io.Writer) error {
+	return a.api.download(ctx, url, w)
+}
+
+func (a *repositoryApiT) getIssuePage(ctx context.Context, state string, page int) (
+	[]*Issue, bool, error) {
+	return a.api.getIssuePage(ctx, a.owner, a.name, state, page)
+}
+
+func (a *repositoryApiT) getIssue(ctx context.Context, number int) (*Issue, error) {
+	return a.api.getIssue(ctx, a.owner, a.name, number)
+}
+
+func (a *repositoryApiT) getPullRequestPage(ctx context.Context, page int) (
+	[]*PullRequest, bool, error) {
+	return a.api.getPullRequestPage(ctx, a.owner, a.name, page)
+}
+
+func (a *repositoryApiT) getPullRequest(ctx context.Context, number int) (*PullRequest, error) {
+	return a.api.getPullRequest(ctx, a.owner, a.name, number)
+}
+
+func (a *repositoryApiT) getPullRequestPatch(ctx context.Context, number int) ([]byte, error) {
+	return a.api.getPullRequestPatch(ctx, a.owner, a.name, number)
+}
+
+func (a *repositoryApiT) getCommitPage(ctx context.Context, hash string, page int) (
+	[]*Commit, bool, error) {
+	return a.api.getCommitPage(ctx, a.owner, a.name, hash, page)
+}
+
+func (a *repositoryApiT) getBlame(ctx context.Context, hash string, path string) (
+	[]*BlameRange, error) {
+	return a.api.getBlame(ctx, a.owner, a.name, hash, path)
+}
+
+func (a *repositoryApiT) getArchive(ctx context.Context, hash string, format string,
+	w io.Writer) error {
+	return a.api.getArchive(ctx, a.owner, a.name, hash, format, w)
+}
+
+func (a *repositoryApiT) getBlob(ctx context.Context, hash string, w io.Writer) error {
+	return a.api.getBlob(ctx, a.owner, a.name, hash, w)
+}
+
+func (a *repositoryApiT) getDiff(ctx context.Context, base string, head string) ([]byte, error) {
+	return a.api.getDiff(ctx, a.owner, a.name, base, head)
+}
+
+func (a *repositoryApiT) getWorkflows(ctx context.Context) ([]*Workflow, error) {
+	return a.api.getWorkflows(ctx, a.owner, a.name)
+}
+
+func (a *repositoryApiT) getWorkflowRuns(ctx context.Context, workflow int64) (
+	[]*WorkflowRun, error) {
+	return a.api.getWorkflowRuns(ctx, a.owner, a.name, workflow)
+}
+
+func (a *repositoryApiT) getWorkflowRun(ctx context.Context, id int64) (*WorkflowRun, error) {
+	return a.api.getWorkflowRun(ctx, a.owner, a.name, id)
+}
+
+func (a *repositoryApiT) getWorkflowJobs(ctx context.Context, run int64) (
+	[]*WorkflowJob, error) {
+	return a.api.getWorkflowJobs(ctx, a.owner, a.name, run)
+}
+
+func (a *repositoryApiT) getWorkflowJobLog(ctx context.Context, job int64, w io.Writer) error {
+	return a.api.getWorkflowJobLog(ctx, a.owner, a.name, job, w)
+}
+
+func (a *repositoryApiT) getArtifacts(ctx context.Context, run int64) ([]*Artifact, error) {
+	return a.api.getArtifacts(ctx, a.owner, a.name, run)
+}
+
 func (c *client) CloseRepository(R Repository) {
 	c.lock.Lock()
 	c.cache.touchCacheItem(&R.(*repository).cacheItem, -1)
 	c.lock.Unlock()
 }
 
-func (c *client) StartExpiration() {
-	ttl := 30 * time.Second
+// expiration returns the cache expiration time. Without authentication the rate limit
+// is much lower, so items are cached for longer.
+func (c *client) expiration() time.Duration {
 	if 0 != c.ttl {
-		ttl = c.ttl
+		return c.ttl
+	}
+	if c.anonymous {
+		return 10 * time.Minute
+	}
+	return 30 * time.Second
+}
+
+func (c *client) StartExpiration() {
+	c.cache.startExpiration(c.expiration())
+	c.gcstop = make(chan struct{})
+	go c.collectOrphans(c.gcstop)
+
+	c.lock.Lock()
+	if "" != c.dir {
+		c.inuse = filepath.Join(c.dir, inuseDirName,
+			fmt.Sprintf("%d-%d", os.Getpid(), atomic.AddUint32(&inuseSeq, 1)))
+		if nil == os.MkdirAll(filepath.Dir(c.inuse), 0700) {
+			ioutil.WriteFile(c.inuse, nil, 0600)
+		}
 	}
-	c.cache.startExpiration(ttl)
+	c.lock.Unlock()
 }
 
 func (c *client) StopExpiration() {
 	c.cache.stopExpiration()
+	close(c.gcstop)
 
 	c.lock.Lock()
+	if "" != c.inuse {
+		os.Remove(c.inuse)
+		c.inuse = ""
+	}
 	if "" == c.dir || c.keepdir {
 		c.lock.Unlock()
 		return
@@ -287,6 +1040,87 @@ func (c *client) StopExpiration() {
 	}
 }
 
+// staleRe matches the directories that are left behind when their removal is interrupted
+// (see RemoveDirectory).
+var staleRe = regexp.MustCompile(`\.[0-9]{8}T[0-9]{6}\.[0-9]{3}Z$`)
+
+// orphanTmpRe matches the temporary files of the cache files written by the provider
+// (see createCacheFile), e.g. <hash>.<random>.tmp or <hash>.<format>.<random>.tmp.
+var orphanTmpRe = regexp.MustCompile(`^[0-9a-f]+(\.[a-z.]+)?\.[0-9]+\.tmp$`)
+
+// orphanDirNames are the directories of a repository directory that only the provider
+// writes. Other directories (e.g. the "files" of overlay mounts) belong to the user.
+var orphanDirNames = []string{"objects", "lfs", "archive"}
+
+// collectOrphans periodically removes the files in the cache directory that no repository
+// owns: directories whose removal was interrupted and temporary files that were left behind
+// by a crash.
+func (c *client) collectOrphans(stop chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		c.lock.Lock()
+		dir := c.dir
+		c.lock.Unlock()
+		if "" != dir {
+			removeOrphans(dir, time.Now().Add(-time.Hour))
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// removeOrphans removes the orphans of the cache directory dir. Only the directories that
+// the provider writes are examined; temporary files are removed if older than cutoff.
+func removeOrphans(dir string, cutoff time.Time) {
+	removeStale := func(pattern string) {
+		list, _ := filepath.Glob(pattern)
+		for _, p := range list {
+			if staleRe.MatchString(p) {
+				os.RemoveAll(p)
+			}
+		}
+	}
+	removeTmp := func(root string) {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if nil != err {
+				return nil
+			}
+			if !info.IsDir() && orphanTmpRe.MatchString(info.Name()) &&
+				info.ModTime().Before(cutoff) {
+				os.Remove(path)
+			}
+			return nil
+		})
+	}
+
+	/* the cache directory, the shared blob directory and repository directories */
+	removeStale(dir + ".*")
+	removeStale(filepath.Join(dir, blobDirName+".*"))
+	removeStale(filepath.Join(dir, "*", "*.*"))
+	removeStale(filepath.Join(dir, "@gists", "*", "*.*"))
+
+	removeTmp(filepath.Join(dir, blobDirName))
+	repos, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	gists, _ := filepath.Glob(filepath.Join(dir, "@gists", "*", "*"))
+	for _, r := range append(repos, gists...) {
+		for _, n := range orphanDirNames {
+			removeTmp(filepath.Join(r, n))
+		}
+	}
+}
+
+// gcCutoff returns the time before which state that has been idle for ttl is collected.
+func gcCutoff(currentTime time.Time, ttl time.Duration) time.Time {
+	if 0 >= ttl {
+		return time.Time{}
+	}
+	return currentTime.Add(-ttl)
+}
+
 func (o *owner) Name() string {
 	return o.FName
 }
@@ -304,7 +1138,11 @@ func (o *owner) expire(c *cache, currentTime time.Time) bool {
 		}
 
 		c := c.Value.(*client)
-		c.owners.Delete(o.FName)
+		if gistKind == o.FKind {
+			c.gists.Delete(o.FName)
+		} else {
+			c.owners.Delete(o.FName)
+		}
 		tracef("%s", o.FName)
 	})
 }
@@ -313,7 +1151,15 @@ func (r *repository) Name() string {
 	return r.FName
 }
 
+func (r *repository) StreamTree(ctx context.Context, ref Ref, entry TreeEntry,
+	fn func(entry TreeEntry) bool) error {
+	return StreamTree(ctx, r.Repository, ref, entry, fn)
+}
+
 func (r *repository) keep() bool {
+	if nil != r.pinned && r.pinned() {
+		return true
+	}
 	var list []string
 	if dir := r.GetDirectory(); "" != dir {
 		list, _ = filepath.Glob(filepath.Join(dir, "files/*/.keep"))
@@ -322,6 +1168,12 @@ func (r *repository) keep() bool {
 }
 
 func (r *repository) expire(c *cache, currentTime time.Time) bool {
+	if g, ok := r.Repository.(*gitRepository); ok &&
+		0 < r.inUse && !r.lastUsedTime.After(currentTime) {
+		/* the repository stays open; collect the state that it no longer uses */
+		cl := c.Value.(*client)
+		go g.collect(gcCutoff(currentTime, cl.tempttl), gcCutoff(currentTime, cl.idlettl))
+	}
 	return c.expireCacheItem(&r.cacheItem, currentTime, func() {
 		if emptyRepository == r.Repository {
 			return
@@ -335,6 +1187,17 @@ func (r *repository) expire(c *cache, currentTime time.Time) bool {
 		}
 		r.Close()
 		r.Repository = emptyRepository
+
+		/* the shared blobs are removed along with the last repository that uses them */
+		c := c.Value.(*client)
+		c.openrepos--
+		if 0 == c.openrepos && "" != c.dir && !c.keepdir {
+			blobdir := filepath.Join(c.dir, blobDirName)
+			tmpdir := blobdir + time.Now().Format(".20060102T150405.000Z")
+			if nil == os.Rename(blobdir, tmpdir) {
+				os.RemoveAll(tmpdir)
+			}
+		}
 	})
 }
 
@@ -0,0 +1,104 @@
+/*
+ * client_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachePin(t *testing.T) {
+	c := &client{}
+	for _, p := range []string{"winfsp/hubfs", "billziss-gh/golib/feature/x", "winfsp/cgofuse/master"} {
+		if err := c.setCachePin(p); nil != err {
+			t.Error(err)
+		}
+	}
+	for _, p := range []string{"winfsp", "winfsp/", "/hubfs", "winfsp//master"} {
+		if err := c.setCachePin(p); nil == err {
+			t.Error(p)
+		}
+	}
+
+	tests := []struct {
+		owner, name, ref string
+		pinned           bool
+	}{
+		{"winfsp", "hubfs", "", true},
+		{"WinFsp", "HubFS", "master", true},
+		{"winfsp", "cgofuse", "", true},
+		{"winfsp", "cgofuse", "master", true},
+		{"winfsp", "cgofuse", "release", false},
+		{"billziss-gh", "golib", "feature" + string(AltPathSeparator) + "x", true},
+		{"billziss-gh", "golib", "feature/x", false},
+		{"winfsp", "winfsp", "", false},
+	}
+	for _, tt := range tests {
+		if tt.pinned != c.isCachePinned(tt.owner, tt.name, tt.ref) {
+			t.Error(tt)
+		}
+	}
+
+	c.setCachePin("-winfsp/hubfs")
+	if c.isCachePinned("winfsp", "hubfs", "") {
+		t.Error()
+	}
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-orphans")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, p := range []string{
+		"@objects/objects/ab/cdef.123.tmp",
+		"owner/repo/objects/ab/cdef.123.tmp",
+		"owner/repo/archive/abcdef.zip.123.tmp",
+		"owner/repo.20220101T000000.000Z/objects/ab/cdef",
+		"owner/repo/objects/ab/cdef",
+		"owner/repo/objects/ab/new.123.tmp",
+		"owner/repo/files/master/ab.123.tmp",
+		"owner/repo/files/master/dir.20220101T000000.000Z/file",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		os.MkdirAll(filepath.Dir(path), 0700)
+		ioutil.WriteFile(path, nil, 0600)
+		if "owner/repo/objects/ab/new.123.tmp" != p {
+			os.Chtimes(path, old, old)
+		}
+	}
+
+	removeOrphans(dir, time.Now().Add(-time.Hour))
+
+	for p, exists := range map[string]bool{
+		"@objects/objects/ab/cdef.123.tmp":                      false,
+		"owner/repo/objects/ab/cdef.123.tmp":                    false,
+		"owner/repo/archive/abcdef.zip.123.tmp":                 false,
+		"owner/repo.20220101T000000.000Z":                       false,
+		"owner/repo/objects/ab/cdef":                            true,
+		"owner/repo/objects/ab/new.123.tmp":                     true,
+		"owner/repo/files/master/ab.123.tmp":                    true,
+		"owner/repo/files/master/dir.20220101T000000.000Z/file": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p))); exists != (nil == err) {
+			t.Error(p, err)
+		}
+	}
+}
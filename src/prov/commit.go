@@ -0,0 +1,113 @@
+/*
+ * commit.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+	"strconv"
+)
+
+// GetCommits returns the commit history of a ref, most recent commit first. The history
+// is fetched from the provider page by page on first use. Since the history of a commit
+// never changes it is kept for the lifetime of the repository.
+func (r *gitRepository) GetCommits(ctx context.Context, ref Ref) (res []*Commit, err error) {
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	err = r.peel(ctx, ref)
+	if nil != err {
+		return nil, err
+	}
+	hash := ref.Hash()
+
+	r.lock.RLock()
+	res = r.commits[hash]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	res = make([]*Commit, 0)
+	for page := 1; ; page++ {
+		lst, more, err := r.api.getCommitPage(ctx, hash, page)
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		if !more {
+			break
+		}
+	}
+
+	r.lock.Lock()
+	if nil == r.commits {
+		r.commits = make(map[string][]*Commit)
+	}
+	r.commits[hash] = res
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetRecentCommits returns up to count of the most recent commits of a ref. Only the pages
+// of the history that are needed are fetched, unless GetCommits already has the full history.
+func (r *gitRepository) GetRecentCommits(ctx context.Context, ref Ref, count int) (
+	res []*Commit, err error) {
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	err = r.peel(ctx, ref)
+	if nil != err {
+		return nil, err
+	}
+	hash := ref.Hash()
+	key := hash + ":" + strconv.Itoa(count)
+
+	r.lock.RLock()
+	res = r.commits[hash]
+	if nil == res {
+		res = r.recent[key]
+	}
+	r.lock.RUnlock()
+	if nil != res {
+		if count < len(res) {
+			res = res[:count]
+		}
+		return res, nil
+	}
+
+	res = make([]*Commit, 0)
+	for page := 1; count > len(res); page++ {
+		lst, more, err := r.api.getCommitPage(ctx, hash, page)
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		if !more {
+			break
+		}
+	}
+	if count < len(res) {
+		res = res[:count]
+	}
+
+	r.lock.Lock()
+	if nil == r.recent {
+		r.recent = make(map[string][]*Commit)
+	}
+	r.recent[key] = res
+	r.lock.Unlock()
+	return res, nil
+}
@@ -0,0 +1,369 @@
+/*
+ * crypt.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Files in the cache directory are encrypted with AES-256-GCM when a cache key is set.
+// An encrypted file starts with a magic string and a random salt; the file key is derived
+// from the cache key and the salt with HMAC-SHA256. The content follows in chunks that are
+// sealed separately (so that they can be read at random), with the chunk index as the
+// nonce and a flag for the last chunk so that a truncated file does not go undetected.
+
+const (
+	cryptMagic      = "HUBFSCR1"
+	cryptSaltSize   = 32
+	cryptHeaderSize = len(cryptMagic) + cryptSaltSize
+	cryptChunkSize  = 64 * 1024
+	cryptOverhead   = 16
+)
+
+var errCryptFormat = errors.New("cache file is not encrypted or is corrupt")
+
+var cacheKey []byte
+
+// SetCacheKey sets the 32 byte key that encrypts the files in the cache directories of
+// all clients; a nil key disables encryption. It must be called before any client is
+// created.
+func SetCacheKey(key []byte) error {
+	if nil != key && 32 != len(key) {
+		return errors.New("invalid cache key size")
+	}
+	cacheKey = nil
+	if nil != key {
+		cacheKey = append([]byte(nil), key...)
+	}
+	return nil
+}
+
+func newCryptAead(salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, cacheKey)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if nil != err {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func cryptNonce(nonce []byte, index int64, last bool) []byte {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	if last {
+		nonce[0] = 1
+	}
+	return nonce
+}
+
+type cryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	buf   []byte
+	index int64
+}
+
+func newCryptWriter(w io.Writer) (*cryptWriter, error) {
+	header := make([]byte, cryptHeaderSize)
+	copy(header, cryptMagic)
+	_, err := rand.Read(header[len(cryptMagic):])
+	if nil != err {
+		return nil, err
+	}
+	aead, err := newCryptAead(header[len(cryptMagic):])
+	if nil != err {
+		return nil, err
+	}
+	_, err = w.Write(header)
+	if nil != err {
+		return nil, err
+	}
+	return &cryptWriter{
+		w:     w,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		buf:   make([]byte, 0, cryptChunkSize),
+	}, nil
+}
+
+func (cw *cryptWriter) Write(p []byte) (n int, err error) {
+	for 0 < len(p) {
+		/* a full chunk is only sealed once it is known not to be the last one */
+		if cryptChunkSize == len(cw.buf) {
+			err = cw.seal(false)
+			if nil != err {
+				return
+			}
+		}
+		k := cryptChunkSize - len(cw.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		cw.buf = append(cw.buf, p[:k]...)
+		n += k
+		p = p[k:]
+	}
+	return
+}
+
+func (cw *cryptWriter) seal(last bool) error {
+	_, err := cw.w.Write(cw.aead.Seal(nil, cryptNonce(cw.nonce, cw.index, last), cw.buf, nil))
+	cw.buf = cw.buf[:0]
+	cw.index++
+	return err
+}
+
+func (cw *cryptWriter) Close() error {
+	return cw.seal(true)
+}
+
+// cacheFile is a file in the cache directory.
+type cacheFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Size() (int64, error)
+}
+
+type plainFile struct {
+	*os.File
+}
+
+func (f plainFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if nil != err {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+type cryptFile struct {
+	file   *os.File
+	aead   cipher.AEAD
+	nonce  []byte
+	size   int64
+	last   int64
+	lock   sync.Mutex
+	index  int64
+	chunk  []byte
+	offset int64
+}
+
+func newCryptFile(file *os.File) (*cryptFile, error) {
+	header := make([]byte, cryptHeaderSize)
+	_, err := file.ReadAt(header, 0)
+	if nil != err || cryptMagic != string(header[:len(cryptMagic)]) {
+		return nil, errCryptFormat
+	}
+	info, err := file.Stat()
+	if nil != err {
+		return nil, err
+	}
+	size, last, err := cryptPlainSize(info.Size())
+	if nil != err {
+		return nil, err
+	}
+	aead, err := newCryptAead(header[len(cryptMagic):])
+	if nil != err {
+		return nil, err
+	}
+	f := &cryptFile{
+		file:  file,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		size:  size,
+		last:  last,
+		index: -1,
+	}
+
+	/* authenticate the last chunk, so that a file truncated at a chunk boundary is detected */
+	err = f.open(last)
+	if nil != err {
+		return nil, err
+	}
+	return f, nil
+}
+
+// cryptPlainSize computes the size of the content and the index of the last chunk of an
+// encrypted file from the size of the file.
+func cryptPlainSize(n int64) (size int64, last int64, err error) {
+	n -= int64(cryptHeaderSize)
+	full := int64(cryptChunkSize + cryptOverhead)
+	chunks := (n + full - 1) / full
+	if 0 >= chunks || cryptOverhead > n-(chunks-1)*full {
+		return 0, 0, errCryptFormat
+	}
+	return n - chunks*cryptOverhead, chunks - 1, nil
+}
+
+func (f *cryptFile) ReadAt(p []byte, off int64) (n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for len(p) > n && f.size > off {
+		index := off / cryptChunkSize
+		if index != f.index {
+			err = f.open(index)
+			if nil != err {
+				return
+			}
+		}
+		k := copy(p[n:], f.chunk[off-index*cryptChunkSize:])
+		n += k
+		off += int64(k)
+	}
+	if len(p) > n {
+		err = io.EOF
+	}
+	return
+}
+
+func (f *cryptFile) open(index int64) error {
+	buf := make([]byte, cryptChunkSize+cryptOverhead)
+	if f.last == index {
+		buf = buf[:f.size-index*cryptChunkSize+cryptOverhead]
+	}
+	_, err := f.file.ReadAt(buf, int64(cryptHeaderSize)+index*(cryptChunkSize+cryptOverhead))
+	if nil != err {
+		return err
+	}
+	chunk, err := f.aead.Open(buf[:0], cryptNonce(f.nonce, index, f.last == index), buf, nil)
+	if nil != err {
+		f.index = -1
+		return errCryptFormat
+	}
+	f.index = index
+	f.chunk = chunk
+	return nil
+}
+
+func (f *cryptFile) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if 0 < n && io.EOF == err {
+		err = nil
+	}
+	return
+}
+
+func (f *cryptFile) Size() (int64, error) {
+	return f.size, nil
+}
+
+func (f *cryptFile) Close() error {
+	return f.file.Close()
+}
+
+// openCacheFile opens a file in the cache directory for reading. An encrypted file
+// cannot be opened without the cache key and an unencrypted one cannot be opened with it,
+// so that the file is fetched again.
+func openCacheFile(path string) (cacheFile, error) {
+	file, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	if nil == cacheKey {
+		magic := make([]byte, len(cryptMagic))
+		if _, err := file.ReadAt(magic, 0); nil == err && cryptMagic == string(magic) {
+			file.Close()
+			return nil, errCryptFormat
+		}
+		return plainFile{file}, nil
+	}
+	f, err := newCryptFile(file)
+	if nil != err {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// createCacheFile creates a file in the cache directory with the content written by
+// fill. The file is written to a temporary file first, because another repository may
+// be writing the same file at the same time.
+func createCacheFile(path string, fill func(w io.Writer) error) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if nil != err {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if nil != err {
+		return err
+	}
+	if nil == cacheKey {
+		err = fill(file)
+	} else {
+		var cw *cryptWriter
+		cw, err = newCryptWriter(file)
+		if nil == err {
+			err = fill(cw)
+		}
+		if nil == err {
+			err = cw.Close()
+		}
+	}
+	if e := file.Close(); nil == err {
+		err = e
+	}
+	if nil == err {
+		err = os.Rename(file.Name(), path)
+	}
+	if nil != err {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// cacheFileSize returns the size of the content of a file in the cache directory.
+func cacheFileSize(path string) (int64, error) {
+	f, err := openCacheFile(path)
+	if nil != err {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Size()
+}
+
+// readCacheFile reads the content of a file in the cache directory.
+func readCacheFile(path string) ([]byte, error) {
+	f, err := openCacheFile(path)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+	size, err := f.Size()
+	if nil != err {
+		return nil, err
+	}
+	content := make([]byte, size)
+	_, err = f.ReadAt(content, 0)
+	if nil != err && io.EOF != err {
+		return nil, err
+	}
+	return content, nil
+}
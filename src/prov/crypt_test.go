@@ -0,0 +1,106 @@
+/*
+ * crypt_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheFileCrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer SetCacheKey(nil)
+	err = SetCacheKey(bytes.Repeat([]byte{42}, 32))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, cryptChunkSize - 1, cryptChunkSize, cryptChunkSize + 1, 3*cryptChunkSize + 7} {
+		content := make([]byte, size)
+		rand.Read(content)
+		path := filepath.Join(dir, "file")
+		err = createCacheFile(path, func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		if nil != err {
+			t.Fatal(err)
+		}
+
+		data, _ := ioutil.ReadFile(path)
+		if 0 < size && bytes.Contains(data, content) {
+			t.Error("content is not encrypted", size)
+		}
+		if n, err := cacheFileSize(path); nil != err || int64(size) != n {
+			t.Error(err, size, n)
+		}
+		if c, err := readCacheFile(path); nil != err || !bytes.Equal(content, c) {
+			t.Error(err, size)
+		}
+
+		f, err := openCacheFile(path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for i := 0; 20 > i && 0 < size; i++ {
+			off := rand.Intn(size)
+			buf := make([]byte, rand.Intn(2*cryptChunkSize))
+			n, err := f.ReadAt(buf, int64(off))
+			if (nil != err && io.EOF != err) || !bytes.Equal(content[off:off+n], buf[:n]) ||
+				(n < len(buf) && off+n != size) {
+				t.Error(err, size, off, n)
+			}
+		}
+		f.Close()
+
+		/* truncation and tampering are detected */
+		if 0 < size {
+			ioutil.WriteFile(path, data[:len(data)-1], 0600)
+			if _, err := readCacheFile(path); nil == err {
+				t.Error("truncation not detected", size)
+			}
+			data[len(data)-1] ^= 1
+			ioutil.WriteFile(path, data, 0600)
+			if _, err := readCacheFile(path); nil == err {
+				t.Error("tampering not detected", size)
+			}
+		}
+	}
+
+	path := filepath.Join(dir, "plain")
+	ioutil.WriteFile(path, []byte("plain text"), 0600)
+	if _, err := openCacheFile(path); errCryptFormat != err {
+		t.Error(err)
+	}
+
+	path = filepath.Join(dir, "crypt")
+	createCacheFile(path, func(w io.Writer) error {
+		_, err := w.Write([]byte("plain text"))
+		return err
+	})
+	SetCacheKey(nil)
+	if _, err := openCacheFile(path); errCryptFormat != err {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+ * diff.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+)
+
+// GetDiff returns the changes between two refs or commits as reported by the provider's
+// compare API. The base and head names are ref names (with '/' and not AltPathSeparator)
+// or commit hashes.
+func (r *gitRepository) GetDiff(ctx context.Context, base string, head string) (
+	res []byte, err error) {
+	r.lock.RLock()
+	res = r.diffs[base+".."+head]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getDiff(ctx, base, head)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	if nil == r.diffs {
+		r.diffs = make(map[string][]byte)
+	}
+	r.diffs[base+".."+head] = res
+	r.lock.Unlock()
+	return res, nil
+}
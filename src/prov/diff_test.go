@@ -0,0 +1,48 @@
+/*
+ * diff_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGitlabDiffFormat(t *testing.T) {
+	expect := func(d gitlabDiff, e string) {
+		var buf bytes.Buffer
+		d.format(&buf)
+		if e != buf.String() {
+			t.Errorf("expect %q got %q", e, buf.String())
+		}
+	}
+
+	expect(gitlabDiff{
+		OldPath: "a.txt", NewPath: "a.txt", AMode: "100644", BMode: "100644",
+		Diff: "@@ -1 +1 @@\n-x\n+y\n",
+	}, "diff --git a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-x\n+y\n")
+	expect(gitlabDiff{
+		OldPath: "n.txt", NewPath: "n.txt", AMode: "0", BMode: "100644",
+		Diff: "@@ -0,0 +1 @@\n+n", NewFile: true,
+	}, "diff --git a/n.txt b/n.txt\nnew file mode 100644\n"+
+		"--- /dev/null\n+++ b/n.txt\n@@ -0,0 +1 @@\n+n\n")
+	expect(gitlabDiff{
+		OldPath: "d.txt", NewPath: "d.txt", AMode: "100644", BMode: "0",
+		Diff: "@@ -1 +0,0 @@\n-d\n", DeletedFile: true,
+	}, "diff --git a/d.txt b/d.txt\ndeleted file mode 100644\n"+
+		"--- a/d.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-d\n")
+	expect(gitlabDiff{
+		OldPath: "o.sh", NewPath: "r.sh", AMode: "100644", BMode: "100755", RenamedFile: true,
+	}, "diff --git a/o.sh b/r.sh\nold mode 100644\nnew mode 100755\n"+
+		"rename from o.sh\nrename to r.sh\n")
+}
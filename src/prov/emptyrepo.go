@@ -14,6 +14,7 @@
 package prov
 
 import (
+	"context"
 	"io"
 )
 
@@ -48,34 +49,138 @@ func (*emptyRepositoryT) Name() string {
 	return ""
 }
 
-func (*emptyRepositoryT) GetRefs() ([]Ref, error) {
+func (*emptyRepositoryT) GetRefs(ctx context.Context) ([]Ref, error) {
 	return []Ref{}, nil
 }
 
-func (*emptyRepositoryT) GetRef(name string) (Ref, error) {
+func (*emptyRepositoryT) GetRef(ctx context.Context, name string) (Ref, error) {
 	return nil, ErrNotFound
 }
 
-func (*emptyRepositoryT) GetTempRef(name string) (Ref, error) {
+func (*emptyRepositoryT) GetTempRef(ctx context.Context, name string) (Ref, error) {
 	return nil, ErrNotFound
 }
 
-func (*emptyRepositoryT) GetTree(ref Ref, entry TreeEntry) ([]TreeEntry, error) {
+func (*emptyRepositoryT) GetDefaultRef(ctx context.Context) (Ref, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetLatestRef(ctx context.Context) (Ref, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetTree(ctx context.Context, ref Ref, entry TreeEntry) ([]TreeEntry, error) {
 	return []TreeEntry{}, nil
 }
 
-func (*emptyRepositoryT) GetTreeEntry(ref Ref, entry TreeEntry, name string) (TreeEntry, error) {
+func (*emptyRepositoryT) GetTreeEntry(ctx context.Context, ref Ref, entry TreeEntry, name string) (
+	TreeEntry, error) {
 	return nil, ErrNotFound
 }
 
-func (*emptyRepositoryT) GetBlobReader(entry TreeEntry) (io.ReaderAt, error) {
+func (*emptyRepositoryT) GetBlobReader(ctx context.Context, entry TreeEntry) (io.ReaderAt, error) {
 	return nil, ErrNotFound
 }
 
-func (*emptyRepositoryT) GetModule(ref Ref, path string, rootrel bool) (string, error) {
+func (*emptyRepositoryT) GetModule(ctx context.Context, ref Ref, path string, rootrel bool) (string, error) {
 	return "", ErrNotFound
 }
 
+func (*emptyRepositoryT) GetInfo(ctx context.Context) (*RepositoryInfo, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetReleases(ctx context.Context) ([]*Release, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetReleaseAssetReader(ctx context.Context, asset *ReleaseAsset) (
+	io.ReaderAt, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetIssues(ctx context.Context, state string) ([]*Issue, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetIssue(ctx context.Context, number int) (*Issue, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetPullRequests(ctx context.Context) ([]*PullRequest, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetPullRequest(ctx context.Context, number int) (*PullRequest, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetPullRequestPatch(ctx context.Context, number int) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetWiki(ctx context.Context) (Repository, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetCommits(ctx context.Context, ref Ref) ([]*Commit, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetRecentCommits(ctx context.Context, ref Ref, count int) ([]*Commit, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetArchiveReader(ctx context.Context, ref Ref, format string) (
+	io.ReaderAt, int64, error) {
+	return nil, 0, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetObject(ctx context.Context, hash string) (string, []byte, error) {
+	return "", nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetBlame(ctx context.Context, ref Ref, path string) ([]*BlameRange, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetDiff(ctx context.Context, base string, head string) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetWorkflows(ctx context.Context) ([]*Workflow, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetWorkflowRuns(ctx context.Context, workflow *Workflow) (
+	[]*WorkflowRun, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetWorkflowRun(ctx context.Context, id int64) (*WorkflowRun, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetWorkflowJobs(ctx context.Context, run *WorkflowRun) (
+	[]*WorkflowJob, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetWorkflowJobLogReader(ctx context.Context, job *WorkflowJob) (
+	io.ReaderAt, int64, error) {
+	return nil, 0, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetArtifacts(ctx context.Context, run *WorkflowRun) (
+	[]*Artifact, error) {
+	return nil, ErrNotFound
+}
+
+func (*emptyRepositoryT) GetArtifactReader(ctx context.Context, artifact *Artifact) (
+	io.ReaderAt, int64, error) {
+	return nil, 0, ErrNotFound
+}
+
 func init() {
 	emptyRepository = &emptyRepositoryT{}
 }
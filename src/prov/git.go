@@ -15,58 +15,137 @@ package prov
 
 import (
 	"bytes"
-	"encoding/hex"
+	"context"
 	"io"
-	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/billziss-gh/golib/config"
 	"github.com/winfsp/hubfs/git"
+	"github.com/winfsp/hubfs/httputil"
+	"github.com/winfsp/hubfs/util"
 )
 
+type GitConfig struct {
+	Caseins    bool
+	Fullrefs   bool
+	Nestedrefs bool
+	Pullrefs   bool
+	Lfs        bool
+	Mirror     bool
+	Partial    bool
+	Treetime   string    // tree time source: "commit" (default), "author" or "mount"
+	Mounttime  time.Time // tree time when Treetime is "mount"
+	Refs       []string
+	Pins       func() map[string]string
+	Cachepin   func(ref string) bool
+}
+
 type gitRepository struct {
-	remote   string
-	username string
-	password string
-	caseins  bool
-	fullrefs bool
-	once     sync.Once
-	repo     *git.Repository
-	lock     sync.RWMutex
-	refs     map[string]*gitRef
-	dir      string
+	remote      string
+	credentials func() (string, string)
+	caseins     bool
+	fullrefs    bool
+	nestedrefs  bool
+	pullrefs    bool
+	lfs         bool
+	mirror      bool
+	partial     bool
+	treetime    string
+	mounttime   time.Time
+	refpatts    []string
+	pins        func() map[string]string
+	cachepin    func(ref string) bool
+	once        sync.Once
+	repo        *git.Repository
+	lock        sync.RWMutex
+	mirrorrepo  *git.Mirror
+	refs        map[string]*gitRef
+	pinrefs     map[string]*gitRef
+	dir         string
+	blobdir     string // shared by all repositories of a client
+	api         repositoryApi
+	infores     *RepositoryInfo
+	releases    []*Release
+	issues      map[string][]*Issue
+	issuemap    map[int]*Issue
+	pulls       []*PullRequest
+	pullmap     map[int]*PullRequest
+	patchmap    map[int][]byte
+	wiki        *gitRepository
+	commits     map[string][]*Commit
+	recent      map[string][]*Commit
+	blames      map[string][]*BlameRange
+	archives    *util.ByteCache
+	diffs       map[string][]byte
+	objects     map[string]*gitObject
+	workflows   []*Workflow
+	runs        map[int64][]*WorkflowRun
+	runmap      map[int64]*WorkflowRun
+	jobs        map[int64][]*WorkflowJob
+	artifacts   map[int64][]*Artifact
+	actions     map[string][]byte
+}
+
+// repositoryApi gives a git repository access to the provider API that it was opened from.
+type repositoryApi interface {
+	resolveCommit(ctx context.Context, abbrev string) (string, error)
+	getInfo(ctx context.Context) (*RepositoryInfo, error)
+	getReleases(ctx context.Context) ([]*Release, error)
+	download(ctx context.Context, url string, w io.Writer) error
+	getIssuePage(ctx context.Context, state string, page int) ([]*Issue, bool, error)
+	getIssue(ctx context.Context, number int) (*Issue, error)
+	getPullRequestPage(ctx context.Context, page int) ([]*PullRequest, bool, error)
+	getPullRequest(ctx context.Context, number int) (*PullRequest, error)
+	getPullRequestPatch(ctx context.Context, number int) ([]byte, error)
+	getCommitPage(ctx context.Context, hash string, page int) ([]*Commit, bool, error)
+	getBlame(ctx context.Context, hash string, path string) ([]*BlameRange, error)
+	getArchive(ctx context.Context, hash string, format string, w io.Writer) error
+	getBlob(ctx context.Context, hash string, w io.Writer) error
+	getDiff(ctx context.Context, base string, head string) ([]byte, error)
+	getWorkflows(ctx context.Context) ([]*Workflow, error)
+	getWorkflowRuns(ctx context.Context, workflow int64) ([]*WorkflowRun, error)
+	getWorkflowRun(ctx context.Context, id int64) (*WorkflowRun, error)
+	getWorkflowJobs(ctx context.Context, run int64) ([]*WorkflowJob, error)
+	getWorkflowJobLog(ctx context.Context, job int64, w io.Writer) error
+	getArtifacts(ctx context.Context, run int64) ([]*Artifact, error)
 }
 
 type gitRef struct {
+	used       int64 // first field for 64-bit alignment of atomic operations
 	name       string
+	fullname   string
 	kind       RefKind
 	targetHash string
+	commitHash string
 	tree       map[string]*gitTreeEntry
 	treeTime   time.Time
 	modules    map[string]string
 }
 
+func (ref *gitRef) touch() {
+	atomic.StoreInt64(&ref.used, time.Now().UnixNano())
+}
+
 type gitTreeEntry struct {
 	entry  git.TreeEntry
 	size   int64
 	target string
+	lfsoid string
 	tree   map[string]*gitTreeEntry
 }
 
 func NewGitRepository(
-	remote string, username string, password string, caseins bool, fullrefs bool) (Repository, error) {
-	r := &gitRepository{
-		remote:   remote,
-		username: username,
-		password: password,
-		caseins:  caseins,
-	}
+	remote string, username string, password string, config GitConfig) (Repository, error) {
+	r := newGitRepository(remote, func() (string, string) {
+		return username, password
+	}, config)
 
 	var err error
 	r.once.Do(func() { err = r.open() })
@@ -77,26 +156,46 @@ func NewGitRepository(
 	return r, nil
 }
 
+// newGitRepository creates a repository whose credentials are obtained from the
+// credentials function whenever they are needed, so that they can change over time.
 func newGitRepository(
-	remote string, username string, password string, caseins bool, fullrefs bool) Repository {
+	remote string, credentials func() (string, string), config GitConfig) *gitRepository {
 	return &gitRepository{
-		remote:   remote,
-		username: username,
-		password: password,
-		caseins:  caseins,
-		fullrefs: fullrefs,
+		remote:      remote,
+		credentials: credentials,
+		caseins:     config.Caseins,
+		fullrefs:    config.Fullrefs,
+		nestedrefs:  config.Nestedrefs,
+		pullrefs:    config.Pullrefs,
+		lfs:         config.Lfs,
+		mirror:      config.Mirror,
+		partial:     config.Partial,
+		treetime:    config.Treetime,
+		mounttime:   config.Mounttime,
+		refpatts:    config.Refs,
+		pins:        config.Pins,
+		cachepin:    config.Cachepin,
 	}
 }
 
 func (r *gitRepository) open() (err error) {
-	r.repo, err = git.OpenRepository(r.remote, r.username, r.password)
+	r.repo, err = git.OpenRepositoryWithCredentials(r.remote, r.credentials)
 	return
 }
 
 func (r *gitRepository) Close() (err error) {
+	if nil != r.wiki {
+		r.wiki.Close()
+	}
 	if nil != r.repo {
 		err = r.repo.Close()
 	}
+	r.lock.Lock()
+	if nil != r.mirrorrepo {
+		r.mirrorrepo.Close()
+		r.mirrorrepo = nil
+	}
+	r.lock.Unlock()
 	return
 }
 
@@ -121,12 +220,33 @@ func (r *gitRepository) SetDirectory(path string) (err error) {
 	return
 }
 
+// _blobDirectory returns the directory that blobs are kept in. Blobs are kept by content
+// in the directory that is shared by all repositories of a client (if there is one),
+// so that forks share them. It must be called with the lock held.
+func (r *gitRepository) _blobDirectory() string {
+	if "" != r.dir && "" != r.blobdir {
+		return r.blobdir
+	}
+	return r.dir
+}
+
+// cachePinned determines if the content of a ref is pinned in the cache. The blobs of a
+// pinned ref are kept in the directory of the repository rather than the shared one, so
+// that they are not removed along with the shared blobs.
+func (r *gitRepository) cachePinned(ref *gitRef) bool {
+	return nil != r.cachepin && nil != ref && r.cachepin(ref.name)
+}
+
 func (r *gitRepository) RemoveDirectory() (err error) {
 	r.lock.Lock()
 	if "" == r.dir {
 		r.lock.Unlock()
 		return
 	}
+	if nil != r.mirrorrepo {
+		r.mirrorrepo.Close()
+		r.mirrorrepo = nil
+	}
 	tmpdir := r.dir + time.Now().Format(".20060102T150405.000Z")
 	err = os.Rename(r.dir, tmpdir)
 	if nil == err {
@@ -139,6 +259,82 @@ func (r *gitRepository) RemoveDirectory() (err error) {
 	return
 }
 
+// openMirror returns the mirror of the repository (see GitConfig.Mirror), which is kept in
+// the cache directory of the repository, or nil if there is none. There is no mirror
+// without a cache directory or when the cache is encrypted, because the mirror is a
+// regular (unencrypted) bare repository.
+func (r *gitRepository) openMirror() *git.Mirror {
+	if !r.mirror || nil != cacheKey {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if nil == r.mirrorrepo && "" != r.dir {
+		m, err := git.OpenMirror(filepath.Join(r.dir, "mirror.git"))
+		if nil != err {
+			tracef("repo=%#v OpenMirror: %v", r.remote, err)
+			return nil
+		}
+		r.mirrorrepo = m
+	}
+	return r.mirrorrepo
+}
+
+// syncMirror fetches the commit of a ref into the mirror (if there is one), unless the
+// mirror has it already. Errors are ignored, because objects that are not in the mirror
+// are fetched from the remote one at a time.
+func (r *gitRepository) syncMirror(ctx context.Context, ref *gitRef) {
+	m := r.openMirror()
+	if nil == m || m.HasObject(ref.targetHash) {
+		return
+	}
+	err := r.repo.FetchMirror(ctx, m, ref.fullname, ref.targetHash)
+	if nil != err {
+		tracef("repo=%#v FetchMirror(%#v, %s): %v", r.remote, ref.fullname, ref.targetHash, err)
+	}
+}
+
+// fetchTrees fetches all trees of a commit into the directory in a single pack (unless
+// the root tree is there already), so that the subdirectories of the commit are listed
+// without fetching their trees one request at a time. Errors are ignored, because trees
+// are otherwise fetched when they are needed.
+func (r *gitRepository) fetchTrees(ctx context.Context, dir string, commit string, tree string) {
+	if !r.partial || "" == dir || nil != r.openMirror() {
+		return
+	}
+	if _, err := os.Stat(objectPath(dir, tree)); nil == err {
+		return
+	}
+	err := r.repo.FetchTrees(ctx, commit, func(hash string, ot git.ObjectType, content []byte) error {
+		if git.TreeObject == ot {
+			writeObject(dir, hash, content)
+		}
+		return nil
+	})
+	if nil != err {
+		tracef("repo=%#v FetchTrees(%s): %v", r.remote, commit, err)
+	}
+}
+
+// readMirror calls fn for the wanted objects that are in the mirror (if there is one)
+// and returns the objects that are not.
+func (r *gitRepository) readMirror(want []string, fn func(m *git.Mirror, hash string) error) (
+	[]string, error) {
+	m := r.openMirror()
+	if nil == m {
+		return want, nil
+	}
+	w := make([]string, 0, len(want))
+	for _, hash := range want {
+		if !m.HasObject(hash) {
+			w = append(w, hash)
+		} else if err := fn(m, hash); nil != err {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
 func objectPath(dir string, hash string) string {
 	if 2 < len(hash) {
 		return filepath.Join(dir, "objects", hash[:2], hash[2:])
@@ -146,17 +342,27 @@ func objectPath(dir string, hash string) string {
 	return ""
 }
 
+// writeObject writes an object to a directory.
 func writeObject(dir string, hash string, content []byte) {
-	p := objectPath(dir, hash)
-	if nil == os.MkdirAll(filepath.Dir(p), 0700) {
-		err := ioutil.WriteFile(p+".tmp", content, 0700)
-		if nil == err {
-			err = os.Rename(p+".tmp", p)
-		}
-		if nil != err {
-			os.Remove(p + ".tmp")
-		}
+	createCacheFile(objectPath(dir, hash), func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// fetchFile returns a reader for the file at path. If the file does not exist it is
+// first created with the content written by fetch.
+func fetchFile(path string, fetch func(w io.Writer) error) (cacheFile, error) {
+	if file, err := openCacheFile(path); nil == err {
+		return file, nil
+	}
+
+	err := createCacheFile(path, fetch)
+	if nil != err {
+		return nil, err
 	}
+
+	return openCacheFile(path)
 }
 
 func containsString(l []string, s string) bool {
@@ -168,9 +374,20 @@ func containsString(l []string, s string) bool {
 	return false
 }
 
-func (r *gitRepository) prefetchObjects(dir string, want []string,
+func (r *gitRepository) prefetchObjects(ctx context.Context, dir string, want []string,
 	fn func(hash string, size int64) error) error {
 
+	want, err := r.readMirror(want, func(m *git.Mirror, hash string) error {
+		size, err := m.ObjectSize(hash)
+		if nil != err {
+			return err
+		}
+		return fn(hash, size)
+	})
+	if nil != err {
+		return err
+	}
+
 	if 0 == len(want) {
 		return nil
 	}
@@ -178,11 +395,11 @@ func (r *gitRepository) prefetchObjects(dir string, want []string,
 	if "" != dir {
 		w := make([]string, 0, len(want))
 		for _, hash := range want {
-			info, err := os.Stat(objectPath(dir, hash))
+			size, err := cacheFileSize(objectPath(dir, hash))
 			if nil != err {
 				w = append(w, hash)
 			} else {
-				err = fn(hash, info.Size())
+				err = fn(hash, size)
 				if nil != err {
 					return err
 				}
@@ -194,19 +411,19 @@ func (r *gitRepository) prefetchObjects(dir string, want []string,
 			return nil
 		}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			writeObject(dir, hash, content)
 			if !containsString(want, hash) {
 				return nil
 			}
-			info, err := os.Stat(objectPath(dir, hash))
+			size, err := cacheFileSize(objectPath(dir, hash))
 			if nil != err {
 				return err
 			}
-			return fn(hash, info.Size())
+			return fn(hash, size)
 		})
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -215,9 +432,20 @@ func (r *gitRepository) prefetchObjects(dir string, want []string,
 	}
 }
 
-func (r *gitRepository) fetchObjects(dir string, want []string,
+func (r *gitRepository) fetchObjects(ctx context.Context, dir string, want []string,
 	fn func(hash string, content []byte) error) error {
 
+	want, err := r.readMirror(want, func(m *git.Mirror, hash string) error {
+		_, content, err := m.ReadObject(hash)
+		if nil != err {
+			return err
+		}
+		return fn(hash, content)
+	})
+	if nil != err {
+		return err
+	}
+
 	if 0 == len(want) {
 		return nil
 	}
@@ -225,7 +453,7 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 	if "" != dir {
 		w := make([]string, 0, len(want))
 		for _, hash := range want {
-			content, err := ioutil.ReadFile(objectPath(dir, hash))
+			content, err := readCacheFile(objectPath(dir, hash))
 			if nil != err {
 				w = append(w, hash)
 			} else {
@@ -241,7 +469,7 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 			return nil
 		}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			writeObject(dir, hash, content)
 			if !containsString(want, hash) {
 				return nil
@@ -249,7 +477,7 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 			return fn(hash, content)
 		})
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -258,7 +486,7 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 	}
 }
 
-func (r *gitRepository) refetchObjects(dir string, want []string,
+func (r *gitRepository) refetchObjects(ctx context.Context, dir string, want []string,
 	fn func(hash string, ot git.ObjectType) error) error {
 
 	if 0 == len(want) {
@@ -266,7 +494,7 @@ func (r *gitRepository) refetchObjects(dir string, want []string,
 	}
 
 	if "" != dir {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			writeObject(dir, hash, content)
 			if !containsString(want, hash) {
 				return nil
@@ -274,7 +502,7 @@ func (r *gitRepository) refetchObjects(dir string, want []string,
 			return fn(hash, ot)
 		})
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -296,9 +524,20 @@ func (readerAtNopCloser) Close() error {
 	return nil
 }
 
-func (r *gitRepository) fetchReaders(dir string, want []string,
+func (r *gitRepository) fetchReaders(ctx context.Context, dir string, want []string,
 	fn func(hash string, reader io.ReaderAt) error) error {
 
+	want, err := r.readMirror(want, func(m *git.Mirror, hash string) error {
+		_, content, err := m.ReadObject(hash)
+		if nil != err {
+			return err
+		}
+		return fn(hash, readerAtNopCloser{bytes.NewReader(content)})
+	})
+	if nil != err {
+		return err
+	}
+
 	if 0 == len(want) {
 		return nil
 	}
@@ -306,7 +545,7 @@ func (r *gitRepository) fetchReaders(dir string, want []string,
 	if "" != dir {
 		w := make([]string, 0, len(want))
 		for _, hash := range want {
-			reader, err := os.Open(objectPath(dir, hash))
+			reader, err := openCacheFile(objectPath(dir, hash))
 			if nil != err {
 				w = append(w, hash)
 			} else {
@@ -322,19 +561,19 @@ func (r *gitRepository) fetchReaders(dir string, want []string,
 			return nil
 		}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			writeObject(dir, hash, content)
 			if !containsString(want, hash) {
 				return nil
 			}
-			reader, err := os.Open(objectPath(dir, hash))
+			reader, err := openCacheFile(objectPath(dir, hash))
 			if nil != err {
 				return err
 			}
 			return fn(hash, reader)
 		})
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		return r.repo.FetchObjects(ctx, want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -369,8 +608,37 @@ func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error
 
 	refs := make(map[string]*gitRef)
 	for n, h := range m {
+		fullname := n
+		if 0 < len(r.refpatts) && !refMatch(r.refpatts, n, r.caseins) {
+			continue
+		}
+
 		kind := RefOther
-		if strings.HasPrefix(n, "refs/heads/") {
+		if strings.HasPrefix(n, "refs/pull/") {
+			if !r.pullrefs {
+				if !r.fullrefs {
+					continue
+				}
+			} else if r.nestedrefs {
+				n = n[len("refs/"):]
+				kind = RefPull
+			} else if !r.fullrefs {
+				n = pullRefName(n)
+				if "" == n {
+					continue
+				}
+				kind = RefPull
+			}
+		} else if r.nestedrefs {
+			if strings.HasPrefix(n, "refs/heads/") {
+				kind = RefBranch
+			} else if strings.HasPrefix(n, "refs/tags/") {
+				kind = RefTag
+			} else {
+				continue
+			}
+			n = n[len("refs/"):]
+		} else if strings.HasPrefix(n, "refs/heads/") {
 			if !r.fullrefs {
 				n = n[len("refs/heads/"):]
 			}
@@ -385,19 +653,24 @@ func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error
 				continue
 			}
 		}
-		n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
+		if !r.nestedrefs {
+			n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
+		}
 
 		k := n
 		if r.caseins {
 			k = strings.ToUpper(k)
 		}
 
-		if ref := refs[k]; nil != ref && kind >= ref.kind {
+		/* refs that differ only by case are resolved the same way on every fetch */
+		if ref := refs[k]; nil != ref &&
+			(kind > ref.kind || (kind == ref.kind && n >= ref.name)) {
 			continue
 		}
 
 		refs[k] = &gitRef{
 			name:       n,
+			fullname:   fullname,
 			kind:       kind,
 			targetHash: h,
 		}
@@ -412,16 +685,141 @@ func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error
 	return err
 }
 
-func (r *gitRepository) GetRefs() (res []Ref, err error) {
+// refMatch determines if a ref is available under a list of ref patterns. Patterns are
+// matched against the branch or tag name (or the ref name without refs/ for other refs).
+// A ref is available if it matches an include pattern (or there are none) and does not
+// match an exclude (-) pattern.
+func refMatch(patts []string, n string, caseins bool) bool {
+	switch {
+	case strings.HasPrefix(n, "refs/heads/"):
+		n = n[len("refs/heads/"):]
+	case strings.HasPrefix(n, "refs/tags/"):
+		n = n[len("refs/tags/"):]
+	default:
+		n = strings.TrimPrefix(n, "refs/")
+	}
+	if caseins {
+		n = strings.ToUpper(n)
+	}
+
+	include, included := false, false
+	for _, p := range patts {
+		sign := byte('+')
+		if strings.HasPrefix(p, "+") || strings.HasPrefix(p, "-") {
+			sign, p = p[0], p[1:]
+		}
+		if caseins {
+			p = strings.ToUpper(p)
+		}
+		m, _ := path.Match(p, n)
+		if '-' == sign {
+			if m {
+				return false
+			}
+		} else {
+			include = true
+			included = included || m
+		}
+	}
+	return !include || included
+}
+
+// pullRefName converts refs/pull/N/head to pr-N and refs/pull/N/merge to pr-N-merge.
+func pullRefName(n string) string {
+	lst := strings.Split(n, "/")
+	if 4 != len(lst) {
+		return ""
+	}
+	for _, c := range lst[2] {
+		if '0' > c || c > '9' {
+			return ""
+		}
+	}
+	switch lst[3] {
+	case "head":
+		return "pr-" + lst[2]
+	case "merge":
+		return "pr-" + lst[2] + "-merge"
+	}
+	return ""
+}
+
+type gitPin struct {
+	name string
+	hash string
+}
+
+// getPins returns the refs that are pinned to commits, keyed like the refs map.
+func (r *gitRepository) getPins() map[string]gitPin {
+	if nil == r.pins {
+		return nil
+	}
+	res := make(map[string]gitPin)
+	for n, h := range r.pins() {
+		if !r.nestedrefs {
+			n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
+		}
+		k := n
+		if r.caseins {
+			k = strings.ToUpper(k)
+		}
+		res[k] = gitPin{name: n, hash: h}
+	}
+	return res
+}
+
+// pinRef returns a ref that is pinned to a commit in place of the ref with the same name
+// (which may not exist). Pinned refs are kept, so that their trees are fetched only once.
+func (r *gitRepository) pinRef(k string, ref *gitRef, pin gitPin) *gitRef {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if p := r.pinrefs[k]; nil != p && pin.hash == p.targetHash {
+		return p
+	}
+	p := &gitRef{
+		name:       pin.name,
+		kind:       RefBranch,
+		targetHash: pin.hash,
+	}
+	if nil != ref {
+		p.name = ref.name
+		p.kind = ref.kind
+	}
+	if nil == r.pinrefs {
+		r.pinrefs = make(map[string]*gitRef)
+	}
+	r.pinrefs[k] = p
+	return p
+}
+
+func (r *gitRepository) pinRefs(refs []Ref) []Ref {
+	pins := r.getPins()
+	if 0 == len(pins) {
+		return refs
+	}
+	for i, ref := range refs {
+		k := ref.Name()
+		if r.caseins {
+			k = strings.ToUpper(k)
+		}
+		if pin, ok := pins[k]; ok {
+			refs[i] = r.pinRef(k, ref.(*gitRef), pin)
+		}
+	}
+	return refs
+}
+
+func (r *gitRepository) GetRefs(ctx context.Context) (res []Ref, err error) {
 	err = r.ensureRefs(func(refs map[string]*gitRef) error {
 		res = make([]Ref, 0, len(refs))
-		if r.fullrefs {
+		if r.fullrefs || r.nestedrefs {
 			for _, e := range refs {
 				res = append(res, e)
 			}
 		} else {
 			for _, e := range refs {
-				if RefBranch != e.kind {
+				if RefBranch != e.kind && RefPull != e.kind {
 					continue
 				}
 				res = append(res, e)
@@ -429,54 +827,193 @@ func (r *gitRepository) GetRefs() (res []Ref, err error) {
 		}
 		return nil
 	})
+	if nil == err {
+		res = r.pinRefs(res)
+	}
 	return
 }
 
-func (r *gitRepository) GetRef(name string) (res Ref, err error) {
+func (r *gitRepository) GetRef(ctx context.Context, name string) (res Ref, err error) {
 	k := name
 	if r.caseins {
 		k = strings.ToUpper(k)
 	}
 
+	var ref *gitRef
 	err = r.ensureRefs(func(refs map[string]*gitRef) error {
 		var ok bool
-		res, ok = refs[k]
+		ref, ok = refs[k]
 		if !ok {
 			return ErrNotFound
 		}
 		return nil
 	})
-	return
+	if nil != r.repo {
+		/* a pinned ref is available even if it no longer exists */
+		if pin, ok := r.getPins()[k]; ok {
+			return r.pinRef(k, ref, pin), nil
+		}
+	}
+	if nil != err {
+		return nil, err
+	}
+	return ref, nil
 }
 
-func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
-	_, err = hex.DecodeString(name)
+func (r *gitRepository) GetDefaultRef(ctx context.Context) (res Ref, err error) {
+	r.once.Do(func() { r.open() })
+	if nil == r.repo {
+		return nil, ErrNotFound
+	}
+
+	n, err := r.repo.GetHead()
 	if nil != err {
 		return nil, ErrNotFound
 	}
 
+	if r.nestedrefs {
+		n = strings.TrimPrefix(n, "refs/")
+	} else {
+		if !r.fullrefs {
+			n = strings.TrimPrefix(n, "refs/heads/")
+		}
+		n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
+	}
+
+	return r.GetRef(ctx, n)
+}
+
+// GetLatestRef returns the tag with the highest semantic version.
+// Prerelease versions are only considered if there are no release versions.
+func (r *gitRepository) GetLatestRef(ctx context.Context) (res Ref, err error) {
+	err = r.ensureRefs(func(refs map[string]*gitRef) error {
+		var latest *gitRef
+		var latestver util.Semver
+		for _, ref := range refs {
+			if RefTag != ref.kind {
+				continue
+			}
+			n := strings.TrimPrefix(ref.name, "tags/")
+			n = strings.TrimPrefix(n, "refs"+string(AltPathSeparator)+"tags"+string(AltPathSeparator))
+			v, ok := util.ParseSemver(n)
+			if !ok {
+				continue
+			}
+			if nil != latest {
+				isrel, latestrel := 0 == len(v.Prerelease), 0 == len(latestver.Prerelease)
+				if latestrel && !isrel {
+					continue
+				}
+				if latestrel == isrel && 0 >= v.Compare(latestver) {
+					continue
+				}
+			}
+			latest, latestver = ref, v
+		}
+		if nil == latest {
+			return ErrNotFound
+		}
+		res = latest
+		return nil
+	})
+	if nil == err {
+		res = r.pinRefs([]Ref{res})[0]
+	}
+	return
+}
+
+// treeTime returns the time of the files of a commit according to the tree time source.
+func (r *gitRepository) treeTime(c *git.Commit) time.Time {
+	switch r.treetime {
+	case "author":
+		return c.Author.Time
+	case "mount":
+		return r.mounttime
+	}
+	return c.Committer.Time
+}
+
+// minHashLen is the minimum length of an abbreviated commit hash. Shorter hex-like names
+// (e.g. "add" or "cafe") are common ref and file names and are not looked up as commits.
+const minHashLen = 7
+
+func isHash(name string) bool {
+	if minHashLen > len(name) || 40 < len(name) {
+		return false
+	}
+	for i := 0; len(name) > i; i++ {
+		c := name[i]
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') && !('A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *gitRepository) resolveHash(ctx context.Context, name string) (hash string, err error) {
+	if 40 == len(name) {
+		return strings.ToLower(name), nil
+	}
+
+	prefix := strings.ToLower(name)
+	err = r.ensureRefs(func(refs map[string]*gitRef) error {
+		for _, ref := range refs {
+			if strings.HasPrefix(ref.targetHash, prefix) {
+				if "" != hash && hash != ref.targetHash {
+					return ErrNotFound
+				}
+				hash = ref.targetHash
+			}
+		}
+		return nil
+	})
+	if nil != err || "" != hash {
+		return
+	}
+
+	if nil == r.api {
+		return "", ErrNotFound
+	}
+	hash, err = r.api.resolveCommit(ctx, prefix)
+	if nil == err && !strings.HasPrefix(hash, prefix) {
+		hash, err = "", ErrNotFound
+	}
+	return
+}
+
+func (r *gitRepository) GetTempRef(ctx context.Context, name string) (res Ref, err error) {
+	if !isHash(name) {
+		return nil, ErrNotFound
+	}
+
 	k := name
 	if r.caseins {
 		k = strings.ToUpper(k)
 	}
 
 	err = r.ensureRefs(func(refs map[string]*gitRef) error {
-		var ok bool
-		res, ok = refs[k]
+		ref, ok := refs[k]
 		if !ok {
 			return ErrNotFound
 		}
+		ref.touch()
+		res = ref
 		return nil
 	})
 	if nil == err {
 		return
 	}
 
+	hash, err := r.resolveHash(ctx, name)
+	if nil != err {
+		return
+	}
+
 	r.lock.RLock()
 	dir := r.dir
 	r.lock.RUnlock()
 
-	err = r.refetchObjects(dir, []string{name}, func(hash string, ot git.ObjectType) error {
+	err = r.refetchObjects(ctx, dir, []string{hash}, func(hash string, ot git.ObjectType) error {
 		if git.CommitObject != ot {
 			return ErrNotFound
 		}
@@ -489,8 +1026,9 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 	ref := &gitRef{
 		name:       strings.ToLower(name),
 		kind:       RefTemp,
-		targetHash: name,
+		targetHash: hash,
 	}
+	ref.touch()
 	r.lock.Lock()
 	r.refs[k] = ref
 	r.lock.Unlock()
@@ -498,8 +1036,12 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 	return ref, nil
 }
 
-func (r *gitRepository) ensureTree(
-	ref0 Ref, entry0 TreeEntry, fn func(tree map[string]*gitTreeEntry) error) error {
+// ensureTree fetches a tree (unless it has been fetched already) and calls fn with it.
+// When the tree is fetched, emit (if not nil) is called for every entry as soon as its
+// attributes are known; it is not called when the tree has been fetched already.
+func (r *gitRepository) ensureTree(ctx context.Context,
+	ref0 Ref, entry0 TreeEntry, emit func(e *gitTreeEntry),
+	fn func(tree map[string]*gitTreeEntry) error) error {
 	r.once.Do(func() { r.open() })
 	if nil == r.repo {
 		return ErrNotFound
@@ -510,6 +1052,9 @@ func (r *gitRepository) ensureTree(
 	if ok && 0040000 != entry.entry.Mode {
 		return ErrNotFound
 	}
+	if nil != ref {
+		ref.touch()
+	}
 
 	r.lock.RLock()
 	if nil == entry {
@@ -526,44 +1071,61 @@ func (r *gitRepository) ensureTree(
 		}
 	}
 	dir := r.dir
+	blobdir := r._blobDirectory()
 	r.lock.RUnlock()
+	if "" != dir && r.cachePinned(ref) {
+		blobdir = dir
+	}
 
 	var treeTime time.Time
+	var commit string
 	want := []string{""}
 	if nil == entry {
-		h := ""
-		f := func(hash string, content []byte) error {
-			c, err := git.DecodeCommit(content)
-			if nil != err {
-				return err
+		r.syncMirror(ctx, ref)
+		h := ref.targetHash
+		for i := 0; ; i++ {
+			/* peel annotated tags (possibly pointing to other tags) until we reach a commit */
+			if 8 == i {
+				return ErrNotFound
 			}
-			treeTime = c.Committer.Time
-			want[0] = c.TreeHash
-			return nil
-		}
-		err := r.fetchObjects(dir, []string{ref.targetHash}, func(hash string, content []byte) error {
-			if bytes.HasPrefix(content, []byte("object ")) {
-				t, err := git.DecodeTag(content)
+			peeled := false
+			err := r.fetchObjects(ctx, dir, []string{h}, func(hash string, content []byte) error {
+				if bytes.HasPrefix(content, []byte("object ")) {
+					t, err := git.DecodeTag(content)
+					if nil != err {
+						return err
+					}
+					h = t.TargetHash
+					peeled = true
+					return nil
+				}
+				c, err := git.DecodeCommit(content)
 				if nil != err {
 					return err
 				}
-				h = t.TargetHash
+				treeTime = r.treeTime(c)
+				commit = hash
+				want[0] = c.TreeHash
 				return nil
+			})
+			if nil != err {
+				return err
+			}
+			if !peeled {
+				break
 			}
-			return f(hash, content)
-		})
-		if nil == err && "" != h {
-			err = r.fetchObjects(dir, []string{h}, f)
-		}
-		if nil != err {
-			return err
 		}
+		r.fetchTrees(ctx, dir, commit, want[0])
 	} else {
 		want[0] = entry.entry.Hash
 	}
 
+	if nil == emit {
+		emit = func(e *gitTreeEntry) {}
+	}
+
 	tree := make(map[string]*gitTreeEntry)
-	err := r.fetchObjects(dir, want, func(hash string, content []byte) error {
+	err := r.fetchObjects(ctx, dir, want, func(hash string, content []byte) error {
 		t, err := git.DecodeTree(content)
 		if nil != err {
 			return err
@@ -582,6 +1144,22 @@ func (r *gitRepository) ensureTree(
 		return err
 	}
 
+	/* directories and submodules are complete; files are complete once they are sized */
+	for _, e := range tree {
+		if 0040000 == e.entry.Mode {
+			emit(e)
+		} else if 0160000 == e.entry.Mode {
+			e.target = e.entry.Hash
+			e.size = int64(len(e.target))
+			emit(e)
+		}
+	}
+	sizedEmit := func(e *gitTreeEntry) {
+		if 0100000 == e.entry.Mode&0170000 && !(r.lfs && lfsPointerMaxSize > e.size) {
+			emit(e)
+		}
+	}
+
 	want = make([]string, 0, len(tree))
 	entm := make(map[string][]*gitTreeEntry, len(tree))
 	for _, e := range tree {
@@ -590,36 +1168,88 @@ func (r *gitRepository) ensureTree(
 			entm[e.entry.Hash] = append(entm[e.entry.Hash], e)
 		}
 	}
-	err = r.prefetchObjects(dir, want, func(hash string, size int64) error {
+	sized := make(map[string]bool, len(want))
+	err = r.prefetchObjects(ctx, blobdir, want, func(hash string, size int64) error {
+		sized[hash] = true
 		l, ok := entm[hash]
 		if ok {
 			for _, e := range l {
 				e.size = size
+				sizedEmit(e)
 			}
 		}
 		return nil
 	})
+	if nil != err && nil != r.api && nil == ctx.Err() {
+		/* a pack with a large blob may fail to come through; fetch the remaining blobs raw */
+		tracef("repo=%#v prefetch: %v", r.remote, err)
+		err = nil
+		for _, hash := range want {
+			if sized[hash] {
+				continue
+			}
+			var size int64
+			size, err = r.fetchBlob(ctx, blobdir, hash, nil)
+			if nil != err {
+				break
+			}
+			sized[hash] = true
+			for _, e := range entm[hash] {
+				e.size = size
+				sizedEmit(e)
+			}
+		}
+	}
 	if nil != err {
 		return err
 	}
 
+	if r.lfs {
+		want = make([]string, 0, len(tree))
+		entm = make(map[string][]*gitTreeEntry, len(tree))
+		for _, e := range tree {
+			if 0100000 == e.entry.Mode&0170000 && lfsPointerMaxSize > e.size {
+				want = append(want, e.entry.Hash)
+				entm[e.entry.Hash] = append(entm[e.entry.Hash], e)
+			}
+		}
+		err = r.fetchObjects(ctx, blobdir, want, func(hash string, content []byte) error {
+			oid, size, ok := parseLfsPointer(content)
+			if ok {
+				for _, e := range entm[hash] {
+					e.lfsoid = oid
+					e.size = size
+				}
+			}
+			return nil
+		})
+		if nil != err {
+			/* the files that could not be probed are presented as they are */
+			tracef("repo=%#v lfs probe: %v", r.remote, err)
+			err = nil
+		}
+		for _, l := range entm {
+			for _, e := range l {
+				emit(e)
+			}
+		}
+	}
+
 	want = make([]string, 0, len(tree))
 	entm = make(map[string][]*gitTreeEntry, len(tree))
 	for _, e := range tree {
 		if 0120000 == e.entry.Mode {
 			want = append(want, e.entry.Hash)
 			entm[e.entry.Hash] = append(entm[e.entry.Hash], e)
-		} else if 0160000 == e.entry.Mode {
-			e.target = e.entry.Hash
-			e.size = int64(len(e.target))
 		}
 	}
-	err = r.fetchObjects(dir, want, func(hash string, content []byte) error {
+	err = r.fetchObjects(ctx, blobdir, want, func(hash string, content []byte) error {
 		l, ok := entm[hash]
 		if ok {
 			t := string(content)
 			for _, e := range l {
 				e.target = t
+				emit(e)
 			}
 		}
 		return nil
@@ -633,6 +1263,7 @@ func (r *gitRepository) ensureTree(
 		if nil == ref.tree {
 			ref.tree = tree
 			ref.treeTime = treeTime
+			ref.commitHash = commit
 		}
 		err = fn(ref.tree)
 	} else {
@@ -645,8 +1276,37 @@ func (r *gitRepository) ensureTree(
 	return err
 }
 
-func (r *gitRepository) GetTree(ref Ref, entry TreeEntry) (res []TreeEntry, err error) {
-	err = r.ensureTree(ref, entry, func(tree map[string]*gitTreeEntry) error {
+// collect drops the state of refs that have not been used since the cutoff times: temp
+// refs are removed and the trees of other refs are dropped, to be fetched again (usually
+// from the cache directory) when they are next used. A zero cutoff time disables either.
+func (r *gitRepository) collect(tempCutoff time.Time, treeCutoff time.Time) {
+	r.lock.Lock()
+	for k, ref := range r.refs {
+		used := time.Unix(0, atomic.LoadInt64(&ref.used))
+		if RefTemp == ref.kind {
+			if used.Before(tempCutoff) {
+				delete(r.refs, k)
+			}
+		} else if nil != ref.tree && used.Before(treeCutoff) {
+			ref.tree = nil
+		}
+	}
+	for _, ref := range r.pinrefs {
+		if nil != ref.tree && time.Unix(0, atomic.LoadInt64(&ref.used)).Before(treeCutoff) {
+			ref.tree = nil
+		}
+	}
+	wiki := r.wiki
+	r.lock.Unlock()
+
+	if nil != wiki {
+		wiki.collect(tempCutoff, treeCutoff)
+	}
+}
+
+func (r *gitRepository) GetTree(ctx context.Context, ref Ref, entry TreeEntry) (
+	res []TreeEntry, err error) {
+	err = r.ensureTree(ctx, ref, entry, nil, func(tree map[string]*gitTreeEntry) error {
 		res = make([]TreeEntry, len(tree))
 		i := 0
 		for _, e := range tree {
@@ -658,13 +1318,40 @@ func (r *gitRepository) GetTree(ref Ref, entry TreeEntry) (res []TreeEntry, err
 	return
 }
 
-func (r *gitRepository) GetTreeEntry(ref Ref, entry TreeEntry, name string) (res TreeEntry, err error) {
+// peel ensures that an annotated tag has been peeled to its commit, so that the hash of
+// the ref is the hash of a commit.
+func (r *gitRepository) peel(ctx context.Context, ref Ref) error {
+	_, err := r.GetTree(ctx, ref, nil)
+	return err
+}
+
+func (r *gitRepository) StreamTree(ctx context.Context, ref Ref, entry TreeEntry,
+	fn func(entry TreeEntry) bool) error {
+	emitted, stopped := false, false
+	emit := func(e *gitTreeEntry) {
+		emitted = true
+		if !stopped {
+			stopped = !fn(e)
+		}
+	}
+	return r.ensureTree(ctx, ref, entry, emit, func(tree map[string]*gitTreeEntry) error {
+		if !emitted {
+			for _, e := range tree {
+				emit(e)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *gitRepository) GetTreeEntry(ctx context.Context, ref Ref, entry TreeEntry, name string) (
+	res TreeEntry, err error) {
 	k := name
 	if r.caseins {
 		k = strings.ToUpper(k)
 	}
 
-	err = r.ensureTree(ref, entry, func(tree map[string]*gitTreeEntry) error {
+	err = r.ensureTree(ctx, ref, entry, nil, func(tree map[string]*gitTreeEntry) error {
 		var ok bool
 		res, ok = tree[k]
 		if !ok {
@@ -675,25 +1362,79 @@ func (r *gitRepository) GetTreeEntry(ref Ref, entry TreeEntry, name string) (res
 	return
 }
 
-func (r *gitRepository) GetBlobReader(entry TreeEntry) (res io.ReaderAt, err error) {
+func (r *gitRepository) GetBlobReader(ctx context.Context, entry TreeEntry) (
+	res io.ReaderAt, err error) {
 	r.once.Do(func() { r.open() })
 	if nil == r.repo {
 		return nil, ErrNotFound
 	}
 
 	r.lock.RLock()
-	dir := r.dir
+	dir := r._blobDirectory()
+	if "" != r.dir {
+		if _, err := os.Stat(objectPath(r.dir, entry.Hash())); nil == err {
+			/* blob of a pinned ref */
+			dir = r.dir
+		}
+	}
 	r.lock.RUnlock()
 
+	if e, ok := entry.(*gitTreeEntry); ok && "" != e.lfsoid {
+		return r.fetchLfsReader(ctx, dir, e.lfsoid, e.size)
+	}
+
 	want := []string{entry.Hash()}
-	err = r.fetchReaders(dir, want, func(hash string, reader io.ReaderAt) error {
+	err = r.fetchReaders(ctx, dir, want, func(hash string, reader io.ReaderAt) error {
 		res = reader
 		return nil
 	})
+	if nil != err && ErrNotFound != err && nil != r.api && nil == ctx.Err() {
+		/* a pack with a large blob may fail to come through; fetch the blob raw */
+		tracef("repo=%#v blob=%s: %v", r.remote, entry.Hash(), err)
+		res = nil
+		_, err = r.fetchBlob(ctx, dir, entry.Hash(), &res)
+	}
 	return
 }
 
-func (r *gitRepository) ensureModules(
+// fetchBlob fetches the raw content of a blob with the provider API rather than the pack
+// protocol; the content is streamed to the directory (if there is one) rather than held
+// in memory. It returns the size of the blob and optionally a reader for it.
+func (r *gitRepository) fetchBlob(ctx context.Context, dir string, hash string,
+	reader *io.ReaderAt) (int64, error) {
+
+	ctx = httputil.WithDownload(ctx)
+	if "" == dir {
+		var buf bytes.Buffer
+		err := r.api.getBlob(ctx, hash, &buf)
+		if nil != err {
+			return 0, err
+		}
+		if nil != reader {
+			*reader = readerAtNopCloser{bytes.NewReader(buf.Bytes())}
+		}
+		return int64(buf.Len()), nil
+	}
+
+	res, err := fetchFile(objectPath(dir, hash), func(w io.Writer) error {
+		return r.api.getBlob(ctx, hash, w)
+	})
+	if nil != err {
+		return 0, err
+	}
+	size, err := res.Size()
+	if nil != err || nil == reader {
+		res.Close()
+	} else {
+		*reader = res
+	}
+	if nil != err {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (r *gitRepository) ensureModules(ctx context.Context,
 	ref0 Ref, fn func(modules map[string]string) error) error {
 	r.once.Do(func() { r.open() })
 	if nil == r.repo {
@@ -710,12 +1451,12 @@ func (r *gitRepository) ensureModules(
 	}
 	r.lock.RUnlock()
 
-	entry, err := r.GetTreeEntry(ref, nil, ".gitmodules")
+	entry, err := r.GetTreeEntry(ctx, ref, nil, ".gitmodules")
 	if nil != err {
 		return err
 	}
 
-	reader, err := r.GetBlobReader(entry)
+	reader, err := r.GetBlobReader(ctx, entry)
 	if nil != err {
 		return err
 	}
@@ -749,13 +1490,14 @@ func (r *gitRepository) ensureModules(
 	return err
 }
 
-func (r *gitRepository) GetModule(ref Ref, path string, rootrel bool) (res string, err error) {
+func (r *gitRepository) GetModule(ctx context.Context, ref Ref, path string, rootrel bool) (
+	res string, err error) {
 	k := path
 	if r.caseins {
 		k = strings.ToUpper(k)
 	}
 
-	err = r.ensureModules(ref, func(modules map[string]string) error {
+	err = r.ensureModules(ctx, ref, func(modules map[string]string) error {
 		var ok bool
 		res, ok = modules[k]
 		if !ok {
@@ -775,6 +1517,31 @@ func (r *gitRepository) GetModule(ref Ref, path string, rootrel bool) (res strin
 	return
 }
 
+// GetInfo returns provider metadata about the repository. Repositories that were not
+// opened through a provider client have no such metadata.
+func (r *gitRepository) GetInfo(ctx context.Context) (res *RepositoryInfo, err error) {
+	r.lock.RLock()
+	res = r.infores
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getInfo(ctx)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	r.infores = res
+	r.lock.Unlock()
+	return res, nil
+}
+
 func (r *gitRef) Name() string {
 	return r.name
 }
@@ -787,6 +1554,15 @@ func (r *gitRef) TreeTime() time.Time {
 	return r.treeTime
 }
 
+// Hash returns the commit hash of the ref. Annotated tags are peeled to their commit
+// once the ref tree has been read.
+func (r *gitRef) Hash() string {
+	if "" != r.commitHash {
+		return r.commitHash
+	}
+	return r.targetHash
+}
+
 func (e *gitTreeEntry) Name() string {
 	return e.entry.Name
 }
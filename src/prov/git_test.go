@@ -15,13 +15,17 @@ package prov
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/billziss-gh/golib/keyring"
+	"github.com/winfsp/hubfs/git"
 )
 
 const remote = "https://github.com/winfsp/hubfs"
@@ -36,7 +40,7 @@ var testRepository Repository
 var caseins bool
 
 func TestGetRefs(t *testing.T) {
-	refs, err := testRepository.GetRefs()
+	refs, err := testRepository.GetRefs(context.Background())
 	if nil != err {
 		t.Error(err)
 	}
@@ -51,7 +55,7 @@ func TestGetRefs(t *testing.T) {
 		t.Error()
 	}
 
-	refs, err = testRepository.GetRefs()
+	refs, err = testRepository.GetRefs(context.Background())
 	if nil != err {
 		t.Error(err)
 	}
@@ -68,7 +72,7 @@ func TestGetRefs(t *testing.T) {
 }
 
 func TestGetRef(t *testing.T) {
-	ref, err := testRepository.GetRef(refName)
+	ref, err := testRepository.GetRef(context.Background(), refName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -76,7 +80,126 @@ func TestGetRef(t *testing.T) {
 		t.Error()
 	}
 
-	ref, err = testRepository.GetRef(refName)
+	ref, err = testRepository.GetRef(context.Background(), refName)
+	if nil != err {
+		t.Error(err)
+	}
+	if ref.Name() != refName {
+		t.Error()
+	}
+}
+
+func TestGetRefNested(t *testing.T) {
+	repository, err := NewGitRepository(remote, "", "", GitConfig{Caseins: caseins, Nestedrefs: true})
+	if nil != err {
+		t.Error(err)
+	}
+	defer repository.Close()
+
+	ref, err := repository.GetRef(context.Background(), "heads/"+refName)
+	if nil != err {
+		t.Error(err)
+	}
+	if ref.Name() != "heads/"+refName || ref.Kind() != RefBranch {
+		t.Error()
+	}
+
+	ref, err = repository.GetRef(context.Background(), "tags/"+tagName)
+	if nil != err {
+		t.Error(err)
+	}
+	if ref.Name() != "tags/"+tagName || ref.Kind() != RefTag {
+		t.Error()
+	}
+
+	_, err = repository.GetRef(context.Background(), refName)
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+}
+
+func TestPullRefName(t *testing.T) {
+	if "pr-123" != pullRefName("refs/pull/123/head") {
+		t.Error()
+	}
+	if "pr-123-merge" != pullRefName("refs/pull/123/merge") {
+		t.Error()
+	}
+	if "" != pullRefName("refs/pull/abc/head") {
+		t.Error()
+	}
+	if "" != pullRefName("refs/pull/123/other") {
+		t.Error()
+	}
+}
+
+func TestPinRef(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef01234567"
+	c := &client{}
+	if nil != c.setPin("owner/repo/release/1.x="+hash) ||
+		nil != c.setPin("/owner/repo/main/="+strings.ToUpper(hash)) ||
+		nil != c.setPin("owner/other/main="+hash) {
+		t.Error()
+	}
+	for _, pin := range []string{"owner/repo=" + hash, "owner/repo/main", "owner/repo/main=0123"} {
+		if nil == c.setPin(pin) {
+			t.Error(pin)
+		}
+	}
+	pins := c.getPins("OWNER", "Repo")
+	if 2 != len(pins) || hash != pins["release/1.x"] || hash != pins["main"] {
+		t.Error(pins)
+	}
+
+	r := newGitRepository("", nil, GitConfig{
+		Caseins: true,
+		Pins: func() map[string]string {
+			return c.getPins("owner", "repo")
+		},
+	})
+	p := r.getPins()
+	if 2 != len(p) || "release+1.x" != p["RELEASE+1.X"].name || hash != p["MAIN"].hash {
+		t.Error(p)
+	}
+
+	ref := &gitRef{name: "Main", kind: RefBranch, targetHash: "x"}
+	refs := r.pinRefs([]Ref{ref, &gitRef{name: "dev", kind: RefBranch, targetHash: "y"}})
+	if "Main" != refs[0].Name() || hash != refs[0].(*gitRef).targetHash ||
+		"y" != refs[1].(*gitRef).targetHash {
+		t.Error(refs)
+	}
+	if refs[0] != r.pinRef("MAIN", ref, p["MAIN"]) {
+		t.Error()
+	}
+
+	c.setPin("owner/repo/main=")
+	if refs = r.pinRefs([]Ref{ref}); ref != refs[0] {
+		t.Error()
+	}
+}
+
+func TestRefMatch(t *testing.T) {
+	expect := func(patts []string, n string, caseins bool, e bool) {
+		if m := refMatch(patts, n, caseins); e != m {
+			t.Errorf("%v %q expect %v got %v", patts, n, e, m)
+		}
+	}
+
+	expect(nil, "refs/heads/main", false, true)
+	expect([]string{"main", "v*"}, "refs/heads/main", false, true)
+	expect([]string{"main", "v*"}, "refs/tags/v1.0", false, true)
+	expect([]string{"main", "v*"}, "refs/heads/dev", false, false)
+	expect([]string{"main", "v*"}, "refs/heads/MAIN", false, false)
+	expect([]string{"main", "v*"}, "refs/heads/MAIN", true, true)
+	expect([]string{"release/*"}, "refs/heads/release/1.x", false, true)
+	expect([]string{"-v*-rc*"}, "refs/tags/v1.0-rc1", false, false)
+	expect([]string{"-v*-rc*"}, "refs/tags/v1.0", false, true)
+	expect([]string{"+v*", "-v*-rc*"}, "refs/heads/main", false, false)
+	expect([]string{"pull/*/head"}, "refs/pull/123/head", false, true)
+}
+
+func TestGetDefaultRef(t *testing.T) {
+	ref, err := testRepository.GetDefaultRef(context.Background())
 	if nil != err {
 		t.Error(err)
 	}
@@ -86,7 +209,7 @@ func TestGetRef(t *testing.T) {
 }
 
 func TestGetTempRef(t *testing.T) {
-	ref, err := testRepository.GetTempRef(commitName)
+	ref, err := testRepository.GetTempRef(context.Background(), commitName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -94,7 +217,7 @@ func TestGetTempRef(t *testing.T) {
 		t.Error()
 	}
 
-	ref, err = testRepository.GetTempRef(commitName)
+	ref, err = testRepository.GetTempRef(context.Background(), commitName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -103,8 +226,34 @@ func TestGetTempRef(t *testing.T) {
 	}
 }
 
+func TestGetTempRefAbbrev(t *testing.T) {
+	ref, err := testRepository.GetRef(context.Background(), refName)
+	if nil != err {
+		t.Error(err)
+	}
+	hash := ref.(*gitRef).targetHash
+
+	ref, err = testRepository.GetTempRef(context.Background(), hash[:12])
+	if nil != err {
+		t.Error(err)
+	}
+	if ref.Name() != hash[:12] || ref.(*gitRef).targetHash != hash {
+		t.Error()
+	}
+
+	_, err = testRepository.GetTempRef(context.Background(), "xyz")
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+
+	_, err = testRepository.GetTempRef(context.Background(), hash[:6])
+	if ErrNotFound != err {
+		t.Error(err)
+	}
+}
+
 func testGetRefTree(t *testing.T, name string) {
-	ref, err := testRepository.GetRef(name)
+	ref, err := testRepository.GetRef(context.Background(), name)
 	if nil != err {
 		t.Error(err)
 	}
@@ -112,7 +261,7 @@ func testGetRefTree(t *testing.T, name string) {
 		t.Error()
 	}
 
-	tree, err := testRepository.GetTree(ref, nil)
+	tree, err := testRepository.GetTree(context.Background(), ref, nil)
 	if nil != err {
 		t.Error(err)
 	}
@@ -127,7 +276,7 @@ func testGetRefTree(t *testing.T, name string) {
 		t.Error()
 	}
 
-	tree, err = testRepository.GetTree(ref, nil)
+	tree, err = testRepository.GetTree(context.Background(), ref, nil)
 	if nil != err {
 		t.Error(err)
 	}
@@ -148,8 +297,45 @@ func TestGetRefTree(t *testing.T) {
 	testGetRefTree(t, tagName)
 }
 
+func TestGetRefTreeTime(t *testing.T) {
+	ref, err := testRepository.GetRef(context.Background(), tagName)
+	if nil != err {
+		t.Error(err)
+	}
+
+	_, err = testRepository.GetTree(context.Background(), ref, nil)
+	if nil != err {
+		t.Error(err)
+	}
+	if ref.TreeTime().IsZero() {
+		t.Error()
+	}
+}
+
+func TestTreeTime(t *testing.T) {
+	author := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	committer := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	mount := time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)
+	c := &git.Commit{
+		Author:    git.Signature{Time: author},
+		Committer: git.Signature{Time: committer},
+	}
+
+	expect := func(treetime string, e time.Time) {
+		r := newGitRepository("", nil, GitConfig{Treetime: treetime, Mounttime: mount})
+		if tt := r.treeTime(c); !e.Equal(tt) {
+			t.Errorf("treetime %q expect %v got %v", treetime, e, tt)
+		}
+	}
+
+	expect("", committer)
+	expect("commit", committer)
+	expect("author", author)
+	expect("mount", mount)
+}
+
 func testGetRefTreeEntry(t *testing.T, name string) {
-	ref, err := testRepository.GetRef(name)
+	ref, err := testRepository.GetRef(context.Background(), name)
 	if nil != err {
 		t.Error(err)
 	}
@@ -157,7 +343,7 @@ func testGetRefTreeEntry(t *testing.T, name string) {
 		t.Error()
 	}
 
-	entry, err := testRepository.GetTreeEntry(ref, nil, entryName)
+	entry, err := testRepository.GetTreeEntry(context.Background(), ref, nil, entryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -165,7 +351,7 @@ func testGetRefTreeEntry(t *testing.T, name string) {
 		t.Error()
 	}
 
-	entry, err = testRepository.GetTreeEntry(ref, nil, entryName)
+	entry, err = testRepository.GetTreeEntry(context.Background(), ref, nil, entryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -181,7 +367,7 @@ func TestGetRefTreeEntry(t *testing.T) {
 }
 
 func testGetTree(t *testing.T, name string) {
-	ref, err := testRepository.GetRef(name)
+	ref, err := testRepository.GetRef(context.Background(), name)
 	if nil != err {
 		t.Error(err)
 	}
@@ -189,7 +375,7 @@ func testGetTree(t *testing.T, name string) {
 		t.Error()
 	}
 
-	entry, err := testRepository.GetTreeEntry(ref, nil, subtreeName)
+	entry, err := testRepository.GetTreeEntry(context.Background(), ref, nil, subtreeName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -197,7 +383,7 @@ func testGetTree(t *testing.T, name string) {
 		t.Error()
 	}
 
-	tree, err := testRepository.GetTree(nil, entry)
+	tree, err := testRepository.GetTree(context.Background(), nil, entry)
 	if nil != err {
 		t.Error(err)
 	}
@@ -212,7 +398,7 @@ func testGetTree(t *testing.T, name string) {
 		t.Error()
 	}
 
-	tree, err = testRepository.GetTree(nil, entry)
+	tree, err = testRepository.GetTree(context.Background(), nil, entry)
 	if nil != err {
 		t.Error(err)
 	}
@@ -234,7 +420,7 @@ func TestGetTree(t *testing.T) {
 }
 
 func testGetTreeEntry(t *testing.T, name string) {
-	ref, err := testRepository.GetRef(name)
+	ref, err := testRepository.GetRef(context.Background(), name)
 	if nil != err {
 		t.Error(err)
 	}
@@ -242,7 +428,7 @@ func testGetTreeEntry(t *testing.T, name string) {
 		t.Error()
 	}
 
-	entry, err := testRepository.GetTreeEntry(ref, nil, subtreeName)
+	entry, err := testRepository.GetTreeEntry(context.Background(), ref, nil, subtreeName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -250,7 +436,7 @@ func testGetTreeEntry(t *testing.T, name string) {
 		t.Error()
 	}
 
-	subentry, err := testRepository.GetTreeEntry(nil, entry, subentryName)
+	subentry, err := testRepository.GetTreeEntry(context.Background(), nil, entry, subentryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -258,7 +444,7 @@ func testGetTreeEntry(t *testing.T, name string) {
 		t.Error()
 	}
 
-	subentry, err = testRepository.GetTreeEntry(nil, entry, subentryName)
+	subentry, err = testRepository.GetTreeEntry(context.Background(), nil, entry, subentryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -273,7 +459,7 @@ func TestGetTreeEntry(t *testing.T) {
 }
 
 func TestGetBlobReader(t *testing.T) {
-	ref, err := testRepository.GetRef(refName)
+	ref, err := testRepository.GetRef(context.Background(), refName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -281,7 +467,7 @@ func TestGetBlobReader(t *testing.T) {
 		t.Error()
 	}
 
-	entry, err := testRepository.GetTreeEntry(ref, nil, subtreeName)
+	entry, err := testRepository.GetTreeEntry(context.Background(), ref, nil, subtreeName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -289,7 +475,7 @@ func TestGetBlobReader(t *testing.T) {
 		t.Error()
 	}
 
-	subentry, err := testRepository.GetTreeEntry(nil, entry, subentryName)
+	subentry, err := testRepository.GetTreeEntry(context.Background(), nil, entry, subentryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -297,7 +483,7 @@ func TestGetBlobReader(t *testing.T) {
 		t.Error()
 	}
 
-	reader, err := testRepository.GetBlobReader(subentry)
+	reader, err := testRepository.GetBlobReader(context.Background(), subentry)
 	if nil != err {
 		t.Error(err)
 	}
@@ -307,7 +493,7 @@ func TestGetBlobReader(t *testing.T) {
 		t.Error()
 	}
 
-	reader, err = testRepository.GetBlobReader(subentry)
+	reader, err = testRepository.GetBlobReader(context.Background(), subentry)
 	if nil != err {
 		t.Error(err)
 	}
@@ -324,13 +510,13 @@ func TestGetModule(t *testing.T) {
 	const modulePath = "ext/test"
 	const moduleTarget = "/billziss-gh/secfs.test"
 
-	repository, err := NewGitRepository(remote, "", "", caseins, false)
+	repository, err := NewGitRepository(remote, "", "", GitConfig{Caseins: caseins})
 	if nil != err {
 		t.Error(err)
 	}
 	defer repository.Close()
 
-	ref, err := repository.GetRef(refName)
+	ref, err := repository.GetRef(context.Background(), refName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -338,7 +524,7 @@ func TestGetModule(t *testing.T) {
 		t.Error()
 	}
 
-	module, err := repository.GetModule(ref, modulePath, true)
+	module, err := repository.GetModule(context.Background(), ref, modulePath, true)
 	if nil != err {
 		t.Error(err)
 	}
@@ -346,7 +532,7 @@ func TestGetModule(t *testing.T) {
 		t.Error()
 	}
 
-	module, err = repository.GetModule(ref, modulePath, true)
+	module, err = repository.GetModule(context.Background(), ref, modulePath, true)
 	if nil != err {
 		t.Error(err)
 	}
@@ -369,7 +555,7 @@ func init() {
 			token = os.Getenv("HUBFS_TOKEN")
 		}
 
-		testRepository, err = NewGitRepository(remote, token, "x-oauth-basic", caseins, false)
+		testRepository, err = NewGitRepository(remote, token, "x-oauth-basic", GitConfig{Caseins: caseins})
 		if nil != err {
 			return err
 		}
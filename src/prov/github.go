@@ -15,16 +15,20 @@ package prov
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	pathutil "path"
 	"strings"
+	"time"
 
 	"github.com/cli/oauth"
 	"github.com/winfsp/hubfs/httputil"
+	"github.com/winfsp/hubfs/util"
 )
 
 type GithubProvider struct {
@@ -53,6 +57,7 @@ func init() {
 		"    \taccess github.com\n"+
 		"    \t- owner     file system root is at owner\n"+
 		"    \t- repo      file system root is at owner/repo")
+	RegisterProviderAlias("github", "github.com")
 }
 
 func (p *GithubProvider) Auth() (token string, err error) {
@@ -71,6 +76,23 @@ func (p *GithubProvider) Auth() (token string, err error) {
 	return
 }
 
+func (p *GithubProvider) DeviceAuth(display func(code string, uri string) error) (
+	token string, err error) {
+	flow := &oauth.Flow{
+		Host:        oauth.GitHubHost("https://" + p.Hostname),
+		ClientID:    p.ClientId,
+		Scopes:      strings.Split(p.Scopes, ","),
+		DisplayCode: display,
+		BrowseURL:   func(string) error { return nil },
+		HTTPClient:  httputil.DefaultClient,
+	}
+	accessToken, err := flow.DeviceFlow()
+	if nil != accessToken {
+		token = accessToken.Token
+	}
+	return
+}
+
 func (p *GithubProvider) NewClient(token string) (Client, error) {
 	return NewGithubClient(p.ApiURI, token)
 }
@@ -81,8 +103,6 @@ type githubClient struct {
 	ident      string
 	apiURI     string
 	gqlApiURI  string
-	token      string
-	login      string
 }
 
 func NewGithubClient(apiURI string, token string) (Client, error) {
@@ -96,7 +116,6 @@ func NewGithubClient(apiURI string, token string) (Client, error) {
 		ident:      uri.Hostname(),
 		apiURI:     apiURI,
 		gqlApiURI:  apiURI + "/graphql",
-		token:      token,
 	}
 	c.client.init(c)
 
@@ -104,22 +123,9 @@ func NewGithubClient(apiURI string, token string) (Client, error) {
 		c.gqlApiURI = uri.Scheme + "://" + uri.Host + "/api/graphql"
 	}
 
-	if "" != c.token {
-		rsp, err := c.sendrecv("/user")
-		if nil != err {
-			return nil, err
-		}
-		defer rsp.Body.Close()
-
-		var content struct {
-			Login string `json:"login"`
-		}
-		err = json.NewDecoder(rsp.Body).Decode(&content)
-		if nil != err {
-			return nil, err
-		}
-
-		c.login = content.Login
+	err = c.initToken(token)
+	if nil != err {
+		return nil, err
 	}
 
 	return c, nil
@@ -129,19 +135,93 @@ func (c *githubClient) getIdent() string {
 	return c.ident
 }
 
+func (c *githubClient) getLogin(ctx context.Context, token string) (string, error) {
+	rsp, err := c.sendrecvToken(ctx, "GET", "/user", token)
+	if nil != err {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Login string `json:"login"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return "", err
+	}
+
+	// Classic tokens report their scopes; fine-grained tokens do not. A token without the
+	// repo scope still works for public repositories, so it is not rejected.
+	if _, ok := rsp.Header[http.CanonicalHeaderKey("X-OAuth-Scopes")]; ok &&
+		!hasScope(rsp.Header.Get("X-OAuth-Scopes"), "repo") {
+		util.Log(util.LogWarn, "prov", "token is missing the repo scope; "+
+			"private repositories will not be accessible", "login", content.Login)
+	}
+
+	return content.Login, nil
+}
+
+func hasScope(scopes string, scope string) bool {
+	for _, s := range strings.Split(scopes, ",") {
+		if scope == strings.TrimSpace(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// githubAuthError returns an AuthError if a response reports that the auth token
+// has been rejected.
+func githubAuthError(rsp *http.Response) error {
+	if 401 == rsp.StatusCode {
+		return &AuthError{Reason: AccessToken,
+			Message: "token is invalid, has expired or has been revoked"}
+	}
+	if sso := rsp.Header.Get("X-GitHub-SSO"); 403 == rsp.StatusCode &&
+		strings.HasPrefix(sso, "required") {
+		msg := "token is not authorized for the organization's SAML single sign-on"
+		if i := strings.Index(sso, "url="); -1 != i {
+			msg += "; authorize it at " + sso[i+len("url="):]
+		}
+		return &AuthError{Reason: AccessSSO, Message: msg}
+	}
+	return nil
+}
+
 func (c *githubClient) getGitCredentials() (string, string) {
-	return c.token, "x-oauth-basic"
+	return c.getToken(), "x-oauth-basic"
+}
+
+func (c *githubClient) sendrecv(ctx context.Context, path string) (*http.Response, error) {
+	return c.sendrecvMethod(ctx, "GET", path)
+}
+
+func (c *githubClient) sendrecvMethod(ctx context.Context, method string, path string) (
+	*http.Response, error) {
+	if isUserPath(path) {
+		return c.sendrecvToken(ctx, method, path, c.userToken())
+	}
+	rsp, err := c.sendrecvToken(ctx, method, path, c.getToken())
+	if ErrRateLimit == err && c.rotateToken() {
+		rsp, err = c.sendrecvToken(ctx, method, path, c.getToken())
+	}
+	return rsp, err
 }
 
-func (c *githubClient) sendrecv(path string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", c.apiURI+path, nil)
+func (c *githubClient) sendrecvToken(ctx context.Context,
+	method string, path string, token string) (*http.Response, error) {
+	if err := c.checkRateLimit(); nil != err {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURI+path, nil)
 	if nil != err {
 		return nil, err
 	}
 
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	if "" != c.token {
-		req.Header.Set("Authorization", "token "+c.token)
+	if "" != token {
+		req.Header.Set("Authorization", "token "+token)
 	}
 
 	rsp, err := c.httpClient.Do(req)
@@ -149,16 +229,35 @@ func (c *githubClient) sendrecv(path string) (*http.Response, error) {
 		return nil, err
 	}
 
-	if 404 == rsp.StatusCode {
+	c.updateRateLimit(rsp.Header, "X-RateLimit-", token)
+
+	if err = rateLimitError(rsp, "X-RateLimit-"); nil != err {
+		rsp.Body.Close()
+		return nil, err
+	} else if err = githubAuthError(rsp); nil != err {
+		rsp.Body.Close()
+		return nil, err
+	} else if 404 == rsp.StatusCode {
+		rsp.Body.Close()
 		return nil, ErrNotFound
 	} else if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
 		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
 	}
 
 	return rsp, nil
 }
 
-func (c *githubClient) sendrecvGql(query string) (*http.Response, error) {
+func (c *githubClient) sendrecvGql(ctx context.Context, query string) (*http.Response, error) {
+	rsp, err := c.sendrecvGqlToken(ctx, query, c.getToken())
+	if ErrRateLimit == err && c.rotateToken() {
+		rsp, err = c.sendrecvGqlToken(ctx, query, c.getToken())
+	}
+	return rsp, err
+}
+
+func (c *githubClient) sendrecvGqlToken(ctx context.Context,
+	query string, token string) (*http.Response, error) {
 	var content = struct {
 		Query string `json:"query"`
 	}{
@@ -170,14 +269,14 @@ func (c *githubClient) sendrecvGql(query string) (*http.Response, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", c.gqlApiURI, &body)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.gqlApiURI, &body)
 	if nil != err {
 		return nil, err
 	}
 
 	req.Header.Set("Content-type", "application/json")
-	if "" != c.token {
-		req.Header.Set("Authorization", "token "+c.token)
+	if "" != token {
+		req.Header.Set("Authorization", "token "+token)
 	}
 
 	rsp, err := c.httpClient.Do(req)
@@ -185,19 +284,29 @@ func (c *githubClient) sendrecvGql(query string) (*http.Response, error) {
 		return nil, err
 	}
 
-	if 404 == rsp.StatusCode {
+	c.updateRateLimit(rsp.Header, "X-RateLimit-", token)
+
+	if err = rateLimitError(rsp, "X-RateLimit-"); nil != err {
+		rsp.Body.Close()
+		return nil, err
+	} else if err = githubAuthError(rsp); nil != err {
+		rsp.Body.Close()
+		return nil, err
+	} else if 404 == rsp.StatusCode {
+		rsp.Body.Close()
 		return nil, ErrNotFound
 	} else if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
 		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
 	}
 
 	return rsp, nil
 }
 
-func (c *githubClient) getOwner(o string) (res *owner, err error) {
+func (c *githubClient) getOwner(ctx context.Context, o string) (res *owner, err error) {
 	defer trace(o)(&err)
 
-	rsp, err := c.sendrecv(fmt.Sprintf("/users/%s", url.PathEscape(o)))
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/users/%s", url.PathEscape(o)))
 	if nil != err {
 		return nil, err
 	}
@@ -220,16 +329,61 @@ func (c *githubClient) getOwner(o string) (res *owner, err error) {
 	return
 }
 
-func (c *githubClient) getRepositoryPageRest(path string) ([]*repository, error) {
-	rsp, err := c.sendrecv(path)
+// getOwners returns the authenticated user and the user's organizations, including
+// those with private membership. No owners are returned without authentication.
+func (c *githubClient) getOwners(ctx context.Context) (res []*owner, err error) {
+	defer trace()(&err)
+
+	res = make([]*owner, 0)
+	if "" == c.login {
+		return res, nil
+	}
+
+	o := &owner{FName: c.login, FKind: "User"}
+	o.Value = o
+	res = append(res, o)
+
+	for page := 1; ; page++ {
+		rsp, err := c.sendrecv(ctx, fmt.Sprintf("/user/orgs?per_page=100&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+
+		var content []struct {
+			FName string `json:"login"`
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&content)
+		rsp.Body.Close()
+		if nil != err {
+			return nil, err
+		}
+
+		for _, elm := range content {
+			o := &owner{FName: elm.FName, FKind: "Organization"}
+			o.Value = o
+			res = append(res, o)
+		}
+		if len(content) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) getRepositoryPageRest(ctx context.Context, path string) (
+	[]*repository, error) {
+	rsp, err := c.sendrecv(ctx, path)
 	if nil != err {
 		return nil, err
 	}
 	defer rsp.Body.Close()
 
 	var content []struct {
-		FName   string `json:"name"`
-		FRemote string `json:"clone_url"`
+		FName     string `json:"name"`
+		FRemote   string `json:"clone_url"`
+		FFork     bool   `json:"fork"`
+		FArchived bool   `json:"archived"`
 	}
 	err = json.NewDecoder(rsp.Body).Decode(&content)
 	if nil != err {
@@ -239,8 +393,10 @@ func (c *githubClient) getRepositoryPageRest(path string) ([]*repository, error)
 	res := make([]*repository, len(content))
 	for i, elm := range content {
 		r := &repository{
-			FName:   elm.FName,
-			FRemote: elm.FRemote,
+			fork:     elm.FFork,
+			archived: elm.FArchived,
+			FName:    elm.FName,
+			FRemote:  elm.FRemote,
 		}
 		r.Value = r
 		r.Repository = emptyRepository
@@ -251,7 +407,8 @@ func (c *githubClient) getRepositoryPageRest(path string) ([]*repository, error)
 	return res, nil
 }
 
-func (c *githubClient) getRepositoriesRest(owner string, kind string) (res []*repository, err error) {
+func (c *githubClient) getRepositoriesRest(ctx context.Context, owner string, kind string) (
+	res []*repository, err error) {
 	defer trace(owner)(&err)
 
 	var path string
@@ -265,7 +422,7 @@ func (c *githubClient) getRepositoriesRest(owner string, kind string) (res []*re
 
 	res = make([]*repository, 0)
 	for page := 1; ; page++ {
-		lst, err := c.getRepositoryPageRest(path + fmt.Sprintf("&page=%d", page))
+		lst, err := c.getRepositoryPageRest(ctx, path+fmt.Sprintf("&page=%d", page))
 		if nil != err {
 			return nil, err
 		}
@@ -278,8 +435,15 @@ func (c *githubClient) getRepositoriesRest(owner string, kind string) (res []*re
 	return res, nil
 }
 
-func (c *githubClient) getRepositoryPageGql(query string) ([]*repository, string, error) {
-	rsp, err := c.sendrecvGql(query)
+func (c *githubClient) getRepositoryPageGql(ctx context.Context, query string, user bool) (
+	[]*repository, string, error) {
+	var rsp *http.Response
+	var err error
+	if user {
+		rsp, err = c.sendrecvGqlToken(ctx, query, c.userToken())
+	} else {
+		rsp, err = c.sendrecvGql(ctx, query)
+	}
 	if nil != err {
 		return nil, "", err
 	}
@@ -294,8 +458,10 @@ func (c *githubClient) getRepositoryPageGql(query string) ([]*repository, string
 						EndCursor   string `json:"endCursor"`
 					} `json:"pageInfo"`
 					Nodes []struct {
-						FName   string `json:"name"`
-						FRemote string `json:"url"`
+						FName     string `json:"name"`
+						FRemote   string `json:"url"`
+						FFork     bool   `json:"isFork"`
+						FArchived bool   `json:"isArchived"`
 					} `json:"nodes"`
 				} `json:"repositories"`
 			} `json:"owner"`
@@ -315,8 +481,10 @@ func (c *githubClient) getRepositoryPageGql(query string) ([]*repository, string
 	res := make([]*repository, len(content.Data.Owner.Repositories.Nodes))
 	for i, elm := range content.Data.Owner.Repositories.Nodes {
 		r := &repository{
-			FName:   elm.FName,
-			FRemote: elm.FRemote,
+			fork:     elm.FFork,
+			archived: elm.FArchived,
+			FName:    elm.FName,
+			FRemote:  elm.FRemote,
 		}
 		r.Value = r
 		r.Repository = emptyRepository
@@ -332,7 +500,8 @@ func (c *githubClient) getRepositoryPageGql(query string) ([]*repository, string
 	return res, crs, nil
 }
 
-func (c *githubClient) getRepositoriesGql(owner string, kind string) (res []*repository, err error) {
+func (c *githubClient) getRepositoriesGql(ctx context.Context, owner string, kind string) (
+	res []*repository, err error) {
 	defer trace(owner)(&err)
 
 	query := `{
@@ -345,6 +514,8 @@ func (c *githubClient) getRepositoriesGql(owner string, kind string) (res []*rep
 				nodes {
 					name
 					url
+					isFork
+					isArchived
 				}
 			}
 		}
@@ -363,7 +534,7 @@ func (c *githubClient) getRepositoriesGql(owner string, kind string) (res []*rep
 		if "" != crs {
 			crs = `, after: "` + crs + `"`
 		}
-		lst, crs, err = c.getRepositoryPageGql(fmt.Sprintf(query, crs))
+		lst, crs, err = c.getRepositoryPageGql(ctx, fmt.Sprintf(query, crs), c.login == owner)
 		if nil != err {
 			return nil, err
 		}
@@ -376,8 +547,12 @@ func (c *githubClient) getRepositoriesGql(owner string, kind string) (res []*rep
 	return res, nil
 }
 
-func (c *githubClient) getRepositories(owner string, kind string) (res []*repository, err error) {
-	if "" != c.token {
+func (c *githubClient) getRepositories(ctx context.Context, owner string, kind string) (
+	res []*repository, err error) {
+	if gistKind == kind {
+		return c.getGists(ctx, owner)
+	}
+	if !c.anonymous {
 		/*
 		 * Attempt to list repositories via a GraphQL query because they are much faster for large
 		 * listings than REST. For example, listing the GitHub microsoft account takes 1m26s(!)
@@ -402,10 +577,1038 @@ func (c *githubClient) getRepositories(owner string, kind string) (res []*reposi
 		 * secondary rate limiting:
 		 * https://docs.github.com/en/rest/overview/resources-in-the-rest-api#secondary-rate-limits.
 		 */
-		res, err = c.getRepositoriesGql(owner, kind)
+		res, err = c.getRepositoriesGql(ctx, owner, kind)
 		if nil == err {
 			return
 		}
 	}
-	return c.getRepositoriesRest(owner, kind)
+	return c.getRepositoriesRest(ctx, owner, kind)
+}
+
+func (c *githubClient) resolveCommit(
+	ctx context.Context, owner string, repository string, abbrev string) (
+	res string, err error) {
+	defer trace(owner, repository, abbrev)(&res, &err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/commits/%s",
+		url.PathEscape(owner), url.PathEscape(repository), url.PathEscape(abbrev)))
+	if nil != err {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Sha string `json:"sha"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return "", err
+	}
+
+	return content.Sha, nil
+}
+
+func (c *githubClient) getRepositoryInfo(ctx context.Context, owner string, repository string) (
+	res *RepositoryInfo, err error) {
+	defer trace(owner, repository)(&res, &err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s",
+		url.PathEscape(owner), url.PathEscape(repository)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Name          string   `json:"full_name"`
+		Description   string   `json:"description"`
+		DefaultBranch string   `json:"default_branch"`
+		Stars         int      `json:"stargazers_count"`
+		Visibility    string   `json:"visibility"`
+		Private       bool     `json:"private"`
+		WebURL        string   `json:"html_url"`
+		CloneURL      string   `json:"clone_url"`
+		SSHURL        string   `json:"ssh_url"`
+		Topics        []string `json:"topics"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	if "" == content.Visibility {
+		content.Visibility = "public"
+		if content.Private {
+			content.Visibility = "private"
+		}
+	}
+
+	res = &RepositoryInfo{
+		Name:          content.Name,
+		Description:   content.Description,
+		DefaultBranch: content.DefaultBranch,
+		Stars:         content.Stars,
+		Visibility:    content.Visibility,
+		WebURL:        content.WebURL,
+		CloneURL:      content.CloneURL,
+		SSHURL:        content.SSHURL,
+		Topics:        content.Topics,
+	}
+	return res, nil
+}
+
+func (c *githubClient) getReleasePage(ctx context.Context, path string) (
+	[]*Release, int, error) {
+	rsp, err := c.sendrecv(ctx, path)
+	if nil != err {
+		return nil, 0, err
+	}
+	defer rsp.Body.Close()
+
+	var content []struct {
+		TagName     string    `json:"tag_name"`
+		Name        string    `json:"name"`
+		Body        string    `json:"body"`
+		Draft       bool      `json:"draft"`
+		Prerelease  bool      `json:"prerelease"`
+		PublishedAt time.Time `json:"published_at"`
+		Assets      []struct {
+			Name      string    `json:"name"`
+			Size      int64     `json:"size"`
+			URL       string    `json:"url"`
+			UpdatedAt time.Time `json:"updated_at"`
+			Digest    string    `json:"digest"`
+		} `json:"assets"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, 0, err
+	}
+
+	res := make([]*Release, 0, len(content))
+	for _, elm := range content {
+		if elm.Draft {
+			continue
+		}
+		r := &Release{
+			Name:       elm.TagName,
+			Title:      elm.Name,
+			Notes:      elm.Body,
+			Time:       elm.PublishedAt,
+			Prerelease: elm.Prerelease,
+			Assets:     make([]*ReleaseAsset, len(elm.Assets)),
+		}
+		for i, a := range elm.Assets {
+			r.Assets[i] = &ReleaseAsset{
+				Name:   a.Name,
+				Size:   a.Size,
+				URL:    a.URL,
+				Time:   a.UpdatedAt,
+				Digest: a.Digest,
+			}
+		}
+		res = append(res, r)
+	}
+
+	return res, len(content), nil
+}
+
+func (c *githubClient) getReleases(ctx context.Context, owner string, repository string) (
+	res []*Release, err error) {
+	defer trace(owner, repository)(&err)
+
+	path := fmt.Sprintf("/repos/%s/%s/releases?per_page=100",
+		url.PathEscape(owner), url.PathEscape(repository))
+
+	res = make([]*Release, 0)
+	for page := 1; ; page++ {
+		lst, n, err := c.getReleasePage(ctx, path+fmt.Sprintf("&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		if n < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) download(ctx context.Context, url string, w io.Writer) (err error) {
+	defer trace(url)(&err)
+
+	return c.fetch(ctx, url, "application/octet-stream", w)
+}
+
+// fetch copies the raw content at url to w. The accept media type selects the content
+// format for API endpoints that support more than one.
+func (c *githubClient) fetch(ctx context.Context, url string, accept string, w io.Writer) (
+	err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if nil != err {
+		return
+	}
+
+	req.Header.Set("Accept", accept)
+	if token := c.getToken(); "" != token && sameOrigin(url, c.apiURI) {
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if 404 == rsp.StatusCode {
+		return ErrNotFound
+	} else if 400 <= rsp.StatusCode {
+		return errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	}
+
+	_, err = io.Copy(w, rsp.Body)
+	return
+}
+
+type githubIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Body        string           `json:"body"`
+	HtmlURL     string           `json:"html_url"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	PullRequest *json.RawMessage `json:"pull_request"`
+}
+
+func (elm *githubIssue) issue() *Issue {
+	labels := make([]string, len(elm.Labels))
+	for i, l := range elm.Labels {
+		labels[i] = l.Name
+	}
+	return &Issue{
+		Number:  elm.Number,
+		Title:   elm.Title,
+		State:   elm.State,
+		Author:  elm.User.Login,
+		Labels:  labels,
+		Body:    elm.Body,
+		WebURL:  elm.HtmlURL,
+		Created: elm.CreatedAt,
+		Updated: elm.UpdatedAt,
+	}
+}
+
+func (c *githubClient) getIssuePage(
+	ctx context.Context, owner string, repository string, state string, page int) (
+	res []*Issue, more bool, err error) {
+	defer trace(owner, repository, state, page)(&more, &err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/issues?state=%s&per_page=100&page=%d",
+		url.PathEscape(owner), url.PathEscape(repository), url.QueryEscape(state), page))
+	if nil != err {
+		return nil, false, err
+	}
+	defer rsp.Body.Close()
+
+	var content []githubIssue
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, false, err
+	}
+
+	// The issues API also returns pull requests; these are skipped.
+	res = make([]*Issue, 0, len(content))
+	for i := range content {
+		if nil == content[i].PullRequest {
+			res = append(res, content[i].issue())
+		}
+	}
+
+	return res, 100 <= len(content), nil
+}
+
+func (c *githubClient) getIssue(ctx context.Context, owner string, repository string, number int) (
+	res *Issue, err error) {
+	defer trace(owner, repository, number)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/issues/%d",
+		url.PathEscape(owner), url.PathEscape(repository), number))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content githubIssue
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	if nil != content.PullRequest {
+		return nil, ErrNotFound
+	}
+
+	return content.issue(), nil
+}
+
+type githubPullRequest struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Draft  bool   `json:"draft"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	Head struct {
+		Label string `json:"label"`
+	} `json:"head"`
+	Body      string     `json:"body"`
+	HtmlURL   string     `json:"html_url"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	MergedAt  *time.Time `json:"merged_at"`
+}
+
+func (elm *githubPullRequest) pullRequest() *PullRequest {
+	labels := make([]string, len(elm.Labels))
+	for i, l := range elm.Labels {
+		labels[i] = l.Name
+	}
+	state := elm.State
+	if nil != elm.MergedAt {
+		state = PullRequestMerged
+	}
+	return &PullRequest{
+		Number:  elm.Number,
+		Title:   elm.Title,
+		State:   state,
+		Draft:   elm.Draft,
+		Author:  elm.User.Login,
+		Labels:  labels,
+		Base:    elm.Base.Ref,
+		Head:    elm.Head.Label,
+		Body:    elm.Body,
+		WebURL:  elm.HtmlURL,
+		Created: elm.CreatedAt,
+		Updated: elm.UpdatedAt,
+	}
+}
+
+func (c *githubClient) getPullRequestPage(
+	ctx context.Context, owner string, repository string, page int) (
+	res []*PullRequest, more bool, err error) {
+	defer trace(owner, repository, page)(&more, &err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/pulls?state=open&per_page=100&page=%d",
+		url.PathEscape(owner), url.PathEscape(repository), page))
+	if nil != err {
+		return nil, false, err
+	}
+	defer rsp.Body.Close()
+
+	var content []githubPullRequest
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, false, err
+	}
+
+	res = make([]*PullRequest, len(content))
+	for i := range content {
+		res[i] = content[i].pullRequest()
+	}
+
+	return res, 100 <= len(content), nil
+}
+
+func (c *githubClient) getPullRequest(
+	ctx context.Context, owner string, repository string, number int) (
+	res *PullRequest, err error) {
+	defer trace(owner, repository, number)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/pulls/%d",
+		url.PathEscape(owner), url.PathEscape(repository), number))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content githubPullRequest
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	return content.pullRequest(), nil
+}
+
+func (c *githubClient) getPullRequestPatch(
+	ctx context.Context, owner string, repository string, number int) (
+	res []byte, err error) {
+	defer trace(owner, repository, number)(&err)
+
+	var buf bytes.Buffer
+	err = c.fetch(ctx, fmt.Sprintf("%s/repos/%s/%s/pulls/%d",
+		c.apiURI, url.PathEscape(owner), url.PathEscape(repository), number),
+		"application/vnd.github.v3.patch", &buf)
+	if nil != err {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *githubClient) getGistPage(ctx context.Context, path string) (
+	[]*repository, error) {
+	rsp, err := c.sendrecv(ctx, path)
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content []struct {
+		FName   string `json:"id"`
+		FRemote string `json:"git_pull_url"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res := make([]*repository, len(content))
+	for i, elm := range content {
+		r := &repository{
+			FName:   elm.FName,
+			FRemote: elm.FRemote,
+		}
+		r.Value = r
+		r.Repository = emptyRepository
+		r.keepdir = c.keepdir
+		res[i] = r
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) getGists(ctx context.Context, owner string) (
+	res []*repository, err error) {
+	defer trace(owner)(&err)
+
+	path := fmt.Sprintf("/users/%s/gists?per_page=100", url.PathEscape(owner))
+
+	res = make([]*repository, 0)
+	for page := 1; ; page++ {
+		lst, err := c.getGistPage(ctx, path+fmt.Sprintf("&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		if len(lst) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// searchRepositories returns the first 100 repositories that match a query, in the
+// order of the provider's best match.
+func (c *githubClient) searchRepositories(ctx context.Context, query string) (
+	res []string, err error) {
+	defer trace(query)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/search/repositories?q=%s&per_page=100",
+		url.QueryEscape(query)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Items []struct {
+			FullName string `json:"full_name"`
+		} `json:"items"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]string, len(content.Items))
+	for i, elm := range content.Items {
+		res[i] = elm.FullName
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) getNotifications(ctx context.Context) (res []*Notification, err error) {
+	defer trace()(&err)
+
+	res = make([]*Notification, 0)
+	for page := 1; ; page++ {
+		rsp, err := c.sendrecv(ctx, fmt.Sprintf("/notifications?per_page=50&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+
+		var content []struct {
+			Id         string `json:"id"`
+			Repository struct {
+				FullName string `json:"full_name"`
+				HtmlURL  string `json:"html_url"`
+			} `json:"repository"`
+			Subject struct {
+				Title string `json:"title"`
+				URL   string `json:"url"`
+				Type  string `json:"type"`
+			} `json:"subject"`
+			Reason    string    `json:"reason"`
+			UpdatedAt time.Time `json:"updated_at"`
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&content)
+		rsp.Body.Close()
+		if nil != err {
+			return nil, err
+		}
+
+		for _, elm := range content {
+			// the subject URL is an API URL; the web URL is derived from its number
+			weburl := elm.Repository.HtmlURL
+			number := pathutil.Base(elm.Subject.URL)
+			switch elm.Subject.Type {
+			case "Issue":
+				weburl += "/issues/" + number
+			case "PullRequest":
+				weburl += "/pull/" + number
+			}
+			res = append(res, &Notification{
+				ID:         elm.Id,
+				Repository: elm.Repository.FullName,
+				Type:       elm.Subject.Type,
+				Title:      elm.Subject.Title,
+				Reason:     elm.Reason,
+				WebURL:     weburl,
+				Updated:    elm.UpdatedAt,
+			})
+		}
+		if len(content) < 50 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) markNotificationRead(ctx context.Context, id string) (err error) {
+	defer trace(id)(&err)
+
+	rsp, err := c.sendrecvMethod(ctx, "PATCH", "/notifications/threads/"+url.PathEscape(id))
+	if nil != err {
+		return err
+	}
+	rsp.Body.Close()
+	return nil
+}
+
+func (c *githubClient) getStarred(ctx context.Context) (res []string, err error) {
+	defer trace()(&err)
+
+	res = make([]string, 0)
+	for page := 1; ; page++ {
+		rsp, err := c.sendrecv(ctx, fmt.Sprintf("/user/starred?per_page=100&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+
+		var content []struct {
+			FullName string `json:"full_name"`
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&content)
+		rsp.Body.Close()
+		if nil != err {
+			return nil, err
+		}
+
+		for _, elm := range content {
+			res = append(res, elm.FullName)
+		}
+		if len(content) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// getCollaborator returns the repositories of other users that the authenticated user
+// is a collaborator on. Organization repositories are listed under their organizations.
+func (c *githubClient) getCollaborator(ctx context.Context) (res []string, err error) {
+	defer trace()(&err)
+
+	res = make([]string, 0)
+	if "" == c.login {
+		return res, nil
+	}
+	for page := 1; ; page++ {
+		rsp, err := c.sendrecv(ctx,
+			fmt.Sprintf("/user/repos?affiliation=collaborator&per_page=100&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+
+		var content []struct {
+			FullName string `json:"full_name"`
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&content)
+		rsp.Body.Close()
+		if nil != err {
+			return nil, err
+		}
+
+		for _, elm := range content {
+			res = append(res, elm.FullName)
+		}
+		if len(content) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) getCommitPage(
+	ctx context.Context, owner string, repository string, hash string, page int) (
+	res []*Commit, more bool, err error) {
+	defer trace(owner, repository, hash, page)(&more, &err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/commits?sha=%s&per_page=100&page=%d",
+		url.PathEscape(owner), url.PathEscape(repository), url.QueryEscape(hash), page))
+	if nil != err {
+		return nil, false, err
+	}
+	defer rsp.Body.Close()
+
+	type signature struct {
+		Name  string    `json:"name"`
+		Email string    `json:"email"`
+		Date  time.Time `json:"date"`
+	}
+	var content []struct {
+		Sha    string `json:"sha"`
+		Commit struct {
+			Author       signature `json:"author"`
+			Committer    signature `json:"committer"`
+			Message      string    `json:"message"`
+			Verification *struct {
+				Verified  bool   `json:"verified"`
+				Reason    string `json:"reason"`
+				Signature string `json:"signature"`
+			} `json:"verification"`
+		} `json:"commit"`
+		HtmlURL string `json:"html_url"`
+		Parents []struct {
+			Sha string `json:"sha"`
+		} `json:"parents"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, false, err
+	}
+
+	res = make([]*Commit, len(content))
+	for i, elm := range content {
+		parents := make([]string, len(elm.Parents))
+		for j, p := range elm.Parents {
+			parents[j] = p.Sha
+		}
+		res[i] = &Commit{
+			Hash:           elm.Sha,
+			Parents:        parents,
+			Author:         elm.Commit.Author.Name,
+			AuthorEmail:    elm.Commit.Author.Email,
+			AuthorTime:     elm.Commit.Author.Date,
+			Committer:      elm.Commit.Committer.Name,
+			CommitterEmail: elm.Commit.Committer.Email,
+			CommitTime:     elm.Commit.Committer.Date,
+			Message:        elm.Commit.Message,
+			WebURL:         elm.HtmlURL,
+		}
+		if v := elm.Commit.Verification; nil != v {
+			res[i].Verification = &CommitVerification{
+				Signed:   "" != v.Signature,
+				Verified: v.Verified,
+				Format:   signatureFormat(v.Signature),
+				Reason:   v.Reason,
+			}
+		}
+	}
+
+	return res, 100 <= len(content), nil
+}
+
+// signatureFormat returns the format of an armored commit signature.
+func signatureFormat(signature string) string {
+	switch {
+	case strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----"):
+		return "gpg"
+	case strings.HasPrefix(signature, "-----BEGIN SSH SIGNATURE-----"):
+		return "ssh"
+	case strings.HasPrefix(signature, "-----BEGIN SIGNED MESSAGE-----"):
+		return "x509"
+	}
+	return ""
+}
+
+// getBlame uses the GraphQL API, because blame is not available through REST.
+func (c *githubClient) getBlame(
+	ctx context.Context, owner string, repository string, hash string, path string) (
+	res []*BlameRange, err error) {
+	defer trace(owner, repository, hash, path)(&err)
+
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	rsp, err := c.sendrecvGql(ctx, fmt.Sprintf(`{
+  repository(owner: %s, name: %s) {
+    object(oid: %s) {
+      ... on Commit {
+        blame(path: %s) {
+          ranges {
+            startingLine
+            endingLine
+            commit {
+              oid
+              author {
+                name
+                date
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`, quote(owner), quote(repository), quote(hash), quote(path)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Data struct {
+			Repository *struct {
+				Object *struct {
+					Blame *struct {
+						Ranges []struct {
+							StartingLine int `json:"startingLine"`
+							EndingLine   int `json:"endingLine"`
+							Commit       struct {
+								Oid    string `json:"oid"`
+								Author struct {
+									Name string    `json:"name"`
+									Date time.Time `json:"date"`
+								} `json:"author"`
+							} `json:"commit"`
+						} `json:"ranges"`
+					} `json:"blame"`
+				} `json:"object"`
+			} `json:"repository"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+	if 0 < len(content.Errors) {
+		return nil, errors.New(fmt.Sprintf("GraphQL: %s", content.Errors[0].Message))
+	}
+	if nil == content.Data.Repository || nil == content.Data.Repository.Object ||
+		nil == content.Data.Repository.Object.Blame {
+		return nil, ErrNotFound
+	}
+
+	ranges := content.Data.Repository.Object.Blame.Ranges
+	res = make([]*BlameRange, len(ranges))
+	for i, elm := range ranges {
+		res[i] = &BlameRange{
+			Start:      elm.StartingLine,
+			End:        elm.EndingLine,
+			Commit:     elm.Commit.Oid,
+			Author:     elm.Commit.Author.Name,
+			AuthorTime: elm.Commit.Author.Date,
+		}
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) getArchive(ctx context.Context, owner string, repository string,
+	hash string, format string, w io.Writer) (err error) {
+	defer trace(owner, repository, hash, format)(&err)
+
+	kind := "tarball"
+	if "zip" == format {
+		kind = "zipball"
+	}
+
+	return c.fetch(ctx, fmt.Sprintf("%s/repos/%s/%s/%s/%s",
+		c.apiURI, url.PathEscape(owner), url.PathEscape(repository), kind, url.PathEscape(hash)),
+		"application/vnd.github.v3+json", w)
+}
+
+// getBlob fetches the raw content of a blob. The raw media type avoids the base64 JSON
+// format and its 1MB limit.
+func (c *githubClient) getBlob(ctx context.Context, owner string, repository string,
+	hash string, w io.Writer) (err error) {
+	defer trace(owner, repository, hash)(&err)
+
+	return c.fetch(ctx, fmt.Sprintf("%s/repos/%s/%s/git/blobs/%s",
+		c.apiURI, url.PathEscape(owner), url.PathEscape(repository), url.PathEscape(hash)),
+		"application/vnd.github.raw", w)
+}
+
+func (c *githubClient) getDiff(
+	ctx context.Context, owner string, repository string, base string, head string) (
+	res []byte, err error) {
+	defer trace(owner, repository, base, head)(&err)
+
+	var buf bytes.Buffer
+	err = c.fetch(ctx, fmt.Sprintf("%s/repos/%s/%s/compare/%s...%s",
+		c.apiURI, url.PathEscape(owner), url.PathEscape(repository),
+		url.PathEscape(base), url.PathEscape(head)),
+		"application/vnd.github.v3.patch", &buf)
+	if nil != err {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *githubClient) getWorkflows(ctx context.Context, owner string, repository string) (
+	res []*Workflow, err error) {
+	defer trace(owner, repository)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/actions/workflows?per_page=100",
+		url.PathEscape(owner), url.PathEscape(repository)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Workflows []struct {
+			Id    int64  `json:"id"`
+			Name  string `json:"name"`
+			Path  string `json:"path"`
+			State string `json:"state"`
+		} `json:"workflows"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*Workflow, len(content.Workflows))
+	for i, elm := range content.Workflows {
+		res[i] = &Workflow{
+			ID:    elm.Id,
+			Name:  elm.Name,
+			Path:  elm.Path,
+			State: elm.State,
+		}
+	}
+
+	return res, nil
+}
+
+type githubWorkflowRun struct {
+	Id           int64     `json:"id"`
+	RunNumber    int       `json:"run_number"`
+	WorkflowId   int64     `json:"workflow_id"`
+	DisplayTitle string    `json:"display_title"`
+	Event        string    `json:"event"`
+	Status       string    `json:"status"`
+	Conclusion   string    `json:"conclusion"`
+	HeadBranch   string    `json:"head_branch"`
+	HeadSha      string    `json:"head_sha"`
+	HtmlURL      string    `json:"html_url"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (elm *githubWorkflowRun) workflowRun() *WorkflowRun {
+	return &WorkflowRun{
+		ID:         elm.Id,
+		Number:     elm.RunNumber,
+		Workflow:   elm.WorkflowId,
+		Title:      elm.DisplayTitle,
+		Event:      elm.Event,
+		Status:     elm.Status,
+		Conclusion: elm.Conclusion,
+		Branch:     elm.HeadBranch,
+		Commit:     elm.HeadSha,
+		WebURL:     elm.HtmlURL,
+		Created:    elm.CreatedAt,
+		Updated:    elm.UpdatedAt,
+	}
+}
+
+func (c *githubClient) getWorkflowRuns(
+	ctx context.Context, owner string, repository string, workflow int64) (
+	res []*WorkflowRun, err error) {
+	defer trace(owner, repository, workflow)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/actions/workflows/%d/runs?per_page=100",
+		url.PathEscape(owner), url.PathEscape(repository), workflow))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		WorkflowRuns []githubWorkflowRun `json:"workflow_runs"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*WorkflowRun, len(content.WorkflowRuns))
+	for i := range content.WorkflowRuns {
+		res[i] = content.WorkflowRuns[i].workflowRun()
+	}
+
+	return res, nil
+}
+
+func (c *githubClient) getWorkflowRun(
+	ctx context.Context, owner string, repository string, id int64) (
+	res *WorkflowRun, err error) {
+	defer trace(owner, repository, id)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/actions/runs/%d",
+		url.PathEscape(owner), url.PathEscape(repository), id))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content githubWorkflowRun
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	return content.workflowRun(), nil
+}
+
+func (c *githubClient) getWorkflowJobs(
+	ctx context.Context, owner string, repository string, run int64) (
+	res []*WorkflowJob, err error) {
+	defer trace(owner, repository, run)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/actions/runs/%d/jobs?per_page=100",
+		url.PathEscape(owner), url.PathEscape(repository), run))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Jobs []struct {
+			Id          int64     `json:"id"`
+			Name        string    `json:"name"`
+			Status      string    `json:"status"`
+			Conclusion  string    `json:"conclusion"`
+			StartedAt   time.Time `json:"started_at"`
+			CompletedAt time.Time `json:"completed_at"`
+		} `json:"jobs"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*WorkflowJob, len(content.Jobs))
+	for i, elm := range content.Jobs {
+		res[i] = &WorkflowJob{
+			ID:         elm.Id,
+			Name:       elm.Name,
+			Status:     elm.Status,
+			Conclusion: elm.Conclusion,
+			Started:    elm.StartedAt,
+			Completed:  elm.CompletedAt,
+		}
+	}
+
+	return res, nil
+}
+
+// getWorkflowJobLog downloads the log of a job. The API redirects to the log storage,
+// which does not receive the token, because it is on a different host.
+func (c *githubClient) getWorkflowJobLog(
+	ctx context.Context, owner string, repository string, job int64, w io.Writer) (err error) {
+	defer trace(owner, repository, job)(&err)
+
+	return c.fetch(ctx, fmt.Sprintf("%s/repos/%s/%s/actions/jobs/%d/logs",
+		c.apiURI, url.PathEscape(owner), url.PathEscape(repository), job),
+		"application/vnd.github.v3+json", w)
+}
+
+func (c *githubClient) getArtifacts(
+	ctx context.Context, owner string, repository string, run int64) (
+	res []*Artifact, err error) {
+	defer trace(owner, repository, run)(&err)
+
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/repos/%s/%s/actions/runs/%d/artifacts?per_page=100",
+		url.PathEscape(owner), url.PathEscape(repository), run))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Artifacts []struct {
+			Id                 int64     `json:"id"`
+			Name               string    `json:"name"`
+			ArchiveDownloadURL string    `json:"archive_download_url"`
+			Expired            bool      `json:"expired"`
+			UpdatedAt          time.Time `json:"updated_at"`
+		} `json:"artifacts"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*Artifact, len(content.Artifacts))
+	for i, elm := range content.Artifacts {
+		res[i] = &Artifact{
+			ID:      elm.Id,
+			Name:    elm.Name,
+			URL:     elm.ArchiveDownloadURL,
+			Expired: elm.Expired,
+			Time:    elm.UpdatedAt,
+		}
+	}
+
+	return res, nil
 }
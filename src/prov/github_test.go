@@ -14,6 +14,9 @@
 package prov
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"net/url"
 	"os"
 	"testing"
@@ -28,7 +31,7 @@ const repositoryName = "hubfs"
 var testClient Client
 
 func TestOpenCloseOwner(t *testing.T) {
-	owner, err := testClient.OpenOwner(ownerName)
+	owner, err := testClient.OpenOwner(context.Background(), ownerName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -37,7 +40,7 @@ func TestOpenCloseOwner(t *testing.T) {
 	}
 	testClient.CloseOwner(owner)
 
-	owner, err = testClient.OpenOwner(ownerName)
+	owner, err = testClient.OpenOwner(context.Background(), ownerName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -48,7 +51,7 @@ func TestOpenCloseOwner(t *testing.T) {
 }
 
 func TestGetRepositories(t *testing.T) {
-	owner, err := testClient.OpenOwner(ownerName)
+	owner, err := testClient.OpenOwner(context.Background(), ownerName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -57,7 +60,7 @@ func TestGetRepositories(t *testing.T) {
 		t.Error()
 	}
 
-	repositories, err := testClient.GetRepositories(owner)
+	repositories, err := testClient.GetRepositories(context.Background(), owner)
 	if nil != err {
 		t.Error(err)
 	}
@@ -72,7 +75,7 @@ func TestGetRepositories(t *testing.T) {
 		t.Error()
 	}
 
-	repositories, err = testClient.GetRepositories(owner)
+	repositories, err = testClient.GetRepositories(context.Background(), owner)
 	if nil != err {
 		t.Error(err)
 	}
@@ -89,7 +92,7 @@ func TestGetRepositories(t *testing.T) {
 }
 
 func TestOpenCloseRepository(t *testing.T) {
-	owner, err := testClient.OpenOwner(ownerName)
+	owner, err := testClient.OpenOwner(context.Background(), ownerName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -98,7 +101,7 @@ func TestOpenCloseRepository(t *testing.T) {
 		t.Error()
 	}
 
-	repository, err := testClient.OpenRepository(owner, repositoryName)
+	repository, err := testClient.OpenRepository(context.Background(), owner, repositoryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -107,7 +110,7 @@ func TestOpenCloseRepository(t *testing.T) {
 	}
 	testClient.CloseRepository(repository)
 
-	repository, err = testClient.OpenRepository(owner, repositoryName)
+	repository, err = testClient.OpenRepository(context.Background(), owner, repositoryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -121,7 +124,7 @@ func testExpiration(t *testing.T) {
 	testClient.StartExpiration()
 	defer testClient.StopExpiration()
 
-	owner, err := testClient.OpenOwner(ownerName)
+	owner, err := testClient.OpenOwner(context.Background(), ownerName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -129,7 +132,7 @@ func testExpiration(t *testing.T) {
 		t.Error()
 	}
 
-	repository, err := testClient.OpenRepository(owner, repositoryName)
+	repository, err := testClient.OpenRepository(context.Background(), owner, repositoryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -142,7 +145,7 @@ func testExpiration(t *testing.T) {
 
 	time.Sleep(3 * time.Second)
 
-	owner, err = testClient.OpenOwner(ownerName)
+	owner, err = testClient.OpenOwner(context.Background(), ownerName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -150,7 +153,7 @@ func testExpiration(t *testing.T) {
 		t.Error()
 	}
 
-	repository, err = testClient.OpenRepository(owner, repositoryName)
+	repository, err = testClient.OpenRepository(context.Background(), owner, repositoryName)
 	if nil != err {
 		t.Error(err)
 	}
@@ -167,6 +170,38 @@ func TestExpiration(t *testing.T) {
 	testExpiration(t)
 }
 
+func TestGithubAuthError(t *testing.T) {
+	rsp := &http.Response{StatusCode: 401, Header: http.Header{}}
+	if err := githubAuthError(rsp); !errors.Is(err, ErrAuth) {
+		t.Error(err)
+	}
+
+	rsp = &http.Response{StatusCode: 403, Header: http.Header{}}
+	if err := githubAuthError(rsp); nil != err {
+		t.Error(err)
+	}
+
+	rsp.Header.Set("X-GitHub-SSO", "required; url=https://github.com/orgs/test/sso?x=1")
+	err := githubAuthError(rsp)
+	if !errors.Is(err, ErrAuth) {
+		t.Error(err)
+	}
+	if e := "auth token rejected: " +
+		"token is not authorized for the organization's SAML single sign-on; " +
+		"authorize it at https://github.com/orgs/test/sso?x=1"; e != err.Error() {
+		t.Errorf("expect %q got %q", e, err.Error())
+	}
+
+	rsp = &http.Response{StatusCode: 404, Header: http.Header{}}
+	if err := githubAuthError(rsp); nil != err {
+		t.Error(err)
+	}
+
+	if !hasScope("read:org, repo, user", "repo") || hasScope("public_repo", "repo") {
+		t.Error()
+	}
+}
+
 func init() {
 	atinit(func() error {
 		token, err := keyring.Get("hubfs", "github.com")
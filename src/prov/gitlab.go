@@ -14,15 +14,21 @@
 package prov
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cli/browser"
 	"github.com/cli/oauth"
@@ -55,6 +61,7 @@ func init() {
 		"    \taccess gitlab.com\n"+
 		"    \t- owner     file system root is at owner\n"+
 		"    \t- repo      file system root is at owner/repo")
+	RegisterProviderAlias("gitlab", "gitlab.com")
 }
 
 type gitlabWebAppFlowHttpClient struct {
@@ -120,8 +127,6 @@ type gitlabClient struct {
 	httpClient *http.Client
 	ident      string
 	apiURI     string
-	token      string
-	login      string
 }
 
 func NewGitlabClient(apiURI string, token string) (Client, error) {
@@ -134,26 +139,12 @@ func NewGitlabClient(apiURI string, token string) (Client, error) {
 		httpClient: httputil.DefaultClient,
 		ident:      uri.Hostname(),
 		apiURI:     apiURI,
-		token:      token,
 	}
 	c.client.init(c)
 
-	if "" != c.token {
-		rsp, err := c.sendrecv("/user")
-		if nil != err {
-			return nil, err
-		}
-		defer rsp.Body.Close()
-
-		var content struct {
-			Login string `json:"username"`
-		}
-		err = json.NewDecoder(rsp.Body).Decode(&content)
-		if nil != err {
-			return nil, err
-		}
-
-		c.login = content.Login
+	err = c.initToken(token)
+	if nil != err {
+		return nil, err
 	}
 
 	return c, nil
@@ -163,18 +154,92 @@ func (c *gitlabClient) getIdent() string {
 	return c.ident
 }
 
+func (c *gitlabClient) getLogin(ctx context.Context, token string) (string, error) {
+	rsp, err := c.sendrecvToken(ctx, "GET", "/user", token)
+	if nil != err {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Login string `json:"username"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return "", err
+	}
+
+	return content.Login, nil
+}
+
+// gitlabAuthError returns an AuthError if a response reports that the auth token
+// has been rejected. GitLab explains the reason in the WWW-Authenticate header.
+func gitlabAuthError(rsp *http.Response) error {
+	if 401 != rsp.StatusCode && 403 != rsp.StatusCode {
+		return nil
+	}
+	header := rsp.Header.Get("WWW-Authenticate")
+	switch authenticateParam(header, "error") {
+	case "invalid_token":
+		if desc := authenticateParam(header, "error_description"); "" != desc {
+			return &AuthError{Reason: AccessToken, Message: desc}
+		}
+	case "insufficient_scope":
+		return &AuthError{Reason: AccessScope,
+			Message: "token requires scope " + authenticateParam(header, "scope")}
+	}
+	if 401 == rsp.StatusCode {
+		return &AuthError{Reason: AccessToken,
+			Message: "token is invalid, has expired or has been revoked"}
+	}
+	return nil
+}
+
+var authenticateParamRe = regexp.MustCompile(`([A-Za-z_]+)="([^"]*)"`)
+
+// authenticateParam returns the value of a parameter of a WWW-Authenticate header.
+func authenticateParam(header string, name string) string {
+	for _, m := range authenticateParamRe.FindAllStringSubmatch(header, -1) {
+		if name == m[1] {
+			return m[2]
+		}
+	}
+	return ""
+}
+
 func (c *gitlabClient) getGitCredentials() (string, string) {
-	return "oauth2", c.token
+	return "oauth2", c.getToken()
+}
+
+func (c *gitlabClient) sendrecv(ctx context.Context, path string) (*http.Response, error) {
+	return c.sendrecvMethod(ctx, "GET", path)
 }
 
-func (c *gitlabClient) sendrecv(path string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", c.apiURI+path, nil)
+func (c *gitlabClient) sendrecvMethod(ctx context.Context, method string, path string) (
+	*http.Response, error) {
+	if isUserPath(path) {
+		return c.sendrecvToken(ctx, method, path, c.userToken())
+	}
+	rsp, err := c.sendrecvToken(ctx, method, path, c.getToken())
+	if ErrRateLimit == err && c.rotateToken() {
+		rsp, err = c.sendrecvToken(ctx, method, path, c.getToken())
+	}
+	return rsp, err
+}
+
+func (c *gitlabClient) sendrecvToken(ctx context.Context,
+	method string, path string, token string) (*http.Response, error) {
+	if err := c.checkRateLimit(); nil != err {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURI+path, nil)
 	if nil != err {
 		return nil, err
 	}
 
-	if "" != c.token {
-		req.Header.Set("Authorization", "Bearer "+c.token)
+	if "" != token {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
 	rsp, err := c.httpClient.Do(req)
@@ -182,19 +247,29 @@ func (c *gitlabClient) sendrecv(path string) (*http.Response, error) {
 		return nil, err
 	}
 
-	if 404 == rsp.StatusCode {
+	c.updateRateLimit(rsp.Header, "RateLimit-", token)
+
+	if err = rateLimitError(rsp, "RateLimit-"); nil != err {
+		rsp.Body.Close()
+		return nil, err
+	} else if err = gitlabAuthError(rsp); nil != err {
+		rsp.Body.Close()
+		return nil, err
+	} else if 404 == rsp.StatusCode {
+		rsp.Body.Close()
 		return nil, ErrNotFound
 	} else if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
 		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
 	}
 
 	return rsp, nil
 }
 
-func (c *gitlabClient) getUser(o string) (res *owner, err error) {
+func (c *gitlabClient) getUser(ctx context.Context, o string) (res *owner, err error) {
 	defer trace(o)(&err)
 
-	rsp, err := c.sendrecv(fmt.Sprintf("/users?username=%s", url.PathEscape(o)))
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/users?username=%s", url.PathEscape(o)))
 	if nil != err {
 		return nil, err
 	}
@@ -219,10 +294,10 @@ func (c *gitlabClient) getUser(o string) (res *owner, err error) {
 	return
 }
 
-func (c *gitlabClient) getGroup(o string) (res *owner, err error) {
+func (c *gitlabClient) getGroup(ctx context.Context, o string) (res *owner, err error) {
 	defer trace(o)(&err)
 
-	rsp, err := c.sendrecv(fmt.Sprintf("/groups/%s?with_projects=false", url.PathEscape(o)))
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/groups/%s?with_projects=false", url.PathEscape(o)))
 	if nil != err {
 		return nil, err
 	}
@@ -244,25 +319,71 @@ func (c *gitlabClient) getGroup(o string) (res *owner, err error) {
 	return
 }
 
-func (c *gitlabClient) getOwner(o string) (res *owner, err error) {
-	res, err = c.getUser(o)
+func (c *gitlabClient) getOwner(ctx context.Context, o string) (res *owner, err error) {
+	res, err = c.getUser(ctx, o)
 	if ErrNotFound != err {
 		return
 	}
-	res, err = c.getGroup(o)
+	res, err = c.getGroup(ctx, o)
 	return
 }
 
-func (c *gitlabClient) getRepositoryPage(prefix string, path string) ([]*repository, error) {
-	rsp, err := c.sendrecv(path)
+// getOwners returns the authenticated user and the top-level groups that the user is a
+// member of. No owners are returned without authentication.
+func (c *gitlabClient) getOwners(ctx context.Context) (res []*owner, err error) {
+	defer trace()(&err)
+
+	res = make([]*owner, 0)
+	if "" == c.login {
+		return res, nil
+	}
+
+	o := &owner{FName: c.login, FKind: "user"}
+	o.Value = o
+	res = append(res, o)
+
+	for page := 1; ; page++ {
+		rsp, err := c.sendrecv(ctx, fmt.Sprintf(
+			"/groups?top_level_only=true&min_access_level=10&per_page=100&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+
+		var content []struct {
+			FName string `json:"path"`
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&content)
+		rsp.Body.Close()
+		if nil != err {
+			return nil, err
+		}
+
+		for _, elm := range content {
+			o := &owner{FName: elm.FName, FKind: "group"}
+			o.Value = o
+			res = append(res, o)
+		}
+		if len(content) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *gitlabClient) getRepositoryPage(ctx context.Context, prefix string, path string) (
+	[]*repository, error) {
+	rsp, err := c.sendrecv(ctx, path)
 	if nil != err {
 		return nil, err
 	}
 	defer rsp.Body.Close()
 
 	var content []struct {
-		FName   string `json:"path_with_namespace"`
-		FRemote string `json:"http_url_to_repo"`
+		FName     string           `json:"path_with_namespace"`
+		FRemote   string           `json:"http_url_to_repo"`
+		FFork     *json.RawMessage `json:"forked_from_project"`
+		FArchived bool             `json:"archived"`
 	}
 	err = json.NewDecoder(rsp.Body).Decode(&content)
 	if nil != err {
@@ -275,8 +396,10 @@ func (c *gitlabClient) getRepositoryPage(prefix string, path string) ([]*reposit
 		n = strings.TrimPrefix(n, prefix)
 		n = strings.ReplaceAll(n, "/", string(AltPathSeparator))
 		r := &repository{
-			FName:   n,
-			FRemote: elm.FRemote,
+			fork:     nil != elm.FFork,
+			archived: elm.FArchived,
+			FName:    n,
+			FRemote:  elm.FRemote,
 		}
 		r.Value = r
 		r.Repository = emptyRepository
@@ -287,21 +410,26 @@ func (c *gitlabClient) getRepositoryPage(prefix string, path string) ([]*reposit
 	return res, nil
 }
 
-func (c *gitlabClient) getRepositories(owner string, kind string) (res []*repository, err error) {
+func (c *gitlabClient) getRepositories(ctx context.Context, owner string, kind string) (
+	res []*repository, err error) {
 	defer trace(owner)(&err)
 
+	// The full project representation is requested (instead of simple=true), because
+	// the simple one lacks the archived and forked_from_project fields.
 	var path string
-	if "group" == kind {
+	if gistKind == kind {
+		return nil, ErrNotFound
+	} else if "group" == kind {
 		path = fmt.Sprintf("/groups/%s/projects?"+
-			"include_subgroups=true&simple=true&order_by=id&per_page=100", url.PathEscape(owner))
+			"include_subgroups=true&order_by=id&per_page=100", url.PathEscape(owner))
 	} else {
 		path = fmt.Sprintf("/users/%s/projects?"+
-			"simple=true&order_by=id&per_page=100", url.PathEscape(owner))
+			"order_by=id&per_page=100", url.PathEscape(owner))
 	}
 
 	res = make([]*repository, 0)
 	for page := 1; ; page++ {
-		lst, err := c.getRepositoryPage(owner+"/", path+fmt.Sprintf("&page=%d", page))
+		lst, err := c.getRepositoryPage(ctx, owner+"/", path+fmt.Sprintf("&page=%d", page))
 		if nil != err {
 			return nil, err
 		}
@@ -313,3 +441,774 @@ func (c *gitlabClient) getRepositories(owner string, kind string) (res []*reposi
 
 	return res, nil
 }
+
+// getStarred returns the starred projects. Projects in subgroups are named after their
+// top-level group, with the remaining path separators replaced by AltPathSeparator.
+func (c *gitlabClient) getStarred(ctx context.Context) (res []string, err error) {
+	defer trace()(&err)
+
+	res = make([]string, 0)
+	for page := 1; ; page++ {
+		lst, err := c.getRepositoryPage(ctx, "",
+			fmt.Sprintf("/projects?starred=true&simple=true&order_by=id&per_page=100&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+		for _, elm := range lst {
+			res = append(res, gitlabFullName(elm.FName))
+		}
+		if len(lst) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// getCollaborator returns the projects that the authenticated user is a member of,
+// except for those in the user's namespace or in the user's groups, which are listed
+// under their owners.
+func (c *gitlabClient) getCollaborator(ctx context.Context) (res []string, err error) {
+	defer trace()(&err)
+
+	res = make([]string, 0)
+	if "" == c.login {
+		return res, nil
+	}
+	owners, err := c.getOwners(ctx)
+	if nil != err {
+		return nil, err
+	}
+	for page := 1; ; page++ {
+		lst, err := c.getRepositoryPage(ctx, "",
+			fmt.Sprintf("/projects?membership=true&simple=true&order_by=id&per_page=100&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+	next:
+		for _, elm := range lst {
+			n := gitlabFullName(elm.FName)
+			for _, o := range owners {
+				if strings.HasPrefix(strings.ToUpper(n), strings.ToUpper(o.FName)+"/") {
+					continue next
+				}
+			}
+			res = append(res, n)
+		}
+		if len(lst) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// getNotifications returns the pending to-do items of the authenticated user, which are
+// GitLab's equivalent of notifications.
+func (c *gitlabClient) getNotifications(ctx context.Context) (res []*Notification, err error) {
+	defer trace()(&err)
+
+	res = make([]*Notification, 0)
+	for page := 1; ; page++ {
+		rsp, err := c.sendrecv(ctx, fmt.Sprintf("/todos?state=pending&per_page=100&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+
+		var content []struct {
+			Id      int64 `json:"id"`
+			Project struct {
+				PathWithNamespace string `json:"path_with_namespace"`
+			} `json:"project"`
+			ActionName string `json:"action_name"`
+			TargetType string `json:"target_type"`
+			Target     struct {
+				Title string `json:"title"`
+			} `json:"target"`
+			TargetURL string    `json:"target_url"`
+			UpdatedAt time.Time `json:"updated_at"`
+		}
+		err = json.NewDecoder(rsp.Body).Decode(&content)
+		rsp.Body.Close()
+		if nil != err {
+			return nil, err
+		}
+
+		for _, elm := range content {
+			res = append(res, &Notification{
+				ID:         strconv.FormatInt(elm.Id, 10),
+				Repository: elm.Project.PathWithNamespace,
+				Type:       elm.TargetType,
+				Title:      elm.Target.Title,
+				Reason:     elm.ActionName,
+				WebURL:     elm.TargetURL,
+				Updated:    elm.UpdatedAt,
+			})
+		}
+		if len(content) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *gitlabClient) markNotificationRead(ctx context.Context, id string) (err error) {
+	defer trace(id)(&err)
+
+	rsp, err := c.sendrecvMethod(ctx, "POST", "/todos/"+url.PathEscape(id)+"/mark_as_done")
+	if nil != err {
+		return err
+	}
+	rsp.Body.Close()
+	return nil
+}
+
+// searchRepositories returns the first 100 projects that match a query. GitLab does not
+// support search qualifiers; the query is matched against project names.
+func (c *gitlabClient) searchRepositories(ctx context.Context, query string) (
+	res []string, err error) {
+	defer trace(query)(&err)
+
+	lst, err := c.getRepositoryPage(ctx, "",
+		fmt.Sprintf("/projects?search=%s&simple=true&per_page=100", url.QueryEscape(query)))
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]string, len(lst))
+	for i, elm := range lst {
+		res[i] = gitlabFullName(elm.FName)
+	}
+
+	return res, nil
+}
+
+// gitlabFullName converts a project name as returned by getRepositoryPage with an empty
+// prefix to the form owner/repository.
+func gitlabFullName(n string) string {
+	if i := strings.IndexByte(n, AltPathSeparator); -1 != i {
+		n = n[:i] + "/" + n[i+1:]
+	}
+	return n
+}
+
+func (c *gitlabClient) resolveCommit(
+	ctx context.Context, owner string, repository string, abbrev string) (
+	res string, err error) {
+	defer trace(owner, repository, abbrev)(&res, &err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/projects/%s/repository/commits/%s",
+		url.PathEscape(owner+"/"+repository), url.PathEscape(abbrev)))
+	if nil != err {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Id string `json:"id"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return "", err
+	}
+
+	return content.Id, nil
+}
+
+func (c *gitlabClient) getRepositoryInfo(ctx context.Context, owner string, repository string) (
+	res *RepositoryInfo, err error) {
+	defer trace(owner, repository)(&res, &err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/projects/%s",
+		url.PathEscape(owner+"/"+repository)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Name          string   `json:"path_with_namespace"`
+		Description   string   `json:"description"`
+		DefaultBranch string   `json:"default_branch"`
+		Stars         int      `json:"star_count"`
+		Visibility    string   `json:"visibility"`
+		WebURL        string   `json:"web_url"`
+		CloneURL      string   `json:"http_url_to_repo"`
+		SSHURL        string   `json:"ssh_url_to_repo"`
+		Topics        []string `json:"topics"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = &RepositoryInfo{
+		Name:          content.Name,
+		Description:   content.Description,
+		DefaultBranch: content.DefaultBranch,
+		Stars:         content.Stars,
+		Visibility:    content.Visibility,
+		WebURL:        content.WebURL,
+		CloneURL:      content.CloneURL,
+		SSHURL:        content.SSHURL,
+		Topics:        content.Topics,
+	}
+	return res, nil
+}
+
+func (c *gitlabClient) getReleasePage(ctx context.Context, path string) (
+	[]*Release, error) {
+	rsp, err := c.sendrecv(ctx, path)
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content []struct {
+		TagName     string    `json:"tag_name"`
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		Upcoming    bool      `json:"upcoming_release"`
+		ReleasedAt  time.Time `json:"released_at"`
+		Assets      struct {
+			Links []struct {
+				Name           string `json:"name"`
+				URL            string `json:"url"`
+				DirectAssetURL string `json:"direct_asset_url"`
+			} `json:"links"`
+		} `json:"assets"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res := make([]*Release, len(content))
+	for i, elm := range content {
+		r := &Release{
+			Name:       elm.TagName,
+			Title:      elm.Name,
+			Notes:      elm.Description,
+			Time:       elm.ReleasedAt,
+			Prerelease: elm.Upcoming,
+			Assets:     make([]*ReleaseAsset, 0, len(elm.Assets.Links)),
+		}
+		for _, l := range elm.Assets.Links {
+			u := l.DirectAssetURL
+			if "" == u {
+				u = l.URL
+			}
+			// The releases API does not report the size of asset links.
+			size, err := c.getSize(ctx, u)
+			if nil != err {
+				continue
+			}
+			r.Assets = append(r.Assets, &ReleaseAsset{
+				Name: l.Name,
+				Size: size,
+				URL:  u,
+				Time: elm.ReleasedAt,
+			})
+		}
+		res[i] = r
+	}
+
+	return res, nil
+}
+
+func (c *gitlabClient) getReleases(ctx context.Context, owner string, repository string) (
+	res []*Release, err error) {
+	defer trace(owner, repository)(&err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	path := fmt.Sprintf("/projects/%s/releases?per_page=100",
+		url.PathEscape(owner+"/"+repository))
+
+	res = make([]*Release, 0)
+	for page := 1; ; page++ {
+		lst, err := c.getReleasePage(ctx, path+fmt.Sprintf("&page=%d", page))
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		if len(lst) < 100 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *gitlabClient) newDownloadRequest(ctx context.Context, method string, url string) (
+	*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	if token := c.getToken(); "" != token && sameOrigin(url, c.apiURI) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+func (c *gitlabClient) getSize(ctx context.Context, url string) (size int64, err error) {
+	req, err := c.newDownloadRequest(ctx, "HEAD", url)
+	if nil != err {
+		return
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return
+	}
+	rsp.Body.Close()
+
+	if 404 == rsp.StatusCode {
+		return 0, ErrNotFound
+	} else if 400 <= rsp.StatusCode {
+		return 0, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	} else if 0 > rsp.ContentLength {
+		return 0, errors.New("unknown content length")
+	}
+
+	return rsp.ContentLength, nil
+}
+
+func (c *gitlabClient) download(ctx context.Context, url string, w io.Writer) (err error) {
+	defer trace(url)(&err)
+
+	req, err := c.newDownloadRequest(ctx, "GET", url)
+	if nil != err {
+		return
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if nil != err {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if 404 == rsp.StatusCode {
+		return ErrNotFound
+	} else if 400 <= rsp.StatusCode {
+		return errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	}
+
+	_, err = io.Copy(w, rsp.Body)
+	return
+}
+
+type gitlabIssue struct {
+	Iid    int    `json:"iid"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Author struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Labels      []string  `json:"labels"`
+	Description string    `json:"description"`
+	WebURL      string    `json:"web_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (elm *gitlabIssue) issue() *Issue {
+	state := elm.State
+	if "opened" == state {
+		state = IssueOpen
+	}
+	return &Issue{
+		Number:  elm.Iid,
+		Title:   elm.Title,
+		State:   state,
+		Author:  elm.Author.Username,
+		Labels:  elm.Labels,
+		Body:    elm.Description,
+		WebURL:  elm.WebURL,
+		Created: elm.CreatedAt,
+		Updated: elm.UpdatedAt,
+	}
+}
+
+func (c *gitlabClient) getIssuePage(
+	ctx context.Context, owner string, repository string, state string, page int) (
+	res []*Issue, more bool, err error) {
+	defer trace(owner, repository, state, page)(&more, &err)
+
+	if IssueOpen == state {
+		state = "opened"
+	}
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/projects/%s/issues?state=%s&per_page=100&page=%d",
+		url.PathEscape(owner+"/"+repository), url.QueryEscape(state), page))
+	if nil != err {
+		return nil, false, err
+	}
+	defer rsp.Body.Close()
+
+	var content []gitlabIssue
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, false, err
+	}
+
+	res = make([]*Issue, len(content))
+	for i := range content {
+		res[i] = content[i].issue()
+	}
+
+	return res, 100 <= len(content), nil
+}
+
+func (c *gitlabClient) getIssue(ctx context.Context, owner string, repository string, number int) (
+	res *Issue, err error) {
+	defer trace(owner, repository, number)(&err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/projects/%s/issues/%d",
+		url.PathEscape(owner+"/"+repository), number))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content gitlabIssue
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	return content.issue(), nil
+}
+
+type gitlabMergeRequest struct {
+	Iid    int    `json:"iid"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Draft  bool   `json:"draft"`
+	Author struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Labels       []string  `json:"labels"`
+	TargetBranch string    `json:"target_branch"`
+	SourceBranch string    `json:"source_branch"`
+	Description  string    `json:"description"`
+	WebURL       string    `json:"web_url"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (elm *gitlabMergeRequest) pullRequest() *PullRequest {
+	state := elm.State
+	if "opened" == state {
+		state = IssueOpen
+	}
+	return &PullRequest{
+		Number:  elm.Iid,
+		Title:   elm.Title,
+		State:   state,
+		Draft:   elm.Draft,
+		Author:  elm.Author.Username,
+		Labels:  elm.Labels,
+		Base:    elm.TargetBranch,
+		Head:    elm.SourceBranch,
+		Body:    elm.Description,
+		WebURL:  elm.WebURL,
+		Created: elm.CreatedAt,
+		Updated: elm.UpdatedAt,
+	}
+}
+
+func (c *gitlabClient) getPullRequestPage(
+	ctx context.Context, owner string, repository string, page int) (
+	res []*PullRequest, more bool, err error) {
+	defer trace(owner, repository, page)(&more, &err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf(
+		"/projects/%s/merge_requests?state=opened&per_page=100&page=%d",
+		url.PathEscape(owner+"/"+repository), page))
+	if nil != err {
+		return nil, false, err
+	}
+	defer rsp.Body.Close()
+
+	var content []gitlabMergeRequest
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, false, err
+	}
+
+	res = make([]*PullRequest, len(content))
+	for i := range content {
+		res[i] = content[i].pullRequest()
+	}
+
+	return res, 100 <= len(content), nil
+}
+
+func (c *gitlabClient) getPullRequest(
+	ctx context.Context, owner string, repository string, number int) (
+	res *PullRequest, err error) {
+	defer trace(owner, repository, number)(&err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/projects/%s/merge_requests/%d",
+		url.PathEscape(owner+"/"+repository), number))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content gitlabMergeRequest
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	return content.pullRequest(), nil
+}
+
+// getPullRequestPatch downloads the patch of a merge request from its web page, since
+// the API does not offer the changes in "git format-patch" format.
+func (c *gitlabClient) getPullRequestPatch(
+	ctx context.Context, owner string, repository string, number int) (
+	res []byte, err error) {
+	defer trace(owner, repository, number)(&err)
+
+	mr, err := c.getPullRequest(ctx, owner, repository, number)
+	if nil != err {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = c.download(ctx, mr.WebURL+".patch", &buf)
+	if nil != err {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *gitlabClient) getCommitPage(
+	ctx context.Context, owner string, repository string, hash string, page int) (
+	res []*Commit, more bool, err error) {
+	defer trace(owner, repository, hash, page)(&more, &err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf(
+		"/projects/%s/repository/commits?ref_name=%s&per_page=100&page=%d",
+		url.PathEscape(owner+"/"+repository), url.QueryEscape(hash), page))
+	if nil != err {
+		return nil, false, err
+	}
+	defer rsp.Body.Close()
+
+	var content []struct {
+		Id             string    `json:"id"`
+		ParentIds      []string  `json:"parent_ids"`
+		AuthorName     string    `json:"author_name"`
+		AuthorEmail    string    `json:"author_email"`
+		AuthoredDate   time.Time `json:"authored_date"`
+		CommitterName  string    `json:"committer_name"`
+		CommitterEmail string    `json:"committer_email"`
+		CommittedDate  time.Time `json:"committed_date"`
+		Message        string    `json:"message"`
+		WebURL         string    `json:"web_url"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, false, err
+	}
+
+	res = make([]*Commit, len(content))
+	for i, elm := range content {
+		res[i] = &Commit{
+			Hash:           elm.Id,
+			Parents:        elm.ParentIds,
+			Author:         elm.AuthorName,
+			AuthorEmail:    elm.AuthorEmail,
+			AuthorTime:     elm.AuthoredDate,
+			Committer:      elm.CommitterName,
+			CommitterEmail: elm.CommitterEmail,
+			CommitTime:     elm.CommittedDate,
+			Message:        elm.Message,
+			WebURL:         elm.WebURL,
+		}
+	}
+
+	return res, 100 <= len(content), nil
+}
+
+// getBlame converts the blame that the API reports as groups of lines to line ranges.
+func (c *gitlabClient) getBlame(
+	ctx context.Context, owner string, repository string, hash string, path string) (
+	res []*BlameRange, err error) {
+	defer trace(owner, repository, hash, path)(&err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/projects/%s/repository/files/%s/blame?ref=%s",
+		url.PathEscape(owner+"/"+repository), url.PathEscape(path), url.QueryEscape(hash)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content []struct {
+		Commit struct {
+			ID           string    `json:"id"`
+			AuthorName   string    `json:"author_name"`
+			AuthoredDate time.Time `json:"authored_date"`
+		} `json:"commit"`
+		Lines []string `json:"lines"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*BlameRange, 0, len(content))
+	line := 1
+	for _, elm := range content {
+		if 0 == len(elm.Lines) {
+			continue
+		}
+		res = append(res, &BlameRange{
+			Start:      line,
+			End:        line + len(elm.Lines) - 1,
+			Commit:     elm.Commit.ID,
+			Author:     elm.Commit.AuthorName,
+			AuthorTime: elm.Commit.AuthoredDate,
+		})
+		line += len(elm.Lines)
+	}
+
+	return res, nil
+}
+
+func (c *gitlabClient) getArchive(ctx context.Context, owner string, repository string,
+	hash string, format string, w io.Writer) (err error) {
+	defer trace(owner, repository, hash, format)(&err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	return c.download(ctx, fmt.Sprintf("%s/projects/%s/repository/archive.%s?sha=%s",
+		c.apiURI, url.PathEscape(owner+"/"+repository), format, url.QueryEscape(hash)), w)
+}
+
+func (c *gitlabClient) getBlob(ctx context.Context, owner string, repository string,
+	hash string, w io.Writer) (err error) {
+	defer trace(owner, repository, hash)(&err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	return c.download(ctx, fmt.Sprintf("%s/projects/%s/repository/blobs/%s/raw",
+		c.apiURI, url.PathEscape(owner+"/"+repository), url.PathEscape(hash)), w)
+}
+
+// getDiff formats the file diffs reported by the compare API as a git diff, since the
+// API does not provide the diff in patch format.
+func (c *gitlabClient) getDiff(
+	ctx context.Context, owner string, repository string, base string, head string) (
+	res []byte, err error) {
+	defer trace(owner, repository, base, head)(&err)
+
+	repository = strings.ReplaceAll(repository, string(AltPathSeparator), "/")
+	rsp, err := c.sendrecv(ctx, fmt.Sprintf("/projects/%s/repository/compare?from=%s&to=%s",
+		url.PathEscape(owner+"/"+repository), url.QueryEscape(base), url.QueryEscape(head)))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Diffs []gitlabDiff `json:"diffs"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for i := range content.Diffs {
+		content.Diffs[i].format(&buf)
+	}
+
+	return buf.Bytes(), nil
+}
+
+type gitlabDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	AMode       string `json:"a_mode"`
+	BMode       string `json:"b_mode"`
+	Diff        string `json:"diff"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+}
+
+func (elm *gitlabDiff) format(w io.Writer) {
+	fmt.Fprintf(w, "diff --git a/%s b/%s\n", elm.OldPath, elm.NewPath)
+	oldPath, newPath := "a/"+elm.OldPath, "b/"+elm.NewPath
+	switch {
+	case elm.NewFile:
+		fmt.Fprintf(w, "new file mode %s\n", elm.BMode)
+		oldPath = "/dev/null"
+	case elm.DeletedFile:
+		fmt.Fprintf(w, "deleted file mode %s\n", elm.AMode)
+		newPath = "/dev/null"
+	default:
+		if elm.AMode != elm.BMode {
+			fmt.Fprintf(w, "old mode %s\nnew mode %s\n", elm.AMode, elm.BMode)
+		}
+		if elm.RenamedFile {
+			fmt.Fprintf(w, "rename from %s\nrename to %s\n", elm.OldPath, elm.NewPath)
+		}
+	}
+	if "" != elm.Diff {
+		fmt.Fprintf(w, "--- %s\n+++ %s\n", oldPath, newPath)
+		io.WriteString(w, elm.Diff)
+		if !strings.HasSuffix(elm.Diff, "\n") {
+			io.WriteString(w, "\n")
+		}
+	}
+}
+
+// GitLab CI pipelines are not presented as workflows.
+func (c *gitlabClient) getWorkflows(ctx context.Context, owner string, repository string) (
+	res []*Workflow, err error) {
+	return nil, ErrNotFound
+}
+
+func (c *gitlabClient) getWorkflowRuns(
+	ctx context.Context, owner string, repository string, workflow int64) (
+	res []*WorkflowRun, err error) {
+	return nil, ErrNotFound
+}
+
+func (c *gitlabClient) getWorkflowRun(
+	ctx context.Context, owner string, repository string, id int64) (
+	res *WorkflowRun, err error) {
+	return nil, ErrNotFound
+}
+
+func (c *gitlabClient) getWorkflowJobs(
+	ctx context.Context, owner string, repository string, run int64) (
+	res []*WorkflowJob, err error) {
+	return nil, ErrNotFound
+}
+
+func (c *gitlabClient) getWorkflowJobLog(
+	ctx context.Context, owner string, repository string, job int64, w io.Writer) (err error) {
+	return ErrNotFound
+}
+
+func (c *gitlabClient) getArtifacts(
+	ctx context.Context, owner string, repository string, run int64) (
+	res []*Artifact, err error) {
+	return nil, ErrNotFound
+}
@@ -0,0 +1,52 @@
+/*
+ * gitlab_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestGitlabAuthError(t *testing.T) {
+	tests := []struct {
+		status int
+		header string
+		expect string
+	}{
+		{401, `Bearer realm="gitlab", error="invalid_token", error_description="Token has expired."`,
+			"auth token rejected: Token has expired."},
+		{403, `Bearer realm="gitlab", error="insufficient_scope", ` +
+			`error_description="The request requires higher privileges.", scope="api read_api"`,
+			"auth token rejected: token requires scope api read_api"},
+		{401, "",
+			"auth token rejected: token is invalid, has expired or has been revoked"},
+		{403, "", ""},
+		{404, "", ""},
+	}
+	for _, test := range tests {
+		rsp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+		if "" != test.header {
+			rsp.Header.Set("WWW-Authenticate", test.header)
+		}
+		err := gitlabAuthError(rsp)
+		if "" == test.expect {
+			if nil != err {
+				t.Error(err)
+			}
+		} else if !errors.Is(err, ErrAuth) || test.expect != err.Error() {
+			t.Errorf("expect %q got %v", test.expect, err)
+		}
+	}
+}
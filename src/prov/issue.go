@@ -0,0 +1,87 @@
+/*
+ * issue.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+)
+
+// GetIssues returns the issues of the repository that are in the specified state
+// (IssueOpen or IssueClosed). Issue pages are fetched from the provider on first use.
+func (r *gitRepository) GetIssues(ctx context.Context, state string) (res []*Issue, err error) {
+	r.lock.RLock()
+	res = r.issues[state]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res = make([]*Issue, 0)
+	for page := 1; ; page++ {
+		lst, more, err := r.api.getIssuePage(ctx, state, page)
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		if !more {
+			break
+		}
+	}
+
+	r.lock.Lock()
+	if nil == r.issues {
+		r.issues = make(map[string][]*Issue)
+	}
+	if nil == r.issuemap {
+		r.issuemap = make(map[int]*Issue)
+	}
+	r.issues[state] = res
+	for _, issue := range res {
+		r.issuemap[issue.Number] = issue
+	}
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetIssue returns a single issue. The issue is fetched from the provider unless
+// it has already been seen in an issue listing.
+func (r *gitRepository) GetIssue(ctx context.Context, number int) (res *Issue, err error) {
+	r.lock.RLock()
+	res = r.issuemap[number]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getIssue(ctx, number)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	if nil == r.issuemap {
+		r.issuemap = make(map[int]*Issue)
+	}
+	r.issuemap[number] = res
+	r.lock.Unlock()
+	return res, nil
+}
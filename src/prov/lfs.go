@@ -0,0 +1,232 @@
+/*
+ * lfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/winfsp/hubfs/httputil"
+)
+
+// Git LFS pointer files are required to be smaller than 1024 bytes.
+// See https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md
+const lfsPointerMaxSize = 1024
+
+var lfsPointerVersion = []byte("version https://git-lfs.github.com/spec/v1\n")
+
+// parseLfsPointer parses the content of an LFS pointer file and returns the oid
+// (SHA-256 hash) and size of the actual content.
+func parseLfsPointer(content []byte) (oid string, size int64, ok bool) {
+	if lfsPointerMaxSize <= len(content) || !bytes.HasPrefix(content, lfsPointerVersion) {
+		return "", 0, false
+	}
+
+	size = -1
+	for _, line := range strings.Split(string(content[len(lfsPointerVersion):]), "\n") {
+		switch {
+		case strings.HasPrefix(line, "oid sha256:"):
+			oid = strings.TrimPrefix(line, "oid sha256:")
+		case strings.HasPrefix(line, "size "):
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, "size "), 10, 64)
+			if nil != err {
+				return "", 0, false
+			}
+			size = n
+		}
+	}
+
+	if 64 != len(oid) || 0 > size {
+		return "", 0, false
+	}
+	if _, err := hex.DecodeString(oid); nil != err {
+		return "", 0, false
+	}
+
+	return oid, size, true
+}
+
+func lfsEndpoint(remote string) string {
+	remote = strings.TrimSuffix(remote, "/")
+	if !strings.HasSuffix(remote, ".git") {
+		remote += ".git"
+	}
+	return remote + "/info/lfs"
+}
+
+func lfsObjectPath(dir string, oid string) string {
+	return filepath.Join(dir, "lfs", oid[:2], oid[2:4], oid)
+}
+
+type lfsObject struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+func (r *gitRepository) lfsDownloadAction(ctx context.Context, oid string, size int64) (
+	href string, header map[string]string, err error) {
+
+	var request = struct {
+		Operation string      `json:"operation"`
+		Transfers []string    `json:"transfers"`
+		Objects   []lfsObject `json:"objects"`
+	}{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   []lfsObject{{Oid: oid, Size: size}},
+	}
+
+	var body bytes.Buffer
+	err = json.NewEncoder(&body).Encode(&request)
+	if nil != err {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx,
+		"POST", lfsEndpoint(r.remote)+"/objects/batch", &body)
+	if nil != err {
+		return
+	}
+
+	req.Header.Set("Accept", "application/vnd.git-lfs+json")
+	req.Header.Set("Content-type", "application/vnd.git-lfs+json")
+	if username, password := r.credentials(); "" != username || "" != password {
+		req.SetBasicAuth(username, password)
+	}
+
+	rsp, err := httputil.DefaultClient.Do(req)
+	if nil != err {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if 404 == rsp.StatusCode {
+		err = ErrNotFound
+		return
+	} else if 400 <= rsp.StatusCode {
+		err = errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+		return
+	}
+
+	var content struct {
+		Objects []struct {
+			Oid     string `json:"oid"`
+			Actions struct {
+				Download struct {
+					Href   string            `json:"href"`
+					Header map[string]string `json:"header"`
+				} `json:"download"`
+			} `json:"actions"`
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"objects"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return
+	}
+
+	for _, o := range content.Objects {
+		if oid != o.Oid {
+			continue
+		}
+		if nil != o.Error {
+			if 404 == o.Error.Code {
+				err = ErrNotFound
+			} else {
+				err = errors.New(fmt.Sprintf("LFS %d: %s", o.Error.Code, o.Error.Message))
+			}
+			return
+		}
+		href, header = o.Actions.Download.Href, o.Actions.Download.Header
+		if "" == href {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	err = ErrNotFound
+	return
+}
+
+func (r *gitRepository) lfsDownload(ctx context.Context, oid string, size int64, w io.Writer) (
+	err error) {
+	href, header, err := r.lfsDownloadAction(ctx, oid, size)
+	if nil != err {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(httputil.WithDownload(ctx), "GET", href, nil)
+	if nil != err {
+		return
+	}
+
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	rsp, err := httputil.DefaultClient.Do(req)
+	if nil != err {
+		return
+	}
+	defer rsp.Body.Close()
+
+	if 404 == rsp.StatusCode {
+		return ErrNotFound
+	} else if 400 <= rsp.StatusCode {
+		return errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), rsp.Body)
+	if nil != err {
+		return
+	}
+	if size != n || oid != hex.EncodeToString(h.Sum(nil)) {
+		return errors.New("LFS object corrupt")
+	}
+
+	return nil
+}
+
+// fetchLfsReader returns a reader for the LFS object with the specified oid. When a
+// cache directory is set the object is downloaded once and kept in the directory.
+func (r *gitRepository) fetchLfsReader(ctx context.Context, dir string, oid string, size int64) (
+	res io.ReaderAt, err error) {
+
+	if "" == dir {
+		var buf bytes.Buffer
+		err = r.lfsDownload(ctx, oid, size, &buf)
+		if nil != err {
+			return nil, err
+		}
+		return readerAtNopCloser{bytes.NewReader(buf.Bytes())}, nil
+	}
+
+	return fetchFile(lfsObjectPath(dir, oid), func(w io.Writer) error {
+		return r.lfsDownload(ctx, oid, size, w)
+	})
+}
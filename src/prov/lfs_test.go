@@ -0,0 +1,70 @@
+/*
+ * lfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"testing"
+)
+
+func TestParseLfsPointer(t *testing.T) {
+	expect := func(content string, eoid string, esize int64, eok bool) {
+		oid, size, ok := parseLfsPointer([]byte(content))
+		if eoid != oid || esize != size || eok != ok {
+			t.Errorf("content %q expect (%q, %v, %v) got (%q, %v, %v)",
+				content, eoid, esize, eok, oid, size, ok)
+		}
+	}
+
+	oid := "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	expect(
+		"version https://git-lfs.github.com/spec/v1\n"+
+			"oid sha256:"+oid+"\n"+
+			"size 12345\n",
+		oid, 12345, true)
+	expect(
+		"version https://git-lfs.github.com/spec/v1\n"+
+			"ext-0-foo sha256:"+oid+"\n"+
+			"oid sha256:"+oid+"\n"+
+			"size 0\n",
+		oid, 0, true)
+	expect(
+		"version https://git-lfs.github.com/spec/v1\n"+
+			"oid sha256:"+oid[1:]+"\n"+
+			"size 12345\n",
+		"", 0, false)
+	expect(
+		"version https://git-lfs.github.com/spec/v1\n"+
+			"oid sha256:"+oid+"\n",
+		"", 0, false)
+	expect(
+		"version https://git-lfs.github.com/spec/v1\n"+
+			"oid sha256:"+oid+"\n"+
+			"size -1\n",
+		"", 0, false)
+	expect("hello world\n", "", 0, false)
+	expect("", "", 0, false)
+}
+
+func TestLfsEndpoint(t *testing.T) {
+	expect := func(remote string, e string) {
+		u := lfsEndpoint(remote)
+		if e != u {
+			t.Errorf("remote %q expect %q got %q", remote, e, u)
+		}
+	}
+
+	expect("https://github.com/winfsp/hubfs.git", "https://github.com/winfsp/hubfs.git/info/lfs")
+	expect("https://github.com/winfsp/hubfs", "https://github.com/winfsp/hubfs.git/info/lfs")
+	expect("https://github.com/winfsp/hubfs/", "https://github.com/winfsp/hubfs.git/info/lfs")
+}
@@ -0,0 +1,315 @@
+/*
+ * memprov.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package memprov implements a provider whose owners, repositories, refs and trees are
+// kept in memory and are populated programmatically. It does not access the network
+// and is meant for tests and for programs that embed hubfs.
+//
+//	client := memprov.NewClient()
+//	repo := client.AddOwner("owner").AddRepository("repo")
+//	ref := repo.AddRef("main", prov.RefBranch, time.Now())
+//	ref.AddFile("README.md", 0100644, []byte("hello\n"))
+//
+// Workflows and artifacts are not supported.
+package memprov
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/winfsp/hubfs/prov"
+)
+
+// Client is an in-memory client. Its methods may be called concurrently, including
+// those that populate it.
+type Client struct {
+	lock    sync.RWMutex
+	caseins bool
+	owners  []*Owner
+	starred []string
+	collab  []string
+	notes   []*prov.Notification
+	access  []*prov.AccessDiagnosis
+}
+
+// Owner is an owner of in-memory repositories.
+type Owner struct {
+	client       *Client
+	name         string
+	repositories []*Repository
+}
+
+// NewClient creates an empty in-memory client.
+func NewClient() *Client {
+	return &Client{}
+}
+
+// NewProvider returns a provider whose clients are all the in-memory client c.
+func NewProvider(c *Client) prov.Provider {
+	return &provider{client: c}
+}
+
+type provider struct {
+	client *Client
+}
+
+func (p *provider) Auth() (string, error) {
+	return "", nil
+}
+
+func (p *provider) NewClient(token string) (prov.Client, error) {
+	return p.client, nil
+}
+
+func (c *Client) equal(s, t string) bool {
+	if c.caseins {
+		return strings.EqualFold(s, t)
+	}
+	return s == t
+}
+
+// AddOwner adds an owner or returns the existing owner with the same name.
+func (c *Client) AddOwner(name string) *Owner {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, o := range c.owners {
+		if o.name == name {
+			return o
+		}
+	}
+	o := &Owner{client: c, name: name}
+	c.owners = append(c.owners, o)
+	return o
+}
+
+// AddCollaborator adds repositories (owner/repository) to the repositories that the user
+// collaborates on.
+func (c *Client) AddCollaborator(names ...string) {
+	c.lock.Lock()
+	c.collab = append(c.collab, names...)
+	c.lock.Unlock()
+}
+
+// AddAccessDiagnosis adds a diagnosis for a repository (owner/repository) that cannot be
+// opened. Opening the repository fails with a prov.AuthError if the reason of the
+// diagnosis is prov.AccessToken, prov.AccessScope or prov.AccessSSO.
+func (c *Client) AddAccessDiagnosis(d *prov.AccessDiagnosis) {
+	c.lock.Lock()
+	c.access = append(c.access, d)
+	c.lock.Unlock()
+}
+
+// AddStarred adds repositories (owner/repository) to the starred repositories.
+func (c *Client) AddStarred(names ...string) {
+	c.lock.Lock()
+	c.starred = append(c.starred, names...)
+	c.lock.Unlock()
+}
+
+// AddNotification adds an unread notification.
+func (c *Client) AddNotification(note *prov.Notification) {
+	c.lock.Lock()
+	c.notes = append(c.notes, note)
+	c.lock.Unlock()
+}
+
+// SetConfig applies the config._caseins option and returns the others.
+func (c *Client) SetConfig(config []string) ([]string, error) {
+	res := []string{}
+	for _, s := range config {
+		switch s {
+		case "config._caseins=1":
+			c.lock.Lock()
+			c.caseins = true
+			c.lock.Unlock()
+		case "config._caseins=0":
+			c.lock.Lock()
+			c.caseins = false
+			c.lock.Unlock()
+		default:
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+func (c *Client) GetDirectory() string {
+	return ""
+}
+
+func (c *Client) GetOwners(ctx context.Context) ([]prov.Owner, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := make([]prov.Owner, 0, len(c.owners))
+	for _, o := range c.owners {
+		res = append(res, o)
+	}
+	return res, nil
+}
+
+func (c *Client) OpenOwner(ctx context.Context, name string) (prov.Owner, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, o := range c.owners {
+		if c.equal(o.name, name) {
+			return o, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (c *Client) OpenGistOwner(ctx context.Context, name string) (prov.Owner, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (c *Client) GetStarredRepositories(ctx context.Context) ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]string{}, c.starred...), nil
+}
+
+func (c *Client) GetCollaboratorRepositories(ctx context.Context) ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]string{}, c.collab...), nil
+}
+
+// SearchRepositories returns the repositories (owner/repository) whose full names
+// contain the query, ignoring case.
+func (c *Client) SearchRepositories(ctx context.Context, query string) ([]string, error) {
+	query = strings.ToLower(query)
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := []string{}
+	for _, o := range c.owners {
+		for _, r := range o.repositories {
+			n := o.name + "/" + r.name
+			if strings.Contains(strings.ToLower(n), query) {
+				res = append(res, n)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (c *Client) GetNotifications(ctx context.Context) ([]*prov.Notification, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]*prov.Notification{}, c.notes...), nil
+}
+
+func (c *Client) MarkNotificationRead(ctx context.Context, id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, n := range c.notes {
+		if n.ID == id {
+			c.notes = append(c.notes[:i:i], c.notes[i+1:]...)
+			return nil
+		}
+	}
+	return prov.ErrNotFound
+}
+
+func (c *Client) CloseOwner(owner prov.Owner) {
+}
+
+func (c *Client) GetRepositories(ctx context.Context, owner prov.Owner) ([]prov.Repository, error) {
+	o, ok := owner.(*Owner)
+	if !ok || o.client != c {
+		return nil, prov.ErrNotFound
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := make([]prov.Repository, 0, len(o.repositories))
+	for _, r := range o.repositories {
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func (c *Client) OpenRepository(ctx context.Context, owner prov.Owner, name string) (
+	prov.Repository, error) {
+	o, ok := owner.(*Owner)
+	if !ok || o.client != c {
+		return nil, prov.ErrNotFound
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, r := range o.repositories {
+		if c.equal(r.name, name) {
+			return r, nil
+		}
+	}
+	for _, d := range c.access {
+		if !c.equal(d.Repository, o.name+"/"+name) {
+			continue
+		}
+		switch d.Reason {
+		case prov.AccessToken, prov.AccessScope, prov.AccessSSO:
+			return nil, &prov.AuthError{Reason: d.Reason, Message: d.Message}
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (c *Client) CloseRepository(repository prov.Repository) {
+}
+
+func (c *Client) StartExpiration() {
+}
+
+func (c *Client) StopExpiration() {
+}
+
+func (c *Client) FlushCache() {
+}
+
+func (c *Client) GetOpenDirectories() []string {
+	return nil
+}
+
+func (c *Client) GetRateLimit() prov.RateLimit {
+	return prov.RateLimit{}
+}
+
+func (c *Client) GetAccessDiagnoses() []*prov.AccessDiagnosis {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]*prov.AccessDiagnosis{}, c.access...)
+}
+
+func (c *Client) SetToken(ctx context.Context, token string) error {
+	return nil
+}
+
+func (o *Owner) Name() string {
+	return o.name
+}
+
+// AddRepository adds a repository or returns the existing repository with the same name.
+func (o *Owner) AddRepository(name string) *Repository {
+	c := o.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range o.repositories {
+		if r.name == name {
+			return r
+		}
+	}
+	r := newRepository(c, o.name, name)
+	o.repositories = append(o.repositories, r)
+	return r
+}
@@ -0,0 +1,146 @@
+/*
+ * memprov_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package memprov
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/winfsp/hubfs/prov"
+)
+
+var _ prov.Client = (*Client)(nil)
+var _ prov.Repository = (*Repository)(nil)
+
+func TestMemprov(t *testing.T) {
+	ctx := context.Background()
+
+	client := NewClient()
+	repo := client.AddOwner("Owner").AddRepository("Repo")
+	main := repo.AddRef("main", prov.RefBranch, time.Unix(1, 0))
+	main.AddFile("README.md", 0100644, []byte("hello\n"))
+	main.AddFile("bin/run", 0100755, []byte("#!/bin/sh\n"))
+	main.AddFile("link", 0120000, []byte("README.md"))
+	repo.AddRef("v1.0.0", prov.RefTag, time.Unix(2, 0))
+	repo.AddRef("v1.1.0-rc.1", prov.RefTag, time.Unix(3, 0))
+	sub := client.AddOwner("Owner").AddRepository("Sub")
+	subref := sub.AddRef("main", prov.RefBranch, time.Unix(4, 0))
+	main.AddModule("sub", "Owner/Sub", subref.Hash())
+
+	owner, err := client.OpenOwner(ctx, "Owner")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = client.OpenOwner(ctx, "owner"); prov.ErrNotFound != err {
+		t.Error(err)
+	}
+	client.SetConfig([]string{"config._caseins=1"})
+	if _, err = client.OpenOwner(ctx, "owner"); nil != err {
+		t.Error(err)
+	}
+
+	r, err := client.OpenRepository(ctx, owner, "repo")
+	if nil != err {
+		t.Fatal(err)
+	}
+	refs, _ := r.GetRefs(ctx)
+	if 1 != len(refs) || "main" != refs[0].Name() {
+		t.Error(refs)
+	}
+	ref, err := r.GetDefaultRef(ctx)
+	if nil != err || "main" != ref.Name() {
+		t.Error(ref, err)
+	}
+	latest, err := r.GetLatestRef(ctx)
+	if nil != err || "v1.0.0" != latest.Name() {
+		t.Error(latest, err)
+	}
+
+	tree, _ := r.GetTree(ctx, ref, nil)
+	names := []string{}
+	for _, e := range tree {
+		names = append(names, e.Name())
+	}
+	if "[README.md bin link sub]" != fmt.Sprint(names) {
+		t.Error(names)
+	}
+	bin, err := r.GetTreeEntry(ctx, ref, nil, "BIN")
+	if nil != err || 0040000 != bin.Mode() {
+		t.Fatal(bin, err)
+	}
+	run, err := r.GetTreeEntry(ctx, ref, bin, "run")
+	if nil != err || 0100755 != run.Mode() || 10 != run.Size() {
+		t.Fatal(run, err)
+	}
+	link, _ := r.GetTreeEntry(ctx, ref, nil, "link")
+	if "README.md" != link.Target() {
+		t.Error(link.Target())
+	}
+	readme, _ := r.GetTreeEntry(ctx, ref, nil, "README.md")
+	if "ce013625030ba8dba906f756967f9e9ca394464a" != readme.Hash() {
+		t.Error(readme.Hash())
+	}
+	reader, err := r.GetBlobReader(ctx, readme)
+	if nil != err {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, _ := reader.ReadAt(buf, 0)
+	if "hello\n" != string(buf[:n]) {
+		t.Error(string(buf[:n]))
+	}
+
+	module, err := r.GetModule(ctx, ref, "sub", true)
+	if nil != err || "Owner/Sub" != module {
+		t.Error(module, err)
+	}
+	s, _ := client.OpenRepository(ctx, owner, "Sub")
+	modref, err := s.GetTempRef(ctx, subref.Hash()[:8])
+	if nil != err || prov.RefTemp != modref.Kind() || subref.Hash() != modref.Hash() {
+		t.Error(modref, err)
+	}
+
+	archive, size, err := r.GetArchiveReader(ctx, ref, "zip")
+	if nil != err {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(archive, size)
+	if nil != err || 2 != len(z.File) || "Repo-"+ref.Hash()+"/README.md" != z.File[0].Name {
+		t.Fatal(err)
+	}
+	f, _ := z.File[0].Open()
+	data, _ := ioutil.ReadAll(f)
+	f.Close()
+	if "hello\n" != string(data) {
+		t.Error(string(data))
+	}
+
+	repo.AddRelease(&prov.Release{Name: "v1.0.0"}, map[string][]byte{"a.txt": []byte("asset")})
+	releases, _ := r.GetReleases(ctx)
+	if 1 != len(releases) || 1 != len(releases[0].Assets) || 5 != releases[0].Assets[0].Size {
+		t.Fatal(releases)
+	}
+	if _, err = r.GetReleaseAssetReader(ctx, releases[0].Assets[0]); nil != err {
+		t.Error(err)
+	}
+
+	res, _ := client.SearchRepositories(ctx, "sub")
+	if "[Owner/Sub]" != fmt.Sprint(res) {
+		t.Error(res)
+	}
+}
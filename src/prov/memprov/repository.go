@@ -0,0 +1,797 @@
+/*
+ * repository.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package memprov
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+// Repository is an in-memory repository.
+type Repository struct {
+	client   *Client
+	owner    string
+	name     string
+	dir      string
+	refs     []*Ref
+	defref   string
+	info     *prov.RepositoryInfo
+	releases []*prov.Release
+	assets   map[string][]byte
+	issues   []*prov.Issue
+	pulls    []*prov.PullRequest
+	patches  map[int][]byte
+	commits  map[string][]*prov.Commit
+	blames   map[string][]*prov.BlameRange
+	diffs    map[string][]byte
+	wiki     *Repository
+}
+
+// Ref is a ref of an in-memory repository. Its tree is populated by AddFile and
+// AddModule; parent directories are created as needed.
+type Ref struct {
+	repository *Repository
+	name       string
+	kind       prov.RefKind
+	time       time.Time
+	hash       string
+	root       *entry
+	modules    map[string]string
+}
+
+type entry struct {
+	name     string
+	mode     uint32
+	size     int64
+	target   string
+	hash     string
+	data     []byte
+	children []*entry
+}
+
+func newRepository(c *Client, owner string, name string) *Repository {
+	return &Repository{
+		client:  c,
+		owner:   owner,
+		name:    name,
+		assets:  make(map[string][]byte),
+		patches: make(map[int][]byte),
+		commits: make(map[string][]*prov.Commit),
+		blames:  make(map[string][]*prov.BlameRange),
+		diffs:   make(map[string][]byte),
+	}
+}
+
+func hashOf(kind string, data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", kind, len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AddRef adds a ref (e.g. a branch or a tag) with an empty tree, or returns the existing
+// ref with the same name. The first branch that is added is the default ref.
+func (r *Repository) AddRef(name string, kind prov.RefKind, treeTime time.Time) *Ref {
+	c := r.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, ref := range r.refs {
+		if ref.name == name {
+			return ref
+		}
+	}
+	ref := &Ref{
+		repository: r,
+		name:       name,
+		kind:       kind,
+		time:       treeTime,
+		hash:       hashOf("commit", []byte(r.owner+"/"+r.name+"/"+name)),
+		root:       &entry{mode: 0040000},
+		modules:    make(map[string]string),
+	}
+	r.refs = append(r.refs, ref)
+	if "" == r.defref && prov.RefBranch == kind {
+		r.defref = name
+	}
+	return ref
+}
+
+// SetDefaultRef sets the name of the default ref.
+func (r *Repository) SetDefaultRef(name string) {
+	c := r.client
+	c.lock.Lock()
+	r.defref = name
+	c.lock.Unlock()
+}
+
+// SetInfo sets the provider metadata of the repository.
+func (r *Repository) SetInfo(info *prov.RepositoryInfo) {
+	c := r.client
+	c.lock.Lock()
+	r.info = info
+	c.lock.Unlock()
+}
+
+// AddRelease adds a release with assets that have the specified contents. The assets
+// have SHA-256 digests like those that GitHub reports.
+func (r *Repository) AddRelease(release *prov.Release, assets map[string][]byte) {
+	names := make([]string, 0, len(assets))
+	for n := range assets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	c := r.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	release.Assets = nil
+	for _, n := range names {
+		url := "mem:///" + r.owner + "/" + r.name + "/releases/" + release.Name + "/" + n
+		sum := sha256.Sum256(assets[n])
+		release.Assets = append(release.Assets, &prov.ReleaseAsset{
+			Name:   n,
+			Size:   int64(len(assets[n])),
+			URL:    url,
+			Time:   release.Time,
+			Digest: "sha256:" + hex.EncodeToString(sum[:]),
+		})
+		r.assets[url] = assets[n]
+	}
+	r.releases = append(r.releases, release)
+}
+
+// AddIssue adds an issue.
+func (r *Repository) AddIssue(issue *prov.Issue) {
+	c := r.client
+	c.lock.Lock()
+	r.issues = append(r.issues, issue)
+	c.lock.Unlock()
+}
+
+// AddPullRequest adds a pull request with its patch.
+func (r *Repository) AddPullRequest(pull *prov.PullRequest, patch []byte) {
+	c := r.client
+	c.lock.Lock()
+	r.pulls = append(r.pulls, pull)
+	r.patches[pull.Number] = patch
+	c.lock.Unlock()
+}
+
+// AddDiff adds the diff between two refs or commits.
+func (r *Repository) AddDiff(base string, head string, diff []byte) {
+	c := r.client
+	c.lock.Lock()
+	r.diffs[base+"..."+head] = diff
+	c.lock.Unlock()
+}
+
+// Wiki returns the wiki repository, which is created on first use.
+func (r *Repository) Wiki() *Repository {
+	c := r.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if nil == r.wiki {
+		r.wiki = newRepository(c, r.owner, r.name+".wiki")
+	}
+	return r.wiki
+}
+
+func (r *Repository) Close() error {
+	return nil
+}
+
+func (r *Repository) GetDirectory() string {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return r.dir
+}
+
+func (r *Repository) SetDirectory(path string) error {
+	c := r.client
+	c.lock.Lock()
+	r.dir = path
+	c.lock.Unlock()
+	return nil
+}
+
+func (r *Repository) RemoveDirectory() error {
+	return nil
+}
+
+func (r *Repository) Name() string {
+	return r.name
+}
+
+// GetRefs returns the branches and pull request refs, like the other providers do by
+// default. Tags are available by name through GetRef.
+func (r *Repository) GetRefs(ctx context.Context) ([]prov.Ref, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := []prov.Ref{}
+	for _, ref := range r.refs {
+		if prov.RefBranch == ref.kind || prov.RefPull == ref.kind {
+			res = append(res, ref)
+		}
+	}
+	return res, nil
+}
+
+func (r *Repository) getRef(name string) (prov.Ref, error) {
+	for _, ref := range r.refs {
+		if r.client.equal(ref.name, name) {
+			return ref, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetRef(ctx context.Context, name string) (prov.Ref, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return r.getRef(name)
+}
+
+// GetTempRef returns a ref for a commit hash (or an unambiguous hash prefix) of a ref.
+func (r *Repository) GetTempRef(ctx context.Context, name string) (prov.Ref, error) {
+	if 7 > len(name) || 40 < len(name) {
+		return nil, prov.ErrNotFound
+	}
+	prefix := strings.ToLower(name)
+
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var res *Ref
+	for _, ref := range r.refs {
+		if strings.HasPrefix(ref.hash, prefix) {
+			if nil != res && res.hash != ref.hash {
+				return nil, prov.ErrNotFound
+			}
+			res = ref
+		}
+	}
+	if nil == res {
+		return nil, prov.ErrNotFound
+	}
+	tmp := *res
+	tmp.name = prefix
+	tmp.kind = prov.RefTemp
+	return &tmp, nil
+}
+
+func (r *Repository) GetDefaultRef(ctx context.Context) (prov.Ref, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if "" == r.defref {
+		return nil, prov.ErrNotFound
+	}
+	return r.getRef(r.defref)
+}
+
+// GetLatestRef returns the tag with the highest semantic version.
+// Prerelease versions are only considered if there are no release versions.
+func (r *Repository) GetLatestRef(ctx context.Context) (prov.Ref, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var latest *Ref
+	var latestver util.Semver
+	for _, ref := range r.refs {
+		if prov.RefTag != ref.kind {
+			continue
+		}
+		v, ok := util.ParseSemver(ref.name)
+		if !ok {
+			continue
+		}
+		if nil != latest {
+			isrel, latestrel := 0 == len(v.Prerelease), 0 == len(latestver.Prerelease)
+			if latestrel && !isrel {
+				continue
+			}
+			if latestrel == isrel && 0 >= v.Compare(latestver) {
+				continue
+			}
+		}
+		latest, latestver = ref, v
+	}
+	if nil == latest {
+		return nil, prov.ErrNotFound
+	}
+	return latest, nil
+}
+
+func (r *Repository) tree(ref prov.Ref, e prov.TreeEntry) (*entry, error) {
+	if nil == e {
+		m, ok := ref.(*Ref)
+		if !ok || m.repository != r {
+			return nil, prov.ErrNotFound
+		}
+		return m.root, nil
+	}
+	m, ok := e.(*entry)
+	if !ok || 0040000 != m.mode {
+		return nil, prov.ErrNotFound
+	}
+	return m, nil
+}
+
+func (r *Repository) GetTree(ctx context.Context, ref prov.Ref, e prov.TreeEntry) (
+	[]prov.TreeEntry, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	dir, err := r.tree(ref, e)
+	if nil != err {
+		return nil, err
+	}
+	res := make([]prov.TreeEntry, 0, len(dir.children))
+	for _, child := range dir.children {
+		res = append(res, child)
+	}
+	return res, nil
+}
+
+func (r *Repository) GetTreeEntry(ctx context.Context, ref prov.Ref, e prov.TreeEntry, name string) (
+	prov.TreeEntry, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	dir, err := r.tree(ref, e)
+	if nil != err {
+		return nil, err
+	}
+	for _, child := range dir.children {
+		if c.equal(child.name, name) {
+			return child, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetBlobReader(ctx context.Context, e prov.TreeEntry) (io.ReaderAt, error) {
+	m, ok := e.(*entry)
+	if !ok || 0100000 != m.mode&0170000 {
+		return nil, prov.ErrNotFound
+	}
+	return bytes.NewReader(m.data), nil
+}
+
+// GetObject returns the content of the blobs of the trees of the refs; the directories of
+// an in-memory repository are not real git trees, so they cannot be returned.
+func (r *Repository) GetObject(ctx context.Context, hash string) (string, []byte, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, ref := range r.refs {
+		var blob *entry
+		ref.root.walk("", func(path string, e *entry) error {
+			if nil == blob && e.hash == hash && 0100000 == e.mode&0170000 {
+				blob = e
+			}
+			return nil
+		})
+		if nil != blob {
+			return "blob", blob.data, nil
+		}
+	}
+	return "", nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetModule(ctx context.Context, ref prov.Ref, path string, rootrel bool) (
+	string, error) {
+	m, ok := ref.(*Ref)
+	if !ok || m.repository != r {
+		return "", prov.ErrNotFound
+	}
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for p, module := range m.modules {
+		if c.equal(p, path) {
+			return module, nil
+		}
+	}
+	return "", prov.ErrNotFound
+}
+
+func (r *Repository) GetInfo(ctx context.Context) (*prov.RepositoryInfo, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if nil == r.info {
+		return nil, prov.ErrNotFound
+	}
+	return r.info, nil
+}
+
+func (r *Repository) GetReleases(ctx context.Context) ([]*prov.Release, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]*prov.Release{}, r.releases...), nil
+}
+
+func (r *Repository) GetReleaseAssetReader(ctx context.Context, asset *prov.ReleaseAsset) (
+	io.ReaderAt, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	data, ok := r.assets[asset.URL]
+	if !ok {
+		return nil, prov.ErrNotFound
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (r *Repository) GetIssues(ctx context.Context, state string) ([]*prov.Issue, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := []*prov.Issue{}
+	for _, issue := range r.issues {
+		if issue.State == state {
+			res = append(res, issue)
+		}
+	}
+	return res, nil
+}
+
+func (r *Repository) GetIssue(ctx context.Context, number int) (*prov.Issue, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, issue := range r.issues {
+		if issue.Number == number {
+			return issue, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetPullRequests(ctx context.Context) ([]*prov.PullRequest, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]*prov.PullRequest{}, r.pulls...), nil
+}
+
+func (r *Repository) GetPullRequest(ctx context.Context, number int) (*prov.PullRequest, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, pull := range r.pulls {
+		if pull.Number == number {
+			return pull, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetPullRequestPatch(ctx context.Context, number int) ([]byte, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	patch, ok := r.patches[number]
+	if !ok {
+		return nil, prov.ErrNotFound
+	}
+	return patch, nil
+}
+
+func (r *Repository) GetWiki(ctx context.Context) (prov.Repository, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if nil == r.wiki {
+		return nil, prov.ErrNotFound
+	}
+	return r.wiki, nil
+}
+
+// GetCommits returns the commits that were added to a ref by AddCommit, most recent
+// commit first.
+func (r *Repository) GetCommits(ctx context.Context, ref prov.Ref) ([]*prov.Commit, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]*prov.Commit{}, r.commits[ref.Hash()]...), nil
+}
+
+// GetRecentCommits returns up to count of the commits that were added to a ref by
+// AddCommit, most recent commit first.
+func (r *Repository) GetRecentCommits(ctx context.Context, ref prov.Ref, count int) (
+	[]*prov.Commit, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := r.commits[ref.Hash()]
+	if count < len(res) {
+		res = res[:count]
+	}
+	return append([]*prov.Commit{}, res...), nil
+}
+
+// GetBlame returns the blame that was set by AddBlame.
+func (r *Repository) GetBlame(ctx context.Context, ref prov.Ref, path string) (
+	[]*prov.BlameRange, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	blame, ok := r.blames[ref.Hash()+":"+path]
+	if !ok {
+		return nil, prov.ErrNotFound
+	}
+	return blame, nil
+}
+
+// GetArchiveReader returns an archive of the tree of a ref, which is created on every call.
+func (r *Repository) GetArchiveReader(ctx context.Context, ref prov.Ref, format string) (
+	io.ReaderAt, int64, error) {
+	m, ok := ref.(*Ref)
+	if !ok || m.repository != r {
+		return nil, 0, prov.ErrNotFound
+	}
+
+	var buf bytes.Buffer
+	prefix := r.name + "-" + m.hash + "/"
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	switch format {
+	case "zip":
+		w := zip.NewWriter(&buf)
+		err := m.root.walk(prefix, func(path string, e *entry) error {
+			if 0100000 != e.mode&0170000 {
+				return nil
+			}
+			f, err := w.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Deflate, Modified: m.time})
+			if nil == err {
+				_, err = f.Write(e.data)
+			}
+			return err
+		})
+		if nil == err {
+			err = w.Close()
+		}
+		if nil != err {
+			return nil, 0, err
+		}
+	case "tar.gz":
+		z := gzip.NewWriter(&buf)
+		w := tar.NewWriter(z)
+		err := m.root.walk(prefix, func(path string, e *entry) error {
+			if 0100000 != e.mode&0170000 {
+				return nil
+			}
+			err := w.WriteHeader(&tar.Header{
+				Name:    path,
+				Mode:    int64(e.mode & 0777),
+				Size:    e.size,
+				ModTime: m.time,
+			})
+			if nil == err {
+				_, err = w.Write(e.data)
+			}
+			return err
+		})
+		if nil == err {
+			err = w.Close()
+		}
+		if nil == err {
+			err = z.Close()
+		}
+		if nil != err {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, prov.ErrNotFound
+	}
+	return bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil
+}
+
+func (r *Repository) GetDiff(ctx context.Context, base string, head string) ([]byte, error) {
+	c := r.client
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	diff, ok := r.diffs[base+"..."+head]
+	if !ok {
+		return nil, prov.ErrNotFound
+	}
+	return diff, nil
+}
+
+func (r *Repository) GetWorkflows(ctx context.Context) ([]*prov.Workflow, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetWorkflowRuns(ctx context.Context, workflow *prov.Workflow) (
+	[]*prov.WorkflowRun, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetWorkflowRun(ctx context.Context, id int64) (*prov.WorkflowRun, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetWorkflowJobs(ctx context.Context, run *prov.WorkflowRun) (
+	[]*prov.WorkflowJob, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetWorkflowJobLogReader(ctx context.Context, job *prov.WorkflowJob) (
+	io.ReaderAt, int64, error) {
+	return nil, 0, prov.ErrNotFound
+}
+
+func (r *Repository) GetArtifacts(ctx context.Context, run *prov.WorkflowRun) (
+	[]*prov.Artifact, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (r *Repository) GetArtifactReader(ctx context.Context, artifact *prov.Artifact) (
+	io.ReaderAt, int64, error) {
+	return nil, 0, prov.ErrNotFound
+}
+
+func (ref *Ref) Name() string {
+	return ref.name
+}
+
+func (ref *Ref) Kind() prov.RefKind {
+	return ref.kind
+}
+
+func (ref *Ref) TreeTime() time.Time {
+	return ref.time
+}
+
+func (ref *Ref) Hash() string {
+	return ref.hash
+}
+
+// AddFile adds a file with a git mode (e.g. 0100644, 0100755 or 0120000 for a symlink
+// whose target is data) to the tree of the ref, replacing any existing file.
+func (ref *Ref) AddFile(path string, mode uint32, data []byte) {
+	e := &entry{mode: mode, size: int64(len(data)), hash: hashOf("blob", data)}
+	if 0120000 == mode {
+		e.target = string(data)
+	} else {
+		e.data = data
+	}
+	ref.add(path, e)
+}
+
+// AddModule adds a submodule to the tree of the ref. The module is the full name of
+// its repository (owner/repository) and hash is the commit of the submodule, which
+// must be the hash of a ref of that repository.
+func (ref *Ref) AddModule(path string, module string, hash string) {
+	ref.add(path, &entry{mode: 0160000, hash: hash, target: hash})
+	c := ref.repository.client
+	c.lock.Lock()
+	ref.modules[strings.Trim(path, "/")] = module
+	c.lock.Unlock()
+}
+
+// AddCommit adds a commit to the history of the ref. Commits should be added
+// most recent first.
+func (ref *Ref) AddCommit(commit *prov.Commit) {
+	r := ref.repository
+	c := r.client
+	c.lock.Lock()
+	r.commits[ref.hash] = append(r.commits[ref.hash], commit)
+	c.lock.Unlock()
+}
+
+// AddBlame sets the blame of a file of the ref.
+func (ref *Ref) AddBlame(path string, blame []*prov.BlameRange) {
+	r := ref.repository
+	c := r.client
+	c.lock.Lock()
+	r.blames[ref.hash+":"+strings.Trim(path, "/")] = blame
+	c.lock.Unlock()
+}
+
+func (ref *Ref) add(path string, e *entry) {
+	c := ref.repository.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dir := ref.root
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	e.name = names[len(names)-1]
+	for _, n := range names[:len(names)-1] {
+		var next *entry
+		for _, child := range dir.children {
+			if child.name == n && 0040000 == child.mode {
+				next = child
+				break
+			}
+		}
+		if nil == next {
+			/* directories have a hash that is unique but not a real tree hash */
+			next = &entry{name: n, mode: 0040000, hash: hashOf("tree", []byte(ref.hash+"/"+n))}
+			dir.insert(next)
+		}
+		dir = next
+	}
+	dir.insert(e)
+}
+
+// insert adds or replaces a child entry.
+func (dir *entry) insert(e *entry) {
+	i := sort.Search(len(dir.children), func(i int) bool {
+		return dir.children[i].name >= e.name
+	})
+	if len(dir.children) > i && dir.children[i].name == e.name {
+		dir.children[i] = e
+	} else {
+		dir.children = append(dir.children, nil)
+		copy(dir.children[i+1:], dir.children[i:])
+		dir.children[i] = e
+	}
+}
+
+func (dir *entry) walk(prefix string, fn func(path string, e *entry) error) error {
+	for _, child := range dir.children {
+		path := prefix + child.name
+		if err := fn(path, child); nil != err {
+			return err
+		}
+		if 0040000 == child.mode {
+			if err := child.walk(path+"/", fn); nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *entry) Name() string {
+	return e.name
+}
+
+func (e *entry) Mode() uint32 {
+	return e.mode
+}
+
+func (e *entry) Size() int64 {
+	return e.size
+}
+
+func (e *entry) Target() string {
+	return e.target
+}
+
+func (e *entry) Hash() string {
+	return e.hash
+}
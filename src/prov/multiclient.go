@@ -0,0 +1,204 @@
+/*
+ * multiclient.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+	pathutil "path"
+	"sort"
+	"strings"
+)
+
+// OwnerClient is a client that is used for the owners whose names match Pattern.
+// Patterns are matched case-insensitively and may contain wildcards (e.g. "my-org-*").
+type OwnerClient struct {
+	Pattern string
+	Client  Client
+}
+
+type multiClient struct {
+	def     Client
+	clients []OwnerClient
+}
+
+type multiRepository struct {
+	Repository
+	client Client
+}
+
+func (r *multiRepository) StreamTree(ctx context.Context, ref Ref, entry TreeEntry,
+	fn func(entry TreeEntry) bool) error {
+	return StreamTree(ctx, r.Repository, ref, entry, fn)
+}
+
+// NewMultiClient returns a client that uses different clients (and therefore different
+// credentials) for different owners. The first client whose pattern matches an owner
+// name is used for the owner; owners that match no pattern use the default client.
+// Operations that are not specific to an owner are performed by the default client.
+func NewMultiClient(def Client, clients []OwnerClient) Client {
+	return &multiClient{
+		def:     def,
+		clients: clients,
+	}
+}
+
+func (c *multiClient) clientFor(owner string) Client {
+	owner = strings.ToUpper(owner)
+	for _, elm := range c.clients {
+		if m, _ := pathutil.Match(strings.ToUpper(elm.Pattern), owner); m {
+			return elm.Client
+		}
+	}
+	return c.def
+}
+
+func (c *multiClient) all() []Client {
+	res := make([]Client, 0, len(c.clients)+1)
+	for _, elm := range c.clients {
+		res = append(res, elm.Client)
+	}
+	return append(res, c.def)
+}
+
+func (c *multiClient) SetConfig(config []string) ([]string, error) {
+	for _, elm := range c.clients {
+		if _, err := elm.Client.SetConfig(config); nil != err {
+			return nil, err
+		}
+	}
+	return c.def.SetConfig(config)
+}
+
+func (c *multiClient) GetDirectory() string {
+	return c.def.GetDirectory()
+}
+
+// GetOwners returns the owners of all clients; an owner is only returned by the client
+// that is used for it.
+func (c *multiClient) GetOwners(ctx context.Context) ([]Owner, error) {
+	res := make([]Owner, 0)
+	names := make(map[string]bool)
+	for _, client := range c.all() {
+		lst, err := client.GetOwners(ctx)
+		if nil != err {
+			return nil, err
+		}
+		for _, o := range lst {
+			n := strings.ToUpper(o.Name())
+			if !names[n] && client == c.clientFor(o.Name()) {
+				names[n] = true
+				res = append(res, o)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (c *multiClient) OpenOwner(ctx context.Context, name string) (Owner, error) {
+	return c.clientFor(name).OpenOwner(ctx, name)
+}
+
+func (c *multiClient) OpenGistOwner(ctx context.Context, name string) (Owner, error) {
+	return c.clientFor(name).OpenGistOwner(ctx, name)
+}
+
+func (c *multiClient) GetStarredRepositories(ctx context.Context) ([]string, error) {
+	return c.def.GetStarredRepositories(ctx)
+}
+
+func (c *multiClient) GetCollaboratorRepositories(ctx context.Context) ([]string, error) {
+	return c.def.GetCollaboratorRepositories(ctx)
+}
+
+func (c *multiClient) SearchRepositories(ctx context.Context, query string) ([]string, error) {
+	return c.def.SearchRepositories(ctx, query)
+}
+
+func (c *multiClient) GetNotifications(ctx context.Context) ([]*Notification, error) {
+	return c.def.GetNotifications(ctx)
+}
+
+func (c *multiClient) MarkNotificationRead(ctx context.Context, id string) error {
+	return c.def.MarkNotificationRead(ctx, id)
+}
+
+func (c *multiClient) CloseOwner(owner Owner) {
+	c.clientFor(owner.Name()).CloseOwner(owner)
+}
+
+func (c *multiClient) GetRepositories(ctx context.Context, owner Owner) ([]Repository, error) {
+	return c.clientFor(owner.Name()).GetRepositories(ctx, owner)
+}
+
+func (c *multiClient) OpenRepository(ctx context.Context, owner Owner, name string) (
+	Repository, error) {
+	client := c.clientFor(owner.Name())
+	repository, err := client.OpenRepository(ctx, owner, name)
+	if nil != err {
+		return nil, err
+	}
+	return &multiRepository{Repository: repository, client: client}, nil
+}
+
+func (c *multiClient) CloseRepository(repository Repository) {
+	r := repository.(*multiRepository)
+	r.client.CloseRepository(r.Repository)
+}
+
+func (c *multiClient) StartExpiration() {
+	for _, client := range c.all() {
+		client.StartExpiration()
+	}
+}
+
+func (c *multiClient) StopExpiration() {
+	for _, client := range c.all() {
+		client.StopExpiration()
+	}
+}
+
+func (c *multiClient) FlushCache() {
+	for _, client := range c.all() {
+		client.FlushCache()
+	}
+}
+
+func (c *multiClient) GetOpenDirectories() []string {
+	var res []string
+	for _, client := range c.all() {
+		res = append(res, client.GetOpenDirectories()...)
+	}
+	return res
+}
+
+func (c *multiClient) GetRateLimit() RateLimit {
+	return c.def.GetRateLimit()
+}
+
+func (c *multiClient) GetAccessDiagnoses() []*AccessDiagnosis {
+	var res []*AccessDiagnosis
+	for _, client := range c.all() {
+		res = append(res, client.GetAccessDiagnoses()...)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+	return res
+}
+
+// SetToken replaces the auth token of the default client. The tokens of the clients
+// selected by owner are not affected.
+func (c *multiClient) SetToken(ctx context.Context, token string) error {
+	return c.def.SetToken(ctx, token)
+}
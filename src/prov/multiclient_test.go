@@ -0,0 +1,77 @@
+/*
+ * multiclient_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+	"testing"
+)
+
+type multiTestOwner string
+
+func (o multiTestOwner) Name() string {
+	return string(o)
+}
+
+type multiTestClient struct {
+	Client
+	owners []string
+}
+
+func (c *multiTestClient) GetOwners(ctx context.Context) ([]Owner, error) {
+	res := make([]Owner, len(c.owners))
+	for i, n := range c.owners {
+		res[i] = multiTestOwner(n)
+	}
+	return res, nil
+}
+
+func TestMultiClient(t *testing.T) {
+	def := &multiTestClient{owners: []string{"me", "my-org", "other-org"}}
+	work := &multiTestClient{owners: []string{"me-at-work", "my-org", "my-org-infra"}}
+	c := NewMultiClient(def, []OwnerClient{
+		{Pattern: "my-org", Client: work},
+		{Pattern: "MY-ORG-*", Client: work},
+	}).(*multiClient)
+
+	for n, e := range map[string]Client{
+		"me":           def,
+		"My-Org":       work,
+		"my-org-infra": work,
+		"my-orgx":      def,
+		"me-at-work":   def,
+	} {
+		if e != c.clientFor(n) {
+			t.Errorf("clientFor(%q)", n)
+		}
+	}
+
+	lst, err := c.GetOwners(context.Background())
+	if nil != err {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, o := range lst {
+		names = append(names, o.Name())
+	}
+	expect := []string{"my-org", "my-org-infra", "me", "other-org"}
+	if len(expect) != len(names) {
+		t.Fatal(names)
+	}
+	for i := range expect {
+		if expect[i] != names[i] {
+			t.Error(names)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * object.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+var objectKinds = map[git.ObjectType]string{
+	git.CommitObject: "commit",
+	git.TreeObject:   "tree",
+	git.BlobObject:   "blob",
+	git.TagObject:    "tag",
+}
+
+type gitObject struct {
+	kind    string
+	content []byte
+}
+
+// GetObject returns the kind ("commit", "tree", "blob" or "tag") and the content of the
+// git object with the specified hash; abbreviated hashes are only resolved for commits.
+// The object is fetched over the pack protocol unless it is in the mirror; objects never
+// change, so they are kept for the lifetime of the repository.
+func (r *gitRepository) GetObject(ctx context.Context, hash string) (
+	kind string, content []byte, err error) {
+	if !isHash(hash) {
+		return "", nil, ErrNotFound
+	}
+	hash, err = r.resolveHash(ctx, hash)
+	if nil != err {
+		return "", nil, err
+	}
+
+	r.lock.RLock()
+	obj := r.objects[hash]
+	r.lock.RUnlock()
+	if nil != obj {
+		return obj.kind, obj.content, nil
+	}
+
+	r.once.Do(func() { r.open() })
+	if nil == r.repo {
+		return "", nil, ErrNotFound
+	}
+
+	want, err := r.readMirror([]string{hash}, func(m *git.Mirror, hash string) error {
+		ot, content, err := m.ReadObject(hash)
+		if nil == err {
+			obj = &gitObject{kind: objectKinds[ot], content: content}
+		}
+		return err
+	})
+	if nil == err && 0 < len(want) {
+		err = r.repo.FetchObjects(ctx, want, func(h string, ot git.ObjectType, content []byte) error {
+			if h == hash {
+				obj = &gitObject{kind: objectKinds[ot], content: content}
+			}
+			return nil
+		})
+	}
+	if nil != err {
+		return "", nil, err
+	}
+	if nil == obj {
+		return "", nil, ErrNotFound
+	}
+
+	r.lock.Lock()
+	if nil == r.objects {
+		r.objects = make(map[string]*gitObject)
+	}
+	r.objects[hash] = obj
+	r.lock.Unlock()
+	return obj.kind, obj.content, nil
+}
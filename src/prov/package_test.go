@@ -18,7 +18,7 @@ import (
 	"os"
 	"testing"
 
-	libtrace "github.com/billziss-gh/golib/trace"
+	"github.com/winfsp/hubfs/util"
 )
 
 var atinitFn []func() error
@@ -33,8 +33,7 @@ func atexit(fn func()) {
 }
 
 func TestMain(m *testing.M) {
-	libtrace.Verbose = true
-	libtrace.Pattern = "github.com/winfsp/hubfs/*"
+	util.SetLogLevel("debug")
 
 	for i := range atinitFn {
 		err := atinitFn[i]()
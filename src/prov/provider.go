@@ -14,14 +14,16 @@
 package prov
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/url"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	libtrace "github.com/billziss-gh/golib/trace"
+	"github.com/winfsp/hubfs/util"
 )
 
 type Provider interface {
@@ -29,17 +31,42 @@ type Provider interface {
 	NewClient(token string) (Client, error)
 }
 
+// DeviceAuthProvider is implemented by providers that support the OAuth device flow.
+// DeviceAuth calls display with the one-time code that the user must enter at the
+// verification URI (on any device) and waits until the user has authorized the
+// application. It does not open a browser.
+type DeviceAuthProvider interface {
+	DeviceAuth(display func(code string, uri string) error) (string, error)
+}
+
+// TokenPoolClient is implemented by clients that can switch among several auth tokens
+// when the rate limit of one is exhausted.
+type TokenPoolClient interface {
+	AddPoolToken(ctx context.Context, token string) error
+}
+
 type Client interface {
 	SetConfig(config []string) ([]string, error)
 	GetDirectory() string
-	GetOwners() ([]Owner, error)
-	OpenOwner(name string) (Owner, error)
+	GetOwners(ctx context.Context) ([]Owner, error)
+	OpenOwner(ctx context.Context, name string) (Owner, error)
+	OpenGistOwner(ctx context.Context, name string) (Owner, error)
+	GetStarredRepositories(ctx context.Context) ([]string, error)
+	GetCollaboratorRepositories(ctx context.Context) ([]string, error)
+	SearchRepositories(ctx context.Context, query string) ([]string, error)
+	GetNotifications(ctx context.Context) ([]*Notification, error)
+	MarkNotificationRead(ctx context.Context, id string) error
 	CloseOwner(owner Owner)
-	GetRepositories(owner Owner) ([]Repository, error)
-	OpenRepository(owner Owner, name string) (Repository, error)
+	GetRepositories(ctx context.Context, owner Owner) ([]Repository, error)
+	OpenRepository(ctx context.Context, owner Owner, name string) (Repository, error)
 	CloseRepository(repository Repository)
 	StartExpiration()
 	StopExpiration()
+	FlushCache()
+	GetOpenDirectories() []string
+	GetRateLimit() RateLimit
+	GetAccessDiagnoses() []*AccessDiagnosis
+	SetToken(ctx context.Context, token string) error
 }
 
 type Owner interface {
@@ -52,19 +79,223 @@ type Repository interface {
 	SetDirectory(path string) error
 	RemoveDirectory() error
 	Name() string
-	GetRefs() ([]Ref, error)
-	GetRef(name string) (Ref, error)
-	GetTempRef(name string) (Ref, error)
-	GetTree(ref Ref, entry TreeEntry) ([]TreeEntry, error)
-	GetTreeEntry(ref Ref, entry TreeEntry, name string) (TreeEntry, error)
-	GetBlobReader(entry TreeEntry) (io.ReaderAt, error)
-	GetModule(ref Ref, path string, rootrel bool) (string, error)
+	GetRefs(ctx context.Context) ([]Ref, error)
+	GetRef(ctx context.Context, name string) (Ref, error)
+	GetTempRef(ctx context.Context, name string) (Ref, error)
+	GetDefaultRef(ctx context.Context) (Ref, error)
+	GetLatestRef(ctx context.Context) (Ref, error)
+	GetTree(ctx context.Context, ref Ref, entry TreeEntry) ([]TreeEntry, error)
+	GetTreeEntry(ctx context.Context, ref Ref, entry TreeEntry, name string) (TreeEntry, error)
+	GetBlobReader(ctx context.Context, entry TreeEntry) (io.ReaderAt, error)
+	GetObject(ctx context.Context, hash string) (string, []byte, error)
+	GetModule(ctx context.Context, ref Ref, path string, rootrel bool) (string, error)
+	GetInfo(ctx context.Context) (*RepositoryInfo, error)
+	GetReleases(ctx context.Context) ([]*Release, error)
+	GetReleaseAssetReader(ctx context.Context, asset *ReleaseAsset) (io.ReaderAt, error)
+	GetIssues(ctx context.Context, state string) ([]*Issue, error)
+	GetIssue(ctx context.Context, number int) (*Issue, error)
+	GetPullRequests(ctx context.Context) ([]*PullRequest, error)
+	GetPullRequest(ctx context.Context, number int) (*PullRequest, error)
+	GetPullRequestPatch(ctx context.Context, number int) ([]byte, error)
+	GetWiki(ctx context.Context) (Repository, error)
+	GetCommits(ctx context.Context, ref Ref) ([]*Commit, error)
+	GetRecentCommits(ctx context.Context, ref Ref, count int) ([]*Commit, error)
+	GetBlame(ctx context.Context, ref Ref, path string) ([]*BlameRange, error)
+	GetArchiveReader(ctx context.Context, ref Ref, format string) (io.ReaderAt, int64, error)
+	GetDiff(ctx context.Context, base string, head string) ([]byte, error)
+	GetWorkflows(ctx context.Context) ([]*Workflow, error)
+	GetWorkflowRuns(ctx context.Context, workflow *Workflow) ([]*WorkflowRun, error)
+	GetWorkflowRun(ctx context.Context, id int64) (*WorkflowRun, error)
+	GetWorkflowJobs(ctx context.Context, run *WorkflowRun) ([]*WorkflowJob, error)
+	GetWorkflowJobLogReader(ctx context.Context, job *WorkflowJob) (io.ReaderAt, int64, error)
+	GetArtifacts(ctx context.Context, run *WorkflowRun) ([]*Artifact, error)
+	GetArtifactReader(ctx context.Context, artifact *Artifact) (io.ReaderAt, int64, error)
+}
+
+// TreeStreamer is implemented by repositories that can return the entries of a tree while
+// the tree is being fetched, so that the first entries of a very large tree are available
+// before all of it has been fetched. StreamTree calls fn for every entry (in no particular
+// order) as soon as the entry is complete; when fn returns false it is no longer called,
+// but the tree is still fetched. The fn must not call back into the repository.
+type TreeStreamer interface {
+	StreamTree(ctx context.Context, ref Ref, entry TreeEntry, fn func(entry TreeEntry) bool) error
+}
+
+// StreamTree returns the entries of a tree with the TreeStreamer of the repository, or
+// with GetTree if the repository does not implement it.
+func StreamTree(ctx context.Context, repository Repository, ref Ref, entry TreeEntry,
+	fn func(entry TreeEntry) bool) error {
+	if s, ok := repository.(TreeStreamer); ok {
+		return s.StreamTree(ctx, ref, entry, fn)
+	}
+	lst, err := repository.GetTree(ctx, ref, entry)
+	if nil != err {
+		return err
+	}
+	for _, e := range lst {
+		if !fn(e) {
+			break
+		}
+	}
+	return nil
+}
+
+type Notification struct {
+	ID         string    `json:"id"`
+	Repository string    `json:"repository"`
+	Type       string    `json:"type"`
+	Title      string    `json:"title"`
+	Reason     string    `json:"reason"`
+	WebURL     string    `json:"web_url"`
+	Updated    time.Time `json:"updated"`
+}
+
+type RepositoryInfo struct {
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	DefaultBranch string   `json:"default_branch"`
+	Stars         int      `json:"stars"`
+	Visibility    string   `json:"visibility"`
+	WebURL        string   `json:"web_url"`
+	CloneURL      string   `json:"clone_url"`
+	SSHURL        string   `json:"ssh_url"`
+	Topics        []string `json:"topics"`
+}
+
+type Release struct {
+	Name       string
+	Title      string
+	Notes      string
+	Time       time.Time
+	Prerelease bool
+	Assets     []*ReleaseAsset
+}
+
+type ReleaseAsset struct {
+	Name   string
+	Size   int64
+	URL    string
+	Time   time.Time
+	Digest string // e.g. "sha256:<hex>", if reported by the provider
+}
+
+type Issue struct {
+	Number  int
+	Title   string
+	State   string
+	Author  string
+	Labels  []string
+	Body    string
+	WebURL  string
+	Created time.Time
+	Updated time.Time
+}
+
+const (
+	IssueOpen   = "open"
+	IssueClosed = "closed"
+)
+
+type PullRequest struct {
+	Number  int       `json:"number"`
+	Title   string    `json:"title"`
+	State   string    `json:"state"`
+	Draft   bool      `json:"draft"`
+	Author  string    `json:"author"`
+	Labels  []string  `json:"labels"`
+	Base    string    `json:"base"`
+	Head    string    `json:"head"`
+	Body    string    `json:"-"`
+	WebURL  string    `json:"web_url"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+const PullRequestMerged = "merged"
+
+type Commit struct {
+	Hash           string    `json:"hash"`
+	Parents        []string  `json:"parents"`
+	Author         string    `json:"author"`
+	AuthorEmail    string    `json:"author_email"`
+	AuthorTime     time.Time `json:"author_time"`
+	Committer      string    `json:"committer"`
+	CommitterEmail string    `json:"committer_email"`
+	CommitTime     time.Time `json:"commit_time"`
+	Message        string    `json:"message"`
+	WebURL         string    `json:"web_url"`
+
+	// Verification is nil if the provider does not report signatures in the history.
+	Verification *CommitVerification `json:"verification,omitempty"`
+}
+
+// CommitVerification is the provider's verification of the signature of a commit.
+type CommitVerification struct {
+	Signed   bool   `json:"signed"`
+	Verified bool   `json:"verified"`
+	Format   string `json:"format,omitempty"` // gpg, ssh or x509
+	Reason   string `json:"reason"`           // provider specific (e.g. valid, unknown_key)
+}
+
+// BlameRange is a range of lines of a file (1-based, inclusive) that were last changed
+// by a commit.
+type BlameRange struct {
+	Start      int       `json:"start"`
+	End        int       `json:"end"`
+	Commit     string    `json:"commit"`
+	Author     string    `json:"author"`
+	AuthorTime time.Time `json:"author_time"`
+}
+
+type Workflow struct {
+	ID    int64
+	Name  string
+	Path  string
+	State string
 }
 
+type WorkflowRun struct {
+	ID         int64     `json:"id"`
+	Number     int       `json:"number"`
+	Workflow   int64     `json:"workflow_id"`
+	Title      string    `json:"title"`
+	Event      string    `json:"event"`
+	Status     string    `json:"status"`
+	Conclusion string    `json:"conclusion"`
+	Branch     string    `json:"branch"`
+	Commit     string    `json:"commit"`
+	WebURL     string    `json:"web_url"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+type WorkflowJob struct {
+	ID         int64
+	Name       string
+	Status     string
+	Conclusion string
+	Started    time.Time
+	Completed  time.Time
+}
+
+type Artifact struct {
+	ID      int64
+	Name    string
+	URL     string
+	Expired bool
+	Time    time.Time
+}
+
+const WorkflowCompleted = "completed"
+
+// Archive formats supported by GetArchiveReader.
+var ArchiveFormats = []string{"tar.gz", "zip"}
+
 type Ref interface {
 	Name() string
 	Kind() RefKind
 	TreeTime() time.Time
+	Hash() string
 }
 
 type TreeEntry interface {
@@ -82,15 +313,26 @@ const (
 	RefBranch
 	RefTag
 	RefOther
+	RefPull
 )
 
 const AltPathSeparator = '+'
 
 var ErrNotFound = errors.New("not found")
 
+// ErrAuth is returned when the provider rejects the auth token. It is wrapped in an
+// error that explains why (e.g. the token has expired or lacks a required scope).
+var ErrAuth = errors.New("auth token rejected")
+
+// ErrRateLimit is returned when the provider's API rate limit has been exhausted.
+// Requests fail with ErrRateLimit without contacting the provider until the rate limit
+// resets.
+var ErrRateLimit = errors.New("rate limit exceeded")
+
 var regmutex sync.RWMutex
 var registry = make(map[string]func(uri *url.URL) Provider)
 var reghelp = make(map[string]string)
+var regalias = make(map[string]string)
 
 func RegisterProviderClass(name string, ctor func(uri *url.URL) Provider, help string) {
 	regmutex.Lock()
@@ -99,6 +341,30 @@ func RegisterProviderClass(name string, ctor func(uri *url.URL) Provider, help s
 	reghelp[name] = help
 }
 
+// RegisterProviderAlias registers a short name for a provider class (e.g. github for
+// github.com), so that it can be used in place of the host of a remote. This allows UNC
+// paths such as \\hubfs\github\owner\repo on Windows.
+func RegisterProviderAlias(alias string, name string) {
+	regmutex.Lock()
+	defer regmutex.Unlock()
+	regalias[alias] = name
+}
+
+// ResolveProviderAlias returns the provider class name for an alias; it returns the host
+// unchanged if it is not an alias. Only single label hosts without a port can be aliases,
+// so that a fully qualified host name is never rewritten.
+func ResolveProviderAlias(host string) string {
+	if strings.ContainsAny(host, ".:") {
+		return host
+	}
+	regmutex.RLock()
+	defer regmutex.RUnlock()
+	if name, ok := regalias[strings.ToLower(host)]; ok {
+		return name
+	}
+	return host
+}
+
 func GetProviderClassNames() (names []string) {
 	regmutex.RLock()
 	defer regmutex.RUnlock()
@@ -141,9 +407,9 @@ func NewProviderInstance(uri *url.URL) Provider {
 }
 
 func trace(vals ...interface{}) func(vals ...interface{}) {
-	return libtrace.Trace(1, "", vals...)
+	return util.Trace(1, "prov", vals...)
 }
 
 func tracef(form string, vals ...interface{}) {
-	libtrace.Tracef(1, form, vals...)
+	util.Tracef(1, "prov", form, vals...)
 }
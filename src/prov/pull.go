@@ -0,0 +1,111 @@
+/*
+ * pull.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+)
+
+// GetPullRequests returns the open pull requests of the repository.
+func (r *gitRepository) GetPullRequests(ctx context.Context) (res []*PullRequest, err error) {
+	r.lock.RLock()
+	res = r.pulls
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res = make([]*PullRequest, 0)
+	for page := 1; ; page++ {
+		lst, more, err := r.api.getPullRequestPage(ctx, page)
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, lst...)
+		if !more {
+			break
+		}
+	}
+
+	r.lock.Lock()
+	if nil == r.pullmap {
+		r.pullmap = make(map[int]*PullRequest)
+	}
+	r.pulls = res
+	for _, pull := range res {
+		r.pullmap[pull.Number] = pull
+	}
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetPullRequest returns a single pull request in any state.
+func (r *gitRepository) GetPullRequest(ctx context.Context, number int) (
+	res *PullRequest, err error) {
+	r.lock.RLock()
+	res = r.pullmap[number]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getPullRequest(ctx, number)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	if nil == r.pullmap {
+		r.pullmap = make(map[int]*PullRequest)
+	}
+	r.pullmap[number] = res
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetPullRequestPatch returns the changes of a pull request in "git format-patch" format.
+func (r *gitRepository) GetPullRequestPatch(ctx context.Context, number int) (
+	res []byte, err error) {
+	r.lock.RLock()
+	res = r.patchmap[number]
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getPullRequestPatch(ctx, number)
+	if nil != err {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	if nil == r.patchmap {
+		r.patchmap = make(map[int][]byte)
+	}
+	r.patchmap[number] = res
+	r.lock.Unlock()
+	return res, nil
+}
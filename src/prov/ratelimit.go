@@ -0,0 +1,103 @@
+/*
+ * ratelimit.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the API rate limit status as last reported by the provider.
+// A zero RateLimit means that the provider has not reported a rate limit.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+func (r RateLimit) exhausted(now time.Time) bool {
+	return 0 != r.Limit && 0 >= r.Remaining && now.Before(r.Reset)
+}
+
+// parseRateLimit parses the rate limit headers of an API response. GitHub uses
+// headers prefixed with "X-RateLimit-" and GitLab uses headers prefixed with "RateLimit-".
+func parseRateLimit(header http.Header, prefix string) (res RateLimit, ok bool) {
+	limit, err := strconv.Atoi(header.Get(prefix + "Limit"))
+	if nil != err {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get(prefix + "Remaining"))
+	if nil != err {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64)
+	if nil != err {
+		return
+	}
+	return RateLimit{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}, true
+}
+
+// updateRateLimit records the rate limit of an API response to a request that used an
+// auth token. GitHub reports separate rate limits for some APIs (e.g. search); only the
+// core rate limit is recorded. With a token pool the rate limit of every token is recorded
+// separately and the rate limit of the client is that of the current token.
+func (c *client) updateRateLimit(header http.Header, prefix string, token string) {
+	if rsrc := header.Get(prefix + "Resource"); "" != rsrc && "core" != rsrc {
+		return
+	}
+	if r, ok := parseRateLimit(header, prefix); ok {
+		c.lock.Lock()
+		if 0 == len(c.pool) {
+			c.ratelimit = r
+		}
+		for i, p := range c.pool {
+			if token == p.token {
+				p.ratelimit = r
+				if c.poolidx == i {
+					c.ratelimit = r
+				}
+			}
+		}
+		c.lock.Unlock()
+	}
+}
+
+// checkRateLimit returns ErrRateLimit if the rate limit is known to be exhausted.
+func (c *client) checkRateLimit() error {
+	c.lock.Lock()
+	r := c.ratelimit
+	c.lock.Unlock()
+	if r.exhausted(time.Now()) {
+		return ErrRateLimit
+	}
+	return nil
+}
+
+// rateLimitError returns ErrRateLimit if an API response was rejected because the rate
+// limit has been exhausted.
+func rateLimitError(rsp *http.Response, prefix string) error {
+	if (403 == rsp.StatusCode || 429 == rsp.StatusCode) &&
+		"0" == rsp.Header.Get(prefix+"Remaining") {
+		return ErrRateLimit
+	}
+	return nil
+}
+
+func (c *client) GetRateLimit() RateLimit {
+	c.lock.Lock()
+	r := c.ratelimit
+	c.lock.Unlock()
+	return r
+}
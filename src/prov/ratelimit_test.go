@@ -0,0 +1,129 @@
+/*
+ * ratelimit_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "5000")
+	header.Set("X-RateLimit-Remaining", "4987")
+	header.Set("X-RateLimit-Reset", "1650000000")
+
+	r, ok := parseRateLimit(header, "X-RateLimit-")
+	if !ok || 5000 != r.Limit || 4987 != r.Remaining || !time.Unix(1650000000, 0).Equal(r.Reset) {
+		t.Errorf("unexpected rate limit %v %v", r, ok)
+	}
+
+	_, ok = parseRateLimit(header, "RateLimit-")
+	if ok {
+		t.Error("unexpected rate limit")
+	}
+
+	header.Del("X-RateLimit-Reset")
+	_, ok = parseRateLimit(header, "X-RateLimit-")
+	if ok {
+		t.Error("unexpected rate limit")
+	}
+}
+
+func TestCheckRateLimit(t *testing.T) {
+	c := &client{}
+	if nil != c.checkRateLimit() {
+		t.Error("unexpected rate limit error")
+	}
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "60")
+	header.Set("X-RateLimit-Remaining", "0")
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	header.Set("X-RateLimit-Resource", "search")
+	c.updateRateLimit(header, "X-RateLimit-", "")
+	if nil != c.checkRateLimit() {
+		t.Error("unexpected rate limit error")
+	}
+
+	header.Set("X-RateLimit-Resource", "core")
+	c.updateRateLimit(header, "X-RateLimit-", "")
+	if ErrRateLimit != c.checkRateLimit() {
+		t.Error("expected rate limit error")
+	}
+
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10))
+	c.updateRateLimit(header, "X-RateLimit-", "")
+	if nil != c.checkRateLimit() {
+		t.Error("unexpected rate limit error")
+	}
+
+	rsp := &http.Response{StatusCode: 403, Header: header}
+	if ErrRateLimit != rateLimitError(rsp, "X-RateLimit-") {
+		t.Error("expected rate limit error")
+	}
+	header.Set("X-RateLimit-Remaining", "10")
+	if nil != rateLimitError(rsp, "X-RateLimit-") {
+		t.Error("unexpected rate limit error")
+	}
+}
+
+func TestRotateToken(t *testing.T) {
+	c := &client{token: "t0"}
+	c.pool = []*poolToken{{token: "t0"}, {token: "t1"}, {token: "t2"}}
+	if !c.rotateToken() || "t0" != c.getToken() {
+		t.Error("unexpected token rotation")
+	}
+
+	exhausted := func(token string) {
+		header := http.Header{}
+		header.Set("X-RateLimit-Limit", "5000")
+		header.Set("X-RateLimit-Remaining", "0")
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		c.updateRateLimit(header, "X-RateLimit-", token)
+	}
+
+	exhausted("t0")
+	if ErrRateLimit != c.checkRateLimit() {
+		t.Error("expected rate limit error")
+	}
+	if !c.rotateToken() || "t1" != c.getToken() || nil != c.checkRateLimit() {
+		t.Error("expected token rotation")
+	}
+	if "t0" != c.userToken() {
+		t.Error("unexpected user token")
+	}
+
+	exhausted("t2")
+	exhausted("t1")
+	if c.rotateToken() || "t1" != c.getToken() || ErrRateLimit != c.checkRateLimit() {
+		t.Error("unexpected token rotation")
+	}
+}
+
+func TestIsUserPath(t *testing.T) {
+	for _, p := range []string{"/user", "/user/starred?per_page=100", "/user/repos?affiliation=owner",
+		"/notifications?per_page=50", "/todos?state=pending", "/groups?top_level_only=true"} {
+		if !isUserPath(p) {
+			t.Error(p)
+		}
+	}
+	for _, p := range []string{"/users/octocat", "/repos/o/r/issues", "/groups/g?with_projects=false"} {
+		if isUserPath(p) {
+			t.Error(p)
+		}
+	}
+}
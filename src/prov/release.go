@@ -0,0 +1,96 @@
+/*
+ * release.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// GetReleases returns the releases of the repository as reported by the provider.
+// Release names are the release tag names.
+func (r *gitRepository) GetReleases(ctx context.Context) (res []*Release, err error) {
+	r.lock.RLock()
+	res = r.releases
+	r.lock.RUnlock()
+	if nil != res {
+		return res, nil
+	}
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	res, err = r.api.getReleases(ctx)
+	if nil != err {
+		return nil, err
+	}
+	for _, release := range res {
+		release.Name = strings.ReplaceAll(release.Name, "/", string(AltPathSeparator))
+	}
+
+	r.lock.Lock()
+	r.releases = res
+	r.lock.Unlock()
+	return res, nil
+}
+
+// GetReleaseAssetReader returns a reader for the content of a release asset. When a
+// cache directory is set the asset is downloaded once and kept in the directory.
+func (r *gitRepository) GetReleaseAssetReader(ctx context.Context, asset *ReleaseAsset) (
+	res io.ReaderAt, err error) {
+
+	if nil == r.api {
+		return nil, ErrNotFound
+	}
+
+	r.lock.RLock()
+	dir := r.dir
+	r.lock.RUnlock()
+
+	if "" == dir {
+		var buf bytes.Buffer
+		err = r.api.download(ctx, asset.URL, &buf)
+		if nil != err {
+			return nil, err
+		}
+		return readerAtNopCloser{bytes.NewReader(buf.Bytes())}, nil
+	}
+
+	h := sha256.Sum256([]byte(asset.URL))
+	k := hex.EncodeToString(h[:])
+	return fetchFile(filepath.Join(dir, "releases", k[:2], k), func(w io.Writer) error {
+		return r.api.download(ctx, asset.URL, w)
+	})
+}
+
+// sameOrigin reports whether two URLs have the same scheme and host. Credentials
+// are only sent along with downloads from the provider's own origin.
+func sameOrigin(u string, v string) bool {
+	uu, err := url.Parse(u)
+	if nil != err {
+		return false
+	}
+	vv, err := url.Parse(v)
+	if nil != err {
+		return false
+	}
+	return uu.Scheme == vv.Scheme && strings.EqualFold(uu.Host, vv.Host)
+}
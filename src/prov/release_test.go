@@ -0,0 +1,33 @@
+/*
+ * release_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	expect := func(u, v string, e bool) {
+		if e != sameOrigin(u, v) {
+			t.Errorf("sameOrigin(%q, %q) expect %v", u, v, e)
+		}
+	}
+
+	expect("https://api.github.com/repos/o/r/releases/assets/1", "https://api.github.com", true)
+	expect("https://API.github.com/x", "https://api.github.com", true)
+	expect("https://gitlab.com/o/r/-/uploads/x/f.zip", "https://gitlab.com/api/v4", true)
+	expect("http://gitlab.com/o/r/-/uploads/x/f.zip", "https://gitlab.com/api/v4", false)
+	expect("https://example.com/f.zip", "https://gitlab.com/api/v4", false)
+	expect("https://gitlab.com.example.com/f.zip", "https://gitlab.com/api/v4", false)
+}
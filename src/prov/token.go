@@ -0,0 +1,92 @@
+/*
+ * token.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"sync"
+
+	"github.com/billziss-gh/golib/keyring"
+)
+
+// TokenStore saves and loads provider auth tokens. Tokens are stored under a key,
+// which is usually the provider instance name (e.g. github.com).
+type TokenStore interface {
+	GetToken(key string) (string, error)
+	SetToken(key string, token string) error
+	DeleteToken(key string) error
+}
+
+// KeyringTokenStore stores tokens in a keyring. When Keyring is nil the system keyring
+// is used (Credential Manager on Windows, Keychain on macOS, Secret Service on Linux).
+type KeyringTokenStore struct {
+	Service string
+	Keyring keyring.Keyring
+}
+
+func (s *KeyringTokenStore) keyring() keyring.Keyring {
+	if nil != s.Keyring {
+		return s.Keyring
+	}
+	return keyring.DefaultKeyring
+}
+
+func (s *KeyringTokenStore) GetToken(key string) (string, error) {
+	return s.keyring().Get(s.Service, key)
+}
+
+func (s *KeyringTokenStore) SetToken(key string, token string) error {
+	return s.keyring().Set(s.Service, key, token)
+}
+
+func (s *KeyringTokenStore) DeleteToken(key string) error {
+	return s.keyring().Delete(s.Service, key)
+}
+
+// MemoryTokenStore stores tokens in memory. It is useful to embedders that manage
+// tokens themselves.
+type MemoryTokenStore struct {
+	lock   sync.Mutex
+	tokens map[string]string
+}
+
+func (s *MemoryTokenStore) GetToken(key string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	token, ok := s.tokens[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return token, nil
+}
+
+func (s *MemoryTokenStore) SetToken(key string, token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if nil == s.tokens {
+		s.tokens = make(map[string]string)
+	}
+	s.tokens[key] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) DeleteToken(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tokens, key)
+	return nil
+}
+
+// DefaultTokenStore is the token store used by the hubfs command. Embedders may replace
+// it with their own token store before mounting.
+var DefaultTokenStore TokenStore = &KeyringTokenStore{Service: "HUBFS"}
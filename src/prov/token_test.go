@@ -0,0 +1,46 @@
+/*
+ * token_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"testing"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	var store TokenStore = &MemoryTokenStore{}
+
+	if _, err := store.GetToken("github.com"); ErrNotFound != err {
+		t.Error(err)
+	}
+
+	if err := store.SetToken("github.com", "T1"); nil != err {
+		t.Error(err)
+	}
+	if err := store.SetToken("gitlab.com", "T2"); nil != err {
+		t.Error(err)
+	}
+	if token, err := store.GetToken("github.com"); nil != err || "T1" != token {
+		t.Error(token, err)
+	}
+
+	if err := store.DeleteToken("github.com"); nil != err {
+		t.Error(err)
+	}
+	if _, err := store.GetToken("github.com"); ErrNotFound != err {
+		t.Error(err)
+	}
+	if token, err := store.GetToken("gitlab.com"); nil != err || "T2" != token {
+		t.Error(token, err)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * tokenpool.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// poolToken is an auth token of the token pool of a client and its rate limit.
+type poolToken struct {
+	token     string
+	ratelimit RateLimit
+}
+
+// AddPoolToken adds an auth token to the token pool of the client. When the rate limit
+// of the current token is exhausted, the client switches to the next token of the pool
+// whose rate limit is not exhausted. The tokens of the pool may belong to different users
+// (e.g. machine accounts), but should have access to the same repositories; the user of
+// the client remains the user of its initial token: requests whose results depend on the
+// authenticated user (e.g. starred repositories, notifications) always use it.
+func (c *client) AddPoolToken(ctx context.Context, token string) error {
+	if c.anonymous {
+		return errors.New("client is not authenticated")
+	}
+	_, err := c.api.getLogin(ctx, token)
+	if nil != err {
+		return err
+	}
+	c.lock.Lock()
+	if 0 == len(c.pool) {
+		c.pool = append(c.pool, &poolToken{token: c.getToken(), ratelimit: c.ratelimit})
+	}
+	c.pool = append(c.pool, &poolToken{token: token})
+	c.lock.Unlock()
+	return nil
+}
+
+// rotateToken switches to the next token of the token pool whose rate limit is not
+// exhausted. It returns false if there is no such token.
+func (c *client) rotateToken() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if 0 == len(c.pool) {
+		return false
+	}
+	if !c.ratelimit.exhausted(now) {
+		// another request has already switched to a different token
+		return true
+	}
+	for n := 1; len(c.pool) > n; n++ {
+		i := (c.poolidx + n) % len(c.pool)
+		if p := c.pool[i]; !p.ratelimit.exhausted(now) {
+			c.poolidx = i
+			c.ratelimit = p.ratelimit
+			c.tokenlock.Lock()
+			c.token = p.token
+			c.tokenlock.Unlock()
+			return true
+		}
+	}
+	return false
+}
+
+// userToken returns the initial token of the token pool, which is the token of the user
+// of the client.
+func (c *client) userToken() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if 0 != len(c.pool) {
+		return c.pool[0].token
+	}
+	return c.getToken()
+}
+
+// isUserPath reports whether the results of an API request depend on the authenticated
+// user, in which case it must not be sent with a different token of the token pool.
+func isUserPath(path string) bool {
+	for _, p := range []string{"/user?", "/user/", "/notifications", "/todos", "/groups?"} {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return "/user" == path
+}
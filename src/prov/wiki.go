@@ -0,0 +1,58 @@
+/*
+ * wiki.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
+
+// wikiRemote returns the remote of the wiki of a repository. Both GitHub and GitLab
+// keep the wiki in a separate git repository named repo.wiki.git.
+func wikiRemote(remote string) string {
+	remote = strings.TrimSuffix(remote, "/")
+	remote = strings.TrimSuffix(remote, ".git")
+	return remote + ".wiki.git"
+}
+
+// GetWiki returns the repository that contains the wiki of this repository. The wiki
+// repository is owned by this repository and is closed along with it.
+func (r *gitRepository) GetWiki(ctx context.Context) (res Repository, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if nil == r.wiki {
+		w := newGitRepository(wikiRemote(r.remote), r.credentials, GitConfig{
+			Caseins:    r.caseins,
+			Fullrefs:   r.fullrefs,
+			Nestedrefs: r.nestedrefs,
+			Lfs:        r.lfs,
+			Mirror:     r.mirror,
+			Partial:    r.partial,
+			Treetime:   r.treetime,
+			Mounttime:  r.mounttime,
+		})
+		w.blobdir = r.blobdir
+		if "" != r.dir {
+			err = w.SetDirectory(filepath.Join(r.dir, "wiki"))
+			if nil != err {
+				return nil, err
+			}
+		}
+		r.wiki = w
+	}
+
+	return r.wiki, nil
+}
@@ -0,0 +1,31 @@
+/*
+ * wiki_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"testing"
+)
+
+func TestWikiRemote(t *testing.T) {
+	expect := func(remote string, e string) {
+		u := wikiRemote(remote)
+		if e != u {
+			t.Errorf("remote %q expect %q got %q", remote, e, u)
+		}
+	}
+
+	expect("https://github.com/winfsp/hubfs.git", "https://github.com/winfsp/hubfs.wiki.git")
+	expect("https://github.com/winfsp/hubfs", "https://github.com/winfsp/hubfs.wiki.git")
+	expect("https://gitlab.com/group/sub/proj.git/", "https://gitlab.com/group/sub/proj.wiki.git")
+}
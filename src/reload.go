@@ -0,0 +1,349 @@
+/*
+ * reload.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+const reloadTimeout = time.Minute
+
+// Options that can be changed by reloading the configuration file.
+var reloadableOptions = map[string]bool{
+	"d":          true,
+	"loglevel":   true,
+	"slowlog":    true,
+	"cachequota": true,
+	"filter":     true,
+	"forks":      true,
+	"archived":   true,
+	"auth":       true,
+	"authkey":    true,
+}
+
+// reloader reloads the configuration file while the file systems are mounted, when the
+// process receives SIGHUP or when the reload control file is written. As on startup,
+// options set on the command line win over the configuration file, except for -filter,
+// whose rules are combined.
+type reloader struct {
+	cachequota int64 // first field for 64-bit alignment of atomic operations
+	path       string
+	required   bool
+	cmdline    map[string]bool
+	filter     []string
+	authmeth   string
+	authkey    string
+	debug      bool
+	loglevel   string
+	clients    map[string]prov.Client
+	lock       sync.Mutex
+	values     map[string][]string
+	tokens     map[string]string
+	sigc       chan os.Signal
+}
+
+func newReloader(path string, required bool, cmdline map[string]bool, filter []string,
+	authmeth string, authkey string, cachequota int64, debug bool, loglevel string,
+	clients map[string]prov.Client) *reloader {
+	r := &reloader{
+		cachequota: cachequota,
+		path:       path,
+		required:   required,
+		cmdline:    cmdline,
+		filter:     filter,
+		authmeth:   authmeth,
+		authkey:    authkey,
+		debug:      debug,
+		loglevel:   loglevel,
+		clients:    clients,
+		values:     make(map[string][]string),
+		tokens:     make(map[string]string),
+	}
+	if "" != path {
+		r.values, _ = readConfigValues(path, required)
+	}
+	for p := range clients {
+		r.tokens[p], _ = reloadToken(authmeth, r.key(p, authkey))
+	}
+	return r
+}
+
+// logLevelSpec returns the log level spec of the -d and -loglevel options.
+func logLevelSpec(debug bool, loglevel string) string {
+	if debug {
+		return "debug"
+	}
+	return loglevel
+}
+
+// cmdlineFlags returns the names of the flags that were set on the command line.
+func cmdlineFlags() map[string]bool {
+	res := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		res[f.Name] = true
+	})
+	return res
+}
+
+// readConfigValues reads a configuration file into a map of option names to values.
+func readConfigValues(path string, required bool) (map[string][]string, error) {
+	res := make(map[string][]string)
+	file, err := os.Open(path)
+	if nil != err {
+		if !required && os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := util.ReadConfig(file)
+	if nil != err {
+		return nil, errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+	for _, e := range entries {
+		switch e.Name {
+		case "remote", "mountpoint":
+		default:
+			if "config" == e.Name || nil == flag.Lookup(e.Name) {
+				return nil, errors.New(fmt.Sprintf("%s: unknown option %s", path, e.Name))
+			}
+		}
+		res[e.Name] = append(res[e.Name], e.Value)
+	}
+	return res, nil
+}
+
+// reloadToken returns the auth token that an auth method uses, if it can be obtained
+// without user interaction.
+func reloadToken(authmeth string, authkey string) (string, bool) {
+	switch authmeth {
+	case "", "force", "full", "device", "required", "optional":
+		token, err := prov.DefaultTokenStore.GetToken(authkey)
+		return token, nil == err && "" != token
+	default:
+		if strings.HasPrefix(authmeth, "token=") {
+			return strings.TrimPrefix(authmeth, "token="), true
+		}
+		return "", false
+	}
+}
+
+func (r *reloader) getCacheQuota() int64 {
+	return atomic.LoadInt64(&r.cachequota)
+}
+
+// key returns the name of the key that stores the auth token of a provider.
+func (r *reloader) key(provider string, authkey string) string {
+	if "" == authkey {
+		return provider
+	}
+	return authkey
+}
+
+// value returns the value of an option that is not a list: the command line value if it
+// was set on the command line, else the last value in the configuration file, else def.
+func (r *reloader) value(values map[string][]string, name string, cmdval string,
+	def string) string {
+	if r.cmdline[name] {
+		return cmdval
+	}
+	if v := values[name]; 0 < len(v) {
+		return v[len(v)-1]
+	}
+	return def
+}
+
+func (r *reloader) notify() {
+	r.sigc = make(chan os.Signal, 1)
+	signal.Notify(r.sigc, syscall.SIGHUP)
+	go func() {
+		for range r.sigc {
+			r.reload()
+		}
+	}()
+}
+
+func (r *reloader) stop() {
+	signal.Stop(r.sigc)
+	close(r.sigc)
+}
+
+// reload reads the configuration file again, applies the options that can be changed
+// without remounting and reports what has changed.
+func (r *reloader) reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if "" == r.path {
+		warn("reload: no configuration file")
+		return nil
+	}
+	values, err := readConfigValues(r.path, r.required)
+	if nil != err {
+		warn("reload error: %v", err)
+		return err
+	}
+
+	debug := r.debug
+	if !r.cmdline["d"] {
+		debug, err = strconv.ParseBool(r.value(values, "d", "", "false"))
+		if nil != err {
+			err = errors.New(fmt.Sprintf("%s: option d: %v", r.path, err))
+			warn("reload error: %v", err)
+			return err
+		}
+	}
+	loglevel := logLevelSpec(debug, r.value(values, "loglevel", r.loglevel, "warn"))
+	err = util.CheckLogLevel(loglevel)
+	if nil != err {
+		err = errors.New(fmt.Sprintf("%s: option loglevel: %v", r.path, err))
+		warn("reload error: %v", err)
+		return err
+	}
+	var slowlog time.Duration
+	if !r.cmdline["slowlog"] {
+		slowlog, err = time.ParseDuration(r.value(values, "slowlog", "", "0s"))
+		if nil != err {
+			err = errors.New(fmt.Sprintf("%s: option slowlog: %v", r.path, err))
+			warn("reload error: %v", err)
+			return err
+		}
+	}
+	var cachequota util.Size
+	if !r.cmdline["cachequota"] {
+		err = cachequota.Set(r.value(values, "cachequota", "", "0"))
+		if nil != err {
+			err = errors.New(fmt.Sprintf("%s: option cachequota: %v", r.path, err))
+			warn("reload error: %v", err)
+			return err
+		}
+	}
+
+	shown := map[string]bool{"forks": true, "archived": true}
+	for n := range shown {
+		if !r.cmdline[n] {
+			shown[n], err = strconv.ParseBool(r.value(values, n, "", "true"))
+			if nil != err {
+				err = errors.New(fmt.Sprintf("%s: option %s: %v", r.path, n, err))
+				warn("reload error: %v", err)
+				return err
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for n := range r.values {
+		names[n] = true
+	}
+	for n := range values {
+		names[n] = true
+	}
+	changed := []string{}
+	for n := range names {
+		if strings.Join(r.values[n], ",") != strings.Join(values[n], ",") {
+			changed = append(changed, n)
+		}
+	}
+	sort.Strings(changed)
+
+	changes := 0
+	for _, n := range changed {
+		switch {
+		case r.cmdline[n] && "filter" != n:
+			warn("reload: option %s is set on the command line; ignored", n)
+			continue
+		case !reloadableOptions[n]:
+			warn("reload: option %s changed; remount to apply", n)
+			continue
+		case "auth" == n:
+			/* do not report auth tokens */
+			warn("reload: option %s changed", n)
+		default:
+			warn("reload: option %s changed: %q -> %q", n,
+				strings.Join(r.values[n], ","), strings.Join(values[n], ","))
+		}
+		changes++
+
+		switch n {
+		case "d", "loglevel":
+			util.SetLogLevel(loglevel)
+		case "slowlog":
+			util.SetSlowThreshold(slowlog)
+		case "cachequota":
+			atomic.StoreInt64(&r.cachequota, int64(cachequota))
+		case "filter":
+			config := []string{"config._filter="}
+			for _, f := range append(append([]string{}, r.filter...), values[n]...) {
+				for _, s := range strings.Split(f, ",") {
+					config = append(config, "config._filter="+s)
+				}
+			}
+			for _, client := range r.clients {
+				client.SetConfig(config)
+				client.FlushCache()
+			}
+		case "forks", "archived":
+			config := []string{"config._" + n + "=1"}
+			if !shown[n] {
+				config[0] = "config._" + n + "=0"
+			}
+			for _, client := range r.clients {
+				client.SetConfig(config)
+				client.FlushCache()
+			}
+		}
+	}
+
+	/* auth tokens may also change in the system keyring (e.g. with -authonly -auth force) */
+	authmeth := r.value(values, "auth", r.authmeth, "full")
+	authkey := r.value(values, "authkey", r.authkey, "")
+	for p, client := range r.clients {
+		token, ok := reloadToken(authmeth, r.key(p, authkey))
+		if !ok || r.tokens[p] == token {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+		err = client.SetToken(ctx, token)
+		cancel()
+		if nil != err {
+			warn("reload: %s: auth token error: %v", p, err)
+			continue
+		}
+		warn("reload: %s: auth token changed", p)
+		r.tokens[p] = token
+		changes++
+	}
+
+	if 0 == changes {
+		warn("reload: no changes applied")
+	}
+	r.values = values
+	return nil
+}
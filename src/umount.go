@@ -0,0 +1,80 @@
+/*
+ * umount.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const umountTimeout = 30 * time.Second
+
+// isUmount determines if the program was invoked as "hubfs umount mountpoint...".
+func isUmount() bool {
+	return 1 < len(os.Args) && "umount" == os.Args[1]
+}
+
+// umount asks the running instances that serve the mountpoints to unmount them and
+// waits until they have been unmounted.
+func umount(args []string) int {
+	if 0 == len(args) {
+		fmt.Fprintf(os.Stderr, "usage: %s umount mountpoint...\n", progname)
+		return 2
+	}
+
+	ec := 0
+	for _, mntpnt := range args {
+		err := unmountInstance(mntpnt)
+		if nil != err {
+			warn("umount error: %s: %v", mntpnt, err)
+			ec = 1
+		}
+	}
+	return ec
+}
+
+// unmountInstance writes to the unmount file of the control directory at the mount root.
+// The instance unmounts the file system once the write has completed, which shuts it down
+// cleanly. The mountpoint has been unmounted when the control directory is gone.
+func unmountInstance(mntpnt string) error {
+	path := filepath.Join(mntpnt, ".hubfs", "unmount")
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if nil != err {
+		if os.IsNotExist(err) {
+			return errors.New("not a hubfs mountpoint")
+		}
+		return err
+	}
+	_, err = file.Write([]byte("1\n"))
+	if nil == err {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if nil != err {
+		return err
+	}
+
+	deadline := time.Now().Add(umountTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); nil != err {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.New("timeout waiting for unmount")
+}
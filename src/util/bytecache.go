@@ -0,0 +1,85 @@
+/*
+ * bytecache.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ByteCache is a cache of byte slices. When the total size of the cached slices exceeds
+// the limit of the cache, the least recently used ones are evicted.
+type ByteCache struct {
+	mux   sync.Mutex
+	limit int64
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type byteCacheItem struct {
+	key   string
+	value []byte
+}
+
+// NewByteCache creates a byte cache with the specified size limit.
+func NewByteCache(limit int64) *ByteCache {
+	return &ByteCache{
+		limit: limit,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached slice for a key.
+func (c *ByteCache) Get(key string) ([]byte, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*byteCacheItem).value, true
+}
+
+// Set caches the slice for a key. A slice that is larger than the limit is not cached.
+func (c *ByteCache) Set(key string, value []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	if int64(len(value)) > c.limit {
+		return
+	}
+	c.items[key] = c.lru.PushFront(&byteCacheItem{key: key, value: value})
+	c.size += int64(len(value))
+	for c.size > c.limit {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Size returns the total size of the cached slices.
+func (c *ByteCache) Size() int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.size
+}
+
+func (c *ByteCache) remove(e *list.Element) {
+	item := c.lru.Remove(e).(*byteCacheItem)
+	delete(c.items, item.key)
+	c.size -= int64(len(item.value))
+}
@@ -0,0 +1,49 @@
+/*
+ * bytecache_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"testing"
+)
+
+func TestByteCache(t *testing.T) {
+	c := NewByteCache(10)
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+	if v, ok := c.Get("a"); !ok || "aaaa" != string(v) {
+		t.Error(v, ok)
+	}
+
+	/* b is the least recently used */
+	c.Set("c", []byte("cccc"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("b")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a")
+	}
+	if 8 != c.Size() {
+		t.Error(c.Size())
+	}
+
+	c.Set("a", []byte("a"))
+	if 5 != c.Size() {
+		t.Error(c.Size())
+	}
+
+	c.Set("d", []byte("ddddddddddd"))
+	if _, ok := c.Get("d"); ok || 5 != c.Size() {
+		t.Error("d", c.Size())
+	}
+}
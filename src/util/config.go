@@ -0,0 +1,141 @@
+/*
+ * config.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ConfigEntry is a name and value read from a configuration file.
+type ConfigEntry struct {
+	Name  string
+	Value string
+}
+
+// ReadConfig reads a configuration file. The file is a flat TOML file: every line has the
+// form "name = value", where the value is a string ("..." or '...'), a boolean, a number
+// or an array of strings, which is read as the name repeated for every element. Names may
+// also be repeated; tables are not supported. For compatibility with earlier versions a
+// value may also be unquoted text and a line that only contains a name has the value
+// "true". Empty lines and comments that start with '#' are ignored.
+func ReadConfig(reader io.Reader) (res []ConfigEntry, err error) {
+	scanner := bufio.NewScanner(reader)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || '#' == line[0] {
+			continue
+		}
+		if '[' == line[0] {
+			return nil, errors.New(fmt.Sprintf("line %d: tables are not supported", lineno))
+		}
+
+		name, values := line, []string{"true"}
+		if i := strings.IndexByte(line, '='); -1 != i {
+			name = strings.TrimSpace(line[:i])
+			values, err = configValues(strings.TrimSpace(line[i+1:]))
+			if nil != err {
+				return nil, errors.New(fmt.Sprintf("line %d: %v", lineno, err))
+			}
+		}
+		if "" == name || strings.ContainsAny(name, " \t") {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid name", lineno))
+		}
+
+		for _, v := range values {
+			res = append(res, ConfigEntry{Name: name, Value: v})
+		}
+	}
+	return res, scanner.Err()
+}
+
+// configValues parses the value of a configuration line, which may be an array.
+func configValues(s string) (res []string, err error) {
+	if !strings.HasPrefix(s, "[") {
+		var v string
+		v, s, err = configValue(s, false)
+		if nil != err {
+			return nil, err
+		}
+		if "" != s && '#' != s[0] {
+			return nil, errors.New("invalid value")
+		}
+		return []string{v}, nil
+	}
+
+	s = strings.TrimSpace(s[1:])
+	for !strings.HasPrefix(s, "]") {
+		var v string
+		v, s, err = configValue(s, true)
+		if nil != err {
+			return nil, err
+		}
+		res = append(res, v)
+		if strings.HasPrefix(s, ",") {
+			s = strings.TrimSpace(s[1:])
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, errors.New("invalid array")
+		}
+	}
+	if s = strings.TrimSpace(s[1:]); "" != s && '#' != s[0] {
+		return nil, errors.New("invalid array")
+	}
+	return res, nil
+}
+
+// configValue parses a single value and returns the remaining text, which starts with a
+// comment or (within an array) a separator.
+func configValue(s string, inarray bool) (v string, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		i := 1
+		for ; len(s) > i && '"' != s[i]; i++ {
+			if '\\' == s[i] {
+				i++
+			}
+		}
+		if len(s) <= i {
+			return "", "", errors.New("invalid quoted value")
+		}
+		v, err = strconv.Unquote(s[:i+1])
+		if nil != err {
+			return "", "", errors.New("invalid quoted value")
+		}
+		rest = s[i+1:]
+	case strings.HasPrefix(s, "'"):
+		i := strings.IndexByte(s[1:], '\'')
+		if -1 == i {
+			return "", "", errors.New("invalid quoted value")
+		}
+		v, rest = s[1:i+1], s[i+2:]
+	default:
+		/* unquoted text extends to the end of the line or a comment (or array separator) */
+		i := len(s)
+		if inarray {
+			if j := strings.IndexAny(s, ",]"); -1 != j {
+				i = j
+			}
+		}
+		if j := strings.Index(s, " #"); -1 != j && j < i {
+			i = j
+		}
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i:]), nil
+	}
+	rest = strings.TrimSpace(rest)
+	return v, rest, nil
+}
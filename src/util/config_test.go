@@ -0,0 +1,56 @@
+/*
+ * config_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	res, err := ReadConfig(strings.NewReader(`
+# comment
+remote = gitlab.com
+releases
+filter = +winfsp/*
+filter = "-winfsp/old # not a comment"
+  o=config.dir=/var/cache/hubfs
+issues = true # comment
+authmap = ["winfsp=work", 'billziss-gh=home']
+history = 10
+`))
+	expect := []ConfigEntry{
+		{"remote", "gitlab.com"},
+		{"releases", "true"},
+		{"filter", "+winfsp/*"},
+		{"filter", "-winfsp/old # not a comment"},
+		{"o", "config.dir=/var/cache/hubfs"},
+		{"issues", "true"},
+		{"authmap", "winfsp=work"},
+		{"authmap", "billziss-gh=home"},
+		{"history", "10"},
+	}
+	if nil != err || !reflect.DeepEqual(expect, res) {
+		t.Errorf("expect %v got %v %v", expect, res, err)
+	}
+
+	for _, s := range []string{"bad name = 1", "= 1", `filter = "unterminated`,
+		`filter = "a" b`, `filter = ["a" "b"]`, "[table]"} {
+		_, err = ReadConfig(strings.NewReader(s))
+		if nil == err {
+			t.Errorf("config %q expect error", s)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+/*
+ * log.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log levels. Traces of file system operations and API requests are logged at LogDebug.
+const (
+	LogError = iota
+	LogWarn
+	LogInfo
+	LogDebug
+)
+
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+type logModule struct {
+	pattern string
+	level   int
+}
+
+// The logger writes records in one of the formats:
+//
+//	text:   prog: msg key=value (error, warn)
+//	        2006/01/02 15:04:05 module: msg key=value (info, debug)
+//	logfmt: time=... level=... module=... msg=... key=value
+//	json:   {"time":...,"level":...,"module":...,"msg":...,"key":value}
+type logger struct {
+	maxlevel int32 // first field for alignment of atomic operations
+	lock     sync.RWMutex
+	out      io.Writer
+	prog     string
+	format   string
+	spec     string
+	level    int
+	modules  []logModule
+}
+
+var deflogger = &logger{
+	maxlevel: LogWarn,
+	out:      os.Stderr,
+	prog:     strings.TrimSuffix(path.Base(strings.ReplaceAll(os.Args[0], `\`, `/`)), ".exe"),
+	format:   "text",
+	spec:     "warn",
+	level:    LogWarn,
+}
+
+// SetLogOutput sets the writer that log records are written to.
+func SetLogOutput(w io.Writer) {
+	deflogger.lock.Lock()
+	deflogger.out = w
+	deflogger.lock.Unlock()
+}
+
+// SetLogFormat sets the format of log records: text, logfmt or json.
+func SetLogFormat(format string) error {
+	switch format {
+	case "text", "logfmt", "json":
+	default:
+		return errors.New("invalid log format: " + format)
+	}
+	deflogger.lock.Lock()
+	deflogger.format = format
+	deflogger.lock.Unlock()
+	return nil
+}
+
+// SetLogLevel sets the log level from a spec of the form level,module=level,...
+// A module may use wildcards for pattern matching (e.g. fs/*=debug).
+func SetLogLevel(spec string) error {
+	level, modules, maxlevel, err := parseLogSpec(spec)
+	if nil != err {
+		return err
+	}
+	deflogger.lock.Lock()
+	deflogger.spec = spec
+	deflogger.level = level
+	deflogger.modules = modules
+	atomic.StoreInt32(&deflogger.maxlevel, int32(maxlevel))
+	deflogger.lock.Unlock()
+	return nil
+}
+
+// CheckLogLevel checks a log level spec without changing the log level.
+func CheckLogLevel(spec string) error {
+	_, _, _, err := parseLogSpec(spec)
+	return err
+}
+
+func parseLogSpec(spec string) (level int, modules []logModule, maxlevel int, err error) {
+	level = LogWarn
+	maxlevel = -1
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); "" == s {
+			continue
+		}
+		pattern, name := "", s
+		if i := strings.IndexByte(s, '='); -1 != i {
+			pattern, name = s[:i], s[i+1:]
+		}
+		l := parseLogLevel(name)
+		if -1 == l {
+			err = errors.New("invalid log level: " + s)
+			return
+		}
+		if "" == pattern {
+			level = l
+		} else {
+			modules = append(modules, logModule{pattern: pattern, level: l})
+		}
+		if maxlevel < l {
+			maxlevel = l
+		}
+	}
+	if maxlevel < level {
+		maxlevel = level
+	}
+	return
+}
+
+// GetLogLevel returns the current log level spec.
+func GetLogLevel() string {
+	deflogger.lock.RLock()
+	defer deflogger.lock.RUnlock()
+	return deflogger.spec
+}
+
+func parseLogLevel(name string) int {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// LogEnabled determines if records of a level are logged for a module.
+func LogEnabled(level int, module string) bool {
+	if int32(level) > atomic.LoadInt32(&deflogger.maxlevel) {
+		return false
+	}
+	deflogger.lock.RLock()
+	defer deflogger.lock.RUnlock()
+	return level <= deflogger.levelOf(module)
+}
+
+func (l *logger) levelOf(module string) int {
+	/* the last matching module wins */
+	level := l.level
+	for _, m := range l.modules {
+		if m.pattern == module {
+			level = m.level
+		} else if ok, _ := path.Match(m.pattern, module); ok {
+			level = m.level
+		}
+	}
+	return level
+}
+
+// Log logs a record with a message and fields that are pairs of names and values.
+func Log(level int, module string, msg string, fields ...interface{}) {
+	if !LogEnabled(level, module) {
+		return
+	}
+
+	deflogger.lock.RLock()
+	out, format, prog := deflogger.out, deflogger.format, deflogger.prog
+	deflogger.lock.RUnlock()
+
+	now := time.Now()
+	var buf bytes.Buffer
+	switch format {
+	case "text":
+		if LogWarn >= level {
+			fmt.Fprintf(&buf, "%s: %s", prog, msg)
+		} else {
+			fmt.Fprintf(&buf, "%s %s: %s", now.Format("2006/01/02 15:04:05"), module, msg)
+		}
+		for i := 0; len(fields) > i+1; i += 2 {
+			fmt.Fprintf(&buf, " %v=%s", fields[i], logfmtValue(fields[i+1]))
+		}
+	case "logfmt":
+		fmt.Fprintf(&buf, "time=%s level=%s module=%s msg=%s",
+			now.Format(time.RFC3339Nano), logLevelNames[level], logfmtValue(module), logfmtValue(msg))
+		for i := 0; len(fields) > i+1; i += 2 {
+			fmt.Fprintf(&buf, " %v=%s", fields[i], logfmtValue(fields[i+1]))
+		}
+	case "json":
+		buf.WriteString("{")
+		jsonField(&buf, "time", now.Format(time.RFC3339Nano))
+		buf.WriteString(",")
+		jsonField(&buf, "level", logLevelNames[level])
+		buf.WriteString(",")
+		jsonField(&buf, "module", module)
+		buf.WriteString(",")
+		jsonField(&buf, "msg", msg)
+		for i := 0; len(fields) > i+1; i += 2 {
+			buf.WriteString(",")
+			jsonField(&buf, fmt.Sprint(fields[i]), fields[i+1])
+		}
+		buf.WriteString("}")
+	}
+	buf.WriteString("\n")
+
+	/* a single write per record, so that records are not interleaved */
+	deflogger.lock.Lock()
+	out.Write(buf.Bytes())
+	deflogger.lock.Unlock()
+}
+
+func logfmtValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if "" == s || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func jsonField(buf *bytes.Buffer, name string, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	k, _ := json.Marshal(name)
+	b, err := json.Marshal(v)
+	if nil != err {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(k)
+	buf.WriteString(":")
+	buf.Write(b)
+}
+
+// Trace logs the arguments and results of a function at LogDebug. It is used as:
+//
+//	defer util.Trace(0, module, arg1, arg2)(&result1, &result2)
+func Trace(skip int, module string, vals ...interface{}) func(vals ...interface{}) {
+	if !LogEnabled(LogDebug, module) {
+		return func(vals ...interface{}) {}
+	}
+	name := traceName(skip + 1)
+	args := traceJoin(false, vals)
+	return func(vals ...interface{}) {
+		rcvr := recover()
+		if nil != rcvr {
+			Log(LogDebug, module, name, "args", args, "panic", fmt.Sprint(rcvr))
+			panic(rcvr)
+		}
+		Log(LogDebug, module, name, "args", args, "result", traceJoin(true, vals))
+	}
+}
+
+// Tracef logs a formatted message of a function at LogDebug.
+func Tracef(skip int, module string, form string, vals ...interface{}) {
+	if !LogEnabled(LogDebug, module) {
+		return
+	}
+	Log(LogDebug, module, traceName(skip+1)+": "+fmt.Sprintf(form, vals...))
+}
+
+func traceName(skip int) string {
+	name := ""
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if ok {
+		if fn := runtime.FuncForPC(pc); nil != fn {
+			name = fn.Name()
+		}
+	}
+	if "" == name {
+		return fmt.Sprintf("pc=%x", pc)
+	}
+	if i := strings.LastIndex(name, "/"); -1 != i {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func traceJoin(deref bool, vals []interface{}) string {
+	res := []string{}
+	for _, v := range vals {
+		if deref {
+			/* results are passed as pointers; dereference pointers to basic types */
+			if e, ok := v.(*error); ok {
+				v = *e
+			} else if r := reflect.ValueOf(v); reflect.Ptr == r.Kind() && !r.IsNil() {
+				switch r.Elem().Kind() {
+				case reflect.Struct, reflect.Array, reflect.Slice, reflect.Map, reflect.Interface:
+				default:
+					v = r.Elem().Interface()
+				}
+			}
+		}
+		res = append(res, fmt.Sprintf("%#v", v))
+	}
+	return strings.Join(res, ", ")
+}
@@ -0,0 +1,89 @@
+/*
+ * log_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testLogTrace(a int) (r int) {
+	defer Trace(0, "test", a)(&r)
+	return a + 1
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogOutput(&buf)
+	defer func() {
+		SetLogOutput(os.Stderr)
+		SetLogFormat("text")
+		SetLogLevel("warn")
+	}()
+
+	if nil == SetLogLevel("warn,prov=verbose") || nil == SetLogFormat("xml") {
+		t.Error()
+	}
+
+	SetLogLevel("warn,fs/*=debug,fs/unionfs=error")
+	if !LogEnabled(LogWarn, "prov") || LogEnabled(LogInfo, "prov") ||
+		!LogEnabled(LogDebug, "fs/hubfs") || LogEnabled(LogWarn, "fs/unionfs") {
+		t.Error()
+	}
+	if "warn,fs/*=debug,fs/unionfs=error" != GetLogLevel() {
+		t.Error()
+	}
+
+	SetLogFormat("logfmt")
+	Log(LogInfo, "prov", "hidden")
+	Log(LogWarn, "prov", "hello world", "path", "/a b", "n", 1)
+	if !strings.HasSuffix(buf.String(),
+		" level=warn module=prov msg=\"hello world\" path=\"/a b\" n=1\n") ||
+		!strings.HasPrefix(buf.String(), "time=") {
+		t.Error(buf.String())
+	}
+	buf.Reset()
+
+	SetLogFormat("json")
+	Log(LogDebug, "fs/hubfs", "op", "errc", -2)
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); nil != err {
+		t.Fatal(err)
+	}
+	if "debug" != rec["level"] || "fs/hubfs" != rec["module"] || "op" != rec["msg"] ||
+		-2.0 != rec["errc"] {
+		t.Error(rec)
+	}
+	buf.Reset()
+
+	SetLogFormat("text")
+	Log(LogWarn, "main", "config error")
+	if !strings.HasSuffix(buf.String(), ": config error\n") {
+		t.Error(buf.String())
+	}
+	buf.Reset()
+
+	testLogTrace(1)
+	if "" != buf.String() {
+		t.Error(buf.String())
+	}
+	SetLogLevel("test=debug")
+	testLogTrace(1)
+	if !strings.Contains(buf.String(), " test: util.testLogTrace args=1 result=2\n") {
+		t.Error(buf.String())
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * logfile.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// LogFile is a log file that is rotated when it grows beyond a maximum size. The rotated
+// files are named path.1 (most recent), path.2, ..., path.N.
+type LogFile struct {
+	path    string
+	maxsize int64
+	keep    int
+	lock    sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+// OpenLogFile opens a log file for appending. A maxsize of 0 disables rotation.
+func OpenLogFile(path string, maxsize int64, keep int) (*LogFile, error) {
+	f := &LogFile{
+		path:    path,
+		maxsize: maxsize,
+		keep:    keep,
+	}
+	err := f.open()
+	if nil != err {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *LogFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if nil != err {
+		return err
+	}
+	info, err := file.Stat()
+	if nil != err {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *LogFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	for i := f.keep - 1; 0 < i; i-- {
+		os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+	}
+	if 0 < f.keep {
+		os.Rename(f.path, f.path+".1")
+	} else {
+		os.Remove(f.path)
+	}
+	return f.open()
+}
+
+// Write appends to the log file and rotates it first if the write would make it grow
+// beyond its maximum size.
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if nil == f.file {
+		if err := f.open(); nil != err {
+			return 0, err
+		}
+	}
+	if 0 < f.maxsize && 0 < f.size && f.maxsize < f.size+int64(len(p)) {
+		if err := f.rotate(); nil != err {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *LogFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if nil == f.file {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
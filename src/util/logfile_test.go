@@ -0,0 +1,56 @@
+/*
+ * logfile_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logfile_test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	f, err := OpenLogFile(path, 10, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"11111\n", "2222\n", "33333\n", "44444\n", "55555\n"} {
+		if _, err := f.Write([]byte(s)); nil != err {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	expect := map[string]string{
+		"log":   "55555\n",
+		"log.1": "44444\n",
+		"log.2": "33333\n",
+	}
+	for n, e := range expect {
+		b, err := ioutil.ReadFile(filepath.Join(dir, n))
+		if nil != err || e != string(b) {
+			t.Errorf("%s: expect %q got %q (%v)", n, e, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log.3")); nil == err {
+		t.Error()
+	}
+}
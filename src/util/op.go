@@ -0,0 +1,211 @@
+/*
+ * op.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slowMaxRequests is the number of requests that are reported with a slow operation.
+const slowMaxRequests = 3
+
+var slowThreshold int64
+
+// Operations and requests in flight.
+var (
+	oplock sync.Mutex
+	opmap  = make(map[*opRecord]bool)
+	reqmap = make(map[*reqRecord]bool)
+)
+
+type opRecord struct {
+	pc       uintptr
+	module   string
+	fields   []interface{}
+	start    time.Time
+	lock     sync.Mutex
+	requests []*reqRecord
+}
+
+type reqRecord struct {
+	call     string
+	start    time.Time
+	duration time.Duration // 0 while the request is in flight
+}
+
+type opKey struct{}
+
+// SetSlowThreshold sets the duration beyond which operations and requests are logged
+// as slow. A duration of 0 disables slow logging.
+func SetSlowThreshold(d time.Duration) {
+	atomic.StoreInt64(&slowThreshold, int64(d))
+}
+
+// SlowThreshold returns the duration beyond which operations and requests are slow.
+func SlowThreshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&slowThreshold))
+}
+
+// StartOp starts the operation of the calling function (skip is as in Trace). The
+// operation is in flight until the returned function is called, which also logs the
+// operation if it took longer than the slow threshold, along with the fields and the
+// slowest requests that were made using the returned context.
+func StartOp(ctx context.Context, skip int, module string, fields ...interface{}) (
+	context.Context, func()) {
+	pc := [1]uintptr{}
+	runtime.Callers(skip+2, pc[:])
+	op := &opRecord{
+		pc:     pc[0],
+		module: module,
+		fields: fields,
+		start:  time.Now(),
+	}
+	oplock.Lock()
+	opmap[op] = true
+	oplock.Unlock()
+
+	return context.WithValue(ctx, opKey{}, op), func() {
+		oplock.Lock()
+		delete(opmap, op)
+		oplock.Unlock()
+
+		threshold := SlowThreshold()
+		d := time.Since(op.start)
+		if 0 == threshold || threshold >= d {
+			return
+		}
+		fields := append([]interface{}{"op", op.name(), "duration", d.String()}, op.fields...)
+		op.lock.Lock()
+		requests := make([]reqRecord, 0, len(op.requests))
+		for _, req := range op.requests {
+			requests = append(requests, *req)
+		}
+		op.lock.Unlock()
+		if 0 < len(requests) {
+			sort.SliceStable(requests, func(i, j int) bool {
+				return requests[i].duration > requests[j].duration
+			})
+			calls := []string{}
+			for i := 0; len(requests) > i && slowMaxRequests > i; i++ {
+				calls = append(calls, fmt.Sprintf("%s (%v)", requests[i].call, requests[i].duration))
+			}
+			fields = append(fields, "requests", len(requests), "slowest", strings.Join(calls, ", "))
+		}
+		Log(LogWarn, module, "slow operation", fields...)
+	}
+}
+
+func (op *opRecord) name() string {
+	name := fmt.Sprintf("pc=%x", op.pc)
+	if fn := runtime.FuncForPC(op.pc - 1); nil != fn {
+		name = fn.Name()
+		if i := strings.LastIndex(name, "/"); -1 != i {
+			name = name[i+1:]
+		}
+	}
+	return name
+}
+
+// StartRequest starts a request that is made using the context of an operation. The
+// request is in flight until the returned function is called, which also logs the
+// request if it took longer than the slow threshold.
+func StartRequest(ctx context.Context, module string, call string) func() {
+	req := &reqRecord{
+		call:  call,
+		start: time.Now(),
+	}
+	oplock.Lock()
+	reqmap[req] = true
+	oplock.Unlock()
+
+	op, _ := ctx.Value(opKey{}).(*opRecord)
+	if nil != op {
+		op.lock.Lock()
+		op.requests = append(op.requests, req)
+		op.lock.Unlock()
+	}
+
+	return func() {
+		oplock.Lock()
+		delete(reqmap, req)
+		oplock.Unlock()
+
+		d := time.Since(req.start)
+		if nil != op {
+			op.lock.Lock()
+			req.duration = d
+			op.lock.Unlock()
+		}
+
+		threshold := SlowThreshold()
+		if 0 != threshold && threshold < d {
+			Log(LogWarn, module, "slow request", "request", call, "duration", d.String())
+		}
+	}
+}
+
+// DumpOps writes the operations that are in flight along with their requests that are in
+// flight, followed by any other requests that are in flight.
+func DumpOps(w io.Writer) {
+	oplock.Lock()
+	ops := make([]*opRecord, 0, len(opmap))
+	for op := range opmap {
+		ops = append(ops, op)
+	}
+	reqs := make(map[*reqRecord]bool, len(reqmap))
+	for req := range reqmap {
+		reqs[req] = true
+	}
+	oplock.Unlock()
+
+	now := time.Now()
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].start.Before(ops[j].start)
+	})
+	for _, op := range ops {
+		fmt.Fprintf(w, "%s %s", op.module, op.name())
+		for i := 0; len(op.fields) > i+1; i += 2 {
+			fmt.Fprintf(w, " %v=%s", op.fields[i], logfmtValue(op.fields[i+1]))
+		}
+		fmt.Fprintf(w, " duration=%v\n", now.Sub(op.start).Round(time.Millisecond))
+		op.lock.Lock()
+		for _, req := range op.requests {
+			if reqs[req] {
+				delete(reqs, req)
+				fmt.Fprintf(w, "    %s duration=%v\n",
+					req.call, now.Sub(req.start).Round(time.Millisecond))
+			}
+		}
+		op.lock.Unlock()
+	}
+
+	rest := make([]*reqRecord, 0, len(reqs))
+	for req := range reqs {
+		rest = append(rest, req)
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return rest[i].start.Before(rest[j].start)
+	})
+	for _, req := range rest {
+		fmt.Fprintf(w, "%s duration=%v\n", req.call, now.Sub(req.start).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * op_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpSlow(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogOutput(&buf)
+	SetLogFormat("logfmt")
+	defer func() {
+		SetLogOutput(os.Stderr)
+		SetLogFormat("text")
+		SetSlowThreshold(0)
+	}()
+
+	ctx, done := StartOp(context.Background(), 0, "test", "path", "/a")
+	StartRequest(ctx, "test", "GET /x")()
+	done()
+	if "" != buf.String() {
+		t.Error(buf.String())
+	}
+
+	SetSlowThreshold(10 * time.Millisecond)
+	ctx, done = StartOp(context.Background(), 0, "test", "path", "/a")
+	StartRequest(ctx, "test", "GET /x")()
+	end := StartRequest(ctx, "test", "GET /y")
+	time.Sleep(20 * time.Millisecond)
+	end()
+	if !strings.Contains(buf.String(), "msg=\"slow request\" request=\"GET /y\" duration=") {
+		t.Error(buf.String())
+	}
+	buf.Reset()
+	done()
+	if !strings.Contains(buf.String(), "msg=\"slow operation\"") {
+		t.Error(buf.String())
+	}
+	buf.Reset()
+
+	ctx, done = StartOp(context.Background(), 0, "test", "path", "/a")
+	StartRequest(ctx, "test", "GET /x")()
+	time.Sleep(20 * time.Millisecond)
+	done()
+	s := buf.String()
+	if !strings.Contains(s, "msg=\"slow operation\" op=util.TestOpSlow duration=") ||
+		!strings.Contains(s, " path=/a requests=1 slowest=\"GET /x (") {
+		t.Error(s)
+	}
+}
+
+func TestOpDump(t *testing.T) {
+	ctx, done := StartOp(context.Background(), 0, "test", "path", "/a b")
+	end1 := StartRequest(ctx, "test", "GET /x")
+	StartRequest(ctx, "test", "GET /y")()
+	end2 := StartRequest(context.Background(), "test", "GET /z")
+
+	var buf bytes.Buffer
+	DumpOps(&buf)
+	lines := strings.Split(buf.String(), "\n")
+	if 4 != len(lines) ||
+		!strings.HasPrefix(lines[0], "test util.TestOpDump path=\"/a b\" duration=") ||
+		!strings.HasPrefix(lines[1], "    GET /x duration=") ||
+		!strings.HasPrefix(lines[2], "GET /z duration=") {
+		t.Error(buf.String())
+	}
+
+	end1()
+	end2()
+	done()
+	buf.Reset()
+	DumpOps(&buf)
+	if "" != buf.String() {
+		t.Error(buf.String())
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * semver.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Semver struct {
+	Major, Minor, Patch uint64
+	Prerelease          []string
+}
+
+// ParseSemver parses a semantic version such as v1.2.3-rc.1+build.
+// A leading "v" is optional and the patch number may be omitted; the minor number may
+// only be omitted after a "v", so that date-like names such as "2021" are not versions.
+func ParseSemver(s string) (res Semver, ok bool) {
+	hasv := strings.HasPrefix(s, "v")
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); -1 != i {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); -1 != i {
+		res.Prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+		for _, p := range res.Prerelease {
+			if "" == p {
+				return Semver{}, false
+			}
+		}
+	}
+
+	lst := strings.Split(s, ".")
+	if 3 < len(lst) || (1 == len(lst) && !hasv) {
+		return Semver{}, false
+	}
+	nums := [3]uint64{}
+	for i, c := range lst {
+		n, err := strconv.ParseUint(c, 10, 64)
+		if nil != err {
+			return Semver{}, false
+		}
+		nums[i] = n
+	}
+	res.Major, res.Minor, res.Patch = nums[0], nums[1], nums[2]
+	return res, true
+}
+
+// Compare returns -1, 0, +1 depending on the precedence of v relative to w.
+func (v Semver) Compare(w Semver) int {
+	if c := compareUint(v.Major, w.Major); 0 != c {
+		return c
+	}
+	if c := compareUint(v.Minor, w.Minor); 0 != c {
+		return c
+	}
+	if c := compareUint(v.Patch, w.Patch); 0 != c {
+		return c
+	}
+
+	/* a version without prerelease has higher precedence */
+	switch {
+	case 0 == len(v.Prerelease) && 0 == len(w.Prerelease):
+		return 0
+	case 0 == len(v.Prerelease):
+		return +1
+	case 0 == len(w.Prerelease):
+		return -1
+	}
+
+	for i := 0; len(v.Prerelease) > i && len(w.Prerelease) > i; i++ {
+		a, b := v.Prerelease[i], w.Prerelease[i]
+		m, e0 := strconv.ParseUint(a, 10, 64)
+		n, e1 := strconv.ParseUint(b, 10, 64)
+		var c int
+		switch {
+		case nil == e0 && nil == e1:
+			c = compareUint(m, n)
+		case nil == e0:
+			c = -1
+		case nil == e1:
+			c = +1
+		default:
+			c = strings.Compare(a, b)
+		}
+		if 0 != c {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(w.Prerelease)))
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return +1
+	}
+	return 0
+}
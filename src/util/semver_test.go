@@ -0,0 +1,61 @@
+/*
+ * semver_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"testing"
+)
+
+func TestSemver(t *testing.T) {
+	if _, ok := ParseSemver("v1.2.3"); !ok {
+		t.Error()
+	}
+	if _, ok := ParseSemver("1.2"); !ok {
+		t.Error()
+	}
+	if _, ok := ParseSemver("v1.0B1"); ok {
+		t.Error()
+	}
+	if _, ok := ParseSemver("release"); ok {
+		t.Error()
+	}
+	if _, ok := ParseSemver("2021"); ok {
+		t.Error()
+	}
+	if _, ok := ParseSemver("v2"); !ok {
+		t.Error()
+	}
+	if _, ok := ParseSemver("1.2.3-"); ok {
+		t.Error()
+	}
+
+	/* ordered by increasing precedence; see semver.org */
+	L := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0+build", "1.0.1", "1.1", "v2.0.0",
+	}
+	for i := 0; len(L)-1 > i; i++ {
+		v, _ := ParseSemver(L[i])
+		w, _ := ParseSemver(L[i+1])
+		if -1 != v.Compare(w) || +1 != w.Compare(v) {
+			t.Errorf("%s < %s", L[i], L[i+1])
+		}
+	}
+
+	v, _ := ParseSemver("v1.0.0")
+	w, _ := ParseSemver("1.0.0+build")
+	if 0 != v.Compare(w) {
+		t.Error()
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * size.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Size is a byte count that can be specified with a K, M, G or T suffix (powers of 1024).
+type Size int64
+
+var sizeSuffixes = "KMGT"
+
+// String implements flag.Value.String.
+func (s *Size) String() string {
+	n := int64(*s)
+	if 0 == n {
+		return "0"
+	}
+	i := -1
+	for ; len(sizeSuffixes) > i+1 && 0 == n%1024; i++ {
+		n /= 1024
+	}
+	if -1 == i {
+		return strconv.FormatInt(n, 10)
+	}
+	return strconv.FormatInt(n, 10) + sizeSuffixes[i:i+1]
+}
+
+// Set implements flag.Value.Set.
+func (s *Size) Set(v string) error {
+	m := int64(1)
+	v = strings.TrimSuffix(strings.ToUpper(v), "B")
+	if "" != v {
+		if i := strings.IndexByte(sizeSuffixes, v[len(v)-1]); -1 != i {
+			for ; 0 <= i; i-- {
+				m *= 1024
+			}
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if nil != err || 0 > n || n > (1<<63-1)/m {
+		return errors.New("invalid size")
+	}
+	*s = Size(n * m)
+	return nil
+}
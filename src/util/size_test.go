@@ -0,0 +1,49 @@
+/*
+ * size_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"testing"
+)
+
+func TestSize(t *testing.T) {
+	expect := func(v string, e int64, estr string) {
+		var s Size
+		err := s.Set(v)
+		if -1 == e {
+			if nil == err {
+				t.Errorf("size %q expect error", v)
+			}
+			return
+		}
+		if nil != err || e != int64(s) || estr != s.String() {
+			t.Errorf("size %q expect (%v, %q) got (%v, %q, %v)", v, e, estr, int64(s), s.String(), err)
+		}
+	}
+
+	expect("0", 0, "0")
+	expect("1000", 1000, "1000")
+	expect("1024", 1024, "1K")
+	expect("4k", 4096, "4K")
+	expect("10M", 10*1024*1024, "10M")
+	expect("10MB", 10*1024*1024, "10M")
+	expect("2G", 2*1024*1024*1024, "2G")
+	expect("1T", 1024*1024*1024*1024, "1T")
+	expect("1536M", 1536*1024*1024, "1536M")
+	expect("", -1, "")
+	expect("G", -1, "")
+	expect("-1", -1, "")
+	expect("1X", -1, "")
+	expect("99999999999T", -1, "")
+}
@@ -0,0 +1,49 @@
+/*
+ * unorm.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization of file names. Git stores names as it receives them, which is
+// usually in composed form (NFC); macOS tends to produce names in decomposed form (NFD).
+// Only canonical equivalence is considered.
+
+// NFD returns the canonical decomposition of s.
+func NFD(s string) string {
+	return norm.NFD.String(s)
+}
+
+// NFC returns the canonical composition of s.
+func NFC(s string) string {
+	return norm.NFC.String(s)
+}
+
+// Unorm returns the function that normalizes names to the named normalization form
+// ("nfc" or "nfd"); it returns nil for an empty name.
+func Unorm(form string) (func(string) string, error) {
+	switch strings.ToLower(form) {
+	case "":
+		return nil, nil
+	case "nfc":
+		return NFC, nil
+	case "nfd":
+		return NFD, nil
+	}
+	return nil, errors.New("invalid normalization form: " + form)
+}
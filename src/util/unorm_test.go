@@ -0,0 +1,49 @@
+/*
+ * unorm_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"testing"
+)
+
+func TestUnorm(t *testing.T) {
+	expect := func(s string, nfc string, nfd string) {
+		if c, d := NFC(s), NFD(s); nfc != c || nfd != d {
+			t.Errorf("unorm %q expect (%q, %q) got (%q, %q)", s, nfc, nfd, c, d)
+		}
+	}
+
+	expect("", "", "")
+	expect("readme.md", "readme.md", "readme.md")
+	expect("caf\u00e9", "caf\u00e9", "cafe\u0301")
+	expect("cafe\u0301", "caf\u00e9", "cafe\u0301")
+	expect("\u1ea5", "\u1ea5", "a\u0302\u0301")
+	expect("a\u0301\u0302", "\u00e1\u0302", "a\u0301\u0302")
+	expect("q\u0307\u0323", "q\u0323\u0307", "q\u0323\u0307")
+	expect("\u212b", "\u00c5", "A\u030a")
+	expect("\uac01", "\uac01", "\u1100\u1161\u11a8")
+	expect("\u1100\u1161\u11a8", "\uac01", "\u1100\u1161\u11a8")
+	expect("\u304c", "\u304c", "\u304b\u3099")
+	expect("\u0958", "\u0915\u093c", "\u0915\u093c")
+
+	if f, err := Unorm("NFD"); nil != err || "e\u0301" != f("\u00e9") {
+		t.Error(err)
+	}
+	if f, err := Unorm(""); nil != err || nil != f {
+		t.Error(err)
+	}
+	if _, err := Unorm("nfkc"); nil == err {
+		t.Error()
+	}
+}
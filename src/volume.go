@@ -0,0 +1,108 @@
+/*
+ * volume.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// volumeSpec is the volume configuration of the mounts (-volume). The options map to
+// WinFsp mount options; the volume label and icon also map to the macFUSE volname and
+// volicon options.
+type volumeSpec struct {
+	label         string
+	caseins       string
+	noindex       bool
+	noappledouble bool
+	mntopt        []string
+}
+
+func parseVolume(options []string) (*volumeSpec, error) {
+	v := &volumeSpec{}
+	for _, elm := range options {
+		for _, o := range strings.Split(elm, ",") {
+			n, s := o, ""
+			if i := strings.IndexByte(o, '='); -1 != i {
+				n, s = o[:i], o[i+1:]
+			}
+			var err error
+			switch n {
+			case "label":
+				if "windows" != runtime.GOOS && "darwin" != runtime.GOOS {
+					err = errors.New("not supported on " + runtime.GOOS)
+				}
+				v.label = s
+			case "caseins":
+				var b bool
+				b, err = strconv.ParseBool(s)
+				v.caseins = "0"
+				if b {
+					v.caseins = "1"
+				}
+			case "icon":
+				if "darwin" != runtime.GOOS {
+					err = errors.New("not supported on " + runtime.GOOS)
+				}
+				v.mntopt = append(v.mntopt, "volicon="+s)
+			case "noindex":
+				v.noindex, err = strconv.ParseBool(s)
+			case "noappledouble":
+				v.noappledouble, err = strconv.ParseBool(s)
+			case "sectorsize", "allocunit", "infotimeout":
+				if "windows" != runtime.GOOS {
+					err = errors.New("not supported on " + runtime.GOOS)
+					break
+				}
+				var k int64
+				k, err = strconv.ParseInt(s, 10, 32)
+				if nil == err && (0 > k && !("infotimeout" == n && -1 == k)) {
+					err = errors.New("invalid value")
+				}
+				switch n {
+				case "sectorsize":
+					v.mntopt = append(v.mntopt, "SectorSize="+s)
+				case "allocunit":
+					v.mntopt = append(v.mntopt, "SectorsPerAllocationUnit="+s)
+				case "infotimeout":
+					v.mntopt = append(v.mntopt, "FileInfoTimeout="+s)
+				}
+			default:
+				err = errors.New("unknown option")
+			}
+			if nil != err {
+				return nil, errors.New("volume option " + o + ": " + err.Error())
+			}
+		}
+	}
+	return v, nil
+}
+
+// mountOptions returns the mount options of a mount. The label may contain {name},
+// which is replaced by the last component of the mounted remote (e.g. the owner or
+// repository name), so that every mount gets its own label.
+func (v *volumeSpec) mountOptions(m *mountSpec) []string {
+	res := append([]string{}, v.mntopt...)
+	if "" != v.label {
+		name := path.Base(strings.Trim(m.uri.Path, "/"))
+		if "." == name {
+			name = m.uri.Host
+		}
+		res = append(res, "volname="+strings.ReplaceAll(v.label, "{name}", name))
+	}
+	return res
+}